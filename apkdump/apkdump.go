@@ -6,27 +6,155 @@ package apkdump
 
 import (
 	"fmt"
+	"strings"
+
+	"github.com/thanm/go-read-a-dex/axmlread"
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+	"github.com/thanm/go-read-a-dex/kotlinmeta"
 )
 
+// DexApkDumper prints everything it visits. If Resources is set, it
+// is used to name resource IDs loaded by const instructions. If Kotlin
+// is set, classes with a kotlin.Metadata annotation also get a
+// Kotlin-level view, printed after the annotation.
 type DexApkDumper struct {
-	Vlevel int
+	Vlevel    int
+	Resources axmlread.Resolver
+	Kotlin    bool
 }
 
 func (d *DexApkDumper) VisitAPK(apk string) {
 	fmt.Printf("APK %s\n", apk)
 }
 
-func (d *DexApkDumper) VisitDEX(dexname string, sha1signature [20]byte) {
-	fmt.Printf(" DEX %s sha1 %x\n", dexname, sha1signature)
+func (d *DexApkDumper) VisitDEX(dexname string, version int, sha1signature [20]byte) {
+	fmt.Printf(" DEX %s version %03d sha1 %x\n", dexname, version, sha1signature)
+}
+
+func (d *DexApkDumper) VisitDEXIntegrity(dexname string, check *dexapkvisit.IntegrityCheck) {
+	fmt.Printf(" DEX %s integrity check failed: %s\n", dexname, check.String())
+}
+
+func (d *DexApkDumper) VisitClass(classname string, nmethods uint32, accessFlags dexapkvisit.AccessFlags, superclass string, interfaces []string) {
+	fmt.Printf("  class %s flags '%s' methods: %d",
+		classname, accessFlags.ClassString(), nmethods)
+	if superclass != "" {
+		fmt.Printf(" extends %s", superclass)
+	}
+	if len(interfaces) != 0 {
+		fmt.Printf(" implements %s", strings.Join(interfaces, ", "))
+	}
+	fmt.Printf("\n")
+}
+
+func (d *DexApkDumper) VisitField(field *dexapkvisit.FieldId, fieldIdx uint64, accessFlags dexapkvisit.AccessFlags, isStatic bool, value *dexapkvisit.EncodedValue) {
+	kind := "instance"
+	if isStatic {
+		kind = "static"
+	}
+	fmt.Printf("   field id %d name '%s' type '%s' flags '%s' %s",
+		fieldIdx, field.Name, field.Type, accessFlags.FieldString(), kind)
+	if value != nil {
+		fmt.Printf(" value %s", value.String())
+	}
+	fmt.Printf("\n")
+}
+
+func (d *DexApkDumper) VisitMethod(method *dexapkvisit.MethodId, methodIdx uint64, accessFlags dexapkvisit.AccessFlags, codeOffset uint64, code *dexapkvisit.MethodCode) {
+	fmt.Printf("   method id %d name '%s' sig '%s' flags '%s' code offset %d\n",
+		methodIdx, method.Name, method.Signature, accessFlags.MethodString(), codeOffset)
+	if code == nil {
+		return
+	}
+	fmt.Printf("    registers %d ins %d outs %d insns %d\n",
+		code.RegistersSize, code.InsSize, code.OutsSize, code.InsnsSize)
+	for i := range code.Insns {
+		insn := &code.Insns[i]
+		fmt.Printf("     %04x: %s", insn.Offset, insn.String())
+		if id, ok := insn.ResourceId(); ok && d.Resources != nil {
+			if name, ok := d.Resources.ResourceName(id); ok {
+				fmt.Printf(" // @%s", name)
+			}
+		}
+		fmt.Printf("\n")
+	}
+	for _, t := range code.Tries {
+		fmt.Printf("     try %04x..%04x\n", t.StartAddr, t.StartAddr+uint32(t.InsnCount))
+		for _, h := range t.Handlers {
+			if h.Type == "" {
+				fmt.Printf("      catch all -> %04x\n", h.Address)
+			} else {
+				fmt.Printf("      catch %s -> %04x\n", h.Type, h.Address)
+			}
+		}
+	}
+	if dbg := code.Debug; dbg != nil {
+		for i := range dbg.Positions {
+			fmt.Printf("     line %s\n", dbg.Positions[i].String())
+		}
+		for i := range dbg.Locals {
+			fmt.Printf("     local %s\n", dbg.Locals[i].String())
+		}
+	}
 }
 
-func (d *DexApkDumper) VisitClass(classname string, nmethods uint32) {
-	fmt.Printf("  class %s methods: %d\n", classname, nmethods)
+func (d *DexApkDumper) VisitAnnotation(target *dexapkvisit.AnnotationTarget, annotation *dexapkvisit.Annotation) {
+	fmt.Printf("    annotation %s %s\n", target.String(), annotation.String())
+	if d.Kotlin && target.Kind == dexapkvisit.TargetClass && annotation.Type == kotlinmeta.MetadataType {
+		d.dumpKotlin(annotation)
+	}
 }
 
-func (d *DexApkDumper) VisitMethod(methodname string, methodIdx uint64, codeOffset uint64) {
-	fmt.Printf("   method id %d name '%s' code offset %d\n",
-		methodIdx, methodname, codeOffset)
+func (d *DexApkDumper) dumpKotlin(annotation *dexapkvisit.Annotation) {
+	md, err := kotlinmeta.Decode(annotation)
+	if err != nil {
+		fmt.Printf("    kotlin error: %v\n", err)
+		return
+	}
+	member := func(s string) {
+		fmt.Printf("     kotlin %s\n", s)
+	}
+	switch {
+	case md.Class != nil:
+		c := md.Class
+		fmt.Printf("    kotlin %s\n", c.String())
+		for _, ctor := range c.Constructors {
+			member(ctor.String())
+		}
+		for _, p := range c.Properties {
+			member(p.String())
+		}
+		for _, f := range c.Functions {
+			member(f.String())
+		}
+		if c.CompanionObject != "" {
+			member("companion object " + c.CompanionObject)
+		}
+		for _, e := range c.EnumEntries {
+			member("enum entry " + e)
+		}
+		for _, n := range c.NestedClasses {
+			member("nested class " + n)
+		}
+		for _, s := range c.SealedSubclasses {
+			member("sealed subclass " + kotlinmeta.ClassName(s))
+		}
+	case md.Package != nil:
+		fmt.Printf("    kotlin %s (package %s)\n", md.Kind, md.PackageName)
+		for _, p := range md.Package.Properties {
+			member(p.String())
+		}
+		for _, f := range md.Package.Functions {
+			member(f.String())
+		}
+	case md.Lambda != nil:
+		fmt.Printf("    kotlin lambda %s\n", md.Lambda.String())
+	default:
+		fmt.Printf("    kotlin %s\n", md.Kind)
+		for _, p := range md.Parts {
+			member("part " + p)
+		}
+	}
 }
 
 func (d *DexApkDumper) Verbose(vlevel int, s string, a ...interface{}) {
@@ -0,0 +1,200 @@
+//
+// Contains TableExporter, an implementation of the DexApkVisitor
+// interface that writes flat tables (CSV or TSV) of the classes,
+// methods and fields it visits, one row each, for audits in a
+// spreadsheet. Each table has a header row, and these columns:
+//
+//	classes  apk, dex, class, access_flags, superclass, interfaces, nmethods
+//	methods  apk, dex, class, method, descriptor, method_idx, code_offset, access_flags, insns_size
+//	fields   apk, dex, class, field, type, field_idx, access_flags, static, value
+//	strings  apk, dex, string_idx, string
+//
+// Class names are Java-style, e.g. "com.example.Foo", and interfaces
+// are separated by spaces; types and descriptors are in descriptor
+// form. Access flags are rendered as modifiers, e.g. "public static".
+// Columns that don't apply (the insns_size of an abstract method, or
+// the value of a field that has none) are left empty.
+//
+// The string table isn't visited, so the strings table is only
+// written by ExportAPK, which works on parsed DEX files.
+//
+package apkexport
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+	"github.com/thanm/go-read-a-dex/dexread"
+)
+
+// Table selects one of the tables.
+type Table uint8
+
+const (
+	Classes Table = iota
+	Methods
+	Fields
+	Strings
+	numTables
+)
+
+var tableNames = [numTables]string{"classes", "methods", "fields", "strings"}
+
+var tableColumns = [numTables][]string{
+	Classes: {"apk", "dex", "class", "access_flags", "superclass", "interfaces", "nmethods"},
+	Methods: {"apk", "dex", "class", "method", "descriptor", "method_idx", "code_offset", "access_flags", "insns_size"},
+	Fields:  {"apk", "dex", "class", "field", "type", "field_idx", "access_flags", "static", "value"},
+	Strings: {"apk", "dex", "string_idx", "string"},
+}
+
+func (t Table) String() string {
+	if t < numTables {
+		return tableNames[t]
+	}
+	return fmt.Sprintf("Table(%d)", uint8(t))
+}
+
+// Format is the file format of a table.
+type Format uint8
+
+const (
+	CSV Format = iota
+	TSV
+)
+
+// ParseFileName works out the table and format for an output file
+// from its name: the base name (without extension) is the table, and
+// the extension, ".csv" or ".tsv", the format. For example,
+// "out/methods.csv" is the methods table in CSV.
+func ParseFileName(path string) (Table, Format, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(filepath.Base(path), ext)
+	var format Format
+	switch strings.ToLower(ext) {
+	case ".csv":
+		format = CSV
+	case ".tsv":
+		format = TSV
+	default:
+		return 0, 0, fmt.Errorf("export file %s: extension must be .csv or .tsv", path)
+	}
+	for t, name := range tableNames {
+		if base == name {
+			return Table(t), format, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("export file %s: name must be one of %s", path, strings.Join(tableNames[:], ", "))
+}
+
+// TableExporter writes a row to each of its tables for everything it
+// visits; see the package comment. Tables are added with AddTable;
+// those not added are not written. Write errors are reported by
+// Flush.
+type TableExporter struct {
+	Vlevel int
+
+	tables [numTables]*csv.Writer
+	apk    string
+	dex    string
+	class  string
+}
+
+// AddTable arranges for table 't' to be written to 'w' in format
+// 'format', starting with its header row.
+func (e *TableExporter) AddTable(t Table, w io.Writer, format Format) {
+	cw := csv.NewWriter(w)
+	if format == TSV {
+		cw.Comma = '\t'
+	}
+	e.tables[t] = cw
+	cw.Write(tableColumns[t])
+}
+
+func (e *TableExporter) row(t Table, cols ...string) {
+	if cw := e.tables[t]; cw != nil {
+		cw.Write(append([]string{e.apk, e.dex}, cols...))
+	}
+}
+
+// Flush flushes the tables, returning the first write error.
+func (e *TableExporter) Flush() error {
+	for _, cw := range e.tables {
+		if cw == nil {
+			continue
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportAPK writes rows for the DEX files 'dexes' of APK 'apk' (see
+// apkread.ParseAPK) to the tables, including the strings table, and
+// flushes them.
+func (e *TableExporter) ExportAPK(apk string, dexes []*dexread.DexFile) error {
+	e.VisitAPK(apk)
+	for _, d := range dexes {
+		if err := d.Walk(e); err != nil {
+			return err
+		}
+		if e.tables[Strings] != nil {
+			for i, s := range d.Strings() {
+				e.row(Strings, strconv.Itoa(i), s)
+			}
+		}
+	}
+	return e.Flush()
+}
+
+func (e *TableExporter) VisitAPK(apk string) {
+	e.apk, e.dex, e.class = apk, "", ""
+}
+
+func (e *TableExporter) VisitDEX(dexname string, version int, sha1signature [20]byte) {
+	e.dex, e.class = dexname, ""
+}
+
+func (e *TableExporter) VisitDEXIntegrity(dexname string, check *dexapkvisit.IntegrityCheck) {
+}
+
+func (e *TableExporter) VisitClass(classname string, nmethods uint32, accessFlags dexapkvisit.AccessFlags, superclass string, interfaces []string) {
+	e.class = classname
+	e.row(Classes, classname, accessFlags.ClassString(), superclass,
+		strings.Join(interfaces, " "), strconv.FormatUint(uint64(nmethods), 10))
+}
+
+func (e *TableExporter) VisitField(field *dexapkvisit.FieldId, fieldIdx uint64, accessFlags dexapkvisit.AccessFlags, isStatic bool, value *dexapkvisit.EncodedValue) {
+	var v string
+	if value != nil {
+		v = value.String()
+	}
+	e.row(Fields, e.class, field.Name, field.Type, strconv.FormatUint(fieldIdx, 10),
+		accessFlags.FieldString(), strconv.FormatBool(isStatic), v)
+}
+
+func (e *TableExporter) VisitMethod(method *dexapkvisit.MethodId, methodIdx uint64, accessFlags dexapkvisit.AccessFlags, codeOffset uint64, code *dexapkvisit.MethodCode) {
+	var insns string
+	if code != nil {
+		insns = strconv.FormatUint(uint64(code.InsnsSize), 10)
+	}
+	e.row(Methods, e.class, method.Name, method.Proto.Descriptor(), strconv.FormatUint(methodIdx, 10),
+		strconv.FormatUint(codeOffset, 10), accessFlags.MethodString(), insns)
+}
+
+func (e *TableExporter) VisitAnnotation(target *dexapkvisit.AnnotationTarget, annotation *dexapkvisit.Annotation) {
+}
+
+func (e *TableExporter) Verbose(vlevel int, s string, a ...interface{}) {
+	if e.Vlevel >= vlevel {
+		fmt.Printf("++ ")
+		fmt.Printf(s, a...)
+		fmt.Printf("\n")
+	}
+}
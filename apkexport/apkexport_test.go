@@ -0,0 +1,86 @@
+package apkexport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/apkread"
+	"github.com/thanm/go-read-a-dex/dexread"
+)
+
+func TestExportAPK(t *testing.T) {
+	const apk = "../apkread/testdata/fibonacci.apk"
+	dexes, err := apkread.ParseAPK(apk, dexread.Options{})
+	if err != nil {
+		t.Fatalf("ParseAPK error %v", err)
+	}
+	var classes, methods, strs bytes.Buffer
+	e := &TableExporter{}
+	e.AddTable(Classes, &classes, CSV)
+	e.AddTable(Methods, &methods, TSV)
+	e.AddTable(Strings, &strs, CSV)
+	if err := e.ExportAPK(apk, dexes); err != nil {
+		t.Fatalf("ExportAPK error %v", err)
+	}
+
+	expected := `apk,dex,class,access_flags,superclass,interfaces,nmethods
+../apkread/testdata/fibonacci.apk,classes.dex,fibonacci,final,java.lang.Object,,6
+`
+	if classes.String() != expected {
+		t.Errorf("classes: got\n%s\nexpected\n%s", classes.String(), expected)
+	}
+	lines := strings.Split(methods.String(), "\n")
+	if len(lines) != 8 || lines[0] != "apk\tdex\tclass\tmethod\tdescriptor\tmethod_idx\tcode_offset\taccess_flags\tinsns_size" {
+		t.Fatalf("methods: unexpected\n%s", methods.String())
+	}
+	found := false
+	for _, l := range lines[1:] {
+		cols := strings.Split(l, "\t")
+		if len(cols) == 9 && cols[3] == "main" {
+			found = true
+			if cols[4] != "([Ljava/lang/String;)V" || cols[7] != "public static" || cols[8] == "" || cols[6] == "0" {
+				t.Errorf("methods: unexpected main row %q", l)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("methods: no main row in\n%s", methods.String())
+	}
+	// Some strings have newlines in them, so read the strings back.
+	records, err := csv.NewReader(&strs).ReadAll()
+	if err != nil {
+		t.Fatalf("strings: csv error %v", err)
+	}
+	if len(records) != len(dexes[0].Strings())+1 {
+		t.Fatalf("strings: got %d records for %d strings", len(records), len(dexes[0].Strings()))
+	}
+	for i, s := range dexes[0].Strings() {
+		if r := records[i+1]; r[1] != "classes.dex" || r[2] != strconv.Itoa(i) || r[3] != s {
+			t.Errorf("strings: unexpected record %q", r)
+		}
+	}
+}
+
+func TestParseFileName(t *testing.T) {
+	tests := []struct {
+		path   string
+		table  Table
+		format Format
+		ok     bool
+	}{
+		{"methods.csv", Methods, CSV, true},
+		{"out/fields.TSV", Fields, TSV, true},
+		{"strings.tsv", Strings, TSV, true},
+		{"classes.txt", 0, 0, false},
+		{"things.csv", 0, 0, false},
+	}
+	for _, tc := range tests {
+		table, format, err := ParseFileName(tc.path)
+		if (err == nil) != tc.ok || table != tc.table || format != tc.format {
+			t.Errorf("%s: got %s %d %v", tc.path, table, format, err)
+		}
+	}
+}
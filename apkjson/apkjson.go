@@ -0,0 +1,587 @@
+//
+// Contains JSONWriter, an implementation of the DexApkVisitor
+// interface that writes what it visits as JSON, for use by scripts.
+// There are two modes:
+//
+// The default is a single JSON document (written by Close), nesting
+// APKs, DEX files, classes and their fields and methods:
+//
+//	{"schema": 1,
+//	 "apks": [{"name": "mumble.apk",
+//	           "dexes": [{"name": "classes.dex", ...,
+//	                      "classes": [{"name": "foo", ...,
+//	                                   "fields": [...],
+//	                                   "methods": [...]}]}]}]}
+//
+// DEX files that are read on their own (not from an APK, see
+// dexread.ReadDEXFile) go in a top-level "dexes" array instead.
+// Annotations are attached to the class, field or method they
+// annotate.
+//
+// In streaming mode (NDJSON), each callback writes one Record on a
+// line of its own, as it happens, which suits large APKs; records
+// name the APK, DEX file and class they belong to, and have no
+// nested children.
+//
+// Every field the visitor callbacks supply is included. Arrays and
+// optional objects (e.g. "code" for abstract methods) are omitted
+// when empty; other values are always present. Field names are
+// stable within a SchemaVersion, which is carried by the document and
+// by every record; new fields may be added without a version change,
+// but renaming or removing fields, or changing what they mean, bumps
+// it.
+//
+package apkjson
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/thanm/go-read-a-dex/axmlread"
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// SchemaVersion is the version of the JSON schema.
+const SchemaVersion = 1
+
+// Document is the top-level object in nested mode.
+type Document struct {
+	Schema int    `json:"schema"`
+	APKs   []*APK `json:"apks,omitempty"`
+	DEXes  []*DEX `json:"dexes,omitempty"`
+}
+
+// Record is a line of output in streaming mode. Kind is one of "apk",
+// "dex", "integrity", "class", "field", "method" or "annotation", and
+// Data is the corresponding object (an *APK, *DEX, *Integrity, and so
+// on). APK, DEX and Class name the enclosing elements, where there
+// are any.
+type Record struct {
+	Schema int         `json:"schema"`
+	Kind   string      `json:"record"`
+	APK    string      `json:"apk,omitempty"`
+	DEX    string      `json:"dex,omitempty"`
+	Class  string      `json:"class,omitempty"`
+	Data   interface{} `json:"data"`
+}
+
+// APK is an APK file.
+type APK struct {
+	Name  string `json:"name"`
+	DEXes []*DEX `json:"dexes,omitempty"`
+}
+
+// DEX is a DEX file. Sha1 is the signature from the header, in hex.
+type DEX struct {
+	Name      string     `json:"name"`
+	Version   int        `json:"version"`
+	Sha1      string     `json:"sha1"`
+	Integrity *Integrity `json:"integrity,omitempty"`
+	Classes   []*Class   `json:"classes,omitempty"`
+}
+
+// Integrity is a failed integrity check (see
+// dexapkvisit.IntegrityCheck); SHA-1 values are in hex.
+type Integrity struct {
+	StoredChecksum   uint32 `json:"stored_checksum"`
+	ComputedChecksum uint32 `json:"computed_checksum"`
+	StoredSha1       string `json:"stored_sha1"`
+	ComputedSha1     string `json:"computed_sha1"`
+	StoredSize       uint32 `json:"stored_size"`
+	ActualSize       uint64 `json:"actual_size"`
+	ChecksumOK       bool   `json:"checksum_ok"`
+	Sha1OK           bool   `json:"sha1_ok"`
+	SizeOK           bool   `json:"size_ok"`
+	Text             string `json:"text"`
+}
+
+// Class is a class. Names are Java-style, e.g. "java.lang.Object";
+// AccessFlags is the raw value, and Flags its rendering as modifiers.
+type Class struct {
+	Name        string        `json:"name"`
+	NumMethods  uint32        `json:"nmethods"`
+	AccessFlags uint32        `json:"access_flags"`
+	Flags       string        `json:"flags"`
+	Superclass  string        `json:"superclass"`
+	Interfaces  []string      `json:"interfaces,omitempty"`
+	Annotations []*Annotation `json:"annotations,omitempty"`
+	Fields      []*Field      `json:"fields,omitempty"`
+	Methods     []*Method     `json:"methods,omitempty"`
+}
+
+// Field is a field; Class and Type are type descriptors, and Value
+// is the initial value of a static field, if the class supplies one.
+type Field struct {
+	Index       uint64        `json:"index"`
+	Class       string        `json:"class"`
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`
+	AccessFlags uint32        `json:"access_flags"`
+	Flags       string        `json:"flags"`
+	Static      bool          `json:"static"`
+	Value       *Value        `json:"value,omitempty"`
+	Annotations []*Annotation `json:"annotations,omitempty"`
+}
+
+// Method is a method. Class is a type descriptor, Descriptor the
+// method descriptor (e.g. "(I)V") and Signature a Java-style
+// rendering. Code is omitted for abstract and native methods.
+type Method struct {
+	Index       uint64        `json:"index"`
+	Class       string        `json:"class"`
+	Name        string        `json:"name"`
+	Proto       Proto         `json:"proto"`
+	Descriptor  string        `json:"descriptor"`
+	Signature   string        `json:"signature"`
+	AccessFlags uint32        `json:"access_flags"`
+	Flags       string        `json:"flags"`
+	CodeOffset  uint64        `json:"code_offset"`
+	Code        *Code         `json:"code,omitempty"`
+	Annotations []*Annotation `json:"annotations,omitempty"`
+}
+
+// Proto is a method prototype; types are type descriptors.
+type Proto struct {
+	Shorty     string   `json:"shorty"`
+	ReturnType string   `json:"return_type"`
+	Parameters []string `json:"parameters,omitempty"`
+}
+
+// Code is a decoded code_item (see dexapkvisit.MethodCode).
+type Code struct {
+	RegistersSize uint16         `json:"registers_size"`
+	InsSize       uint16         `json:"ins_size"`
+	OutsSize      uint16         `json:"outs_size"`
+	DebugInfoOff  uint32         `json:"debug_info_off"`
+	InsnsSize     uint32         `json:"insns_size"`
+	Insns         []*Instruction `json:"insns,omitempty"`
+	Tries         []*Try         `json:"tries,omitempty"`
+	Debug         *Debug         `json:"debug,omitempty"`
+}
+
+// Instruction is a decoded instruction (see dexapkvisit.Instruction).
+// Kind is omitted, and Index meaningless, for instructions that don't
+// refer to the constant pool. Text is the instruction as
+// disassembled, and Resource the name of the resource ID it loads, if
+// it loads one that the APK's resource table names.
+type Instruction struct {
+	Offset   uint32   `json:"offset"`
+	Size     uint32   `json:"size"`
+	Opcode   uint16   `json:"opcode"`
+	Name     string   `json:"name"`
+	Format   string   `json:"format"`
+	Regs     []uint32 `json:"regs,omitempty"`
+	Literal  int64    `json:"literal"`
+	Target   uint32   `json:"target"`
+	Kind     string   `json:"kind,omitempty"`
+	Index    uint32   `json:"index"`
+	Proto    uint32   `json:"proto"`
+	Operands string   `json:"operands"`
+	Text     string   `json:"text"`
+	Resource string   `json:"resource,omitempty"`
+}
+
+// Try is a try block; a handler with an empty Type catches everything.
+type Try struct {
+	StartAddr uint32     `json:"start_addr"`
+	InsnCount uint16     `json:"insn_count"`
+	Handlers  []*Handler `json:"handlers,omitempty"`
+}
+
+// Handler is an exception handler of a Try.
+type Handler struct {
+	Type    string `json:"type"`
+	Address uint32 `json:"address"`
+}
+
+// Debug is a decoded debug_info_item (see dexapkvisit.DebugInfo).
+type Debug struct {
+	LineStart      uint32      `json:"line_start"`
+	ParameterNames []string    `json:"parameter_names,omitempty"`
+	Positions      []*Position `json:"positions,omitempty"`
+	Locals         []*Local    `json:"locals,omitempty"`
+	PrologueEnd    []uint32    `json:"prologue_end,omitempty"`
+	EpilogueBegin  []uint32    `json:"epilogue_begin,omitempty"`
+}
+
+// Position maps a bytecode address to a source position.
+type Position struct {
+	Address uint32 `json:"address"`
+	Line    uint32 `json:"line"`
+	File    string `json:"file"`
+}
+
+// Local is a local variable (see dexapkvisit.LocalVariable).
+type Local struct {
+	Register  uint32 `json:"register"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Signature string `json:"signature"`
+	StartAddr uint32 `json:"start_addr"`
+	EndAddr   uint32 `json:"end_addr"`
+}
+
+// Annotation is an annotation. Target is "class", "field", "method"
+// or "parameter"; Field ("name:type") or Method ("name(params)ret")
+// identifies the annotated member, and Parameter is the parameter
+// number for parameter annotations.
+type Annotation struct {
+	Target     string     `json:"target"`
+	Class      string     `json:"class"`
+	Field      string     `json:"field,omitempty"`
+	Method     string     `json:"method,omitempty"`
+	Parameter  *int       `json:"parameter,omitempty"`
+	Visibility string     `json:"visibility"`
+	Type       string     `json:"type"`
+	Elements   []*Element `json:"elements,omitempty"`
+	Text       string     `json:"text"`
+}
+
+// Element is a name=value pair of an annotation.
+type Element struct {
+	Name  string `json:"name"`
+	Value *Value `json:"value"`
+}
+
+// Value is an encoded_value (see dexapkvisit.EncodedValue). Type is
+// the value type name, e.g. "int" or "string". Value holds numbers
+// (chars as their UTF-16 code unit; null for NaN and infinities),
+// booleans and the text of references; arrays are in Elements and
+// annotations in Annotation instead. Index is the constant pool index
+// for references. Text is the value as rendered by the text dump.
+type Value struct {
+	Type       string             `json:"type"`
+	Value      interface{}        `json:"value"`
+	Index      *uint32            `json:"index,omitempty"`
+	Elements   []*Value           `json:"elements,omitempty"`
+	Annotation *EncodedAnnotation `json:"annotation,omitempty"`
+	Text       string             `json:"text"`
+}
+
+// EncodedAnnotation is an annotation value (see Value).
+type EncodedAnnotation struct {
+	Type     string     `json:"type"`
+	Elements []*Element `json:"elements,omitempty"`
+}
+
+// JSONWriter writes everything it visits as JSON to W; see the
+// package comment. If Stream is set, it writes NDJSON records as it
+// goes, otherwise it builds a Document, which Close writes. If
+// Resources is set, it is used to name resource IDs loaded by const
+// instructions. Verbose output goes to stderr, so as not to mix with
+// the JSON.
+type JSONWriter struct {
+	W         io.Writer
+	Stream    bool
+	Vlevel    int
+	Resources axmlread.Resolver
+
+	enc    *json.Encoder
+	err    error
+	doc    Document
+	apk    *APK
+	dex    *DEX
+	class  *Class
+	field  *Field
+	method *Method
+}
+
+// NewJSONWriter returns a JSONWriter writing to 'w'.
+func NewJSONWriter(w io.Writer, stream bool) *JSONWriter {
+	return &JSONWriter{W: w, Stream: stream}
+}
+
+// Close writes the document (in nested mode), and returns the first
+// error encountered while writing.
+func (j *JSONWriter) Close() error {
+	if !j.Stream {
+		j.doc.Schema = SchemaVersion
+		j.encoder().SetIndent("", "  ")
+		j.write(&j.doc)
+	}
+	return j.err
+}
+
+func (j *JSONWriter) encoder() *json.Encoder {
+	if j.enc == nil {
+		j.enc = json.NewEncoder(j.W)
+	}
+	return j.enc
+}
+
+func (j *JSONWriter) write(v interface{}) {
+	if j.err == nil {
+		j.err = j.encoder().Encode(v)
+	}
+}
+
+// record writes a record in streaming mode.
+func (j *JSONWriter) record(kind string, data interface{}) {
+	r := &Record{Schema: SchemaVersion, Kind: kind, Data: data}
+	if j.apk != nil {
+		r.APK = j.apk.Name
+	}
+	if j.dex != nil && kind != "dex" {
+		r.DEX = j.dex.Name
+	}
+	if j.class != nil && kind != "class" {
+		r.Class = j.class.Name
+	}
+	j.write(r)
+}
+
+func (j *JSONWriter) VisitAPK(apk string) {
+	j.apk, j.dex, j.class = &APK{Name: apk}, nil, nil
+	if j.Stream {
+		j.record("apk", j.apk)
+		return
+	}
+	j.doc.APKs = append(j.doc.APKs, j.apk)
+}
+
+func (j *JSONWriter) VisitDEX(dexname string, version int, sha1signature [20]byte) {
+	j.dex = &DEX{Name: dexname, Version: version, Sha1: hex.EncodeToString(sha1signature[:])}
+	j.class = nil
+	switch {
+	case j.Stream:
+		j.record("dex", j.dex)
+	case j.apk != nil:
+		j.apk.DEXes = append(j.apk.DEXes, j.dex)
+	default:
+		j.doc.DEXes = append(j.doc.DEXes, j.dex)
+	}
+}
+
+func (j *JSONWriter) VisitDEXIntegrity(dexname string, check *dexapkvisit.IntegrityCheck) {
+	ic := &Integrity{
+		StoredChecksum:   check.StoredChecksum,
+		ComputedChecksum: check.ComputedChecksum,
+		StoredSha1:       hex.EncodeToString(check.StoredSha1[:]),
+		ComputedSha1:     hex.EncodeToString(check.ComputedSha1[:]),
+		StoredSize:       check.StoredSize,
+		ActualSize:       check.ActualSize,
+		ChecksumOK:       check.ChecksumOK(),
+		Sha1OK:           check.Sha1OK(),
+		SizeOK:           check.SizeOK(),
+		Text:             check.String(),
+	}
+	if j.Stream {
+		j.record("integrity", ic)
+		return
+	}
+	if j.dex != nil {
+		j.dex.Integrity = ic
+	}
+}
+
+func (j *JSONWriter) VisitClass(classname string, nmethods uint32, accessFlags dexapkvisit.AccessFlags, superclass string, interfaces []string) {
+	j.class = &Class{
+		Name:        classname,
+		NumMethods:  nmethods,
+		AccessFlags: uint32(accessFlags),
+		Flags:       accessFlags.ClassString(),
+		Superclass:  superclass,
+		Interfaces:  interfaces,
+	}
+	j.field, j.method = nil, nil
+	if j.Stream {
+		j.record("class", j.class)
+		return
+	}
+	if j.dex != nil {
+		j.dex.Classes = append(j.dex.Classes, j.class)
+	}
+}
+
+func (j *JSONWriter) VisitField(field *dexapkvisit.FieldId, fieldIdx uint64, accessFlags dexapkvisit.AccessFlags, isStatic bool, value *dexapkvisit.EncodedValue) {
+	j.field = &Field{
+		Index:       fieldIdx,
+		Class:       field.Class,
+		Name:        field.Name,
+		Type:        field.Type,
+		AccessFlags: uint32(accessFlags),
+		Flags:       accessFlags.FieldString(),
+		Static:      isStatic,
+	}
+	if value != nil {
+		j.field.Value = newValue(value)
+	}
+	if j.Stream {
+		j.record("field", j.field)
+		return
+	}
+	if j.class != nil {
+		j.class.Fields = append(j.class.Fields, j.field)
+	}
+}
+
+func (j *JSONWriter) VisitMethod(method *dexapkvisit.MethodId, methodIdx uint64, accessFlags dexapkvisit.AccessFlags, codeOffset uint64, code *dexapkvisit.MethodCode) {
+	j.method = &Method{
+		Index: methodIdx,
+		Class: method.Class,
+		Name:  method.Name,
+		Proto: Proto{
+			Shorty:     method.Proto.Shorty,
+			ReturnType: method.Proto.ReturnType,
+			Parameters: method.Proto.Parameters,
+		},
+		Descriptor:  method.Proto.Descriptor(),
+		Signature:   method.Signature,
+		AccessFlags: uint32(accessFlags),
+		Flags:       accessFlags.MethodString(),
+		CodeOffset:  codeOffset,
+	}
+	if code != nil {
+		j.method.Code = j.newCode(code)
+	}
+	if j.Stream {
+		j.record("method", j.method)
+		return
+	}
+	if j.class != nil {
+		j.class.Methods = append(j.class.Methods, j.method)
+	}
+}
+
+func (j *JSONWriter) VisitAnnotation(target *dexapkvisit.AnnotationTarget, annotation *dexapkvisit.Annotation) {
+	a := &Annotation{
+		Target:     target.Kind.String(),
+		Class:      target.Class,
+		Visibility: annotation.Visibility.String(),
+		Type:       annotation.Type,
+		Elements:   newElements(annotation.Elements),
+		Text:       annotation.String(),
+	}
+	switch target.Kind {
+	case dexapkvisit.TargetField:
+		a.Field = target.Field.Name + ":" + target.Field.Type
+	case dexapkvisit.TargetParameter:
+		p := target.Parameter
+		a.Parameter = &p
+		fallthrough
+	case dexapkvisit.TargetMethod:
+		a.Method = target.Method.Name + target.Method.Proto.Descriptor()
+	}
+	if j.Stream {
+		j.record("annotation", a)
+		return
+	}
+	// Annotations are visited right after what they annotate.
+	switch {
+	case target.Kind == dexapkvisit.TargetClass && j.class != nil:
+		j.class.Annotations = append(j.class.Annotations, a)
+	case target.Kind == dexapkvisit.TargetField && j.field != nil:
+		j.field.Annotations = append(j.field.Annotations, a)
+	case j.method != nil:
+		j.method.Annotations = append(j.method.Annotations, a)
+	}
+}
+
+func (j *JSONWriter) Verbose(vlevel int, s string, a ...interface{}) {
+	if j.Vlevel >= vlevel {
+		fmt.Fprintf(os.Stderr, "++ "+s+"\n", a...)
+	}
+}
+
+func (j *JSONWriter) newCode(code *dexapkvisit.MethodCode) *Code {
+	c := &Code{
+		RegistersSize: code.RegistersSize,
+		InsSize:       code.InsSize,
+		OutsSize:      code.OutsSize,
+		DebugInfoOff:  code.DebugInfoOff,
+		InsnsSize:     code.InsnsSize,
+	}
+	for i := range code.Insns {
+		insn := &code.Insns[i]
+		ji := &Instruction{
+			Offset:   insn.Offset,
+			Size:     insn.Size,
+			Opcode:   insn.Opcode,
+			Name:     insn.Name,
+			Format:   insn.Format,
+			Regs:     insn.Regs,
+			Literal:  insn.Literal,
+			Target:   insn.Target,
+			Index:    insn.Index,
+			Proto:    insn.Proto,
+			Operands: insn.Operands,
+			Text:     insn.String(),
+		}
+		if insn.Kind != dexapkvisit.IndexNone {
+			ji.Kind = insn.Kind.String()
+		}
+		if id, ok := insn.ResourceId(); ok && j.Resources != nil {
+			if name, ok := j.Resources.ResourceName(id); ok {
+				ji.Resource = name
+			}
+		}
+		c.Insns = append(c.Insns, ji)
+	}
+	for _, t := range code.Tries {
+		jt := &Try{StartAddr: t.StartAddr, InsnCount: t.InsnCount}
+		for _, h := range t.Handlers {
+			jt.Handlers = append(jt.Handlers, &Handler{Type: h.Type, Address: h.Address})
+		}
+		c.Tries = append(c.Tries, jt)
+	}
+	if dbg := code.Debug; dbg != nil {
+		c.Debug = &Debug{
+			LineStart:      dbg.LineStart,
+			ParameterNames: dbg.ParameterNames,
+			PrologueEnd:    dbg.PrologueEnd,
+			EpilogueBegin:  dbg.EpilogueBegin,
+		}
+		for _, p := range dbg.Positions {
+			c.Debug.Positions = append(c.Debug.Positions, &Position{Address: p.Address, Line: p.Line, File: p.File})
+		}
+		for _, l := range dbg.Locals {
+			c.Debug.Locals = append(c.Debug.Locals, &Local{
+				Register:  l.Register,
+				Name:      l.Name,
+				Type:      l.Type,
+				Signature: l.Signature,
+				StartAddr: l.StartAddr,
+				EndAddr:   l.EndAddr,
+			})
+		}
+	}
+	return c
+}
+
+func newElements(elems []dexapkvisit.AnnotationElement) []*Element {
+	var retval []*Element
+	for i := range elems {
+		retval = append(retval, &Element{Name: elems[i].Name, Value: newValue(&elems[i].Value)})
+	}
+	return retval
+}
+
+func newValue(v *dexapkvisit.EncodedValue) *Value {
+	jv := &Value{Type: v.Type.String(), Text: v.String()}
+	switch x := v.Value.(type) {
+	case []dexapkvisit.EncodedValue:
+		for i := range x {
+			jv.Elements = append(jv.Elements, newValue(&x[i]))
+		}
+	case *dexapkvisit.EncodedAnnotation:
+		jv.Annotation = &EncodedAnnotation{Type: x.Type, Elements: newElements(x.Elements)}
+	case float32:
+		if !math.IsNaN(float64(x)) && !math.IsInf(float64(x), 0) {
+			jv.Value = x
+		}
+	case float64:
+		if !math.IsNaN(x) && !math.IsInf(x, 0) {
+			jv.Value = x
+		}
+	case string:
+		idx := v.Index
+		jv.Value, jv.Index = x, &idx
+	default:
+		jv.Value = x
+	}
+	return jv
+}
@@ -0,0 +1,117 @@
+package apkjson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/apkread"
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+const testAPK = "../apkread/testdata/fibonacci.apk"
+
+func TestDocument(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewJSONWriter(&buf, false)
+	if err := apkread.ReadAPK(testAPK, w); err != nil {
+		t.Fatalf("ReadAPK error %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close error %v", err)
+	}
+	var doc Document
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Unmarshal error %v", err)
+	}
+	if doc.Schema != SchemaVersion || len(doc.APKs) != 1 || len(doc.APKs[0].DEXes) != 1 {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+	dex := doc.APKs[0].DEXes[0]
+	if dex.Name != "classes.dex" || dex.Version != 35 || len(dex.Sha1) != 40 || len(dex.Classes) != 1 {
+		t.Fatalf("unexpected DEX %+v", dex)
+	}
+	c := dex.Classes[0]
+	if c.Name != "fibonacci" || c.NumMethods != 6 || len(c.Methods) != 6 ||
+		c.Superclass != "java.lang.Object" || c.Flags != "final" {
+		t.Fatalf("unexpected class %+v", c)
+	}
+	var main *Method
+	for _, m := range c.Methods {
+		if m.Name == "main" {
+			main = m
+		}
+	}
+	if main == nil || main.Descriptor != "([Ljava/lang/String;)V" || main.Flags != "public static" ||
+		main.Proto.Shorty != "VL" || main.Code == nil || main.Code.Debug == nil {
+		t.Fatalf("unexpected main %+v", main)
+	}
+	var invoke *Instruction
+	for _, insn := range main.Code.Insns {
+		if strings.HasPrefix(insn.Name, "invoke-") && invoke == nil {
+			invoke = insn
+		}
+	}
+	if invoke == nil || invoke.Kind != "method" || !strings.Contains(invoke.Text, "->") {
+		t.Errorf("unexpected invoke %+v", invoke)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewJSONWriter(&buf, true)
+	if err := apkread.ReadAPK(testAPK, w); err != nil {
+		t.Fatalf("ReadAPK error %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close error %v", err)
+	}
+	var kinds []string
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var r struct {
+			Record
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("Unmarshal error %v on %s", err, scanner.Text())
+		}
+		if r.Schema != SchemaVersion || r.APK != testAPK {
+			t.Errorf("unexpected record %s", scanner.Text())
+		}
+		kinds = append(kinds, r.Kind)
+		if r.Kind == "method" {
+			var m Method
+			if err := json.Unmarshal(r.Data, &m); err != nil || r.DEX != "classes.dex" || r.Class != "fibonacci" ||
+				m.Class != "Lfibonacci;" || m.Code == nil || len(m.Code.Insns) == 0 {
+				t.Errorf("unexpected method record %s", scanner.Text())
+			}
+		}
+	}
+	if actual := strings.Join(kinds, " "); actual != "apk dex class method method method method method method" {
+		t.Errorf("unexpected records %s", actual)
+	}
+}
+
+func TestValue(t *testing.T) {
+	v := &dexapkvisit.EncodedValue{Type: dexapkvisit.ValueArray, Value: []dexapkvisit.EncodedValue{
+		{Type: dexapkvisit.ValueString, Value: "x", Index: 7},
+		{Type: dexapkvisit.ValueDouble, Value: 1.5},
+		{Type: dexapkvisit.ValueFloat, Value: float32(math.NaN())},
+	}}
+	data, err := json.Marshal(newValue(v))
+	if err != nil {
+		t.Fatalf("Marshal error %v", err)
+	}
+	expected := `{"type":"array","value":null,"elements":[` +
+		`{"type":"string","value":"x","index":7,"text":"\"x\""},` +
+		`{"type":"double","value":1.5,"text":"1.5"},` +
+		`{"type":"float","value":null,"text":"NaN"}],"text":"{\"x\", 1.5, NaN}"}`
+	if string(data) != expected {
+		t.Errorf("got\n%s\nexpected\n%s", data, expected)
+	}
+}
@@ -2,8 +2,9 @@
 // Rudimentary package for examining Android APK files. An APK file is
 // basically a ZIP file that contains an Android manifest and a series
 // of DEX files, strings, resources, bitmaps, and assorted other
-// items.  This specific reader looks only at the DEX files, not the
-// other bits and pieces (of which there are many).
+// items.  This specific reader looks mainly at the DEX files, not the
+// other bits and pieces (of which there are many); the exception is
+// the manifest, which ReadManifest decodes (see manifest.go).
 //
 package apkread
 
@@ -11,6 +12,7 @@ import (
 	"archive/zip"
 	"errors"
 	"fmt"
+	"io"
 	"regexp"
 
 	. "github.com/thanm/go-read-a-dex/dexapkvisit"
@@ -22,6 +24,35 @@ import (
 // through a user-supplied visitor object 'visitor'. See DexApkVisitor
 // for more info on which DEX/APK parts are visited.
 func ReadAPK(apk string, visitor DexApkVisitor) error {
+	return ReadAPKWithOptions(apk, visitor, dexread.Options{})
+}
+
+// ReadAPKWithOptions is like ReadAPK, with control over how the DEX
+// files are checked (see dexread.Options).
+func ReadAPKWithOptions(apk string, visitor DexApkVisitor, opts dexread.Options) error {
+	return forEachDex(apk, visitor, func(name string, reader io.Reader, size uint64) error {
+		return dexread.ReadDEXWithOptions(&apk, name, reader, size, visitor, opts)
+	})
+}
+
+// ParseAPK parses each of the DEX files in the APK file 'apk' (see
+// dexread.ParseAll), returning them in the order they appear.
+func ParseAPK(apk string, opts dexread.Options) ([]*dexread.DexFile, error) {
+	var dexes []*dexread.DexFile
+	err := forEachDex(apk, nil, func(name string, reader io.Reader, size uint64) error {
+		d, err := dexread.ParseAll(&apk, name, reader, size, opts)
+		dexes = append(dexes, d...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dexes, nil
+}
+
+// forEachDex calls 'fn' on the contents of each DEX file within the
+// APK. The visitor, if not nil, is told about the APK first.
+func forEachDex(apk string, visitor DexApkVisitor, fn func(name string, reader io.Reader, size uint64) error) error {
 	rc, err := zip.OpenReader(apk)
 	if err != nil {
 		return errors.New(fmt.Sprintf("unable to open APK %s: %v", apk, err))
@@ -29,23 +60,29 @@ func ReadAPK(apk string, visitor DexApkVisitor) error {
 	defer rc.Close()
 	z := &rc.Reader
 
-	visitor.VisitAPK(apk)
-	visitor.Verbose(1, "APK %s contains %d entries", apk, len(z.File))
+	if visitor != nil {
+		visitor.VisitAPK(apk)
+		visitor.Verbose(1, "APK %s contains %d entries", apk, len(z.File))
+	}
 
 	isDex := regexp.MustCompile(`^\S+\.dex$`)
 	for i := 0; i < len(z.File); i++ {
 		entryName := z.File[i].Name
 		if isDex.MatchString(entryName) {
-			visitor.Verbose(1, "dex file %s at entry %d", entryName, i)
+			if visitor != nil {
+				visitor.Verbose(1, "dex file %s at entry %d", entryName, i)
+			}
 			reader, err := z.File[i].Open()
 			if err != nil {
 				return errors.New(fmt.Sprintf("opening apk %s dex %s: %v", apk, entryName, err))
 			}
-			func() {
-				dexread.ReadDEX(&apk, entryName, reader,
-					z.File[i].UncompressedSize64, visitor)
+			err = func() error {
 				defer reader.Close()
+				return fn(entryName, reader, z.File[i].UncompressedSize64)
 			}()
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
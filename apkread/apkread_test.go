@@ -1,11 +1,13 @@
 package apkread
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
 
 	"github.com/thanm/go-read-a-dex/dexapktest"
+	"github.com/thanm/go-read-a-dex/dexread"
 )
 
 func TestSmallApkRead(t *testing.T) {
@@ -15,14 +17,20 @@ func TestSmallApkRead(t *testing.T) {
 	actual := strings.Join(visitor.Result, "\n")
 
 	expected := `APK testdata/fibonacci.apk
-		  DEX classes.dex sha1 fd56aced78355c305a9503d6f3dfe1f7ff6ac440
-		   class fibonacci methods: 6
-		    method id 0 name '<init>' code offset 584
-		    method id 1 name 'ifibonacci' code offset 608
-		    method id 2 name 'main' code offset 656
-		    method id 3 name 'rcnm1' code offset 1008
-		    method id 4 name 'rcnm2' code offset 1040
-		    method id 5 name 'rfibonacci' code offset 1072`
+		  DEX classes.dex version 035 sha1 fd56aced78355c305a9503d6f3dfe1f7ff6ac440
+		   class fibonacci flags 'final' methods: 6 extends java.lang.Object
+		    method id 0 name '<init>' sig 'void fibonacci.<init>()' flags 'constructor' code offset 584
+		     registers 1 ins 1 outs 1 insns 4
+		    method id 1 name 'ifibonacci' sig 'int fibonacci.ifibonacci(int)' flags 'static' code offset 608
+		     registers 5 ins 1 outs 0 insns 16
+		    method id 2 name 'main' sig 'void fibonacci.main(java.lang.String[])' flags 'public static' code offset 656
+		     registers 14 ins 1 outs 3 insns 159
+		    method id 3 name 'rcnm1' sig 'int fibonacci.rcnm1(int)' flags 'private static final' code offset 1008
+		     registers 2 ins 1 outs 1 insns 7
+		    method id 4 name 'rcnm2' sig 'int fibonacci.rcnm2(int)' flags 'private static final' code offset 1040
+		     registers 2 ins 1 outs 1 insns 7
+		    method id 5 name 'rfibonacci' sig 'int fibonacci.rfibonacci(int)' flags 'private static final' code offset 1072
+		     registers 3 ins 1 outs 1 insns 17`
 
 	if dexapktest.SqueezeWhite(actual) != dexapktest.SqueezeWhite(expected) {
 		t.Errorf("got '%s' expected '%s'",
@@ -57,3 +65,31 @@ func TestBadApkFileRead(t *testing.T) {
 		t.Errorf("expected '%s' got '%s', f error", expected, actual)
 	}
 }
+
+func TestMissingManifest(t *testing.T) {
+	_, err := ReadManifest("testdata/fibonacci.apk")
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	actual := fmt.Sprintf("%v", err)
+	expected := "apk testdata/fibonacci.apk has no AndroidManifest.xml: no such entry"
+	if actual != expected {
+		t.Errorf("expected '%s' got '%s'", expected, actual)
+	}
+	if _, err := ReadResources("testdata/fibonacci.apk"); !errors.Is(err, ErrNoEntry) {
+		t.Errorf("ReadResources: expected ErrNoEntry got %v", err)
+	}
+}
+
+func TestParseAPK(t *testing.T) {
+	dexes, err := ParseAPK("testdata/fibonacci.apk", dexread.Options{})
+	if err != nil {
+		t.Fatalf("ParseAPK error %v", err)
+	}
+	if len(dexes) != 1 || dexes[0].Name() != "classes.dex" || len(dexes[0].Classes()) != 1 {
+		t.Errorf("unexpected DEX files %v", dexes)
+	}
+	if _, err := ParseAPK("X", dexread.Options{}); err == nil {
+		t.Errorf("expected error")
+	}
+}
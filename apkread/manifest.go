@@ -0,0 +1,76 @@
+package apkread
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/thanm/go-read-a-dex/arscread"
+	"github.com/thanm/go-read-a-dex/axmlread"
+)
+
+// ManifestName is the name of the (binary XML) manifest within an APK.
+const ManifestName = "AndroidManifest.xml"
+
+// ResourcesName is the name of the resource table within an APK.
+const ResourcesName = "resources.arsc"
+
+// ErrNoEntry is returned (wrapped) by readEntry when the APK does not
+// contain the requested entry.
+var ErrNoEntry = errors.New("no such entry")
+
+// readEntry returns the uncompressed contents of entry 'name' in the
+// APK file 'apk'.
+func readEntry(apk string, name string) ([]byte, error) {
+	rc, err := zip.OpenReader(apk)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to open APK %s: %v", apk, err))
+	}
+	defer rc.Close()
+	for _, f := range rc.File {
+		if f.Name != name {
+			continue
+		}
+		reader, err := f.Open()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("opening apk %s %s: %v", apk, name, err))
+		}
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("reading apk %s %s: %v", apk, name, err))
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("apk %s has no %s: %w", apk, name, ErrNoEntry)
+}
+
+// ReadManifest opens the APK file 'apk' and decodes its binary
+// AndroidManifest.xml. Use Manifest() on the result to get at the
+// package name, permissions, components and so on.
+func ReadManifest(apk string) (*axmlread.Document, error) {
+	data, err := readEntry(apk, ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := axmlread.Parse(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("decoding apk %s manifest: %v", apk, err))
+	}
+	return doc, nil
+}
+
+// ReadResources opens the APK file 'apk' and decodes its resource
+// table. The error wraps ErrNoEntry if the APK has no resources.arsc.
+func ReadResources(apk string) (*arscread.Table, error) {
+	data, err := readEntry(apk, ResourcesName)
+	if err != nil {
+		return nil, err
+	}
+	t, err := arscread.Parse(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("decoding apk %s resources: %v", apk, err))
+	}
+	return t, nil
+}
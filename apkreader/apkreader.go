@@ -2,23 +2,56 @@
 // Rudimentary program for examining Android APK files. An APK file
 // is basically a ZIP file that contains an Android manifest and a series
 // of DEX files, strings, resources, bitmaps, and assorted other items.
-// This specific reader looks only at the DEX files, not the other
-// bits and pieces.
+// This reader mostly looks at the DEX files (dumping, counting,
+// sizing, diffing and cross-referencing their contents), but it can
+// also print the manifest (-manifest), verify the APK's signatures
+// (-verify), and export to CSV/TSV files or an SQLite database
+// (-export, -sqlite).
 //
 package main
 
 import (
+	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/thanm/go-read-a-dex/apkdump"
+	"github.com/thanm/go-read-a-dex/apkexport"
+	"github.com/thanm/go-read-a-dex/apkjson"
 	"github.com/thanm/go-read-a-dex/apkread"
+	"github.com/thanm/go-read-a-dex/apksig"
+	"github.com/thanm/go-read-a-dex/apksql"
+	"github.com/thanm/go-read-a-dex/dexread"
+	"github.com/thanm/go-read-a-dex/sizeprof"
 )
 
 var verbflag = flag.Int("v", 0, "Verbose trace output level")
 var dumpflag = flag.Bool("dump", false, "Dump DEX/APK info to stdout")
+var manifestflag = flag.Bool("manifest", false, "Print AndroidManifest.xml as plain XML")
+var integrityflag = flag.String("integrity", "ignore", "DEX checksum/signature checking for -dump: ignore, report or strict")
+var verifyflag = flag.Bool("verify", false, "Verify APK v1/v2/v3 signatures and report signers")
+var formatflag = flag.String("format", "text", "Output format for -dump: text, json (one document) or ndjson (one record per line); json and ndjson imply -dump")
+var kotlinflag = flag.Bool("kotlin", false, "With -dump, also show the Kotlin view of classes compiled from Kotlin")
+var sectionsflag = flag.Bool("sections", false, "Print the section layout of each DEX file")
+var countflag = flag.Bool("count", false, "Print method and field counts by package, and per-DEX headroom against the 64K limit")
+var depthflag = flag.Int("depth", 0, "With -count, -size or -diff, aggregate packages to this many name components (0 for no limit)")
+var treeflag = flag.Bool("tree", false, "With -count, show packages as a tree rather than a flat list")
+var sizeflag = flag.Bool("size", false, "Print where the bytes of each DEX file go, by package, class and method")
+var topflag = flag.Int("top", 20, "With -size, the number of classes and methods to list")
+var pprofflag = flag.String("pprof", "", "Write the size and method attribution of the DEX files to the given file as a pprof profile")
+var subclassesflag = flag.String("subclasses", "", "Print the classes that extend the given class")
+var implementersflag = flag.String("implementers", "", "Print the classes that implement the given interface")
+var ancestorsflag = flag.String("ancestors", "", "Print the superclass chain of the given class")
+var exportflag = flag.String("export", "", "Export tables to the given comma-separated files, e.g. methods.csv,classes.tsv (tables: classes, methods, fields, strings)")
+var sqliteflag = flag.String("sqlite", "", "Add the APK (manifest and DEX structure) to the given SQLite database file, creating it if needed, using the sqlite3 command; with -sqlite -, print the SQL instead")
+var xrefflag = flag.String("xref", "", "Print the methods that refer to the given method or field (a prefix such as 'Ljava/lang/Runtime;->exec'), type or its members (e.g. 'java.lang.Runtime') or string (in double quotes, with Go escapes)")
+var diffflag = flag.Bool("diff", false, "Compare two APK (or DEX) files: apkreader -diff <old> <new>")
 
 func verb(vlevel int, s string, a ...interface{}) {
 	if *verbflag >= vlevel {
@@ -32,10 +65,455 @@ func usage(msg string) {
 		fmt.Fprintf(os.Stderr, "error: %s\n", msg)
 	}
 	fmt.Fprintf(os.Stderr, "usage: apkread [flags] <APK file>\n")
+	fmt.Fprintf(os.Stderr, "       apkread -diff [flags] <old APK or DEX> <new APK or DEX>\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
+// reportSignatures prints the outcome of signature verification,
+// returning true if the APK is correctly signed.
+func reportSignatures(apk string, res *apksig.Result) bool {
+	fmt.Printf("APK %s\n", apk)
+	verified := func(ok bool) string {
+		if ok {
+			return "verified"
+		}
+		return "NOT VERIFIED"
+	}
+	if v1 := res.V1; v1 != nil {
+		fmt.Printf(" v1 (JAR): %s\n", verified(v1.Verified()))
+		if v1.Err != nil {
+			fmt.Printf("  error: %v\n", v1.Err)
+		}
+		for _, s := range v1.Signers {
+			fmt.Printf("  signer %s %s\n", s.SignatureFile, s.SignatureBlock)
+			for _, c := range s.Certificates {
+				fmt.Printf("   certificate %s sha256 %x\n", c.Subject, sha256.Sum256(c.Raw))
+			}
+			if s.Err != nil {
+				fmt.Printf("   error: %v\n", s.Err)
+			}
+		}
+		for _, name := range v1.Unsigned {
+			fmt.Printf("  unsigned entry %s\n", name)
+		}
+		for _, name := range v1.Tampered {
+			fmt.Printf("  tampered entry %s\n", name)
+		}
+		for _, name := range v1.ManifestOnly {
+			fmt.Printf("  entry %s only in manifest\n", name)
+		}
+	} else {
+		fmt.Printf(" no v1 (JAR) signature\n")
+	}
+	if res.Block == nil {
+		fmt.Printf(" no APK Signing Block\n")
+	} else {
+		for _, p := range res.Block.Pairs {
+			fmt.Printf(" block 0x%08x (%s) %d bytes\n", p.Id, apksig.BlockIdName(p.Id), len(p.Value))
+		}
+	}
+	for _, s := range res.Schemes {
+		fmt.Printf(" v%d (%s): %s\n", s.Version, apksig.BlockIdName(s.BlockId), verified(s.Verified()))
+		if s.Err != nil {
+			fmt.Printf("  error: %v\n", s.Err)
+		}
+		for i, sg := range s.Signers {
+			fmt.Printf("  signer %d", i)
+			if s.Version >= 3 {
+				fmt.Printf(" sdk %d..%d", sg.MinSdk, sg.MaxSdk)
+			}
+			fmt.Printf("\n")
+			for _, alg := range sg.Algorithms {
+				fmt.Printf("   algorithm %s\n", alg)
+			}
+			for _, c := range sg.Certificates {
+				fmt.Printf("   certificate %s sha256 %x\n", c.Subject, sha256.Sum256(c.Raw))
+			}
+			for _, n := range sg.Lineage {
+				fmt.Printf("   lineage %s flags 0x%x\n", n.Certificate.Subject, n.Flags)
+			}
+			if sg.Err != nil {
+				fmt.Printf("   error: %v\n", sg.Err)
+			}
+		}
+	}
+	if err := res.Consistency(); err != nil {
+		fmt.Printf(" error: %v\n", err)
+	}
+	return res.Verified()
+}
+
+// reportSections prints the map_list of each DEX file, along with
+// any problems found in it, returning false if there were problems.
+func reportSections(apk string, dexes []*dexread.DexFile) bool {
+	ok := true
+	fmt.Printf("APK %s\n", apk)
+	for _, d := range dexes {
+		fmt.Printf(" DEX %s version %03d size %d\n", d.Name(), d.Version(), d.FileSize())
+		sections, err := d.Sections()
+		if err != nil {
+			fmt.Printf("  error: %v\n", err)
+			ok = false
+			continue
+		}
+		fmt.Printf("  %8s %8s %7s  %s\n", "offset", "size", "count", "section")
+		for _, s := range sections {
+			fmt.Printf("  0x%06x %8d %7d  %s\n", s.Offset, s.Size, s.Count, s.Type)
+		}
+		for _, err := range d.CheckSections() {
+			fmt.Printf("  error: %v\n", err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// reportCounts prints method and field counts for each DEX file (and
+// how many more the method_ids and field_ids tables could take),
+// followed by the counts for each package.
+func reportCounts(apk string, dexes []*dexread.DexFile) {
+	fmt.Printf("APK %s\n", apk)
+	var total dexread.MemberCounts
+	for _, d := range dexes {
+		c := d.Counts()
+		total.Add(&c)
+		fmt.Printf(" DEX %s methods %d (%d left) fields %d (%d left) defined methods %d fields %d\n",
+			d.Name(), c.Methods, dexread.MaxMemberIds-c.Methods, c.Fields, dexread.MaxMemberIds-c.Fields,
+			c.DefinedMethods, c.DefinedFields)
+	}
+	fmt.Printf(" total methods %d fields %d defined methods %d fields %d\n",
+		total.Methods, total.Fields, total.DefinedMethods, total.DefinedFields)
+	fmt.Printf("  %8s %8s %8s %8s  %s\n", "methods", "fields", "def-meth", "def-fld", "package")
+	for _, pc := range dexread.CountByPackage(dexes, *depthflag, *treeflag) {
+		name := pc.Package
+		if *treeflag && pc.Depth > 1 {
+			name = strings.Repeat("  ", pc.Depth-1) + name[strings.LastIndex(name, ".")+1:]
+		}
+		if pc.Package == "" {
+			name = "<default>"
+		}
+		fmt.Printf("  %8d %8d %8d %8d  %s\n", pc.Methods, pc.Fields, pc.DefinedMethods, pc.DefinedFields, name)
+	}
+}
+
+// reportSizes prints the size attribution for the DEX files: the
+// shared data of each, then packages, and the largest classes and
+// methods across all of them. Percentages are of the total size.
+func reportSizes(apk string, dexes []*dexread.DexFile) {
+	fmt.Printf("APK %s\n", apk)
+	var reports []*dexread.SizeReport
+	var total uint32
+	for _, d := range dexes {
+		r, err := d.SizeReport()
+		if err != nil {
+			log.Fatal(err)
+		}
+		reports = append(reports, r)
+		total += r.FileSize
+	}
+	row := func(size uint32, what string) {
+		fmt.Printf("  %8d %5.1f%%  %s\n", size, 100*float64(size)/float64(total), what)
+	}
+	for _, r := range reports {
+		fmt.Printf(" DEX %s size %d shared data:\n", r.Dex.Name(), r.FileSize)
+		for _, s := range r.Shared {
+			row(s.Size, s.Name)
+		}
+	}
+	fmt.Printf(" packages:\n")
+	for _, ps := range dexread.SizeByPackage(reports, *depthflag) {
+		name := ps.Package
+		if name == "" {
+			name = "<default>"
+		}
+		row(ps.Size, fmt.Sprintf("%s (%d classes)", name, ps.Classes))
+	}
+	var classes []*dexread.ClassSize
+	var methods []dexread.MethodSize
+	for _, r := range reports {
+		classes = append(classes, r.Classes...)
+		for _, cs := range r.Classes {
+			methods = append(methods, cs.Methods...)
+		}
+	}
+	sort.SliceStable(classes, func(i, j int) bool { return classes[i].Size > classes[j].Size })
+	sort.SliceStable(methods, func(i, j int) bool { return methods[i].Size > methods[j].Size })
+	fmt.Printf(" classes:\n")
+	for i, cs := range classes {
+		if i == *topflag {
+			break
+		}
+		row(cs.Size, cs.Class.Name())
+	}
+	fmt.Printf(" methods:\n")
+	for i, ms := range methods {
+		if i == *topflag {
+			break
+		}
+		row(ms.Size, ms.Method.Id.Signature)
+	}
+}
+
+// reportHierarchy answers the -subclasses, -implementers and
+// -ancestors queries against the class hierarchy of the APK. Classes
+// not defined in the APK are marked as platform or library classes.
+func reportHierarchy(apk string, dexes []*dexread.DexFile) {
+	h := dexread.NewHierarchy(dexes)
+	fmt.Printf("APK %s\n", apk)
+	report := func(what, name string, classes []string) {
+		fmt.Printf(" %s %s:\n", what, dexread.JavaName(dexread.ClassDescriptor(name)))
+		for _, c := range classes {
+			if h.IsExternal(c) {
+				fmt.Printf("  %s (platform or library class)\n", dexread.JavaName(c))
+			} else {
+				fmt.Printf("  %s\n", dexread.JavaName(c))
+			}
+		}
+	}
+	if *subclassesflag != "" {
+		report("subclasses of", *subclassesflag, h.Subclasses(dexread.ClassDescriptor(*subclassesflag)))
+	}
+	if *implementersflag != "" {
+		report("implementers of", *implementersflag, h.Implementers(dexread.ClassDescriptor(*implementersflag)))
+	}
+	if *ancestorsflag != "" {
+		report("ancestors of", *ancestorsflag, h.Ancestors(dexread.ClassDescriptor(*ancestorsflag)))
+	}
+}
+
+// reportXrefs prints the methods that refer to what 'query' names:
+// methods and fields whose "Lclass;->name..." form starts with the
+// query if it contains "->", a string if it is in double quotes (with
+// Go escapes, so "a\nb" is a string with a newline in it), or
+// otherwise a type (a descriptor or Java-style class name). For a
+// type, references to its methods and fields are listed too, since
+// TypeXrefs counts only direct references (const-class, new-instance
+// and so on).
+func reportXrefs(apk string, query string, dexes []*dexread.DexFile) {
+	fmt.Printf("APK %s\n", apk)
+	found := false
+	report := func(d *dexread.DexFile, what string, xrefs []dexread.Xref, err error) {
+		if err != nil {
+			log.Fatal(err)
+		}
+		found = true
+		fmt.Printf(" %s %s: %d references\n", d.Name(), what, len(xrefs))
+		for _, x := range xrefs {
+			m := x.Method
+			var pos string
+			if p, ok := m.Position(x.Offset); ok && p.File != "" {
+				pos = fmt.Sprintf(" (%s:%d)", p.File, p.Line)
+			} else if ok {
+				pos = fmt.Sprintf(" (line %d)", p.Line)
+			}
+			fmt.Printf("  %s %s->%s%s at %04x%s\n", x.Kind, m.Id.Class, m.Id.Name, m.Id.Proto.Descriptor(), x.Offset, pos)
+		}
+	}
+	// members reports the methods and fields that 'match'; with
+	// 'used', only those that are referred to.
+	members := func(d *dexread.DexFile, match func(target string) bool, used bool) {
+		for i := uint32(0); i < uint32(d.NumMethods()); i++ {
+			m := d.Method(i)
+			if target := m.Class + "->" + m.Name + m.Proto.Descriptor(); match(target) {
+				xrefs, err := d.MethodXrefs(i)
+				if !used || len(xrefs) != 0 || err != nil {
+					report(d, "method "+target, xrefs, err)
+				}
+			}
+		}
+		for i := uint32(0); i < uint32(d.NumFields()); i++ {
+			f := d.Field(i)
+			if target := f.Class + "->" + f.Name + ":" + f.Type; match(target) {
+				xrefs, err := d.FieldXrefs(i)
+				if !used || len(xrefs) != 0 || err != nil {
+					report(d, "field "+target, xrefs, err)
+				}
+			}
+		}
+	}
+	quoted := len(query) >= 2 && strings.HasPrefix(query, "\"") && strings.HasSuffix(query, "\"")
+	var text string
+	if quoted {
+		var err error
+		if text, err = strconv.Unquote(query); err != nil {
+			log.Fatalf("bad string %s: %v", query, err)
+		}
+	}
+	for _, d := range dexes {
+		switch {
+		case strings.Contains(query, "->"):
+			members(d, func(target string) bool { return strings.HasPrefix(target, query) }, false)
+		case quoted:
+			for i, s := range d.Strings() {
+				if s == text {
+					xrefs, err := d.StringXrefs(uint32(i))
+					report(d, "string "+strconv.Quote(s), xrefs, err)
+				}
+			}
+		default:
+			desc := query
+			if !strings.HasPrefix(desc, "[") {
+				desc = dexread.ClassDescriptor(query)
+			}
+			for i := uint32(0); i < uint32(d.NumTypes()); i++ {
+				if d.Type(i) == desc {
+					xrefs, err := d.TypeXrefs(i)
+					report(d, "type "+desc, xrefs, err)
+					members(d, func(target string) bool { return strings.HasPrefix(target, desc+"->") }, true)
+				}
+			}
+		}
+	}
+	if !found {
+		fmt.Printf(" nothing matches %s\n", query)
+	}
+}
+
+// parseDexes parses the DEX files in 'path', which is either an APK
+// or (if it ends in ".dex") a DEX file.
+func parseDexes(path string) ([]*dexread.DexFile, error) {
+	if !strings.HasSuffix(path, ".dex") {
+		return apkread.ParseAPK(path, dexread.Options{})
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return dexread.ParseAll(nil, path, f, uint64(fi.Size()), dexread.Options{})
+}
+
+// reportDiff prints the differences between the DEX files of two
+// builds: added (+), removed (-) and changed (~) classes and members,
+// then the packages whose method counts or sizes changed.
+func reportDiff(oldPath, newPath string) {
+	oldDexes, err := parseDexes(oldPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	newDexes, err := parseDexes(newPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	diff, err := dexread.DiffDexes(oldDexes, newDexes, *depthflag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	marks := map[dexread.Change]string{dexread.Added: "+", dexread.Removed: "-", dexread.Changed: "~"}
+	counts := make(map[dexread.Change]int)
+	fmt.Printf("diff %s %s\n", oldPath, newPath)
+	for _, cd := range diff.Classes {
+		counts[cd.Change]++
+		fmt.Printf(" %s class %s\n", marks[cd.Change], dexread.JavaName(cd.Descriptor))
+		for _, d := range cd.Details {
+			fmt.Printf("     %s\n", d)
+		}
+		member := func(kind string, md dexread.MemberDiff) {
+			if md.Details == nil {
+				fmt.Printf("   %s %s %s\n", marks[md.Change], kind, md.Name)
+			} else {
+				fmt.Printf("   %s %s %s: %s\n", marks[md.Change], kind, md.Name, strings.Join(md.Details, ", "))
+			}
+		}
+		for _, md := range cd.Fields {
+			member("field", md)
+		}
+		for _, md := range cd.Methods {
+			member("method", md)
+		}
+	}
+	fmt.Printf(" classes: %d added, %d removed, %d changed\n",
+		counts[dexread.Added], counts[dexread.Removed], counts[dexread.Changed])
+	if len(diff.Packages) == 0 {
+		return
+	}
+	fmt.Printf(" packages:\n")
+	fmt.Printf("  %8s %7s %8s %8s %8s  %s\n", "methods", "delta", "def-meth", "size", "delta", "package")
+	for _, pd := range diff.Packages {
+		name := pd.Package
+		if name == "" {
+			name = "<default>"
+		}
+		fmt.Printf("  %8d %+7d %8d %8d %+8d  %s\n", pd.New.Methods, pd.New.Methods-pd.Old.Methods,
+			pd.New.DefinedMethods, pd.NewSize, int64(pd.NewSize)-int64(pd.OldSize), name)
+	}
+}
+
+// exportTables writes the tables named by 'files' (a comma-separated
+// list of file names, see apkexport.ParseFileName) for the DEX files
+// of 'apk'.
+func exportTables(apk string, files string, dexes []*dexread.DexFile) {
+	e := &apkexport.TableExporter{Vlevel: *verbflag}
+	var outs []*os.File
+	for _, path := range strings.Split(files, ",") {
+		table, format, err := apkexport.ParseFileName(path)
+		if err != nil {
+			usage(err.Error())
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		outs = append(outs, f)
+		e.AddTable(table, f, format)
+	}
+	if err := e.ExportAPK(apk, dexes); err != nil {
+		log.Fatal(err)
+	}
+	for _, f := range outs {
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// addToDatabase adds 'apk' to the SQLite database file 'path', or
+// writes the SQL for doing so to stdout if 'path' is "-".
+func addToDatabase(path string, apk string) {
+	if path == "-" {
+		if err := apksql.WriteScript(os.Stdout, apk); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	added, err := apksql.AddAPK(path, apk)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !added {
+		fmt.Printf("%s is already in %s\n", apk, path)
+	}
+}
+
+// writeProfile writes the size attribution for the DEX files to
+// 'path' as a pprof profile.
+func writeProfile(path string, dexes []*dexread.DexFile) {
+	var reports []*dexread.SizeReport
+	for _, d := range dexes {
+		r, err := d.SizeReport()
+		if err != nil {
+			log.Fatal(err)
+		}
+		reports = append(reports, r)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := sizeprof.Write(f, reports); err != nil {
+		f.Close()
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
 //
 // apkreader main function. Nothing to see here.
 //
@@ -44,16 +522,142 @@ func main() {
 	log.SetPrefix("apkreader: ")
 	flag.Parse()
 	verb(1, "in main")
+	if *diffflag {
+		if flag.NArg() != 2 {
+			usage("-diff takes two input files")
+		}
+		reportDiff(flag.Arg(0), flag.Arg(1))
+		return
+	}
 	if flag.NArg() != 1 {
 		usage("please supply an input APK file")
 	}
-	if !*dumpflag {
-		usage("select one of: -dump")
+	switch *formatflag {
+	case "text":
+	case "json", "ndjson":
+		*dumpflag = true
+	default:
+		usage("-format must be one of: text json ndjson")
+	}
+	hierarchy := *subclassesflag != "" || *implementersflag != "" || *ancestorsflag != ""
+	if !*dumpflag && !*manifestflag && !*verifyflag && !*sectionsflag && !*countflag && !*sizeflag && *pprofflag == "" && *exportflag == "" && *sqliteflag == "" && *xrefflag == "" && !hierarchy {
+		usage("select one of: -dump -manifest -verify -sections -count -size -pprof -export -sqlite -xref -subclasses -implementers -ancestors -diff")
 	}
 	verb(1, "APK is %s", flag.Arg(0))
 
+	// Resource table, if present, for naming resource IDs.
+	res, err := apkread.ReadResources(flag.Arg(0))
+	if err != nil && !errors.Is(err, apkread.ErrNoEntry) {
+		log.Printf("warning: %v", err)
+	}
+
 	if *dumpflag {
-		apkread.ReadAPK(flag.Arg(0), &apkdump.DexApkDumper{Vlevel: *verbflag})
+		var opts dexread.Options
+		switch *integrityflag {
+		case "ignore":
+		case "report":
+			opts.Integrity = dexread.IntegrityReport
+		case "strict":
+			opts.Integrity = dexread.IntegrityStrict
+		default:
+			usage("-integrity must be one of: ignore report strict")
+		}
+		if *formatflag != "text" {
+			w := apkjson.NewJSONWriter(os.Stdout, *formatflag == "ndjson")
+			w.Vlevel = *verbflag
+			if res != nil {
+				w.Resources = res
+			}
+			if err := apkread.ReadAPKWithOptions(flag.Arg(0), w, opts); err != nil {
+				log.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			dumper := &apkdump.DexApkDumper{Vlevel: *verbflag, Kotlin: *kotlinflag}
+			if res != nil {
+				dumper.Resources = res
+			}
+			if err := apkread.ReadAPKWithOptions(flag.Arg(0), dumper, opts); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if *manifestflag {
+		doc, err := apkread.ReadManifest(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if res != nil {
+			doc.Resolve(res)
+		}
+		if err := doc.WriteXML(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *verifyflag {
+		res, err := apksig.Verify(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !reportSignatures(flag.Arg(0), res) {
+			os.Exit(1)
+		}
+	}
+	if *sectionsflag {
+		dexes, err := apkread.ParseAPK(flag.Arg(0), dexread.Options{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !reportSections(flag.Arg(0), dexes) {
+			os.Exit(1)
+		}
+	}
+	if *countflag {
+		dexes, err := apkread.ParseAPK(flag.Arg(0), dexread.Options{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		reportCounts(flag.Arg(0), dexes)
+	}
+	if *sizeflag {
+		dexes, err := apkread.ParseAPK(flag.Arg(0), dexread.Options{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		reportSizes(flag.Arg(0), dexes)
+	}
+	if *pprofflag != "" {
+		dexes, err := apkread.ParseAPK(flag.Arg(0), dexread.Options{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeProfile(*pprofflag, dexes)
+	}
+	if *exportflag != "" {
+		dexes, err := apkread.ParseAPK(flag.Arg(0), dexread.Options{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		exportTables(flag.Arg(0), *exportflag, dexes)
+	}
+	if *sqliteflag != "" {
+		addToDatabase(*sqliteflag, flag.Arg(0))
+	}
+	if *xrefflag != "" {
+		dexes, err := apkread.ParseAPK(flag.Arg(0), dexread.Options{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		reportXrefs(flag.Arg(0), *xrefflag, dexes)
+	}
+	if hierarchy {
+		dexes, err := apkread.ParseAPK(flag.Arg(0), dexread.Options{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		reportHierarchy(flag.Arg(0), dexes)
 	}
 	verb(1, "leaving main")
 }
@@ -0,0 +1,132 @@
+package apksig
+
+import (
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// SignatureAlgorithm is a v2/v3 signature algorithm ID, see
+// https://source.android.com/security/apksigning/v2#signature-algorithm-ids
+type SignatureAlgorithm uint32
+
+const (
+	RSAPSSWithSHA256       SignatureAlgorithm = 0x0101
+	RSAPSSWithSHA512       SignatureAlgorithm = 0x0102
+	RSAPKCS1WithSHA256     SignatureAlgorithm = 0x0103
+	RSAPKCS1WithSHA512     SignatureAlgorithm = 0x0104
+	ECDSAWithSHA256        SignatureAlgorithm = 0x0201
+	ECDSAWithSHA512        SignatureAlgorithm = 0x0202
+	DSAWithSHA256          SignatureAlgorithm = 0x0301
+	VerityRSAPKCS1WithSHA2 SignatureAlgorithm = 0x0421
+	VerityECDSAWithSHA256  SignatureAlgorithm = 0x0423
+	VerityDSAWithSHA256    SignatureAlgorithm = 0x0425
+)
+
+type algorithmInfo struct {
+	name string
+	// hash used for the signature, and for the content digest
+	// (chunked SHA-256 or chunked SHA-512)
+	hash   crypto.Hash
+	digest crypto.Hash
+}
+
+// The verity variants use a Merkle tree digest of the contents,
+// which we do not compute; they are recognized but not verified.
+var algorithms = map[SignatureAlgorithm]algorithmInfo{
+	RSAPSSWithSHA256:       {"RSASSA-PSS with SHA2-256", crypto.SHA256, crypto.SHA256},
+	RSAPSSWithSHA512:       {"RSASSA-PSS with SHA2-512", crypto.SHA512, crypto.SHA512},
+	RSAPKCS1WithSHA256:     {"RSASSA-PKCS1-v1_5 with SHA2-256", crypto.SHA256, crypto.SHA256},
+	RSAPKCS1WithSHA512:     {"RSASSA-PKCS1-v1_5 with SHA2-512", crypto.SHA512, crypto.SHA512},
+	ECDSAWithSHA256:        {"ECDSA with SHA2-256", crypto.SHA256, crypto.SHA256},
+	ECDSAWithSHA512:        {"ECDSA with SHA2-512", crypto.SHA512, crypto.SHA512},
+	DSAWithSHA256:          {"DSA with SHA2-256", crypto.SHA256, crypto.SHA256},
+	VerityRSAPKCS1WithSHA2: {"RSASSA-PKCS1-v1_5 with SHA2-256 (verity)", crypto.SHA256, 0},
+	VerityECDSAWithSHA256:  {"ECDSA with SHA2-256 (verity)", crypto.SHA256, 0},
+	VerityDSAWithSHA256:    {"DSA with SHA2-256 (verity)", crypto.SHA256, 0},
+}
+
+func (a SignatureAlgorithm) String() string {
+	if info, ok := algorithms[a]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("SignatureAlgorithm(0x%04x)", uint32(a))
+}
+
+// supported reports whether we can check both the signature and the
+// content digest for algorithm 'a'.
+func (a SignatureAlgorithm) supported() bool {
+	info, ok := algorithms[a]
+	return ok && info.digest != 0
+}
+
+// verifySignature checks 'sig' over 'data' with public key 'pub'.
+func verifySignature(alg SignatureAlgorithm, pub crypto.PublicKey, data, sig []byte) error {
+	info, ok := algorithms[alg]
+	if !ok {
+		return fmt.Errorf("unknown signature algorithm 0x%04x", uint32(alg))
+	}
+	h := info.hash.New()
+	h.Write(data)
+	hashed := h.Sum(nil)
+
+	switch alg {
+	case RSAPSSWithSHA256, RSAPSSWithSHA512:
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RSA signature with non-RSA key")
+		}
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		return rsa.VerifyPSS(key, info.hash, hashed, sig, opts)
+	case RSAPKCS1WithSHA256, RSAPKCS1WithSHA512, VerityRSAPKCS1WithSHA2:
+		if _, ok := pub.(*rsa.PublicKey); !ok {
+			return errors.New("RSA signature with non-RSA key")
+		}
+	case ECDSAWithSHA256, ECDSAWithSHA512, VerityECDSAWithSHA256:
+		if _, ok := pub.(*ecdsa.PublicKey); !ok {
+			return errors.New("ECDSA signature with non-EC key")
+		}
+	case DSAWithSHA256, VerityDSAWithSHA256:
+		if _, ok := pub.(*dsa.PublicKey); !ok {
+			return errors.New("DSA signature with non-DSA key")
+		}
+	}
+	return verifyDigestSignature(pub, info.hash, hashed, sig)
+}
+
+// verifyDigestSignature checks signature 'sig' over the digest
+// 'hashed' (made with hash 'h'), using whichever of RSA PKCS #1 v1.5,
+// ECDSA or DSA goes with the type of key 'pub'.
+func verifyDigestSignature(pub crypto.PublicKey, h crypto.Hash, hashed, sig []byte) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, h, hashed, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hashed, sig) {
+			return errors.New("ECDSA verification failure")
+		}
+		return nil
+	case *dsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+			return err
+		}
+		// The hash must be truncated to the size of the subgroup
+		// (FIPS 186-3 section 4.6); dsa.Verify does not do this.
+		if n := (key.Q.BitLen() + 7) / 8; len(hashed) > n {
+			hashed = hashed[:n]
+		}
+		if !dsa.Verify(key, hashed, rs.R, rs.S) {
+			return errors.New("DSA verification failure")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type %T", pub)
+}
@@ -0,0 +1,494 @@
+//
+// Package for examining and verifying the signatures on an APK. APK
+// Signature Scheme v2 and v3 signatures live in the APK Signing
+// Block (see block.go), and cover the whole file apart from the
+// block itself. Each scheme's block holds one or more signers, each
+// with signed data (content digests, an X.509 certificate chain and
+// attributes), signatures over that signed data, and the signer's
+// public key. Verify checks all of this, and reports who signed the
+// APK. Older APKs are signed with v1 (JAR) signing instead of, or as
+// well as, v2/v3; Verify checks that too (see jar.go). See
+// https://source.android.com/security/apksigning/v2 and
+// https://source.android.com/security/apksigning/v3 for details.
+//
+package apksig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	// v3 additional attribute holding the proof-of-rotation lineage
+	proofOfRotationAttrId = 0x3ba06f8c
+)
+
+// Signer is one signer from a v2 or v3 signature block. Err is nil
+// if the signer verified correctly. Lineage (v3 only) lists the
+// signing certificates the app has rotated through, oldest first,
+// ending with the current one.
+type Signer struct {
+	Certificates []*x509.Certificate
+	PublicKey    crypto.PublicKey
+	Algorithms   []SignatureAlgorithm
+	MinSdk       uint32
+	MaxSdk       uint32
+	Lineage      []LineageNode
+	Err          error
+
+	digests []signerDigest
+}
+
+// LineageNode is one level of a v3 proof-of-rotation lineage. Flags
+// hold the capabilities granted to the certificate (e.g. whether
+// it may still sign installed data). Algorithm is the one the
+// certificate's key signs the next level with.
+type LineageNode struct {
+	Certificate *x509.Certificate
+	Flags       uint32
+	Algorithm   SignatureAlgorithm
+}
+
+// Scheme holds the result for one signature scheme.
+type Scheme struct {
+	Version int
+	BlockId uint32
+	Signers []*Signer
+	Err     error
+}
+
+// Verified reports whether the scheme is present and every signer
+// checks out.
+func (s *Scheme) Verified() bool {
+	if s.Err != nil || len(s.Signers) == 0 {
+		return false
+	}
+	for _, sg := range s.Signers {
+		if sg.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Result is the outcome of verifying an APK: the v1 result (nil if
+// the APK is not v1 signed), the signing block (nil if there is
+// none) and a Scheme for each signature scheme found in it, in order
+// of version.
+type Result struct {
+	V1      *JarResult
+	Block   *SigningBlock
+	Schemes []*Scheme
+}
+
+// Verified reports whether the APK is signed at all, every scheme
+// that is present verifies, and the schemes are consistent with each
+// other (see Consistency).
+func (r *Result) Verified() bool {
+	if len(r.Schemes) == 0 && r.V1 == nil {
+		return false
+	}
+	if r.V1 != nil && !r.V1.Verified() {
+		return false
+	}
+	for _, s := range r.Schemes {
+		if !s.Verified() {
+			return false
+		}
+	}
+	return r.Consistency() == nil
+}
+
+// Scheme returns the result for signature scheme 'version' (2 or 3),
+// or nil if the APK is not signed with it.
+func (r *Result) Scheme(version int) *Scheme {
+	for _, s := range r.Schemes {
+		if s.Version == version {
+			return s
+		}
+	}
+	return nil
+}
+
+// Verify checks the v1/v2/v3 signatures of the APK file 'apk'.
+func Verify(apk string) (*Result, error) {
+	f, err := os.Open(apk)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return VerifyReader(f, fi.Size())
+}
+
+// VerifyReader checks the signatures of the APK held in 'r' (which is
+// 'size' bytes long). An unsigned APK yields an empty Result rather
+// than an error.
+func VerifyReader(r io.ReaderAt, size int64) (*Result, error) {
+	v1, err := VerifyJar(r, size)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ReadSigningBlock(r, size)
+	if err == ErrNoSigningBlock {
+		return &Result{V1: v1}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{V1: v1, Block: block}
+	for _, s := range []struct {
+		version int
+		id      uint32
+	}{{2, V2BlockId}, {3, V3BlockId}, {3, V31BlockId}} {
+		if value := block.Find(s.id); value != nil {
+			scheme := &Scheme{Version: s.version, BlockId: s.id}
+			scheme.Signers, scheme.Err = parseSigners(value, s.version)
+			res.Schemes = append(res.Schemes, scheme)
+		}
+	}
+
+	// Compute each content digest that some signer needs once, and
+	// check the signers against it.
+	digests := make(map[crypto.Hash][]byte)
+	for _, scheme := range res.Schemes {
+		for _, sg := range scheme.Signers {
+			if sg.Err != nil {
+				continue
+			}
+			for _, d := range sg.digests {
+				want, ok := digests[d.hash]
+				if !ok {
+					if want, err = contentDigest(r, block, d.hash); err != nil {
+						return nil, err
+					}
+					digests[d.hash] = want
+				}
+				if !bytes.Equal(want, d.value) {
+					sg.Err = fmt.Errorf("%s content digest mismatch", d.alg)
+					break
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// lpReader reads the little-endian, length-prefixed structures used
+// throughout the v2/v3 signature blocks.
+type lpReader struct {
+	b []byte
+}
+
+var errTruncated = errors.New("truncated signature block")
+
+func (r *lpReader) u32() (uint32, error) {
+	if len(r.b) < 4 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v, nil
+}
+
+func (r *lpReader) bytes() ([]byte, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(n) > uint64(len(r.b)) {
+		return nil, errTruncated
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v, nil
+}
+
+// sequence returns a reader over the next length-prefixed element.
+func (r *lpReader) sequence() (*lpReader, error) {
+	b, err := r.bytes()
+	return &lpReader{b}, err
+}
+
+func (r *lpReader) done() bool {
+	return len(r.b) == 0
+}
+
+// signerDigest is a content digest from a signer's signed data,
+// checked against the APK contents in VerifyReader.
+type signerDigest struct {
+	alg   SignatureAlgorithm
+	hash  crypto.Hash
+	value []byte
+}
+
+func parseSigners(value []byte, version int) ([]*Signer, error) {
+	outer := &lpReader{value}
+	signers, err := outer.sequence()
+	if err != nil {
+		return nil, err
+	}
+	var retval []*Signer
+	for !signers.done() {
+		sb, err := signers.bytes()
+		if err != nil {
+			return retval, err
+		}
+		sg := &Signer{}
+		sg.Err = sg.parse(sb, version)
+		retval = append(retval, sg)
+	}
+	if len(retval) == 0 {
+		return nil, errors.New("no signers")
+	}
+	return retval, nil
+}
+
+// parse decodes and checks a single signer (everything but the
+// content digests, which need the whole APK).
+func (sg *Signer) parse(b []byte, version int) error {
+	r := &lpReader{b}
+	signedData, err := r.bytes()
+	if err != nil {
+		return err
+	}
+	if version >= 3 {
+		if sg.MinSdk, err = r.u32(); err != nil {
+			return err
+		}
+		if sg.MaxSdk, err = r.u32(); err != nil {
+			return err
+		}
+	}
+	sigs, err := r.sequence()
+	if err != nil {
+		return err
+	}
+	pubBytes, err := r.bytes()
+	if err != nil {
+		return err
+	}
+	if sg.PublicKey, err = x509.ParsePKIXPublicKey(pubBytes); err != nil {
+		return fmt.Errorf("public key: %v", err)
+	}
+
+	// Check every signature that we know how to check.
+	verified := 0
+	for !sigs.done() {
+		s, err := sigs.sequence()
+		if err != nil {
+			return err
+		}
+		v, err := s.u32()
+		if err != nil {
+			return err
+		}
+		sig, err := s.bytes()
+		if err != nil {
+			return err
+		}
+		alg := SignatureAlgorithm(v)
+		sg.Algorithms = append(sg.Algorithms, alg)
+		if !alg.supported() {
+			continue
+		}
+		if err := verifySignature(alg, sg.PublicKey, signedData, sig); err != nil {
+			return fmt.Errorf("%s signature: %v", alg, err)
+		}
+		verified++
+	}
+	if verified == 0 {
+		return errors.New("no supported signatures")
+	}
+	return sg.parseSignedData(signedData, version)
+}
+
+// parseSignedData decodes the (now verified) signed data: digests,
+// certificates, SDK range (v3) and additional attributes.
+func (sg *Signer) parseSignedData(b []byte, version int) error {
+	r := &lpReader{b}
+	digests, err := r.sequence()
+	if err != nil {
+		return err
+	}
+	var digestAlgs []SignatureAlgorithm
+	for !digests.done() {
+		d, err := digests.sequence()
+		if err != nil {
+			return err
+		}
+		v, err := d.u32()
+		if err != nil {
+			return err
+		}
+		value, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		alg := SignatureAlgorithm(v)
+		digestAlgs = append(digestAlgs, alg)
+		if alg.supported() {
+			sg.digests = append(sg.digests, signerDigest{alg, algorithms[alg].digest, value})
+		}
+	}
+	if !sameAlgorithms(digestAlgs, sg.Algorithms) {
+		return errors.New("signature and digest algorithm lists differ")
+	}
+
+	certs, err := r.sequence()
+	if err != nil {
+		return err
+	}
+	for !certs.done() {
+		der, err := certs.bytes()
+		if err != nil {
+			return err
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("certificate: %v", err)
+		}
+		sg.Certificates = append(sg.Certificates, cert)
+	}
+	if len(sg.Certificates) == 0 {
+		return errors.New("no certificates")
+	}
+	if !publicKeyMatches(sg.Certificates[0], sg.PublicKey) {
+		return errors.New("public key does not match first certificate")
+	}
+
+	if version >= 3 {
+		minSdk, err := r.u32()
+		if err != nil {
+			return err
+		}
+		maxSdk, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if minSdk != sg.MinSdk || maxSdk != sg.MaxSdk {
+			return errors.New("SDK range in signed data does not match signer")
+		}
+	}
+
+	attrs, err := r.sequence()
+	if err != nil {
+		return err
+	}
+	for !attrs.done() {
+		a, err := attrs.sequence()
+		if err != nil {
+			return err
+		}
+		id, err := a.u32()
+		if err != nil {
+			return err
+		}
+		if version >= 3 && id == proofOfRotationAttrId {
+			if sg.Lineage, err = parseLineage(a.b); err != nil {
+				return fmt.Errorf("proof-of-rotation: %v", err)
+			}
+			last := sg.Lineage[len(sg.Lineage)-1].Certificate
+			if !bytes.Equal(last.Raw, sg.Certificates[0].Raw) {
+				return errors.New("proof-of-rotation does not end with signing certificate")
+			}
+		}
+	}
+	return nil
+}
+
+func sameAlgorithms(a, b []SignatureAlgorithm) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	as := append([]SignatureAlgorithm{}, a...)
+	bs := append([]SignatureAlgorithm{}, b...)
+	sort.Slice(as, func(i, j int) bool { return as[i] < as[j] })
+	sort.Slice(bs, func(i, j int) bool { return bs[i] < bs[j] })
+	for i := range as {
+		if as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func publicKeyMatches(cert *x509.Certificate, pub crypto.PublicKey) bool {
+	k, ok := pub.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(cert.PublicKey)
+}
+
+// parseLineage decodes a proof-of-rotation struct: a version followed
+// by a sequence of levels, each signed by the key of the level
+// before it, with that level's algorithm. A level's signed data
+// names the algorithm too, and (as apksigner insists) it must match.
+func parseLineage(b []byte) ([]LineageNode, error) {
+	r := &lpReader{b}
+	if _, err := r.u32(); err != nil {
+		return nil, err
+	}
+	var retval []LineageNode
+	var prev *x509.Certificate
+	var prevAlg SignatureAlgorithm
+	for !r.done() {
+		level, err := r.sequence()
+		if err != nil {
+			return nil, err
+		}
+		signedData, err := level.bytes()
+		if err != nil {
+			return nil, err
+		}
+		var node LineageNode
+		if node.Flags, err = level.u32(); err != nil {
+			return nil, err
+		}
+		v, err := level.u32()
+		if err != nil {
+			return nil, err
+		}
+		node.Algorithm = SignatureAlgorithm(v)
+		sig, err := level.bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		sd := &lpReader{signedData}
+		der, err := sd.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if node.Certificate, err = x509.ParseCertificate(der); err != nil {
+			return nil, err
+		}
+		signedAlg, err := sd.u32()
+		if err != nil {
+			return nil, err
+		}
+		if prev != nil {
+			if SignatureAlgorithm(signedAlg) != prevAlg {
+				return nil, fmt.Errorf("level %d: signed algorithm 0x%04x does not match 0x%04x of level %d",
+					len(retval), signedAlg, uint32(prevAlg), len(retval)-1)
+			}
+			if err := verifySignature(prevAlg, prev.PublicKey, signedData, sig); err != nil {
+				return nil, fmt.Errorf("level %d: %v", len(retval), err)
+			}
+		}
+		prev, prevAlg = node.Certificate, node.Algorithm
+		retval = append(retval, node)
+	}
+	if len(retval) == 0 {
+		return nil, errors.New("empty lineage")
+	}
+	return retval, nil
+}
@@ -0,0 +1,115 @@
+package apksig
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var testFiles = map[string]string{
+	"AndroidManifest.xml": "not really a manifest",
+	"classes.dex":         "not really a dex",
+}
+
+func verifyBytes(t *testing.T, apk []byte) *Result {
+	res, err := VerifyReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatalf("VerifyReader error %v", err)
+	}
+	return res
+}
+
+func TestVerifyV2(t *testing.T) {
+	for _, useRSA := range []bool{false, true} {
+		key := newTestKey("Test Signer", useRSA)
+		apk := signAPK(makeZip(testFiles), []testSigner{{key: key}}, nil)
+		res := verifyBytes(t, apk)
+		if !res.Verified() {
+			t.Fatalf("rsa=%v: not verified: %+v", useRSA, res.Scheme(2))
+		}
+		v2 := res.Scheme(2)
+		if v2 == nil || len(v2.Signers) != 1 || res.Scheme(3) != nil {
+			t.Fatalf("rsa=%v: unexpected schemes %+v", useRSA, res.Schemes)
+		}
+		sg := v2.Signers[0]
+		if sg.Certificates[0].Subject.CommonName != "Test Signer" ||
+			len(sg.Algorithms) != 1 || sg.Algorithms[0] != key.alg {
+			t.Errorf("rsa=%v: unexpected signer %+v", useRSA, sg)
+		}
+		if len(res.Block.Pairs) != 1 || res.Block.Pairs[0].Id != V2BlockId {
+			t.Errorf("rsa=%v: unexpected pairs %+v", useRSA, res.Block.Pairs)
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	key := newTestKey("Test Signer", false)
+	apk := signAPK(makeZip(testFiles), []testSigner{{key: key}}, nil)
+	i := bytes.Index(apk, []byte("not really a dex"))
+	apk[i] = 'N'
+	res := verifyBytes(t, apk)
+	if res.Verified() {
+		t.Fatalf("tampered APK verified")
+	}
+	err := res.Scheme(2).Signers[0].Err
+	if err == nil || !strings.Contains(err.Error(), "content digest mismatch") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestVerifyWrongSignature(t *testing.T) {
+	key := newTestKey("Test Signer", false)
+	other := newTestKey("Someone Else", false)
+	apk := signAPK(makeZip(testFiles), []testSigner{{key: key, wrongKey: other}}, nil)
+	res := verifyBytes(t, apk)
+	err := res.Scheme(2).Signers[0].Err
+	if res.Verified() || err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("expected signature failure, got %v", err)
+	}
+}
+
+func TestVerifyV3Lineage(t *testing.T) {
+	oldKey := newTestKey("Old Signer", true)
+	newKey := newTestKey("New Signer", false)
+	apk := signAPK(makeZip(testFiles),
+		[]testSigner{{key: oldKey}},
+		[]testSigner{{key: newKey, lineage: []*testKey{oldKey, newKey}, minSdk: 28, maxSdk: 0x7fffffff}})
+	res := verifyBytes(t, apk)
+	if !res.Verified() {
+		t.Fatalf("not verified: v2 %+v v3 %+v", res.Scheme(2).Signers[0], res.Scheme(3))
+	}
+	sg := res.Scheme(3).Signers[0]
+	if sg.MinSdk != 28 || len(sg.Lineage) != 2 ||
+		sg.Lineage[0].Certificate.Subject.CommonName != "Old Signer" ||
+		sg.Lineage[1].Certificate.Subject.CommonName != "New Signer" {
+		t.Errorf("unexpected v3 signer %+v", sg)
+	}
+
+	// A lineage that does not end in the signing certificate
+	apk = signAPK(makeZip(testFiles), nil,
+		[]testSigner{{key: newKey, lineage: []*testKey{newKey, oldKey}}})
+	res = verifyBytes(t, apk)
+	if res.Verified() || res.Scheme(3).Signers[0].Err == nil {
+		t.Errorf("bad lineage verified")
+	}
+
+	// The second level's signed data names an algorithm other than
+	// the one the first level signs with.
+	apk = signAPK(makeZip(testFiles), nil,
+		[]testSigner{{key: newKey, lineage: []*testKey{oldKey, newKey}, lineageAlg: newKey.alg}})
+	res = verifyBytes(t, apk)
+	if err := res.Scheme(3).Signers[0].Err; res.Verified() || err == nil ||
+		!strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected algorithm mismatch, got %v", err)
+	}
+}
+
+func TestUnsignedAPK(t *testing.T) {
+	res := verifyBytes(t, makeZip(testFiles))
+	if res.Verified() || res.Block != nil || len(res.Schemes) != 0 {
+		t.Errorf("unexpected result for unsigned APK %+v", res)
+	}
+	if _, err := VerifyReader(bytes.NewReader([]byte("not a zip")), 9); err == nil {
+		t.Errorf("expected error for non-ZIP")
+	}
+}
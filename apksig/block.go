@@ -0,0 +1,183 @@
+package apksig
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//
+// Locating the APK Signing Block, which sits between the last ZIP
+// entry and the central directory, see
+// https://source.android.com/security/apksigning/v2#apk-signing-block
+//
+
+// Block IDs within the APK Signing Block
+const (
+	V2BlockId            = 0x7109871a
+	V3BlockId            = 0xf05368c0
+	V31BlockId           = 0x1b93ad61
+	VerityPaddingBlockId = 0x42726577
+	DependencyInfoId     = 0x504b4453
+	SourceStampV1Id      = 0x2b09189e
+	SourceStampV2Id      = 0x6dff800d
+)
+
+var blockIdNames = map[uint32]string{
+	V2BlockId:            "v2 signature",
+	V3BlockId:            "v3 signature",
+	V31BlockId:           "v3.1 signature",
+	VerityPaddingBlockId: "verity padding",
+	DependencyInfoId:     "dependency info",
+	SourceStampV1Id:      "source stamp v1",
+	SourceStampV2Id:      "source stamp v2",
+}
+
+// BlockIdName returns a description of an ID/value pair's ID.
+func BlockIdName(id uint32) string {
+	if n, ok := blockIdNames[id]; ok {
+		return n
+	}
+	return fmt.Sprintf("unknown 0x%08x", id)
+}
+
+const (
+	eocdSize          = 22
+	eocdSig           = 0x06054b50
+	maxCommentSize    = 0xffff
+	blockMagic        = "APK Sig Block 42"
+	blockFooterSize   = 24
+	minBlockSize      = 32
+	eocdCDSizeOff     = 12
+	eocdCDOffsetOff   = 16
+	eocdCommentLenOff = 20
+)
+
+// ErrNoSigningBlock is returned when an APK has no APK Signing Block
+// (for example, one that is signed with v1 (JAR) signing only).
+var ErrNoSigningBlock = errors.New("no APK Signing Block")
+
+// Pair is one ID/value pair from the signing block.
+type Pair struct {
+	Id    uint32
+	Value []byte
+}
+
+// SigningBlock describes the APK Signing Block along with the layout
+// of the ZIP file around it; offsets are from the start of the file.
+type SigningBlock struct {
+	Offset int64
+	Size   int64
+	Pairs  []Pair
+
+	cdOffset, cdSize int64
+	eocdOffset       int64
+	eocd             []byte
+}
+
+// Find returns the value of the first pair with ID 'id', or nil.
+func (b *SigningBlock) Find(id uint32) []byte {
+	for _, p := range b.Pairs {
+		if p.Id == id {
+			return p.Value
+		}
+	}
+	return nil
+}
+
+func readAt(r io.ReaderAt, off int64, n int64) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// findEOCD locates the ZIP End of Central Directory record, which is
+// at the end of the file save for a variable length comment.
+func findEOCD(r io.ReaderAt, size int64) (int64, []byte, error) {
+	if size < eocdSize {
+		return 0, nil, errors.New("file too small to be a ZIP")
+	}
+	n := int64(eocdSize + maxCommentSize)
+	if n > size {
+		n = size
+	}
+	tail, err := readAt(r, size-n, n)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := len(tail) - eocdSize; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) != eocdSig {
+			continue
+		}
+		commentLen := int(binary.LittleEndian.Uint16(tail[i+eocdCommentLenOff:]))
+		if i+eocdSize+commentLen == len(tail) {
+			return size - n + int64(i), tail[i:], nil
+		}
+	}
+	return 0, nil, errors.New("no ZIP End of Central Directory record")
+}
+
+// ReadSigningBlock locates and reads the APK Signing Block of the APK
+// held in 'r' (which is 'size' bytes long). The error is
+// ErrNoSigningBlock if the APK does not have one.
+func ReadSigningBlock(r io.ReaderAt, size int64) (*SigningBlock, error) {
+	eocdOffset, eocd, err := findEOCD(r, size)
+	if err != nil {
+		return nil, err
+	}
+	b := &SigningBlock{
+		eocdOffset: eocdOffset,
+		eocd:       eocd,
+		cdSize:     int64(binary.LittleEndian.Uint32(eocd[eocdCDSizeOff:])),
+		cdOffset:   int64(binary.LittleEndian.Uint32(eocd[eocdCDOffsetOff:])),
+	}
+	if b.cdOffset+b.cdSize != eocdOffset {
+		return nil, errors.New("ZIP central directory not immediately followed by EOCD (ZIP64?)")
+	}
+	if b.cdOffset < minBlockSize {
+		return nil, ErrNoSigningBlock
+	}
+	footer, err := readAt(r, b.cdOffset-blockFooterSize, blockFooterSize)
+	if err != nil {
+		return nil, err
+	}
+	if string(footer[8:]) != blockMagic {
+		return nil, ErrNoSigningBlock
+	}
+
+	// The block size is recorded at both ends, and excludes the
+	// leading size field itself.
+	blockSize := binary.LittleEndian.Uint64(footer)
+	if blockSize < blockFooterSize || blockSize > uint64(b.cdOffset-8) {
+		return nil, fmt.Errorf("APK Signing Block size %d out of range", blockSize)
+	}
+	b.Size = int64(blockSize) + 8
+	b.Offset = b.cdOffset - b.Size
+	block, err := readAt(r, b.Offset, b.Size)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint64(block) != blockSize {
+		return nil, errors.New("APK Signing Block sizes do not match")
+	}
+
+	pairs := block[8 : len(block)-blockFooterSize]
+	for len(pairs) > 0 {
+		if len(pairs) < 8 {
+			return nil, errors.New("truncated APK Signing Block pair")
+		}
+		n := binary.LittleEndian.Uint64(pairs)
+		if n < 4 || n > uint64(len(pairs)-8) {
+			return nil, fmt.Errorf("APK Signing Block pair length %d out of range", n)
+		}
+		b.Pairs = append(b.Pairs, Pair{
+			Id:    binary.LittleEndian.Uint32(pairs[8:]),
+			Value: pairs[12 : 8+n],
+		})
+		pairs = pairs[8+n:]
+	}
+	return b, nil
+}
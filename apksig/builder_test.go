@@ -0,0 +1,322 @@
+package apksig
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"strings"
+	"time"
+)
+
+//
+// A minimal v2/v3 APK signer for unit tests, so that we can produce
+// correctly (and incorrectly) signed APKs without apksigner.
+//
+
+type testKey struct {
+	priv crypto.Signer
+	cert *x509.Certificate
+	alg  SignatureAlgorithm
+}
+
+var serial int64
+
+func newTestKey(cn string, useRSA bool) *testKey {
+	k := &testKey{alg: ECDSAWithSHA256}
+	var err error
+	if useRSA {
+		k.priv, err = rsa.GenerateKey(rand.Reader, 2048)
+		k.alg = RSAPKCS1WithSHA256
+	} else {
+		k.priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		panic(err)
+	}
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).AddDate(100, 0, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, k.priv.Public(), k.priv)
+	if err != nil {
+		panic(err)
+	}
+	if k.cert, err = x509.ParseCertificate(der); err != nil {
+		panic(err)
+	}
+	return k
+}
+
+func (k *testKey) sign(data []byte) []byte {
+	var opts crypto.SignerOpts = crypto.SHA256
+	h := sha256.Sum256(data)
+	sig, err := k.priv.Sign(rand.Reader, h[:], opts)
+	if err != nil {
+		panic(err)
+	}
+	return sig
+}
+
+// Length-prefixed encoding helpers
+func lp(parts ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, p := range parts {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(p)))
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
+
+func u32(v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return b[:]
+}
+
+func cat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func makeZip(files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"AndroidManifest.xml", "classes.dex", "res/raw/data.txt"} {
+		if content, ok := files[name]; ok {
+			f, _ := w.Create(name)
+			f.Write([]byte(content))
+		}
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+type testSigner struct {
+	key *testKey
+	// signature made with this key instead, if set
+	wrongKey *testKey
+	lineage  []*testKey
+	minSdk   uint32
+	maxSdk   uint32
+
+	// algorithm claimed for the previous level in the lineage's
+	// signed data, if set
+	lineageAlg SignatureAlgorithm
+}
+
+// lineageBytes builds a proof-of-rotation struct in which each key
+// signs the next. As with apksigner, a level's signed data holds the
+// previous level's algorithm (or 'parentAlg', if set), and the level
+// itself its own.
+func lineageBytes(keys []*testKey, parentAlg SignatureAlgorithm) []byte {
+	out := u32(1)
+	for i, k := range keys {
+		var sig []byte
+		var alg SignatureAlgorithm
+		if i > 0 {
+			alg = keys[i-1].alg
+			if parentAlg != 0 {
+				alg = parentAlg
+			}
+		}
+		signedData := cat(lp(k.cert.Raw), u32(uint32(alg)))
+		if i > 0 {
+			sig = keys[i-1].sign(signedData)
+		}
+		out = cat(out, lp(cat(lp(signedData), u32(0), u32(uint32(k.alg)), lp(sig))))
+	}
+	return out
+}
+
+func signerBlock(s testSigner, version int, digest []byte) []byte {
+	k := s.key
+	var attrs []byte
+	if s.lineage != nil {
+		attrs = lp(cat(u32(proofOfRotationAttrId), lineageBytes(s.lineage, s.lineageAlg)))
+	}
+	digests := lp(lp(cat(u32(uint32(k.alg)), lp(digest))))
+	signedData := cat(digests, lp(lp(k.cert.Raw)))
+	if version >= 3 {
+		signedData = cat(signedData, u32(s.minSdk), u32(s.maxSdk))
+	}
+	signedData = cat(signedData, lp(attrs))
+	signingKey := k
+	if s.wrongKey != nil {
+		signingKey = s.wrongKey
+	}
+	sigs := lp(lp(cat(u32(uint32(k.alg)), lp(signingKey.sign(signedData)))))
+	pub, err := x509.MarshalPKIXPublicKey(k.priv.Public())
+	if err != nil {
+		panic(err)
+	}
+	out := lp(signedData)
+	if version >= 3 {
+		out = cat(out, u32(s.minSdk), u32(s.maxSdk))
+	}
+	return cat(out, sigs, lp(pub))
+}
+
+// signAPK inserts an APK Signing Block with the given v2 and v3
+// signers into the (unsigned) ZIP file 'z'.
+func signAPK(z []byte, v2, v3 []testSigner) []byte {
+	eocdOffset, eocd, err := findEOCD(bytes.NewReader(z), int64(len(z)))
+	if err != nil {
+		panic(err)
+	}
+	cdOffset := int64(binary.LittleEndian.Uint32(eocd[eocdCDOffsetOff:]))
+
+	// The content digest of the signed APK is the same as that of
+	// the unsigned one, treating the block as zero-length.
+	b := &SigningBlock{Offset: cdOffset, cdOffset: cdOffset,
+		cdSize: eocdOffset - cdOffset, eocd: eocd}
+	digest, err := contentDigest(bytes.NewReader(z), b, crypto.SHA256)
+	if err != nil {
+		panic(err)
+	}
+
+	var pairs []byte
+	pair := func(id uint32, signers []testSigner, version int) {
+		var value []byte
+		for _, s := range signers {
+			value = cat(value, lp(signerBlock(s, version, digest)))
+		}
+		v := cat(u32(id), lp(value))
+		var n [8]byte
+		binary.LittleEndian.PutUint64(n[:], uint64(len(v)))
+		pairs = cat(pairs, n[:], v)
+	}
+	if v2 != nil {
+		pair(V2BlockId, v2, 2)
+	}
+	if v3 != nil {
+		pair(V3BlockId, v3, 3)
+	}
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(pairs)+blockFooterSize))
+	block := cat(size[:], pairs, size[:], []byte(blockMagic))
+
+	newEOCD := append([]byte{}, eocd...)
+	binary.LittleEndian.PutUint32(newEOCD[eocdCDOffsetOff:], uint32(cdOffset)+uint32(len(block)))
+	return cat(z[:cdOffset], block, z[cdOffset:eocdOffset], newEOCD)
+}
+
+// A v1 (JAR) signer. 'digested' gives the entry contents that go
+// into the manifest, and 'actual' what ends up in the APK, so that
+// tests can make the two disagree.
+type jarOptions struct {
+	apkSigned string
+	// leave out the whole-manifest digest, so that the signature
+	// file sections are checked one by one
+	perSection bool
+	// sign with authenticated attributes
+	authAttrs bool
+	// main manifest attribute added after signing
+	extraMain string
+}
+
+type namedFile struct {
+	name, content string
+}
+
+func b64(h crypto.Hash, data []byte) string {
+	d := h.New()
+	d.Write(data)
+	return base64.StdEncoding.EncodeToString(d.Sum(nil))
+}
+
+func signJar(digested, actual []namedFile, key *testKey, opts jarOptions) []byte {
+	manifest := "Manifest-Version: 1.0\r\nCreated-By: test\r\n\r\n"
+	sf := "Signature-Version: 1.0\r\nCreated-By: test\r\n"
+	var sections []string
+	for _, f := range digested {
+		sec := "Name: " + f.name + "\r\nSHA-256-Digest: " + b64(crypto.SHA256, []byte(f.content)) + "\r\n\r\n"
+		manifest += sec
+		sections = append(sections, "Name: "+f.name+"\r\nSHA-256-Digest: "+b64(crypto.SHA256, []byte(sec))+"\r\n\r\n")
+	}
+	if !opts.perSection {
+		sf += "SHA-256-Digest-Manifest: " + b64(crypto.SHA256, []byte(manifest)) + "\r\n"
+	}
+	sf += "SHA-256-Digest-Manifest-Main-Attributes: " + b64(crypto.SHA256, []byte(manifest[:strings.Index(manifest, "\r\n\r\n")+4])) + "\r\n"
+	if opts.extraMain != "" {
+		manifest = strings.Replace(manifest, "\r\n\r\n", "\r\n"+opts.extraMain+"\r\n\r\n", 1)
+	}
+	if opts.apkSigned != "" {
+		sf += "X-Android-APK-Signed: " + opts.apkSigned + "\r\n"
+	}
+	sf += "\r\n" + strings.Join(sections, "")
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	add := func(name string, data []byte) {
+		f, _ := w.Create(name)
+		f.Write(data)
+	}
+	add("META-INF/MANIFEST.MF", []byte(manifest))
+	add("META-INF/CERT.SF", []byte(sf))
+	add("META-INF/CERT.RSA", pkcs7Sign(key, []byte(sf), opts.authAttrs))
+	for _, f := range actual {
+		add(f.name, []byte(f.content))
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func mustMarshal(v interface{}, params string) []byte {
+	b, err := asn1.MarshalWithParams(v, params)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// pkcs7Sign makes a detached PKCS #7 SignedData signature over
+// 'content' with SHA-256.
+func pkcs7Sign(key *testKey, content []byte, authAttrs bool) []byte {
+	sha256OID := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}}
+	si := pkcs7SignerInfo{
+		Version: 1,
+		IssuerAndSerial: pkcs7IssuerAndSerial{
+			Issuer: asn1.RawValue{FullBytes: key.cert.RawIssuer},
+			Serial: key.cert.SerialNumber,
+		},
+		DigestAlgorithm:           sha256OID,
+		DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+	}
+	signed := content
+	if authAttrs {
+		md := sha256.Sum256(content)
+		attr := pkcs7Attribute{
+			Type:   oidMessageDigest,
+			Values: asn1.RawValue{Class: 0, Tag: 17, IsCompound: true, Bytes: mustMarshal(md[:], "")},
+		}
+		set := mustMarshal([]pkcs7Attribute{attr}, "set")
+		signed = set
+		si.AuthenticatedAttributes = asn1.RawValue{FullBytes: append([]byte{0xa0}, set[1:]...)}
+	}
+	si.EncryptedDigest = key.sign(signed)
+
+	sd := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256OID},
+		ContentInfo:      asn1.RawValue{FullBytes: mustMarshal(struct{ T asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}}, "")},
+		Certificates:     asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: key.cert.Raw},
+		SignerInfos:      []pkcs7SignerInfo{si},
+	}
+	return mustMarshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: mustMarshal(sd, "")},
+	}, "")
+}
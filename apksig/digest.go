@@ -0,0 +1,68 @@
+package apksig
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"io"
+)
+
+//
+// Content digests for v2/v3 signing: the APK is split into three
+// sections (ZIP entries, central directory, and End of Central
+// Directory), each section into 1 MiB chunks, and the digest is
+// taken over the digests of the chunks, see
+// https://source.android.com/security/apksigning/v2#integrity-protected-contents
+//
+
+const chunkSize = 1 << 20
+
+// contentDigest computes the chunked digest of the APK using hash 'h'.
+// The EOCD is digested as if the central directory offset pointed at
+// the start of the signing block, since that is how it was when the
+// APK was signed.
+func contentDigest(r io.ReaderAt, b *SigningBlock, h crypto.Hash) ([]byte, error) {
+	eocd := append([]byte{}, b.eocd...)
+	binary.LittleEndian.PutUint32(eocd[eocdCDOffsetOff:], uint32(b.Offset))
+
+	type section struct {
+		r    io.ReaderAt
+		size int64
+	}
+	sections := []section{
+		{r, b.Offset},
+		{io.NewSectionReader(r, b.cdOffset, b.cdSize), b.cdSize},
+		{bytes.NewReader(eocd), int64(len(eocd))},
+	}
+
+	var chunks int64
+	for _, s := range sections {
+		chunks += (s.size + chunkSize - 1) / chunkSize
+	}
+	var hdr [5]byte
+	var digests []byte
+	buf := make([]byte, chunkSize)
+	for _, s := range sections {
+		for off := int64(0); off < s.size; off += chunkSize {
+			n := s.size - off
+			if n > chunkSize {
+				n = chunkSize
+			}
+			if _, err := s.r.ReadAt(buf[:n], off); err != nil {
+				return nil, err
+			}
+			hdr[0] = 0xa5
+			binary.LittleEndian.PutUint32(hdr[1:], uint32(n))
+			d := h.New()
+			d.Write(hdr[:])
+			d.Write(buf[:n])
+			digests = d.Sum(digests)
+		}
+	}
+	hdr[0] = 0x5a
+	binary.LittleEndian.PutUint32(hdr[1:], uint32(chunks))
+	d := h.New()
+	d.Write(hdr[:])
+	d.Write(digests)
+	return d.Sum(nil), nil
+}
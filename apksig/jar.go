@@ -0,0 +1,283 @@
+package apksig
+
+import (
+	"archive/zip"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// v1 (JAR) signature verification. MANIFEST.MF lists a digest for
+// each entry of the APK; each signer has a signature file (*.SF) with
+// digests of the manifest (whole, or section by section), and a
+// PKCS #7 signature block (*.RSA, *.DSA or *.EC) over the signature
+// file. See
+// https://docs.oracle.com/javase/8/docs/technotes/guides/jar/jar.html#Signed_JAR_File
+//
+
+const jarManifestName = "META-INF/MANIFEST.MF"
+
+// JarSigner is one v1 signer: the signature file and signature block
+// it comes from, and its certificates (signing certificate first).
+// APKSigned lists the schemes named in the signature file's
+// X-Android-APK-Signed attribute, which says that the APK was also
+// signed with those (newer) schemes; it protects against a v2/v3
+// signature being stripped. Err is nil if the signer verified.
+type JarSigner struct {
+	SignatureFile  string
+	SignatureBlock string
+	Certificates   []*x509.Certificate
+	APKSigned      []int
+	Err            error
+}
+
+// JarResult is the outcome of v1 verification. Unsigned lists entries
+// that no signer covers, Tampered those whose contents do not match
+// the manifest digest, and ManifestOnly those that appear in the
+// manifest but not in the APK.
+type JarResult struct {
+	Signers      []*JarSigner
+	Unsigned     []string
+	Tampered     []string
+	ManifestOnly []string
+	Err          error
+}
+
+// Verified reports whether the APK is v1 signed, every signer checks
+// out, and every entry is signed and intact.
+func (r *JarResult) Verified() bool {
+	if r.Err != nil || len(r.Signers) == 0 || len(r.Unsigned) != 0 ||
+		len(r.Tampered) != 0 || len(r.ManifestOnly) != 0 {
+		return false
+	}
+	for _, s := range r.Signers {
+		if s.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// isSignatureEntry reports whether 'name' is part of the v1 signature
+// itself (and so is not listed in the manifest).
+func isSignatureEntry(name string) bool {
+	if !strings.HasPrefix(name, "META-INF/") || strings.Contains(name[len("META-INF/"):], "/") {
+		return false
+	}
+	if name == jarManifestName {
+		return true
+	}
+	switch strings.ToUpper(path.Ext(name)) {
+	case ".SF", ".RSA", ".DSA", ".EC":
+		return true
+	}
+	return false
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// VerifyJar checks the v1 signature of the APK held in 'r' (which is
+// 'size' bytes long). It returns nil if the APK has no v1 signature.
+// Like Android, it rejects an APK with two entries of the same name,
+// since a tool that reads the first could be shown different content
+// from one that reads the last.
+func VerifyJar(r io.ReaderAt, size int64) (*JarResult, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File)
+	var sigFiles []string
+	for _, f := range z.File {
+		if files[f.Name] != nil {
+			return nil, fmt.Errorf("duplicate entry %s", f.Name)
+		}
+		files[f.Name] = f
+		if isSignatureEntry(f.Name) && strings.ToUpper(path.Ext(f.Name)) == ".SF" {
+			sigFiles = append(sigFiles, f.Name)
+		}
+	}
+	mf := files[jarManifestName]
+	if mf == nil || len(sigFiles) == 0 {
+		return nil, nil
+	}
+	sort.Strings(sigFiles)
+
+	res := &JarResult{}
+	manifest, err := readZipEntry(mf)
+	if err != nil {
+		res.Err = err
+		return res, nil
+	}
+	mfMain, sections := parseJarManifest(manifest)
+
+	// Entries covered by at least one verified signer
+	covered := make(map[string]bool)
+	for _, sf := range sigFiles {
+		s := verifyJarSigner(files, sf, manifest, mfMain, sections)
+		res.Signers = append(res.Signers, s.JarSigner)
+		for name := range s.covered {
+			covered[name] = true
+		}
+	}
+
+	listed := make(map[string]bool)
+	for i := range sections {
+		sec := &sections[i]
+		name := sec.name()
+		listed[name] = true
+		f := files[name]
+		if f == nil {
+			res.ManifestOnly = append(res.ManifestOnly, name)
+			continue
+		}
+		ds := digests(sec.attrs, "-Digest")
+		data, err := readZipEntry(f)
+		if err != nil || !digestsMatch(ds, data) {
+			res.Tampered = append(res.Tampered, name)
+		}
+	}
+	for _, f := range z.File {
+		if strings.HasSuffix(f.Name, "/") || isSignatureEntry(f.Name) {
+			continue
+		}
+		if !listed[f.Name] || !covered[f.Name] {
+			res.Unsigned = append(res.Unsigned, f.Name)
+		}
+	}
+	return res, nil
+}
+
+type jarSignerState struct {
+	*JarSigner
+	covered map[string]bool
+}
+
+// verifyJarSigner checks signature file 'sf' and its signature block,
+// and works out which manifest entries it covers.
+func verifyJarSigner(files map[string]*zip.File, sf string, manifest []byte, mfMain jarSection, sections []jarSection) jarSignerState {
+	s := jarSignerState{JarSigner: &JarSigner{SignatureFile: sf}, covered: map[string]bool{}}
+	sfData, err := readZipEntry(files[sf])
+	if err != nil {
+		s.Err = err
+		return s
+	}
+	base := strings.TrimSuffix(sf, path.Ext(sf))
+	var block []byte
+	for _, ext := range []string{".RSA", ".DSA", ".EC"} {
+		if f := files[base+ext]; f != nil {
+			s.SignatureBlock = f.Name
+			block, err = readZipEntry(f)
+			break
+		}
+	}
+	if s.SignatureBlock == "" {
+		s.Err = errors.New("no signature block")
+		return s
+	}
+	if err != nil {
+		s.Err = err
+		return s
+	}
+	if s.Certificates, err = verifyPKCS7(block, sfData); err != nil {
+		s.Err = fmt.Errorf("signature block %s: %v", s.SignatureBlock, err)
+		return s
+	}
+
+	sfMain, sfSections := parseJarManifest(sfData)
+	for _, v := range strings.Split(sfMain.attrs["X-Android-APK-Signed"], ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			s.APKSigned = append(s.APKSigned, n)
+		}
+	}
+
+	// If the digest of the whole manifest matches, everything in it
+	// is covered; otherwise check section by section, after the main
+	// attributes if the signature file has a digest of them.
+	if digestsMatch(digests(sfMain.attrs, "-Digest-Manifest"), manifest) {
+		for i := range sections {
+			s.covered[sections[i].name()] = true
+		}
+		return s
+	}
+	if ds := digests(sfMain.attrs, "-Digest-Manifest-Main-Attributes"); len(ds) != 0 && !digestsMatch(ds, mfMain.raw) {
+		s.Err = errors.New("manifest main attributes digest does not match")
+		return s
+	}
+	bySection := make(map[string]*jarSection)
+	for i := range sections {
+		bySection[sections[i].name()] = &sections[i]
+	}
+	for i := range sfSections {
+		name := sfSections[i].name()
+		sec := bySection[name]
+		if sec == nil {
+			continue
+		}
+		if digestsMatch(digests(sfSections[i].attrs, "-Digest"), sec.raw) {
+			s.covered[name] = true
+		}
+	}
+	return s
+}
+
+// sameSigners reports whether the v1 signers and the signers of a
+// v2/v3 scheme use the same set of signing certificates.
+func sameSigners(v1 []*JarSigner, scheme *Scheme) bool {
+	set := func(certs []*x509.Certificate, m map[string]bool) {
+		if len(certs) != 0 {
+			m[string(certs[0].Raw)] = true
+		}
+	}
+	a, b := map[string]bool{}, map[string]bool{}
+	for _, s := range v1 {
+		set(s.Certificates, a)
+	}
+	for _, s := range scheme.Signers {
+		set(s.Certificates, b)
+	}
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// Consistency checks the schemes against each other: the v1 and v2
+// signers must agree, and any scheme that the v1 signature files
+// claim (via X-Android-APK-Signed) must be present.
+func (r *Result) Consistency() error {
+	if r.V1 == nil {
+		return nil
+	}
+	for _, s := range r.V1.Signers {
+		for _, v := range s.APKSigned {
+			if r.Scheme(v) == nil {
+				return fmt.Errorf("%s claims v%d signing, but there is no v%d signature (stripped?)",
+					s.SignatureFile, v, v)
+			}
+		}
+	}
+	if v2 := r.Scheme(2); v2 != nil && !sameSigners(r.V1.Signers, v2) {
+		return errors.New("v1 and v2 signers differ")
+	}
+	return nil
+}
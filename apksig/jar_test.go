@@ -0,0 +1,106 @@
+package apksig
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var jarFiles = []namedFile{
+	{"AndroidManifest.xml", "not really a manifest"},
+	{"classes.dex", "not really a dex"},
+}
+
+func TestVerifyV1(t *testing.T) {
+	key := newTestKey("V1 Signer", true)
+	for _, opts := range []jarOptions{{}, {perSection: true}, {authAttrs: true}} {
+		res := verifyBytes(t, signJar(jarFiles, jarFiles, key, opts))
+		if !res.Verified() || res.V1 == nil || res.Block != nil {
+			t.Fatalf("%+v: not verified: %+v", opts, res.V1)
+		}
+		if len(res.V1.Signers) != 1 ||
+			res.V1.Signers[0].SignatureBlock != "META-INF/CERT.RSA" ||
+			res.V1.Signers[0].Certificates[0].Subject.CommonName != "V1 Signer" {
+			t.Errorf("%+v: unexpected signers %+v", opts, res.V1.Signers[0])
+		}
+	}
+}
+
+func TestVerifyV1Problems(t *testing.T) {
+	key := newTestKey("V1 Signer", true)
+	tampered := []namedFile{jarFiles[0], {"classes.dex", "tampered dex"}}
+	extra := append(append([]namedFile{}, jarFiles...), namedFile{"assets/extra", "sneaky"})
+	for _, tc := range []struct {
+		digested, actual []namedFile
+		check            func(r *JarResult) bool
+	}{
+		{jarFiles, tampered, func(r *JarResult) bool {
+			return len(r.Tampered) == 1 && r.Tampered[0] == "classes.dex"
+		}},
+		{jarFiles, extra, func(r *JarResult) bool {
+			return len(r.Unsigned) == 1 && r.Unsigned[0] == "assets/extra"
+		}},
+		{extra, jarFiles, func(r *JarResult) bool {
+			return len(r.ManifestOnly) == 1 && r.ManifestOnly[0] == "assets/extra"
+		}},
+	} {
+		res := verifyBytes(t, signJar(tc.digested, tc.actual, key, jarOptions{}))
+		if res.Verified() || !tc.check(res.V1) {
+			t.Errorf("unexpected result %+v", res.V1)
+		}
+	}
+
+	// Main attributes changed after signing: the whole-manifest
+	// digest no longer matches and the per-section fallback must not
+	// hide the change.
+	for _, opts := range []jarOptions{{extraMain: "Class-Path: evil.jar"}, {perSection: true, extraMain: "Class-Path: evil.jar"}} {
+		res := verifyBytes(t, signJar(jarFiles, jarFiles, key, opts))
+		if res.Verified() || res.V1.Signers[0].Err == nil ||
+			!strings.Contains(res.V1.Signers[0].Err.Error(), "main attributes") {
+			t.Errorf("%+v: tampered main attributes verified: %+v", opts, res.V1.Signers[0])
+		}
+	}
+
+	// Signature file signed by someone other than the certificate
+	other := newTestKey("Other", true)
+	impostor := &testKey{priv: other.priv, cert: key.cert}
+	res := verifyBytes(t, signJar(jarFiles, jarFiles, impostor, jarOptions{}))
+	if res.Verified() || res.V1.Signers[0].Err == nil {
+		t.Errorf("bad signature block verified")
+	}
+}
+
+func TestVerifyV1DuplicateEntry(t *testing.T) {
+	// The manifest digests the first classes.dex, but something
+	// reading the last one would get the second.
+	key := newTestKey("V1 Signer", true)
+	dup := append(append([]namedFile{}, jarFiles...), namedFile{"classes.dex", "evil dex"})
+	apk := signJar(jarFiles, dup, key, jarOptions{})
+	_, err := VerifyReader(bytes.NewReader(apk), int64(len(apk)))
+	if err == nil || err.Error() != "duplicate entry classes.dex" {
+		t.Errorf("expected duplicate entry error, got %v", err)
+	}
+}
+
+func TestV1V2Consistency(t *testing.T) {
+	key := newTestKey("Signer", false)
+	other := newTestKey("Other", false)
+
+	// v1 claims v2, but there is no v2 block
+	res := verifyBytes(t, signJar(jarFiles, jarFiles, key, jarOptions{apkSigned: "2"}))
+	if err := res.Consistency(); res.Verified() || err == nil ||
+		!strings.Contains(err.Error(), "stripped") {
+		t.Errorf("expected stripping error, got %v", err)
+	}
+
+	v1 := signJar(jarFiles, jarFiles, key, jarOptions{apkSigned: "2"})
+	res = verifyBytes(t, signAPK(v1, []testSigner{{key: key}}, nil))
+	if !res.Verified() {
+		t.Errorf("v1+v2 not verified: %v %+v", res.Consistency(), res.V1)
+	}
+	res = verifyBytes(t, signAPK(v1, []testSigner{{key: other}}, nil))
+	if err := res.Consistency(); res.Verified() || err == nil ||
+		err.Error() != "v1 and v2 signers differ" {
+		t.Errorf("expected signer mismatch, got %v", err)
+	}
+}
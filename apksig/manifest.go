@@ -0,0 +1,126 @@
+package apksig
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	"encoding/base64"
+	"strings"
+)
+
+//
+// Parsing of JAR manifests (META-INF/MANIFEST.MF) and signature files
+// (META-INF/*.SF), see
+// https://docs.oracle.com/javase/8/docs/technotes/guides/jar/jar.html#JAR_Manifest
+//
+
+// jarSection is one section of a manifest: its attributes, along
+// with the raw bytes of the section (including the blank line that
+// ends it), which is what the signature file digests.
+type jarSection struct {
+	attrs map[string]string
+	raw   []byte
+}
+
+func (s *jarSection) name() string {
+	return s.attrs["Name"]
+}
+
+// parseJarManifest splits 'data' into the main section and the
+// per-entry sections that follow it. Lines are terminated by CRLF or
+// LF; a line starting with a single space continues the previous one.
+func parseJarManifest(data []byte) (main jarSection, entries []jarSection) {
+	cur := jarSection{attrs: map[string]string{}}
+	start := 0
+	last := ""
+	first := true
+	finish := func(end int) {
+		cur.raw = data[start:end]
+		if first {
+			main = cur
+			first = false
+		} else if len(cur.attrs) != 0 {
+			entries = append(entries, cur)
+		}
+		cur = jarSection{attrs: map[string]string{}}
+		start = end
+		last = ""
+	}
+	pos := 0
+	for pos < len(data) {
+		end := bytes.IndexByte(data[pos:], '\n')
+		next := len(data)
+		if end >= 0 {
+			next = pos + end + 1
+		}
+		line := strings.TrimRight(string(data[pos:next]), "\r\n")
+		switch {
+		case line == "":
+			finish(next)
+		case line[0] == ' ' && last != "":
+			cur.attrs[last] += line[1:]
+		default:
+			if i := strings.Index(line, ": "); i > 0 {
+				last = line[:i]
+				cur.attrs[last] = line[i+2:]
+			}
+		}
+		pos = next
+	}
+	if start < len(data) || first {
+		finish(len(data))
+	}
+	return main, entries
+}
+
+// Digest algorithm names as they appear in attribute names such as
+// "SHA-256-Digest".
+var jarDigestNames = map[string]crypto.Hash{
+	"MD5":     crypto.MD5,
+	"SHA1":    crypto.SHA1,
+	"SHA-1":   crypto.SHA1,
+	"SHA-256": crypto.SHA256,
+	"SHA-384": crypto.SHA384,
+	"SHA-512": crypto.SHA512,
+}
+
+type jarDigest struct {
+	hash  crypto.Hash
+	value []byte
+}
+
+// digests returns the digests in 'attrs' whose attribute names end
+// in 'suffix' (e.g. "-Digest" or "-Digest-Manifest"), skipping any we
+// do not know.
+func digests(attrs map[string]string, suffix string) []jarDigest {
+	var retval []jarDigest
+	for k, v := range attrs {
+		if !strings.HasSuffix(k, suffix) {
+			continue
+		}
+		h, ok := jarDigestNames[strings.TrimSuffix(k, suffix)]
+		if !ok || !h.Available() {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			continue
+		}
+		retval = append(retval, jarDigest{h, value})
+	}
+	return retval
+}
+
+// digestsMatch reports whether 'data' matches every digest in 'ds'
+// (and there is at least one).
+func digestsMatch(ds []jarDigest, data []byte) bool {
+	for _, d := range ds {
+		h := d.hash.New()
+		h.Write(data)
+		if !bytes.Equal(h.Sum(nil), d.value) {
+			return false
+		}
+	}
+	return len(ds) != 0
+}
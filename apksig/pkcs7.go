@@ -0,0 +1,142 @@
+package apksig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+//
+// Just enough PKCS #7 (RFC 2315) to check the detached SignedData
+// signature blocks (META-INF/*.RSA, *.DSA, *.EC) of v1 signed APKs.
+//
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+var digestOIDs = map[string]crypto.Hash{
+	"1.2.840.113549.2.5":     crypto.MD5,
+	"1.3.14.3.2.26":          crypto.SHA1,
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerial           pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// verifyPKCS7 checks the detached PKCS #7 signature 'block' over
+// 'content', returning the certificates of the signer (the signing
+// certificate first).
+func verifyPKCS7(block, content []byte) ([]*x509.Certificate, error) {
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(block, &ci); err != nil {
+		return nil, err
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("not PKCS #7 SignedData")
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("no SignerInfo")
+	}
+
+	// APKs have a single signer per signature block.
+	si := sd.SignerInfos[0]
+	var cert *x509.Certificate
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerial.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(si.IssuerAndSerial.Serial) == 0 {
+			cert = c
+			break
+		}
+	}
+	if cert == nil {
+		return nil, errors.New("signing certificate not found")
+	}
+	h, ok := digestOIDs[si.DigestAlgorithm.Algorithm.String()]
+	if !ok || !h.Available() {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", si.DigestAlgorithm.Algorithm)
+	}
+
+	// With authenticated attributes, the signature is over the
+	// attributes (re-tagged as a SET), and one of them holds the
+	// digest of the content.
+	signed := content
+	if len(si.AuthenticatedAttributes.FullBytes) != 0 {
+		signed = append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+		var attrs []pkcs7Attribute
+		if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+			return nil, err
+		}
+		var md []byte
+		for _, a := range attrs {
+			if a.Type.Equal(oidMessageDigest) {
+				asn1.Unmarshal(a.Values.Bytes, &md)
+			}
+		}
+		d := h.New()
+		d.Write(content)
+		if md == nil || !bytes.Equal(md, d.Sum(nil)) {
+			return nil, errors.New("message digest attribute does not match")
+		}
+	}
+
+	d := h.New()
+	d.Write(signed)
+	if err := verifyDigestSignature(cert.PublicKey, h, d.Sum(nil), si.EncryptedDigest); err != nil {
+		return nil, err
+	}
+	retval := []*x509.Certificate{cert}
+	for _, c := range certs {
+		if c != cert {
+			retval = append(retval, c)
+		}
+	}
+	return retval, nil
+}
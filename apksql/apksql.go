@@ -0,0 +1,547 @@
+//
+// Package for exporting the structure of APKs (and their DEX files)
+// to an SQLite database file, for ad-hoc SQL queries across many
+// APKs. WriteScript writes the SQL that adds an APK to a database, and
+// AddAPK runs it with the sqlite3 command (see
+// https://sqlite.org/cli.html), so the database is only ever written
+// by SQLite itself.
+//
+// APKs are added incrementally; each is keyed by the SHA-256 of the
+// file, and adding one that is already there does nothing. DEX files
+// are keyed by the SHA-1 signature in their header, so a DEX file
+// shared by several APKs (e.g. successive builds with an unchanged
+// secondary DEX) is stored once, and linked to each APK by apk_dex.
+// The tables are:
+//
+//	apk            path, sha256, and package, version_code, version_name,
+//	               min_sdk, target_sdk from the manifest
+//	dex            sha1, version, file_size
+//	apk_dex        apk_id, dex_id, name (e.g. "classes2.dex")
+//	string         dex_id, idx, value
+//	type           dex_id, idx, descriptor
+//	class          dex_id, descriptor, name, access_flags, flags,
+//	               superclass, source_file
+//	interface      class_id, descriptor
+//	field          class_id, field_idx, name, type, access_flags, flags,
+//	               static, value
+//	method         class_id, method_idx, name, descriptor, access_flags,
+//	               flags, code_offset, insns_size
+//	reference      method_id, kind ("method", "field", "type", "string",
+//	               ...), target, count: what the method's code refers to
+//	manifest       apk_id, kind ("uses-permission", "activity",
+//	               "service", "receiver" or "provider"), name,
+//	               exported, permission
+//	intent_filter  manifest_id, filter, kind ("action", "category" or
+//	               "data"), value
+//
+// Every table has an "id INTEGER PRIMARY KEY" column, which the _id
+// columns of other tables refer to (with foreign key constraints).
+// Access flags are given both as a number and rendered as modifiers
+// (flags). Types and descriptors are in descriptor form, and the
+// class name column is Java-style. DEX strings are converted from
+// MUTF-8 to UTF-8; the rare string that is not valid UTF-16 (an
+// unpaired surrogate, say) is stored as a BLOB of its MUTF-8 bytes
+// instead. The schema version is kept in the database's user_version.
+//
+// Tables, views and indexes that users add are left alone. Each APK
+// is added in one transaction, so a failure leaves the database as it
+// was.
+//
+package apksql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/thanm/go-read-a-dex/apkread"
+	"github.com/thanm/go-read-a-dex/axmlread"
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+	"github.com/thanm/go-read-a-dex/dexread"
+)
+
+// SchemaVersion is the version of the database schema, stored as the
+// database's user_version.
+const SchemaVersion = 1
+
+var tableSpecs = []struct{ name, columns string }{
+	{"apk", "id INTEGER PRIMARY KEY, path TEXT NOT NULL, sha256 TEXT NOT NULL, package TEXT, " +
+		"version_code INTEGER, version_name TEXT, min_sdk INTEGER, target_sdk INTEGER"},
+	{"dex", "id INTEGER PRIMARY KEY, sha1 TEXT NOT NULL, version INTEGER NOT NULL, file_size INTEGER NOT NULL"},
+	{"apk_dex", "id INTEGER PRIMARY KEY, apk_id INTEGER NOT NULL REFERENCES apk(id), " +
+		"dex_id INTEGER NOT NULL REFERENCES dex(id), name TEXT NOT NULL"},
+	{"string", "id INTEGER PRIMARY KEY, dex_id INTEGER NOT NULL REFERENCES dex(id), " +
+		"idx INTEGER NOT NULL, value TEXT NOT NULL"},
+	{"type", "id INTEGER PRIMARY KEY, dex_id INTEGER NOT NULL REFERENCES dex(id), " +
+		"idx INTEGER NOT NULL, descriptor TEXT NOT NULL"},
+	{"class", "id INTEGER PRIMARY KEY, dex_id INTEGER NOT NULL REFERENCES dex(id), " +
+		"descriptor TEXT NOT NULL, name TEXT NOT NULL, access_flags INTEGER NOT NULL, flags TEXT NOT NULL, " +
+		"superclass TEXT, source_file TEXT"},
+	{"interface", "id INTEGER PRIMARY KEY, class_id INTEGER NOT NULL REFERENCES class(id), descriptor TEXT NOT NULL"},
+	{"field", "id INTEGER PRIMARY KEY, class_id INTEGER NOT NULL REFERENCES class(id), " +
+		"field_idx INTEGER NOT NULL, name TEXT NOT NULL, type TEXT NOT NULL, " +
+		"access_flags INTEGER NOT NULL, flags TEXT NOT NULL, static INTEGER NOT NULL, value TEXT"},
+	{"method", "id INTEGER PRIMARY KEY, class_id INTEGER NOT NULL REFERENCES class(id), " +
+		"method_idx INTEGER NOT NULL, name TEXT NOT NULL, descriptor TEXT NOT NULL, " +
+		"access_flags INTEGER NOT NULL, flags TEXT NOT NULL, code_offset INTEGER NOT NULL, insns_size INTEGER"},
+	{"reference", "id INTEGER PRIMARY KEY, method_id INTEGER NOT NULL REFERENCES method(id), " +
+		"kind TEXT NOT NULL, target TEXT NOT NULL, count INTEGER NOT NULL"},
+	{"manifest", "id INTEGER PRIMARY KEY, apk_id INTEGER NOT NULL REFERENCES apk(id), " +
+		"kind TEXT NOT NULL, name TEXT NOT NULL, exported INTEGER, permission TEXT"},
+	{"intent_filter", "id INTEGER PRIMARY KEY, manifest_id INTEGER NOT NULL REFERENCES manifest(id), " +
+		"filter INTEGER NOT NULL, kind TEXT NOT NULL, value TEXT NOT NULL"},
+}
+
+// Indexes, on the foreign keys and the columns most likely to be
+// looked up.
+var indexSpecs = []struct{ table, column string }{
+	{"apk", "sha256"},
+	{"dex", "sha1"},
+	{"apk_dex", "apk_id"},
+	{"apk_dex", "dex_id"},
+	{"string", "dex_id"},
+	{"type", "dex_id"},
+	{"class", "dex_id"},
+	{"class", "descriptor"},
+	{"interface", "class_id"},
+	{"field", "class_id"},
+	{"method", "class_id"},
+	{"reference", "method_id"},
+	{"reference", "target"},
+	{"manifest", "apk_id"},
+	{"intent_filter", "manifest_id"},
+}
+
+// SQLite3 is the sqlite3 command that AddAPK runs.
+var SQLite3 = "sqlite3"
+
+// AddAPK adds the APK file 'apk' (its manifest and DEX files) to the
+// database file 'path', creating it if need be, by running SQLite3 on
+// the output of WriteScript. It returns false if the APK is already
+// there.
+func AddAPK(path string, apk string) (bool, error) {
+	var script bytes.Buffer
+	if err := WriteScript(&script, apk); err != nil {
+		return false, err
+	}
+	cmd := exec.Command(SQLite3, "-batch", "-bail", path)
+	cmd.Stdin = &script
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return false, fmt.Errorf("%s: %s", path, msg)
+		}
+		return false, fmt.Errorf("%s: %v", path, err)
+	}
+	return strings.TrimSpace(stdout.String()) == "1", nil
+}
+
+// The script works out each new row's id as the largest id already in
+// its table plus an offset. The temporary table apksql_base holds
+// those largest ids (in a column named after each table), and whether
+// the APK is new; apksql_dex holds, for the n'th DEX file of the APK,
+// its id and whether it is new.
+const (
+	baseTable = "temp.apksql_base"
+	dexTable  = "temp.apksql_dex"
+)
+
+// dexRef stands for the id of the current DEX file in a column that
+// refers to it.
+const dexRef = int64(0)
+
+// maxBatch is the most rows written by one INSERT.
+const maxBatch = 500
+
+// script is the SQL being written for an APK.
+type script struct {
+	w    io.Writer
+	err  error
+	dex  int
+	next map[string]int64
+	rows map[string][][]interface{}
+}
+
+func (s *script) printf(format string, args ...interface{}) {
+	if s.err == nil {
+		_, s.err = fmt.Fprintf(s.w, format, args...)
+	}
+}
+
+// insert queues a row of table 'table', returning its id relative to
+// the table's base id. The values are those of the row's columns
+// after the id; columns that refer to another table (which come
+// first) hold the relative id of the row referred to, or dexRef.
+func (s *script) insert(table string, values ...interface{}) int64 {
+	s.next[table]++
+	id := s.next[table]
+	s.rows[table] = append(s.rows[table], append([]interface{}{id}, values...))
+	return id
+}
+
+// flush writes the queued rows. With 'dex' set, the rows belong to
+// the current DEX file, and are only added if that is new.
+func (s *script) flush(dex bool) {
+	for _, spec := range tableSpecs {
+		rows := s.rows[spec.name]
+		delete(s.rows, spec.name)
+		cols := []string{fmt.Sprintf("b.%s + v.column1", spec.name)}
+		from := baseTable + " AS b"
+		where := "b.fresh"
+		if s.dex != 0 {
+			from += ", " + dexTable + " AS d"
+			where += fmt.Sprintf(" AND d.n = %d", s.dex)
+			if dex {
+				where += " AND d.fresh"
+			}
+		}
+		for i, c := range strings.Split(spec.columns, ", ")[1:] {
+			col := fmt.Sprintf("v.column%d", i+2)
+			if _, target, ok := strings.Cut(c, " REFERENCES "); ok {
+				if target = strings.TrimSuffix(target, "(id)"); target == "dex" {
+					col = "d.id"
+				} else {
+					col = "b." + target + " + " + col
+				}
+			}
+			cols = append(cols, col)
+		}
+		for len(rows) != 0 {
+			n := len(rows)
+			if n > maxBatch {
+				n = maxBatch
+			}
+			s.printf("INSERT INTO %s SELECT %s FROM %s, (VALUES", spec.name, strings.Join(cols, ", "), from)
+			for i, row := range rows[:n] {
+				sep := ","
+				if i == 0 {
+					sep = ""
+				}
+				lits := make([]string, len(row))
+				for j, v := range row {
+					lits[j] = literal(v)
+				}
+				s.printf("%s\n(%s)", sep, strings.Join(lits, ", "))
+			}
+			s.printf(") AS v WHERE %s;\n", where)
+			rows = rows[n:]
+		}
+	}
+}
+
+// literal returns the SQL literal for a column value. Text with
+// control characters in it is written in hex, so that the script is
+// free of NULs (and of lines that sqlite3 might take as commands).
+func literal(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case string:
+		if strings.IndexFunc(x, func(r rune) bool { return r < 0x20 }) >= 0 {
+			return "CAST(x'" + hex.EncodeToString([]byte(x)) + "' AS TEXT)"
+		}
+		return "'" + strings.ReplaceAll(x, "'", "''") + "'"
+	case []byte:
+		return "x'" + hex.EncodeToString(x) + "'"
+	}
+	panic(fmt.Sprintf("apksql: unsupported column value %T", v))
+}
+
+// WriteScript writes the SQL that adds the APK file 'apk' (its
+// manifest and DEX files) to a database to 'w'. The script creates
+// the tables if need be, and does nothing if the APK is already
+// there; it ends by printing 1 if it added the APK and 0 if not. For
+// example:
+//
+//	sqlite3 -bail apks.db < script.sql
+func WriteScript(w io.Writer, apk string) error {
+	data, err := ioutil.ReadFile(apk)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	dexes, err := apkread.ParseAPK(apk, dexread.Options{})
+	if err != nil {
+		return err
+	}
+	var m *axmlread.Manifest
+	doc, err := apkread.ReadManifest(apk)
+	switch {
+	case err == nil:
+		if m, err = doc.Manifest(); err != nil {
+			return fmt.Errorf("apk %s: %v", apk, err)
+		}
+	case !errors.Is(err, apkread.ErrNoEntry):
+		return err
+	}
+
+	s := &script{w: w, next: make(map[string]int64), rows: make(map[string][][]interface{})}
+	s.printf("PRAGMA foreign_keys = ON;\nBEGIN;\n")
+	for _, spec := range tableSpecs {
+		s.printf("CREATE TABLE IF NOT EXISTS %s (%s);\n", spec.name, spec.columns)
+	}
+	for _, spec := range indexSpecs {
+		s.printf("CREATE INDEX IF NOT EXISTS %s_%s ON %s(%s);\n", spec.table, spec.column, spec.table, spec.column)
+	}
+	// Fail (with -bail) on a database of another schema version.
+	s.printf("DROP TABLE IF EXISTS temp.apksql_version;\n")
+	s.printf("CREATE TEMP TABLE apksql_version (user_version CHECK (user_version IN (0, %d)));\n", SchemaVersion)
+	s.printf("INSERT INTO temp.apksql_version SELECT user_version FROM pragma_user_version;\n")
+	s.printf("PRAGMA user_version = %d;\n", SchemaVersion)
+
+	s.printf("DROP TABLE IF EXISTS %s;\n", baseTable)
+	s.printf("CREATE TEMP TABLE apksql_base AS SELECT\n NOT EXISTS (SELECT 1 FROM apk WHERE sha256 = %s) AS fresh", literal(key))
+	for _, spec := range tableSpecs {
+		s.printf(",\n (SELECT coalesce(max(id), 0) FROM %s) AS %s", spec.name, spec.name)
+	}
+	s.printf(";\n")
+	s.printf("DROP TABLE IF EXISTS %s;\n", dexTable)
+	s.printf("CREATE TEMP TABLE apksql_dex (n INTEGER PRIMARY KEY, id INTEGER, fresh INTEGER);\n")
+
+	apkId := s.insert("apk", apk, key, nil, nil, nil, nil, nil)
+	if m != nil {
+		row := s.rows["apk"][0]
+		row[3] = nullable(m.Package)
+		row[4] = int64(m.VersionCode)
+		row[5] = nullable(m.VersionName)
+		row[6] = int64(m.MinSdkVersion)
+		row[7] = int64(m.TargetSdkVersion)
+	}
+	s.flush(false)
+	seen := make(map[string]bool)
+	for i, d := range dexes {
+		sig := d.Sha1Signature()
+		sha1 := hex.EncodeToString(sig[:])
+		s.dex = i + 1
+		// A new DEX file gets the next dex id, whether or not
+		// the ones before it were new.
+		s.printf("INSERT INTO %s SELECT %d, coalesce((SELECT id FROM dex WHERE sha1 = '%s'), b.dex + %d), "+
+			"NOT EXISTS (SELECT 1 FROM dex WHERE sha1 = '%s') FROM %s AS b;\n", dexTable, s.dex, sha1, s.dex, sha1, baseTable)
+		if !seen[sha1] {
+			seen[sha1] = true
+			s.printf("INSERT INTO dex SELECT d.id, '%s', %d, %d FROM %s AS b, %s AS d WHERE b.fresh AND d.n = %d AND d.fresh;\n",
+				sha1, d.Version(), d.FileSize(), baseTable, dexTable, s.dex)
+			if err := s.addDEX(d); err != nil {
+				return fmt.Errorf("apk %s: %v", apk, err)
+			}
+			s.flush(true)
+		}
+		s.insert("apk_dex", apkId, dexRef, d.Name())
+		s.flush(false)
+	}
+	s.dex = 0
+	if m != nil {
+		s.addManifest(apkId, m)
+		s.flush(false)
+	}
+	s.printf("COMMIT;\nSELECT fresh FROM %s;\n", baseTable)
+	return s.err
+}
+
+func (s *script) addDEX(d *dexread.DexFile) error {
+	strs := d.Strings()
+	for i, str := range strs {
+		s.insert("string", dexRef, int64(i), text(str))
+	}
+	for i := 0; i < d.NumTypes(); i++ {
+		s.insert("type", dexRef, int64(i), text(d.Type(uint32(i))))
+	}
+	for _, c := range d.Classes() {
+		classId := s.insert("class", dexRef, text(c.Descriptor), text(c.Name()), int64(c.AccessFlags),
+			c.AccessFlags.ClassString(), nullable(c.Superclass), nullable(c.SourceFile))
+		for _, iface := range c.Interfaces {
+			s.insert("interface", classId, text(iface))
+		}
+		for _, f := range c.Fields() {
+			var value interface{}
+			if f.Value != nil {
+				value = f.Value.String()
+			}
+			s.insert("field", classId, int64(f.Index), text(f.Id.Name), text(f.Id.Type), int64(f.AccessFlags),
+				f.AccessFlags.FieldString(), boolValue(f.IsStatic), value)
+		}
+		for _, m := range c.Methods() {
+			code, err := m.Code()
+			if err != nil {
+				return err
+			}
+			var insns interface{}
+			if code != nil {
+				insns = int64(code.InsnsSize)
+			}
+			methodId := s.insert("method", classId, int64(m.Index), text(m.Id.Name), text(m.Id.Proto.Descriptor()),
+				int64(m.AccessFlags), m.AccessFlags.MethodString(), int64(m.CodeOffset), insns)
+			if code == nil {
+				continue
+			}
+			// One row for each thing referred to, in order of
+			// first reference.
+			type ref struct{ kind, target string }
+			var refs []ref
+			counts := make(map[ref]int64)
+			for i := range code.Insns {
+				insn := &code.Insns[i]
+				if insn.Kind == dexapkvisit.IndexNone {
+					continue
+				}
+				r := ref{insn.Kind.String(), refTarget(d, strs, insn)}
+				if counts[r] == 0 {
+					refs = append(refs, r)
+				}
+				counts[r]++
+			}
+			for _, r := range refs {
+				s.insert("reference", methodId, r.kind, text(r.target), counts[r])
+			}
+		}
+	}
+	return nil
+}
+
+// nullable returns NULL for an empty string, and text(s) otherwise.
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return text(s)
+}
+
+// text returns the DEX string 's' as a column value. DEX strings are
+// in MUTF-8 (see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#mutf-8),
+// which is UTF-8 except that NUL takes two bytes and a supplementary
+// character is a surrogate pair of three bytes each; SQLite wants
+// UTF-8 text, so these are converted. A string that can't be
+// converted is returned as a BLOB of its bytes.
+func text(s string) interface{} {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var units []uint16
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c < 0x80:
+			units = append(units, uint16(c))
+			i++
+		case c&0xe0 == 0xc0 && i+1 < len(s) && s[i+1]&0xc0 == 0x80:
+			units = append(units, uint16(c&0x1f)<<6|uint16(s[i+1]&0x3f))
+			i += 2
+		case c&0xf0 == 0xe0 && i+2 < len(s) && s[i+1]&0xc0 == 0x80 && s[i+2]&0xc0 == 0x80:
+			units = append(units, uint16(c&0x0f)<<12|uint16(s[i+1]&0x3f)<<6|uint16(s[i+2]&0x3f))
+			i += 3
+		default:
+			return []byte(s)
+		}
+	}
+	var b strings.Builder
+	for i := 0; i < len(units); i++ {
+		r := rune(units[i])
+		if utf16.IsSurrogate(r) {
+			if i+1 == len(units) {
+				return []byte(s)
+			}
+			if r = utf16.DecodeRune(r, rune(units[i+1])); r == utf8.RuneError {
+				return []byte(s)
+			}
+			i++
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// refTarget returns the text of what 'insn' refers to: a string's
+// value, a type descriptor, "Lfoo;->name:type" for a field,
+// "Lfoo;->name(params)ret" for a method, or a method descriptor.
+func refTarget(d *dexread.DexFile, strs []string, insn *dexapkvisit.Instruction) string {
+	switch insn.Kind {
+	case dexapkvisit.IndexString:
+		if insn.Index < uint32(len(strs)) {
+			return strs[insn.Index]
+		}
+	case dexapkvisit.IndexType:
+		return d.Type(insn.Index)
+	case dexapkvisit.IndexField:
+		f := d.Field(insn.Index)
+		return f.Class + "->" + f.Name + ":" + f.Type
+	case dexapkvisit.IndexMethod:
+		m := d.Method(insn.Index)
+		return m.Class + "->" + m.Name + m.Proto.Descriptor()
+	case dexapkvisit.IndexProto:
+		p := d.Proto(insn.Index)
+		return p.Descriptor()
+	}
+	return fmt.Sprintf("%s@%d", insn.Kind, insn.Index)
+}
+
+func (s *script) addManifest(apkId int64, m *axmlread.Manifest) {
+	for _, p := range m.Permissions {
+		s.insert("manifest", apkId, "uses-permission", p, nil, nil)
+	}
+	for _, kind := range []struct {
+		name       string
+		components []axmlread.Component
+	}{
+		{"activity", m.Activities},
+		{"service", m.Services},
+		{"receiver", m.Receivers},
+		{"provider", m.Providers},
+	} {
+		for _, c := range kind.components {
+			var exported interface{}
+			if c.Exported != nil {
+				exported = boolValue(*c.Exported)
+			}
+			id := s.insert("manifest", apkId, kind.name, c.Name, exported, nullable(c.Permission))
+			for i, f := range c.IntentFilters {
+				filter := int64(i)
+				for _, a := range f.Actions {
+					s.insert("intent_filter", id, filter, "action", a)
+				}
+				for _, cat := range f.Categories {
+					s.insert("intent_filter", id, filter, "category", cat)
+				}
+				for _, data := range f.Data {
+					s.insert("intent_filter", id, filter, "data", intentData(&data))
+				}
+			}
+		}
+	}
+}
+
+// intentData renders a <data> element as its attributes, e.g.
+// "scheme=https host=example.com pathPrefix=/app".
+func intentData(d *axmlread.IntentData) string {
+	var parts []string
+	for _, a := range []struct{ name, value string }{
+		{"scheme", d.Scheme}, {"host", d.Host}, {"port", d.Port},
+		{"path", d.Path}, {"pathPrefix", d.PathPrefix}, {"pathPattern", d.PathPattern},
+		{"mimeType", d.MimeType},
+	} {
+		if a.value != "" {
+			parts = append(parts, a.name+"="+a.value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
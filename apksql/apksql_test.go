@@ -0,0 +1,171 @@
+package apksql
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testAPK = "../apkread/testdata/fibonacci.apk"
+
+// copyAPK writes a copy of 'src' with an extra entry to 'dst': a
+// different APK with the same classes.dex.
+func copyAPK(t *testing.T, src, dst string) {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		t.Fatalf("OpenReader error %v", err)
+	}
+	defer r.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		t.Fatalf("Create error %v", err)
+	}
+	w := zip.NewWriter(f)
+	for _, zf := range r.File {
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatalf("Open error %v", err)
+		}
+		out, err := w.Create(zf.Name)
+		if err != nil {
+			t.Fatalf("Create error %v", err)
+		}
+		if _, err := io.Copy(out, rc); err != nil {
+			t.Fatalf("Copy error %v", err)
+		}
+		rc.Close()
+	}
+	out, err := w.Create("extra.txt")
+	if err != nil {
+		t.Fatalf("Create error %v", err)
+	}
+	out.Write([]byte("extra\n"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close error %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close error %v", err)
+	}
+}
+
+// sqlite3 runs the sqlite3 command on database 'path', returning its
+// output; the test is skipped if there is no such command.
+func sqlite3(t *testing.T, path string, sql ...string) string {
+	if _, err := exec.LookPath(SQLite3); err != nil {
+		t.Skip("no sqlite3 command")
+	}
+	out, err := exec.Command(SQLite3, append([]string{"-bail", path}, sql...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 error %v: %s", err, out)
+	}
+	return string(out)
+}
+
+func add(t *testing.T, path, apk string) bool {
+	sqlite3(t, path)
+	added, err := AddAPK(path, apk)
+	if err != nil {
+		t.Fatalf("AddAPK(%s) error %v", apk, err)
+	}
+	return added
+}
+
+func TestAddAPK(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apksql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apks.db")
+	other := filepath.Join(dir, "other.apk")
+	copyAPK(t, testAPK, other)
+
+	if !add(t, path, testAPK) {
+		t.Errorf("first AddAPK returned false")
+	}
+	if add(t, path, testAPK) {
+		t.Errorf("AddAPK of the same APK returned true")
+	}
+	// Tables and indexes of the user's own are fine.
+	sqlite3(t, path, "CREATE TABLE notes (x); CREATE INDEX notes_x ON notes(x); CREATE INDEX method_name ON method(name);")
+	if !add(t, path, other) {
+		t.Errorf("AddAPK of another APK returned false")
+	}
+
+	for _, tc := range []struct{ sql, want string }{
+		{"PRAGMA integrity_check; PRAGMA foreign_key_check; PRAGMA user_version;", "ok\n1\n"},
+		{"SELECT (SELECT count(*) FROM apk), (SELECT count(*) FROM dex), (SELECT count(*) FROM apk_dex), " +
+			"(SELECT count(*) FROM class), (SELECT count(*) FROM method), (SELECT count(*) FROM string);",
+			"2|1|2|1|6|47\n"},
+		{"SELECT apk_id, dex_id, name FROM apk_dex;", "1|1|classes.dex\n2|1|classes.dex\n"},
+		{"SELECT DISTINCT class_id FROM method;", "1\n"},
+		// Methods refer to their class, and references to their method.
+		{"SELECT c.name, m.name FROM method m JOIN class c ON m.class_id = c.id JOIN reference r ON r.method_id = m.id " +
+			"WHERE r.target = 'Ljava/lang/System;->out:Ljava/io/PrintStream;';", "fibonacci|main\n"},
+		{"SELECT count(*) FROM sqlite_schema WHERE name IN ('notes', 'notes_x', 'method_name');", "3\n"},
+	} {
+		if got := sqlite3(t, path, tc.sql); got != tc.want {
+			t.Errorf("%s: got %q, expected %q", tc.sql, got, tc.want)
+		}
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apksql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apks.db")
+	sqlite3(t, path, "PRAGMA user_version = 99;")
+	if _, err := AddAPK(path, testAPK); err == nil || !strings.Contains(err.Error(), "CHECK constraint failed") {
+		t.Errorf("expected schema version error, got %v", err)
+	}
+	if got := sqlite3(t, path, "SELECT count(*) FROM sqlite_schema;"); got != "0\n" {
+		t.Errorf("failed AddAPK left %s schema entries", got)
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	for _, tc := range []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, "NULL"},
+		{int64(-42), "-42"},
+		{"it's", "'it''s'"},
+		{"a\nb", "CAST(x'610a62' AS TEXT)"},
+		{[]byte{0, 0xff}, "x'00ff'"},
+	} {
+		if got := literal(tc.in); got != tc.want {
+			t.Errorf("literal(%#v) = %s, expected %s", tc.in, got, tc.want)
+		}
+	}
+}
+func TestText(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want interface{}
+	}{
+		{"", ""},
+		{"hello", "hello"},
+		{"caf\xc3\xa9", "caf\u00e9"},
+		// NUL
+		{"a\xc0\x80b", "a\x00b"},
+		// U+1F600 as a surrogate pair
+		{"\xed\xa0\xbd\xed\xb8\x80", "\U0001f600"},
+		// An unpaired surrogate, and a truncated sequence
+		{"\xed\xa0\xbdx", []byte("\xed\xa0\xbdx")},
+		{"\xe2\x82", []byte("\xe2\x82")},
+	} {
+		if got := text(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("text(%q) = %#v, expected %#v", tc.in, got, tc.want)
+		}
+	}
+}
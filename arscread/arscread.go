@@ -0,0 +1,353 @@
+//
+// Package for reading the resource table (resources.arsc) from an
+// APK. The table is a chunk (see axmlread/chunk.go) containing a
+// global string pool for values and one chunk per package; each
+// package has string pools for type and key (entry) names, and a
+// type spec chunk plus one type chunk per configuration for each
+// resource type. Parse decodes all of this into a Table, which can
+// then map resource IDs (0xPPTTEEEE) to "type/name" and to their
+// value in each configuration.
+//
+package arscread
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+
+	"github.com/thanm/go-read-a-dex/axmlread"
+)
+
+const (
+	tableHeaderSize    = 12
+	packageHeaderSize  = 284
+	typeSpecHeaderSize = 16
+	typeHeaderSize     = 20
+	mapEntryHeaderSize = 16
+	mapSize            = 12
+	noEntry            = 0xffffffff
+
+	// ResTable_type flags
+	typeFlagSparse = 0x01
+
+	// ResTable_entry flags
+	entryFlagComplex = 0x0001
+	entryFlagPublic  = 0x0002
+	entryFlagCompact = 0x0008
+)
+
+// Table is a decoded resource table.
+type Table struct {
+	Strings  *axmlread.StringPool
+	Packages []*Package
+	entries  map[uint32]*Entry
+}
+
+// Package is a resource package; applications normally have a single
+// package with ID 0x7f.
+type Package struct {
+	Id    uint32
+	Name  string
+	Types *axmlread.StringPool
+	Keys  *axmlread.StringPool
+}
+
+// Entry is a single resource, with its values in each configuration
+// the table provides.
+type Entry struct {
+	Id      uint32
+	Package *Package
+	Type    string
+	Key     string
+	Public  bool
+	Values  []ConfigValue
+}
+
+// ConfigValue is the value of a resource in one configuration. Simple
+// resources have a Value; complex ones (styles, plurals, arrays and
+// so on) have a Parent and a list of Map entries instead.
+type ConfigValue struct {
+	Config  Config
+	Complex bool
+	Value   axmlread.Value
+	Parent  uint32
+	Map     []MapEntry
+}
+
+// MapEntry is one name/value pair within a complex resource. Name is
+// itself a resource ID (for styles, the ID of an attribute).
+type MapEntry struct {
+	Name  uint32
+	Value axmlread.Value
+}
+
+// Name returns the entry name in "type/name" form.
+func (e *Entry) Name() string {
+	return e.Type + "/" + e.Key
+}
+
+// Default returns the value for the default configuration, or the
+// first value if there is no default.
+func (e *Entry) Default() *ConfigValue {
+	for i := range e.Values {
+		if e.Values[i].Config == (Config{}) {
+			return &e.Values[i]
+		}
+	}
+	if len(e.Values) == 0 {
+		return nil
+	}
+	return &e.Values[0]
+}
+
+// Parse decodes the resource table held in 'data'.
+func Parse(data []byte) (*Table, error) {
+	top, _, err := axmlread.ReadChunk(data)
+	if err != nil {
+		return nil, err
+	}
+	if top.Type != axmlread.ChunkTable || len(top.Header) < tableHeaderSize {
+		return nil, errors.New("not a resource table")
+	}
+	t := &Table{Strings: &axmlread.StringPool{}, entries: make(map[uint32]*Entry)}
+	rest := top.Body
+	for len(rest) > 0 {
+		var c axmlread.Chunk
+		if c, rest, err = axmlread.ReadChunk(rest); err != nil {
+			return nil, err
+		}
+		switch c.Type {
+		case axmlread.ChunkStringPool:
+			if t.Strings, err = axmlread.ParseStringPool(c); err != nil {
+				return nil, err
+			}
+		case axmlread.ChunkTablePackage:
+			if err = t.parsePackage(c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+func decodePackageName(b []byte) string {
+	var units []uint16
+	for i := 0; i+2 <= len(b); i += 2 {
+		u := binary.LittleEndian.Uint16(b[i:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units))
+}
+
+func (t *Table) parsePackage(c axmlread.Chunk) error {
+	h := c.Header
+	if len(h) < packageHeaderSize {
+		return errors.New("truncated package header")
+	}
+	p := &Package{
+		Id:   binary.LittleEndian.Uint32(h[8:]),
+		Name: decodePackageName(h[12:268]),
+	}
+	t.Packages = append(t.Packages, p)
+
+	// The type and key string pools are located by offset from the
+	// start of the package chunk; everything else is a sequence of
+	// chunks following the header.
+	whole := append(h[:len(h):len(h)], c.Body...)
+	pool := func(off uint32) (*axmlread.StringPool, error) {
+		if off == 0 || uint64(off) >= uint64(len(whole)) {
+			return &axmlread.StringPool{}, nil
+		}
+		pc, _, err := axmlread.ReadChunk(whole[off:])
+		if err != nil {
+			return nil, err
+		}
+		return axmlread.ParseStringPool(pc)
+	}
+	var err error
+	if p.Types, err = pool(binary.LittleEndian.Uint32(h[268:])); err != nil {
+		return fmt.Errorf("package %s type strings: %v", p.Name, err)
+	}
+	if p.Keys, err = pool(binary.LittleEndian.Uint32(h[276:])); err != nil {
+		return fmt.Errorf("package %s key strings: %v", p.Name, err)
+	}
+
+	rest := c.Body
+	for len(rest) > 0 {
+		var tc axmlread.Chunk
+		if tc, rest, err = axmlread.ReadChunk(rest); err != nil {
+			return err
+		}
+		switch tc.Type {
+		case axmlread.ChunkTableTypeSpec:
+			if err = t.parseTypeSpec(p, tc); err != nil {
+				return err
+			}
+		case axmlread.ChunkTableType:
+			if err = t.parseType(p, tc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Table) entry(p *Package, typeId uint8, idx uint32) *Entry {
+	id := p.Id<<24 | uint32(typeId)<<16 | idx
+	e := t.entries[id]
+	if e == nil {
+		e = &Entry{Id: id, Package: p, Type: p.Types.Get(uint32(typeId) - 1)}
+		t.entries[id] = e
+	}
+	return e
+}
+
+// parseTypeSpec records the public flag for each entry of a type.
+func (t *Table) parseTypeSpec(p *Package, c axmlread.Chunk) error {
+	if len(c.Header) < typeSpecHeaderSize {
+		return errors.New("truncated type spec header")
+	}
+	typeId := c.Header[8]
+	count := binary.LittleEndian.Uint32(c.Header[12:])
+	if uint64(count)*4 > uint64(len(c.Body)) {
+		return fmt.Errorf("type spec %d: bad entry count %d", typeId, count)
+	}
+	for i := uint32(0); i < count; i++ {
+		// SPEC_PUBLIC
+		if binary.LittleEndian.Uint32(c.Body[i*4:])&0x40000000 != 0 {
+			t.entry(p, typeId, i).Public = true
+		}
+	}
+	return nil
+}
+
+func (t *Table) parseType(p *Package, c axmlread.Chunk) error {
+	h := c.Header
+	if len(h) < typeHeaderSize {
+		return errors.New("truncated type header")
+	}
+	typeId := h[8]
+	flags := h[9]
+	count := binary.LittleEndian.Uint32(h[12:])
+	entriesStart := binary.LittleEndian.Uint32(h[16:])
+	config := parseConfig(h[typeHeaderSize:])
+	if uint64(count)*4 > uint64(len(c.Body)) || entriesStart < uint32(len(h)) ||
+		uint64(entriesStart) > uint64(len(h)+len(c.Body)) {
+		return fmt.Errorf("type %d: bad layout", typeId)
+	}
+	entries := c.Body[entriesStart-uint32(len(h)):]
+
+	for i := uint32(0); i < count; i++ {
+		var idx, off uint32
+		if flags&typeFlagSparse != 0 {
+			idx = uint32(binary.LittleEndian.Uint16(c.Body[i*4:]))
+			off = uint32(binary.LittleEndian.Uint16(c.Body[i*4+2:])) * 4
+		} else {
+			idx = i
+			off = binary.LittleEndian.Uint32(c.Body[i*4:])
+			if off == noEntry {
+				continue
+			}
+		}
+		key, v, err := t.parseEntry(entries, off)
+		if err != nil {
+			return fmt.Errorf("type %d entry %d: %v", typeId, idx, err)
+		}
+		v.Config = config
+		e := t.entry(p, typeId, idx)
+		e.Key = p.Keys.Get(key)
+		e.Values = append(e.Values, v)
+	}
+	return nil
+}
+
+// parseEntry decodes the ResTable_entry at offset 'off' within
+// 'entries', returning its key index and value.
+func (t *Table) parseEntry(entries []byte, off uint32) (uint32, ConfigValue, error) {
+	var v ConfigValue
+	if uint64(off)+8 > uint64(len(entries)) {
+		return 0, v, errors.New("entry out of range")
+	}
+	b := entries[off:]
+	size := uint32(binary.LittleEndian.Uint16(b[0:]))
+	flags := binary.LittleEndian.Uint16(b[2:])
+
+	// Compact entries pack the key into the size field and the value
+	// type into the high byte of the flags.
+	if flags&entryFlagCompact != 0 {
+		v.Value = axmlread.Value{
+			Type: axmlread.ValueType(flags >> 8),
+			Data: binary.LittleEndian.Uint32(b[4:]),
+		}
+		if v.Value.Type == axmlread.TypeString {
+			v.Value.Str = t.Strings.Get(v.Value.Data)
+		}
+		return size, v, nil
+	}
+
+	key := binary.LittleEndian.Uint32(b[4:])
+	if flags&entryFlagComplex == 0 {
+		if uint64(size)+8 > uint64(len(b)) {
+			return 0, v, errors.New("truncated value")
+		}
+		var err error
+		v.Value, err = axmlread.ReadValue(b[size:], t.Strings)
+		return key, v, err
+	}
+
+	if len(b) < mapEntryHeaderSize {
+		return 0, v, errors.New("truncated map entry")
+	}
+	v.Complex = true
+	v.Parent = binary.LittleEndian.Uint32(b[8:])
+	count := binary.LittleEndian.Uint32(b[12:])
+	if uint64(size)+uint64(count)*mapSize > uint64(len(b)) {
+		return 0, v, errors.New("truncated map")
+	}
+	for i := uint32(0); i < count; i++ {
+		m := b[size+i*mapSize:]
+		value, err := axmlread.ReadValue(m[4:], t.Strings)
+		if err != nil {
+			return 0, v, err
+		}
+		v.Map = append(v.Map, MapEntry{Name: binary.LittleEndian.Uint32(m), Value: value})
+	}
+	return key, v, nil
+}
+
+// Entry returns the resource with ID 'id', or nil if the table does
+// not define it.
+func (t *Table) Entry(id uint32) *Entry {
+	return t.entries[id]
+}
+
+// Entries returns every resource in the table, sorted by ID.
+func (t *Table) Entries() []*Entry {
+	retval := make([]*Entry, 0, len(t.entries))
+	for _, e := range t.entries {
+		if len(e.Values) != 0 {
+			retval = append(retval, e)
+		}
+	}
+	sort.Slice(retval, func(i, j int) bool { return retval[i].Id < retval[j].Id })
+	return retval
+}
+
+// ResourceName returns the "type/name" of resource 'id' (prefixed
+// with "package:" if it is not in the first package of the table).
+// This satisfies axmlread.Resolver.
+func (t *Table) ResourceName(id uint32) (string, bool) {
+	e := t.entries[id]
+	if e == nil || e.Key == "" {
+		return "", false
+	}
+	if len(t.Packages) != 0 && e.Package != t.Packages[0] {
+		return e.Package.Name + ":" + e.Name(), true
+	}
+	return e.Name(), true
+}
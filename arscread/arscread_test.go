@@ -0,0 +1,140 @@
+package arscread
+
+import (
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/axmlread"
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// ResTable_config with the given language and density
+func testConfig(lang string, density uint16) []byte {
+	c := make([]byte, 64)
+	c[0] = 64
+	copy(c[8:], lang)
+	c[14], c[15] = byte(density), byte(density>>8)
+	return c
+}
+
+func buildTable() []byte {
+	str := func(s string) testValue { return testValue{typ: axmlread.TypeString, str: s} }
+	b := &tableBuilder{
+		pkgId:   0x7f,
+		pkgName: "com.example.app",
+		public:  map[string][]uint32{"string": {0}},
+		types: []testType{
+			{typ: "string", count: 2, entries: []testEntry{
+				{idx: 0, key: "app_name", value: str("Example")},
+				{idx: 1, key: "hello", value: str("Hello")},
+			}},
+			{typ: "string", config: testConfig("fr", 0), count: 2, entries: []testEntry{
+				{idx: 1, key: "hello", value: str("Bonjour")},
+			}},
+			{typ: "dimen", config: testConfig("", 320), sparse: true, entries: []testEntry{
+				{idx: 3, key: "margin", value: testValue{typ: axmlread.TypeDimension, data: 0x1001}},
+			}},
+			{typ: "style", count: 1, entries: []testEntry{
+				{idx: 0, key: "AppTheme", complex: true, parent: 0x01030005, maps: []testMap{
+					{0x01010036, testValue{typ: axmlread.TypeReference, data: 0x7f010000}},
+				}},
+			}},
+		},
+	}
+	return b.build()
+}
+
+func TestParseTable(t *testing.T) {
+	table, err := Parse(buildTable())
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	if len(table.Packages) != 1 || table.Packages[0].Id != 0x7f ||
+		table.Packages[0].Name != "com.example.app" {
+		t.Fatalf("unexpected packages %+v", table.Packages)
+	}
+
+	hello := table.Entry(0x7f010001)
+	if hello == nil || hello.Name() != "string/hello" || len(hello.Values) != 2 {
+		t.Fatalf("unexpected entry %+v", hello)
+	}
+	if d := hello.Default(); d.Value.String() != "Hello" || d.Config.String() != "default" {
+		t.Errorf("default value %+v", d)
+	}
+	if fr := hello.Values[1]; fr.Value.String() != "Bonjour" || fr.Config.String() != "fr" {
+		t.Errorf("fr value %+v config %s", fr, fr.Config)
+	}
+	if app := table.Entry(0x7f010000); app == nil || !app.Public || hello.Public {
+		t.Errorf("unexpected public flags")
+	}
+
+	margin := table.Entry(0x7f020003)
+	if margin == nil || margin.Name() != "dimen/margin" ||
+		margin.Values[0].Config.String() != "xhdpi" ||
+		margin.Values[0].Value.String() != "16dp" {
+		t.Errorf("unexpected sparse entry %+v", margin)
+	}
+
+	theme := table.Entry(0x7f030000)
+	if theme == nil || !theme.Values[0].Complex || theme.Values[0].Parent != 0x01030005 ||
+		len(theme.Values[0].Map) != 1 || theme.Values[0].Map[0].Value.Data != 0x7f010000 {
+		t.Errorf("unexpected style entry %+v", theme)
+	}
+
+	if n := len(table.Entries()); n != 4 {
+		t.Errorf("got %d entries", n)
+	}
+	if name, ok := table.ResourceName(0x7f020003); !ok || name != "dimen/margin" {
+		t.Errorf("ResourceName got %s %v", name, ok)
+	}
+	if _, ok := table.ResourceName(0x7f020000); ok {
+		t.Errorf("ResourceName: unexpected name for missing entry")
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	table, err := Parse(buildTable())
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	v := axmlread.Value{Type: axmlread.TypeReference, Data: 0x7f010000}
+	if name, ok := table.ResourceName(v.Data); ok {
+		v.Ref = name
+	}
+	if v.String() != "@string/app_name" {
+		t.Errorf("reference got '%s'", v.String())
+	}
+
+	insn := dexapkvisit.Instruction{Name: "const", Literal: 0x7f010001}
+	id, ok := insn.ResourceId()
+	if !ok {
+		t.Fatalf("ResourceId failed for %+v", insn)
+	}
+	if name, _ := table.ResourceName(id); name != "string/hello" {
+		t.Errorf("const resource got '%s'", name)
+	}
+	small := dexapkvisit.Instruction{Name: "const", Literal: 42}
+	if _, ok := small.ResourceId(); ok {
+		t.Errorf("ResourceId: unexpected success for %+v", small)
+	}
+}
+
+func TestConfigString(t *testing.T) {
+	c := Config{Language: "en", Region: "US", SmallestScreenWidthDp: 600,
+		UIMode: 0x20, Density: 480, SdkVersion: 21}
+	if s := c.String(); s != "en-rUS-sw600dp-night-xxhdpi-v21" {
+		t.Errorf("got '%s'", s)
+	}
+	if s := parseConfig(testConfig("de", 0)).String(); s != "de" {
+		t.Errorf("got '%s'", s)
+	}
+}
+
+func TestBadTable(t *testing.T) {
+	if _, err := Parse([]byte{3, 0, 8, 0, 8, 0, 0, 0}); err == nil {
+		t.Errorf("expected error for non-table chunk")
+	}
+	data := buildTable()
+	if _, err := Parse(data[:len(data)-4]); err == nil {
+		t.Errorf("expected error for truncated table")
+	}
+}
@@ -0,0 +1,205 @@
+package arscread
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+
+	"github.com/thanm/go-read-a-dex/axmlread"
+)
+
+//
+// A small resources.arsc writer for unit tests: one package, with
+// types and keys named by string and values given per configuration.
+//
+
+type testValue struct {
+	typ  axmlread.ValueType
+	data uint32
+	str  string
+}
+
+type testMap struct {
+	name  uint32
+	value testValue
+}
+
+type testEntry struct {
+	idx     uint16
+	key     string
+	value   testValue
+	complex bool
+	parent  uint32
+	maps    []testMap
+}
+
+type testType struct {
+	typ     string
+	config  []byte // ResTable_config, nil for the default
+	entries []testEntry
+	count   uint32
+	sparse  bool
+}
+
+type tableBuilder struct {
+	pkgId   uint32
+	pkgName string
+	public  map[string][]uint32 // type -> public entry indices
+	types   []testType
+}
+
+func le(buf *bytes.Buffer, v interface{}) {
+	binary.Write(buf, binary.LittleEndian, v)
+}
+
+func chunk(typ uint16, header, body []byte) []byte {
+	var buf bytes.Buffer
+	le(&buf, typ)
+	le(&buf, uint16(8+len(header)))
+	le(&buf, uint32(8+len(header)+len(body)))
+	buf.Write(header)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+type stringTable struct {
+	strs []string
+	idx  map[string]uint32
+}
+
+func (s *stringTable) add(str string) uint32 {
+	if s.idx == nil {
+		s.idx = make(map[string]uint32)
+	}
+	if i, ok := s.idx[str]; ok {
+		return i
+	}
+	s.idx[str] = uint32(len(s.strs))
+	s.strs = append(s.strs, str)
+	return uint32(len(s.strs) - 1)
+}
+
+// UTF-16 string pool chunk
+func (s *stringTable) chunk() []byte {
+	var data, offsets bytes.Buffer
+	for _, str := range s.strs {
+		le(&offsets, uint32(data.Len()))
+		u := utf16.Encode([]rune(str))
+		le(&data, uint16(len(u)))
+		le(&data, u)
+		le(&data, uint16(0))
+	}
+	for data.Len()%4 != 0 {
+		data.WriteByte(0)
+	}
+	var hdr bytes.Buffer
+	le(&hdr, []uint32{uint32(len(s.strs)), 0, 0, uint32(28 + offsets.Len()), 0})
+	return chunk(axmlread.ChunkStringPool, hdr.Bytes(), append(offsets.Bytes(), data.Bytes()...))
+}
+
+func resValue(buf *bytes.Buffer, v testValue, values *stringTable) {
+	data := v.data
+	if v.typ == axmlread.TypeString {
+		data = values.add(v.str)
+	}
+	le(buf, uint16(8))
+	le(buf, []uint8{0, uint8(v.typ)})
+	le(buf, data)
+}
+
+func (b *tableBuilder) build() []byte {
+	var values, typeNames, keys stringTable
+	typeIds := map[string]uint8{}
+	for _, t := range b.types {
+		if _, ok := typeIds[t.typ]; !ok {
+			typeIds[t.typ] = uint8(typeNames.add(t.typ) + 1)
+		}
+	}
+
+	var chunks bytes.Buffer
+	for name, pub := range b.public {
+		var hdr, body bytes.Buffer
+		max := uint32(0)
+		for _, i := range pub {
+			if i+1 > max {
+				max = i + 1
+			}
+		}
+		flags := make([]uint32, max)
+		for _, i := range pub {
+			flags[i] = 0x40000000
+		}
+		le(&hdr, []uint8{typeIds[name], 0, 0, 0})
+		le(&hdr, max)
+		le(&body, flags)
+		chunks.Write(chunk(axmlread.ChunkTableTypeSpec, hdr.Bytes(), body.Bytes()))
+	}
+	for _, t := range b.types {
+		config := t.config
+		if config == nil {
+			config = make([]byte, 64)
+		}
+		var entries, offsets bytes.Buffer
+		if t.sparse {
+			for _, e := range t.entries {
+				le(&offsets, []uint16{e.idx, uint16(entries.Len() / 4)})
+				b.entry(&entries, e, &values, &keys)
+			}
+		} else {
+			offs := make([]uint32, t.count)
+			for i := range offs {
+				offs[i] = noEntry
+			}
+			for _, e := range t.entries {
+				offs[e.idx] = uint32(entries.Len())
+				b.entry(&entries, e, &values, &keys)
+			}
+			le(&offsets, offs)
+		}
+		var hdr bytes.Buffer
+		count := t.count
+		flags := uint8(0)
+		if t.sparse {
+			count = uint32(len(t.entries))
+			flags = typeFlagSparse
+		}
+		le(&hdr, []uint8{typeIds[t.typ], flags, 0, 0})
+		le(&hdr, count)
+		le(&hdr, uint32(typeHeaderSize+len(config)+offsets.Len()))
+		hdr.Write(config)
+		chunks.Write(chunk(axmlread.ChunkTableType, hdr.Bytes(), append(offsets.Bytes(), entries.Bytes()...)))
+	}
+
+	// package header: id, name, then the offsets of the type and key
+	// string pools, which immediately follow the header
+	typePool := typeNames.chunk()
+	keyPool := keys.chunk()
+	var phdr bytes.Buffer
+	le(&phdr, b.pkgId)
+	name := make([]uint16, 128)
+	copy(name, utf16.Encode([]rune(b.pkgName)))
+	le(&phdr, name)
+	le(&phdr, []uint32{packageHeaderSize, 0, uint32(packageHeaderSize + len(typePool)), 0})
+	pkg := chunk(axmlread.ChunkTablePackage, phdr.Bytes(),
+		append(append(typePool, keyPool...), chunks.Bytes()...))
+
+	var thdr bytes.Buffer
+	le(&thdr, uint32(1))
+	return chunk(axmlread.ChunkTable, thdr.Bytes(), append(values.chunk(), pkg...))
+}
+
+func (b *tableBuilder) entry(buf *bytes.Buffer, e testEntry, values, keys *stringTable) {
+	if !e.complex {
+		le(buf, []uint16{8, 0})
+		le(buf, keys.add(e.key))
+		resValue(buf, e.value, values)
+		return
+	}
+	le(buf, []uint16{mapEntryHeaderSize, entryFlagComplex})
+	le(buf, keys.add(e.key))
+	le(buf, []uint32{e.parent, uint32(len(e.maps))})
+	for _, m := range e.maps {
+		le(buf, m.name)
+		resValue(buf, m.value, values)
+	}
+}
@@ -0,0 +1,156 @@
+package arscread
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Config is the subset of ResTable_config that we decode: the
+// qualifiers that commonly show up in APKs. Zero means "any".
+type Config struct {
+	Mcc, Mnc              uint16
+	Language, Region      string
+	Orientation           uint8
+	Density               uint16
+	SdkVersion            uint16
+	ScreenLayout          uint8
+	UIMode                uint8
+	SmallestScreenWidthDp uint16
+	ScreenWidthDp         uint16
+	ScreenHeightDp        uint16
+}
+
+const (
+	orientationPort   = 1
+	orientationLand   = 2
+	orientationSquare = 3
+
+	uiModeNightMask = 0x30
+	uiModeNightNo   = 0x10
+	uiModeNightYes  = 0x20
+
+	screenLayoutDirMask = 0xc0
+	screenLayoutDirLTR  = 0x40
+	screenLayoutDirRTL  = 0x80
+)
+
+var densityNames = map[uint16]string{
+	120:    "ldpi",
+	160:    "mdpi",
+	213:    "tvdpi",
+	240:    "hdpi",
+	320:    "xhdpi",
+	480:    "xxhdpi",
+	640:    "xxxhdpi",
+	0xfffe: "anydpi",
+	0xffff: "nodpi",
+}
+
+// Locale codes are two ASCII letters, or (for three-letter codes) a
+// packed form with the high bit of the first byte set.
+func unpackLocale(b []byte, base byte) string {
+	if b[0] == 0 {
+		return ""
+	}
+	if b[0]&0x80 == 0 {
+		return string(b[:2])
+	}
+	first := b[1] & 0x1f
+	second := (b[1]&0xe0)>>5 | (b[0]&0x03)<<3
+	third := (b[0] & 0x7c) >> 2
+	return string([]byte{first + base, second + base, third + base})
+}
+
+// parseConfig decodes a ResTable_config; fields beyond the size the
+// config declares are left as zero.
+func parseConfig(b []byte) Config {
+	var c Config
+	if len(b) < 4 {
+		return c
+	}
+	size := int(binary.LittleEndian.Uint32(b))
+	if size < len(b) {
+		b = b[:size]
+	}
+	// Pad short (older) configs out so that we can read them
+	// unconditionally.
+	if len(b) < 36 {
+		b = append(append([]byte{}, b...), make([]byte, 36-len(b))...)
+	}
+	c.Mcc = binary.LittleEndian.Uint16(b[4:])
+	c.Mnc = binary.LittleEndian.Uint16(b[6:])
+	c.Language = unpackLocale(b[8:10], 'a')
+	c.Region = unpackLocale(b[10:12], '0')
+	c.Orientation = b[12]
+	c.Density = binary.LittleEndian.Uint16(b[14:])
+	c.SdkVersion = binary.LittleEndian.Uint16(b[24:])
+	c.ScreenLayout = b[28]
+	c.UIMode = b[29]
+	c.SmallestScreenWidthDp = binary.LittleEndian.Uint16(b[30:])
+	c.ScreenWidthDp = binary.LittleEndian.Uint16(b[32:])
+	c.ScreenHeightDp = binary.LittleEndian.Uint16(b[34:])
+	return c
+}
+
+// String renders the configuration as resource directory qualifiers,
+// e.g. "en-rUS-sw600dp-night-xhdpi-v21", or "default" if there are
+// none.
+func (c Config) String() string {
+	var q []string
+	if c.Mcc != 0 {
+		q = append(q, fmt.Sprintf("mcc%d", c.Mcc))
+	}
+	if c.Mnc != 0 {
+		q = append(q, fmt.Sprintf("mnc%d", c.Mnc))
+	}
+	if c.Language != "" {
+		q = append(q, c.Language)
+	}
+	if c.Region != "" {
+		q = append(q, "r"+c.Region)
+	}
+	switch c.ScreenLayout & screenLayoutDirMask {
+	case screenLayoutDirLTR:
+		q = append(q, "ldltr")
+	case screenLayoutDirRTL:
+		q = append(q, "ldrtl")
+	}
+	if c.SmallestScreenWidthDp != 0 {
+		q = append(q, fmt.Sprintf("sw%ddp", c.SmallestScreenWidthDp))
+	}
+	if c.ScreenWidthDp != 0 {
+		q = append(q, fmt.Sprintf("w%ddp", c.ScreenWidthDp))
+	}
+	if c.ScreenHeightDp != 0 {
+		q = append(q, fmt.Sprintf("h%ddp", c.ScreenHeightDp))
+	}
+	switch c.Orientation {
+	case orientationPort:
+		q = append(q, "port")
+	case orientationLand:
+		q = append(q, "land")
+	case orientationSquare:
+		q = append(q, "square")
+	}
+	switch c.UIMode & uiModeNightMask {
+	case uiModeNightNo:
+		q = append(q, "notnight")
+	case uiModeNightYes:
+		q = append(q, "night")
+	}
+	if c.Density != 0 {
+		if n, ok := densityNames[c.Density]; ok {
+			q = append(q, n)
+		} else {
+			q = append(q, fmt.Sprintf("%ddpi", c.Density))
+		}
+	}
+	if c.SdkVersion != 0 {
+		q = append(q, fmt.Sprintf("v%d", c.SdkVersion))
+	}
+	if len(q) == 0 {
+		return "default"
+	}
+	return strings.Join(q, "-")
+}
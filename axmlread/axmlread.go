@@ -0,0 +1,320 @@
+//
+// Package for decoding Android binary XML (sometimes called AXML),
+// which is the form that AndroidManifest.xml and the XML resources
+// within an APK take once compiled by aapt. A binary XML file is a
+// sequence of chunks: a string pool, an optional resource map (giving
+// the resource ID of each attribute name), and then a series of
+// namespace and element start/end chunks. Parse turns these back into
+// a tree of elements, which can be written out as plain XML or
+// examined directly (see manifest.go for AndroidManifest.xml).
+//
+package axmlread
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// ResXMLTree_node header (chunk header, line number, comment)
+	xmlNodeHeaderSize = 16
+	// ResXMLTree_attrExt
+	xmlAttrExtSize = 20
+	// ResXMLTree_attribute
+	xmlAttributeSize = 20
+	// "no string" marker used for string pool references
+	noEntry = 0xffffffff
+
+	// AndroidNamespace is the URI bound to the "android" prefix.
+	AndroidNamespace = "http://schemas.android.com/apk/res/android"
+)
+
+// Namespace is a prefix/URI binding declared on an element.
+type Namespace struct {
+	Prefix string
+	URI    string
+}
+
+// Attr is an attribute of an element. ResourceId is the resource ID
+// of the attribute name taken from the resource map (zero if there
+// is none); Raw is the original string value, if aapt kept one.
+type Attr struct {
+	Namespace  string
+	Name       string
+	ResourceId uint32
+	Raw        string
+	Value      Value
+}
+
+// Element is an XML element. Namespaces holds any namespaces that
+// come into scope at this element.
+type Element struct {
+	Namespace  string
+	Name       string
+	Line       uint32
+	Namespaces []Namespace
+	Attrs      []Attr
+	Children   []*Element
+	Text       string
+}
+
+// Document is a decoded binary XML file.
+type Document struct {
+	Root    *Element
+	Strings *StringPool
+	// resource ID for each attribute name string, by string index
+	ResourceMap []uint32
+}
+
+// Parse decodes the binary XML file held in 'data'.
+func Parse(data []byte) (*Document, error) {
+	top, _, err := ReadChunk(data)
+	if err != nil {
+		return nil, err
+	}
+	if top.Type != ChunkXML {
+		return nil, errors.New("not a binary XML file")
+	}
+
+	doc := &Document{Strings: &StringPool{}}
+	var stack []*Element
+	var pending []Namespace
+	rest := top.Body
+	for len(rest) > 0 {
+		var c Chunk
+		if c, rest, err = ReadChunk(rest); err != nil {
+			return nil, err
+		}
+		switch c.Type {
+		case ChunkStringPool:
+			if doc.Strings, err = ParseStringPool(c); err != nil {
+				return nil, err
+			}
+		case ChunkXMLResMap:
+			for i := 0; i+4 <= len(c.Body); i += 4 {
+				doc.ResourceMap = append(doc.ResourceMap, binary.LittleEndian.Uint32(c.Body[i:]))
+			}
+		case ChunkXMLStartNS:
+			if len(c.Body) < 8 {
+				return nil, errTruncatedChunk
+			}
+			pending = append(pending, Namespace{
+				Prefix: doc.Strings.Get(binary.LittleEndian.Uint32(c.Body[0:])),
+				URI:    doc.Strings.Get(binary.LittleEndian.Uint32(c.Body[4:])),
+			})
+		case ChunkXMLEndNS:
+			// Nothing to do; scoping is implied by the element tree.
+		case ChunkXMLStartElem:
+			e, err := doc.parseStartElement(c)
+			if err != nil {
+				return nil, err
+			}
+			e.Namespaces, pending = pending, nil
+			if len(stack) == 0 {
+				if doc.Root != nil {
+					return nil, errors.New("multiple root elements")
+				}
+				doc.Root = e
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, e)
+			}
+			stack = append(stack, e)
+		case ChunkXMLEndElem:
+			if len(stack) == 0 {
+				return nil, errors.New("unbalanced end element")
+			}
+			stack = stack[:len(stack)-1]
+		case ChunkXMLCData:
+			if len(c.Body) < 4 {
+				return nil, errTruncatedChunk
+			}
+			if len(stack) != 0 {
+				stack[len(stack)-1].Text += doc.Strings.Get(binary.LittleEndian.Uint32(c.Body))
+			}
+		default:
+			// Unknown chunks are skipped, as the platform does.
+		}
+	}
+	if doc.Root == nil {
+		return nil, errors.New("no root element")
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("element %s not closed", stack[len(stack)-1].Name)
+	}
+	return doc, nil
+}
+
+func (doc *Document) parseStartElement(c Chunk) (*Element, error) {
+	if len(c.Header) < xmlNodeHeaderSize || len(c.Body) < xmlAttrExtSize {
+		return nil, errTruncatedChunk
+	}
+	b := c.Body
+	e := &Element{
+		Line:      binary.LittleEndian.Uint32(c.Header[8:]),
+		Namespace: doc.Strings.Get(binary.LittleEndian.Uint32(b[0:])),
+		Name:      doc.Strings.Get(binary.LittleEndian.Uint32(b[4:])),
+	}
+	attrStart := int(binary.LittleEndian.Uint16(b[8:]))
+	attrSize := int(binary.LittleEndian.Uint16(b[10:]))
+	attrCount := int(binary.LittleEndian.Uint16(b[12:]))
+	if attrSize < xmlAttributeSize || attrStart+attrSize*attrCount > len(b) {
+		return nil, fmt.Errorf("element %s: bad attribute layout", e.Name)
+	}
+	for i := 0; i < attrCount; i++ {
+		ab := b[attrStart+i*attrSize:]
+		nameIdx := binary.LittleEndian.Uint32(ab[4:])
+		a := Attr{
+			Namespace: doc.Strings.Get(binary.LittleEndian.Uint32(ab[0:])),
+			Name:      doc.Strings.Get(nameIdx),
+		}
+		if rawIdx := binary.LittleEndian.Uint32(ab[8:]); rawIdx != noEntry {
+			a.Raw = doc.Strings.Get(rawIdx)
+		}
+		if nameIdx < uint32(len(doc.ResourceMap)) {
+			a.ResourceId = doc.ResourceMap[nameIdx]
+		}
+		// Some tools strip attribute names that have a resource
+		// ID; fall back on the framework names we know about.
+		if a.Name == "" {
+			a.Name = androidAttrNames[a.ResourceId]
+		}
+		var err error
+		if a.Value, err = ReadValue(ab[12:], doc.Strings); err != nil {
+			return nil, err
+		}
+		e.Attrs = append(e.Attrs, a)
+	}
+	return e, nil
+}
+
+// Attr returns the value of the attribute with the given namespace
+// URI and name, or nil if there is no such attribute.
+func (e *Element) Attr(namespace, name string) *Value {
+	for i := range e.Attrs {
+		if e.Attrs[i].Namespace == namespace && e.Attrs[i].Name == name {
+			return &e.Attrs[i].Value
+		}
+	}
+	return nil
+}
+
+// AttrString returns the value of an attribute rendered as a string,
+// or "" if the attribute is not present.
+func (e *Element) AttrString(namespace, name string) string {
+	if v := e.Attr(namespace, name); v != nil {
+		return v.String()
+	}
+	return ""
+}
+
+// ChildrenNamed returns the child elements with the given name.
+func (e *Element) ChildrenNamed(name string) []*Element {
+	var retval []*Element
+	for _, c := range e.Children {
+		if c.Name == name {
+			retval = append(retval, c)
+		}
+	}
+	return retval
+}
+
+// Resolve fills in the names of resources referred to by attribute
+// values, so that they display as "@string/app_name" rather than
+// "@0x7f040001". IDs that 'r' does not know are left alone.
+func (doc *Document) Resolve(r Resolver) {
+	var walk func(e *Element)
+	walk = func(e *Element) {
+		for i := range e.Attrs {
+			v := &e.Attrs[i].Value
+			if v.IsReference() {
+				if name, ok := r.ResourceName(v.Data); ok {
+					v.Ref = name
+				}
+			}
+		}
+		for _, c := range e.Children {
+			walk(c)
+		}
+	}
+	if doc.Root != nil {
+		walk(doc.Root)
+	}
+}
+
+// WriteXML writes the document to 'w' as plain (indented) XML.
+func (doc *Document) WriteXML(w io.Writer) error {
+	if _, err := io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"); err != nil {
+		return err
+	}
+	return writeElement(w, doc.Root, 0, map[string]string{})
+}
+
+func qualify(prefixes map[string]string, namespace, name string) string {
+	if p, ok := prefixes[namespace]; ok && p != "" {
+		return p + ":" + name
+	}
+	return name
+}
+
+func escape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+func writeElement(w io.Writer, e *Element, depth int, prefixes map[string]string) error {
+	if len(e.Namespaces) != 0 {
+		inner := make(map[string]string, len(prefixes)+len(e.Namespaces))
+		for k, v := range prefixes {
+			inner[k] = v
+		}
+		for _, ns := range e.Namespaces {
+			inner[ns.URI] = ns.Prefix
+		}
+		prefixes = inner
+	}
+	indent := strings.Repeat("  ", depth)
+	var sb strings.Builder
+	sb.WriteString(indent + "<" + qualify(prefixes, e.Namespace, e.Name))
+	for _, ns := range e.Namespaces {
+		fmt.Fprintf(&sb, ` xmlns:%s="%s"`, ns.Prefix, escape(ns.URI))
+	}
+	for _, a := range e.Attrs {
+		name := a.Name
+		if name == "" {
+			name = fmt.Sprintf("attr_0x%08x", a.ResourceId)
+		}
+		fmt.Fprintf(&sb, ` %s="%s"`, qualify(prefixes, a.Namespace, name), escape(a.Value.String()))
+	}
+	if len(e.Children) == 0 && e.Text == "" {
+		sb.WriteString("/>\n")
+		_, err := io.WriteString(w, sb.String())
+		return err
+	}
+	sb.WriteString(">")
+	if e.Text != "" {
+		sb.WriteString(escape(e.Text))
+	}
+	if len(e.Children) != 0 {
+		sb.WriteString("\n")
+	}
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return err
+	}
+	for _, c := range e.Children {
+		if err := writeElement(w, c, depth+1, prefixes); err != nil {
+			return err
+		}
+	}
+	closing := "</" + qualify(prefixes, e.Namespace, e.Name) + ">\n"
+	if len(e.Children) != 0 {
+		closing = indent + closing
+	}
+	_, err := io.WriteString(w, closing)
+	return err
+}
@@ -0,0 +1,223 @@
+package axmlread
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+const android = AndroidNamespace
+
+// buildManifest writes a small manifest; if 'stripped' is set the
+// android: attribute names are left out, as some obfuscators do.
+func buildManifest(stripped bool) []byte {
+	b := newXMLBuilder()
+	a := func(name string) string {
+		if stripped {
+			return ""
+		}
+		return name
+	}
+	b.startNS("android", android)
+	b.start("", "manifest",
+		intAttr(android, a("versionCode"), 0x0101021b, TypeIntDec, 42),
+		strAttr(android, a("versionName"), 0x0101021c, "1.2 <beta>"),
+		strAttr("", "package", 0, "com.example.app"))
+	b.start("", "uses-sdk",
+		intAttr(android, a("minSdkVersion"), 0x0101020c, TypeIntDec, 21),
+		strAttr(android, a("targetSdkVersion"), 0x01010270, "33"))
+	b.end("", "uses-sdk")
+	b.start("", "uses-permission", strAttr(android, a("name"), 0x01010003, "android.permission.INTERNET"))
+	b.end("", "uses-permission")
+	b.start("", "application",
+		intAttr(android, "icon", 0x01010002, TypeReference, 0x7f020000))
+	b.start("", "activity",
+		strAttr(android, a("name"), 0x01010003, ".MainActivity"),
+		intAttr(android, a("exported"), 0x01010010, TypeIntBoolean, 0xffffffff))
+	b.start("", "intent-filter")
+	b.start("", "action", strAttr(android, a("name"), 0x01010003, "android.intent.action.VIEW"))
+	b.end("", "action")
+	b.start("", "category", strAttr(android, a("name"), 0x01010003, "android.intent.category.BROWSABLE"))
+	b.end("", "category")
+	b.start("", "data",
+		strAttr(android, a("scheme"), 0x01010027, "https"),
+		strAttr(android, a("host"), 0x01010028, "example.com"))
+	b.end("", "data")
+	b.end("", "intent-filter")
+	b.end("", "activity")
+	b.start("", "service", strAttr(android, a("name"), 0x01010003, "com.other.Sync"))
+	b.end("", "service")
+	b.start("", "receiver", strAttr(android, a("name"), 0x01010003, "Boot"))
+	b.end("", "receiver")
+	b.start("", "provider",
+		strAttr(android, a("name"), 0x01010003, ".Files"),
+		intAttr(android, a("exported"), 0x01010010, TypeIntBoolean, 0))
+	b.end("", "provider")
+	b.end("", "application")
+	b.end("", "manifest")
+	b.endNS("android", android)
+	return b.build()
+}
+
+func TestWriteXML(t *testing.T) {
+	doc, err := Parse(buildManifest(false))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	var buf bytes.Buffer
+	if err := doc.WriteXML(&buf); err != nil {
+		t.Fatalf("WriteXML error %v", err)
+	}
+	expected := `<?xml version="1.0" encoding="utf-8"?>
+<manifest xmlns:android="http://schemas.android.com/apk/res/android" android:versionCode="42" android:versionName="1.2 &lt;beta&gt;" package="com.example.app">
+  <uses-sdk android:minSdkVersion="21" android:targetSdkVersion="33"/>
+  <uses-permission android:name="android.permission.INTERNET"/>
+  <application android:icon="@0x7f020000">
+    <activity android:name=".MainActivity" android:exported="true">
+      <intent-filter>
+        <action android:name="android.intent.action.VIEW"/>
+        <category android:name="android.intent.category.BROWSABLE"/>
+        <data android:scheme="https" android:host="example.com"/>
+      </intent-filter>
+    </activity>
+    <service android:name="com.other.Sync"/>
+    <receiver android:name="Boot"/>
+    <provider android:name=".Files" android:exported="false"/>
+  </application>
+</manifest>
+`
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	for _, stripped := range []bool{false, true} {
+		doc, err := Parse(buildManifest(stripped))
+		if err != nil {
+			t.Fatalf("Parse error %v", err)
+		}
+		m, err := doc.Manifest()
+		if err != nil {
+			t.Fatalf("Manifest error %v", err)
+		}
+		if m.Package != "com.example.app" || m.VersionCode != 42 ||
+			m.VersionName != "1.2 <beta>" || m.MinSdkVersion != 21 ||
+			m.TargetSdkVersion != 33 {
+			t.Errorf("stripped=%v: unexpected manifest %+v", stripped, m)
+		}
+		if len(m.Permissions) != 1 || m.Permissions[0] != "android.permission.INTERNET" {
+			t.Errorf("stripped=%v: permissions %v", stripped, m.Permissions)
+		}
+		if len(m.Activities) != 1 || len(m.Services) != 1 ||
+			len(m.Receivers) != 1 || len(m.Providers) != 1 {
+			t.Fatalf("stripped=%v: unexpected components %+v", stripped, m)
+		}
+		act := m.Activities[0]
+		if act.Name != "com.example.app.MainActivity" || act.Exported == nil || !*act.Exported {
+			t.Errorf("stripped=%v: activity %+v", stripped, act)
+		}
+		if len(act.IntentFilters) != 1 {
+			t.Fatalf("stripped=%v: activity filters %+v", stripped, act.IntentFilters)
+		}
+		f := act.IntentFilters[0]
+		if len(f.Actions) != 1 || f.Actions[0] != "android.intent.action.VIEW" ||
+			len(f.Categories) != 1 || len(f.Data) != 1 ||
+			f.Data[0].Scheme != "https" || f.Data[0].Host != "example.com" {
+			t.Errorf("stripped=%v: intent filter %+v", stripped, f)
+		}
+		if m.Services[0].Name != "com.other.Sync" || m.Services[0].Exported != nil ||
+			m.Receivers[0].Name != "com.example.app.Boot" ||
+			m.Providers[0].Name != "com.example.app.Files" || *m.Providers[0].Exported {
+			t.Errorf("stripped=%v: unexpected components %+v", stripped, m)
+		}
+	}
+}
+
+func TestUTF8StringPool(t *testing.T) {
+	b := newXMLBuilder()
+	b.utf8 = true
+	b.start("", "string", strAttr("", "name", 0, "café"))
+	b.text("héllo & bye")
+	b.end("", "string")
+	doc, err := Parse(b.build())
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	if !doc.Strings.UTF8 || doc.Root.AttrString("", "name") != "café" ||
+		doc.Root.Text != "héllo & bye" {
+		t.Errorf("unexpected document %+v", doc.Root)
+	}
+	var buf bytes.Buffer
+	doc.WriteXML(&buf)
+	if s := buf.String(); s != "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<string name=\"café\">héllo &amp; bye</string>\n" {
+		t.Errorf("WriteXML got '%s'", s)
+	}
+}
+
+func TestValueStrings(t *testing.T) {
+	values := []struct {
+		v        Value
+		expected string
+	}{
+		{Value{Type: TypeReference, Data: 0x7f040001}, "@0x7f040001"},
+		{Value{Type: TypeAttribute, Data: 0x01010036}, "?0x01010036"},
+		{Value{Type: TypeIntHex, Data: 0x30}, "0x30"},
+		{Value{Type: TypeIntDec, Data: 0xffffffff}, "-1"},
+		{Value{Type: TypeFloat, Data: 0x3fc00000}, "1.5"},
+		{Value{Type: TypeDimension, Data: 0x1001}, "16dp"},
+		{Value{Type: TypeDimension, Data: 0x00000110 | 2}, "0.0078125sp"},
+		{Value{Type: TypeFraction, Data: 0x40000030}, "50%"},
+		{Value{Type: TypeIntColorARGB8, Data: 0xff00ff00}, "#ff00ff00"},
+		{Value{Type: TypeIntColorRGB4, Data: 0xffaabbcc}, "#abc"},
+	}
+	for _, tc := range values {
+		if s := tc.v.String(); s != tc.expected {
+			t.Errorf("type 0x%02x data 0x%x: got '%s' expected '%s'",
+				uint8(tc.v.Type), tc.v.Data, s, tc.expected)
+		}
+	}
+}
+
+func TestBadXML(t *testing.T) {
+	if _, err := Parse([]byte("PK\003\004 not xml at all")); err == nil {
+		t.Errorf("expected error")
+	}
+	data := buildManifest(false)
+	if _, err := Parse(data[:len(data)-12]); err == nil {
+		t.Errorf("expected error for truncated document")
+	}
+}
+
+func TestTruncatedStringPool(t *testing.T) {
+	for _, start := range []uint32{5000, stringPoolHeaderSize - 1} {
+		data := stringPoolChunk([]string{"a", "b"}, false)
+		binary.LittleEndian.PutUint32(data[20:], start)
+		c, _, err := ReadChunk(data)
+		if err != nil {
+			t.Fatalf("ReadChunk error %v", err)
+		}
+		if _, err := ParseStringPool(c); err == nil || err.Error() != "bad string pool header" {
+			t.Errorf("strings start %d: got error %v", start, err)
+		}
+	}
+}
+
+type mapResolver map[uint32]string
+
+func (m mapResolver) ResourceName(id uint32) (string, bool) {
+	name, ok := m[id]
+	return name, ok
+}
+
+func TestResolve(t *testing.T) {
+	doc, err := Parse(buildManifest(false))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	app := doc.Root.ChildrenNamed("application")[0]
+	doc.Resolve(mapResolver{0x7f020000: "drawable/icon"})
+	if s := app.AttrString(android, "icon"); s != "@drawable/icon" {
+		t.Errorf("resolved icon got '%s'", s)
+	}
+}
@@ -0,0 +1,194 @@
+package axmlread
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+//
+// A small binary XML writer for unit tests. Elements and namespaces
+// are recorded as they are added, and only turned into chunks by
+// build(), since attribute names with resource IDs have to come first
+// in the string pool (the resource map is indexed by string index).
+//
+
+type testAttr struct {
+	ns, name string
+	resId    uint32
+	typ      ValueType
+	data     uint32
+	str      string
+}
+
+type testEvent struct {
+	kind     uint16
+	ns, name string
+	attrs    []testAttr
+	text     string
+}
+
+type xmlBuilder struct {
+	events    []testEvent
+	strings   []string
+	stringIdx map[string]uint32
+	resIdx    map[uint32]uint32
+	utf8      bool
+}
+
+func newXMLBuilder() *xmlBuilder {
+	return &xmlBuilder{stringIdx: make(map[string]uint32), resIdx: make(map[uint32]uint32)}
+}
+
+func (b *xmlBuilder) startNS(prefix, uri string) {
+	b.events = append(b.events, testEvent{kind: ChunkXMLStartNS, ns: uri, name: prefix})
+}
+
+func (b *xmlBuilder) endNS(prefix, uri string) {
+	b.events = append(b.events, testEvent{kind: ChunkXMLEndNS, ns: uri, name: prefix})
+}
+
+func (b *xmlBuilder) start(ns, name string, attrs ...testAttr) {
+	b.events = append(b.events, testEvent{kind: ChunkXMLStartElem, ns: ns, name: name, attrs: attrs})
+}
+
+func (b *xmlBuilder) text(s string) {
+	b.events = append(b.events, testEvent{kind: ChunkXMLCData, text: s})
+}
+
+func (b *xmlBuilder) end(ns, name string) {
+	b.events = append(b.events, testEvent{kind: ChunkXMLEndElem, ns: ns, name: name})
+}
+
+func strAttr(ns, name string, resId uint32, s string) testAttr {
+	return testAttr{ns: ns, name: name, resId: resId, typ: TypeString, str: s}
+}
+
+func intAttr(ns, name string, resId uint32, typ ValueType, v uint32) testAttr {
+	return testAttr{ns: ns, name: name, resId: resId, typ: typ, data: v}
+}
+
+func (b *xmlBuilder) str(s string) uint32 {
+	if s == "" {
+		return noEntry
+	}
+	if idx, ok := b.stringIdx[s]; ok {
+		return idx
+	}
+	b.stringIdx[s] = uint32(len(b.strings))
+	b.strings = append(b.strings, s)
+	return uint32(len(b.strings) - 1)
+}
+
+func le(buf *bytes.Buffer, v interface{}) {
+	binary.Write(buf, binary.LittleEndian, v)
+}
+
+// chunk wraps 'header' (the part of the header following the
+// generic chunk header) and 'body' in a ResChunk_header.
+func chunk(typ uint16, header, body []byte) []byte {
+	var buf bytes.Buffer
+	le(&buf, typ)
+	le(&buf, uint16(8+len(header)))
+	le(&buf, uint32(8+len(header)+len(body)))
+	buf.Write(header)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func stringPoolChunk(strs []string, utf8 bool) []byte {
+	var data bytes.Buffer
+	var offsets bytes.Buffer
+	for _, s := range strs {
+		le(&offsets, uint32(data.Len()))
+		if utf8 {
+			data.WriteByte(byte(len(utf16.Encode([]rune(s)))))
+			data.WriteByte(byte(len(s)))
+			data.WriteString(s)
+			data.WriteByte(0)
+		} else {
+			u := utf16.Encode([]rune(s))
+			le(&data, uint16(len(u)))
+			le(&data, u)
+			le(&data, uint16(0))
+		}
+	}
+	for data.Len()%4 != 0 {
+		data.WriteByte(0)
+	}
+	var hdr bytes.Buffer
+	var flags uint32
+	if utf8 {
+		flags = stringPoolUTF8Flag
+	}
+	le(&hdr, uint32(len(strs)))
+	le(&hdr, uint32(0))
+	le(&hdr, flags)
+	le(&hdr, uint32(stringPoolHeaderSize+offsets.Len()))
+	le(&hdr, uint32(0))
+	return chunk(ChunkStringPool, hdr.Bytes(), append(offsets.Bytes(), data.Bytes()...))
+}
+
+func (b *xmlBuilder) build() []byte {
+	// Attribute names with resource IDs go first. These get a slot
+	// of their own even if the name is empty (stripped).
+	var resMap bytes.Buffer
+	for _, e := range b.events {
+		for _, a := range e.attrs {
+			if _, ok := b.resIdx[a.resId]; a.resId != 0 && !ok {
+				b.resIdx[a.resId] = uint32(len(b.strings))
+				b.strings = append(b.strings, a.name)
+				le(&resMap, a.resId)
+			}
+		}
+	}
+
+	var nodes bytes.Buffer
+	for line, e := range b.events {
+		var hdr bytes.Buffer
+		le(&hdr, uint32(line+1))
+		le(&hdr, uint32(noEntry))
+		var body bytes.Buffer
+		switch e.kind {
+		case ChunkXMLStartNS, ChunkXMLEndNS:
+			le(&body, b.str(e.name))
+			le(&body, b.str(e.ns))
+		case ChunkXMLEndElem:
+			le(&body, b.str(e.ns))
+			le(&body, b.str(e.name))
+		case ChunkXMLCData:
+			le(&body, b.str(e.text))
+			le(&body, []uint32{0x03000008, b.str(e.text)})
+		case ChunkXMLStartElem:
+			le(&body, b.str(e.ns))
+			le(&body, b.str(e.name))
+			le(&body, []uint16{xmlAttrExtSize, xmlAttributeSize, uint16(len(e.attrs)), 0, 0, 0})
+			for _, a := range e.attrs {
+				raw, data := uint32(noEntry), a.data
+				if a.typ == TypeString {
+					raw = b.str(a.str)
+					data = raw
+				}
+				name, ok := b.resIdx[a.resId]
+				if !ok {
+					name = b.str(a.name)
+				}
+				le(&body, b.str(a.ns))
+				le(&body, name)
+				le(&body, raw)
+				le(&body, []uint16{resValueSize})
+				le(&body, []uint8{0, uint8(a.typ)})
+				le(&body, data)
+			}
+		}
+		nodes.Write(chunk(e.kind, hdr.Bytes(), body.Bytes()))
+	}
+
+	var all bytes.Buffer
+	all.Write(stringPoolChunk(b.strings, b.utf8))
+	if resMap.Len() != 0 {
+		all.Write(chunk(ChunkXMLResMap, nil, resMap.Bytes()))
+	}
+	all.Write(nodes.Bytes())
+	return chunk(ChunkXML, nil, all.Bytes())
+}
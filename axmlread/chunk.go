@@ -0,0 +1,184 @@
+package axmlread
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+//
+// Chunk headers and string pools, which are common to binary XML and
+// to the resource table (resources.arsc). See ResChunk_header and
+// ResStringPool_header in frameworks/base/libs/androidfw/include/
+// androidfw/ResourceTypes.h.
+//
+
+// Chunk types
+const (
+	ChunkNull          = 0x0000
+	ChunkStringPool    = 0x0001
+	ChunkTable         = 0x0002
+	ChunkXML           = 0x0003
+	ChunkXMLStartNS    = 0x0100
+	ChunkXMLEndNS      = 0x0101
+	ChunkXMLStartElem  = 0x0102
+	ChunkXMLEndElem    = 0x0103
+	ChunkXMLCData      = 0x0104
+	ChunkXMLResMap     = 0x0180
+	ChunkTablePackage  = 0x0200
+	ChunkTableType     = 0x0201
+	ChunkTableTypeSpec = 0x0202
+	ChunkTableLibrary  = 0x0203
+)
+
+const (
+	chunkHeaderSize      = 8
+	stringPoolHeaderSize = 28
+	stringPoolUTF8Flag   = 0x100
+)
+
+// Chunk is a single ResChunk_header along with the bytes it covers.
+// Header holds the bytes of the (type-specific) header, and Body the
+// bytes following the header up to the end of the chunk.
+type Chunk struct {
+	Type   uint16
+	Header []byte
+	Body   []byte
+}
+
+var errTruncatedChunk = errors.New("truncated chunk")
+
+// ReadChunk decodes the chunk at the start of 'data', returning it
+// along with the remainder of 'data' following the chunk.
+func ReadChunk(data []byte) (Chunk, []byte, error) {
+	var c Chunk
+	if len(data) < chunkHeaderSize {
+		return c, nil, errTruncatedChunk
+	}
+	c.Type = binary.LittleEndian.Uint16(data[0:])
+	headerSize := uint32(binary.LittleEndian.Uint16(data[2:]))
+	size := binary.LittleEndian.Uint32(data[4:])
+	if headerSize < chunkHeaderSize || headerSize > size || uint64(size) > uint64(len(data)) {
+		return c, nil, fmt.Errorf("bad chunk type 0x%04x header size %d size %d", c.Type, headerSize, size)
+	}
+	c.Header = data[:headerSize]
+	c.Body = data[headerSize:size]
+	return c, data[size:], nil
+}
+
+// StringPool is a decoded ResStringPool. Style information is not
+// retained.
+type StringPool struct {
+	Strings []string
+	UTF8    bool
+}
+
+// Get returns string 'idx', or the empty string if 'idx' is out of
+// range (0xffffffff is used throughout the format to mean "none").
+func (p *StringPool) Get(idx uint32) string {
+	if idx >= uint32(len(p.Strings)) {
+		return ""
+	}
+	return p.Strings[idx]
+}
+
+// ParseStringPool decodes a string pool chunk (as returned by
+// ReadChunk).
+func ParseStringPool(c Chunk) (*StringPool, error) {
+	if c.Type != ChunkStringPool || len(c.Header) < stringPoolHeaderSize {
+		return nil, errors.New("not a string pool chunk")
+	}
+	h := c.Header
+	count := binary.LittleEndian.Uint32(h[8:])
+	flags := binary.LittleEndian.Uint32(h[16:])
+	stringsStart := binary.LittleEndian.Uint32(h[20:])
+
+	// Offsets are relative to the start of the chunk, which is
+	// where the header begins.
+	hsize := uint32(len(h))
+	if uint64(count)*4 > uint64(len(c.Body)) || stringsStart < hsize ||
+		uint64(stringsStart-hsize) > uint64(len(c.Body)) {
+		return nil, errors.New("bad string pool header")
+	}
+	pool := &StringPool{UTF8: flags&stringPoolUTF8Flag != 0}
+	data := c.Body[stringsStart-hsize:]
+	for i := uint32(0); i < count; i++ {
+		off := binary.LittleEndian.Uint32(c.Body[i*4:])
+		if uint64(off) >= uint64(len(data)) {
+			return nil, fmt.Errorf("string %d offset %d out of range", i, off)
+		}
+		var s string
+		var err error
+		if pool.UTF8 {
+			s, err = decodeUTF8String(data[off:])
+		} else {
+			s, err = decodeUTF16String(data[off:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("string %d: %v", i, err)
+		}
+		pool.Strings = append(pool.Strings, s)
+	}
+	return pool, nil
+}
+
+var errTruncatedString = errors.New("truncated string")
+
+// UTF-8 strings are preceded by their length in UTF-16 code units
+// and then in bytes; each length is one byte, or two if the high bit
+// of the first is set.
+func decodeUTF8String(b []byte) (string, error) {
+	pos := 0
+	grabLen := func() (int, error) {
+		if pos >= len(b) {
+			return 0, errTruncatedString
+		}
+		n := int(b[pos])
+		pos++
+		if n&0x80 != 0 {
+			if pos >= len(b) {
+				return 0, errTruncatedString
+			}
+			n = (n&0x7f)<<8 | int(b[pos])
+			pos++
+		}
+		return n, nil
+	}
+	if _, err := grabLen(); err != nil {
+		return "", err
+	}
+	n, err := grabLen()
+	if err != nil {
+		return "", err
+	}
+	if pos+n > len(b) {
+		return "", errTruncatedString
+	}
+	return string(b[pos : pos+n]), nil
+}
+
+// UTF-16 strings are preceded by their length in code units, which
+// is one unit, or two if the high bit of the first is set.
+func decodeUTF16String(b []byte) (string, error) {
+	if len(b) < 2 {
+		return "", errTruncatedString
+	}
+	n := int(binary.LittleEndian.Uint16(b))
+	pos := 2
+	if n&0x8000 != 0 {
+		if len(b) < 4 {
+			return "", errTruncatedString
+		}
+		n = (n&0x7fff)<<16 | int(binary.LittleEndian.Uint16(b[2:]))
+		pos = 4
+	}
+	if pos+2*n > len(b) {
+		return "", errTruncatedString
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[pos+2*i:])
+	}
+	return string(utf16.Decode(units)), nil
+}
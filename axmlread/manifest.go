@@ -0,0 +1,163 @@
+package axmlread
+
+import (
+	"errors"
+	"strings"
+)
+
+//
+// Interpretation of a decoded AndroidManifest.xml, see
+// https://developer.android.com/guide/topics/manifest/manifest-intro
+//
+
+// Resource IDs of the framework attributes consulted below, for use
+// when a manifest has had its attribute names stripped.
+var androidAttrNames = map[uint32]string{
+	0x01010003: "name",
+	0x01010006: "permission",
+	0x01010010: "exported",
+	0x01010018: "authorities",
+	0x01010026: "mimeType",
+	0x01010027: "scheme",
+	0x01010028: "host",
+	0x01010029: "port",
+	0x0101002a: "path",
+	0x0101002b: "pathPrefix",
+	0x0101002c: "pathPattern",
+	0x0101020c: "minSdkVersion",
+	0x0101021b: "versionCode",
+	0x0101021c: "versionName",
+	0x01010270: "targetSdkVersion",
+}
+
+// Manifest holds the interesting parts of an AndroidManifest.xml.
+// Permissions are the ones the app requests (uses-permission).
+type Manifest struct {
+	Package          string
+	VersionCode      int
+	VersionName      string
+	MinSdkVersion    int
+	TargetSdkVersion int
+	Permissions      []string
+	Activities       []Component
+	Services         []Component
+	Receivers        []Component
+	Providers        []Component
+}
+
+// Component is an activity, service, receiver or provider. Name is
+// fully qualified. Exported is nil if the manifest does not say
+// either way.
+type Component struct {
+	Name          string
+	Exported      *bool
+	Permission    string
+	IntentFilters []IntentFilter
+}
+
+// IntentFilter is an intent-filter within a component.
+type IntentFilter struct {
+	Actions    []string
+	Categories []string
+	Data       []IntentData
+}
+
+// IntentData is a <data> element within an intent filter.
+type IntentData struct {
+	Scheme, Host, Port            string
+	Path, PathPrefix, PathPattern string
+	MimeType                      string
+}
+
+// Manifest interprets the document as an AndroidManifest.xml.
+func (doc *Document) Manifest() (*Manifest, error) {
+	root := doc.Root
+	if root == nil || root.Name != "manifest" {
+		return nil, errors.New("root element is not <manifest>")
+	}
+	m := &Manifest{
+		Package:     root.AttrString("", "package"),
+		VersionName: root.AttrString(AndroidNamespace, "versionName"),
+	}
+	m.VersionCode = androidInt(root, "versionCode")
+	for _, e := range root.ChildrenNamed("uses-sdk") {
+		m.MinSdkVersion = androidInt(e, "minSdkVersion")
+		m.TargetSdkVersion = androidInt(e, "targetSdkVersion")
+	}
+	for _, e := range root.Children {
+		switch e.Name {
+		case "uses-permission", "uses-permission-sdk-23", "uses-permission-sdk-m":
+			m.Permissions = append(m.Permissions, e.AttrString(AndroidNamespace, "name"))
+		}
+	}
+	for _, app := range root.ChildrenNamed("application") {
+		for _, e := range app.Children {
+			switch e.Name {
+			case "activity", "activity-alias":
+				m.Activities = append(m.Activities, m.component(e))
+			case "service":
+				m.Services = append(m.Services, m.component(e))
+			case "receiver":
+				m.Receivers = append(m.Receivers, m.component(e))
+			case "provider":
+				m.Providers = append(m.Providers, m.component(e))
+			}
+		}
+	}
+	return m, nil
+}
+
+// androidInt returns the integer value of attribute android:'name',
+// or zero if it is absent or not an integer.
+func androidInt(e *Element, name string) int {
+	if v := e.Attr(AndroidNamespace, name); v != nil {
+		n, _ := v.Int()
+		return n
+	}
+	return 0
+}
+
+// className expands a component name relative to the package, as
+// the platform does: ".Foo" and "Foo" both mean "<package>.Foo".
+func (m *Manifest) className(name string) string {
+	if strings.HasPrefix(name, ".") {
+		return m.Package + name
+	}
+	if name != "" && !strings.Contains(name, ".") {
+		return m.Package + "." + name
+	}
+	return name
+}
+
+func (m *Manifest) component(e *Element) Component {
+	c := Component{
+		Name:       m.className(e.AttrString(AndroidNamespace, "name")),
+		Permission: e.AttrString(AndroidNamespace, "permission"),
+	}
+	if v := e.Attr(AndroidNamespace, "exported"); v != nil {
+		exported := v.String() == "true"
+		c.Exported = &exported
+	}
+	for _, f := range e.ChildrenNamed("intent-filter") {
+		var filter IntentFilter
+		for _, a := range f.ChildrenNamed("action") {
+			filter.Actions = append(filter.Actions, a.AttrString(AndroidNamespace, "name"))
+		}
+		for _, a := range f.ChildrenNamed("category") {
+			filter.Categories = append(filter.Categories, a.AttrString(AndroidNamespace, "name"))
+		}
+		for _, d := range f.ChildrenNamed("data") {
+			filter.Data = append(filter.Data, IntentData{
+				Scheme:      d.AttrString(AndroidNamespace, "scheme"),
+				Host:        d.AttrString(AndroidNamespace, "host"),
+				Port:        d.AttrString(AndroidNamespace, "port"),
+				Path:        d.AttrString(AndroidNamespace, "path"),
+				PathPrefix:  d.AttrString(AndroidNamespace, "pathPrefix"),
+				PathPattern: d.AttrString(AndroidNamespace, "pathPattern"),
+				MimeType:    d.AttrString(AndroidNamespace, "mimeType"),
+			})
+		}
+		c.IntentFilters = append(c.IntentFilters, filter)
+	}
+	return c
+}
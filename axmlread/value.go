@@ -0,0 +1,165 @@
+package axmlread
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ValueType is the dataType of a Res_value.
+type ValueType uint8
+
+const (
+	TypeNull             ValueType = 0x00
+	TypeReference        ValueType = 0x01
+	TypeAttribute        ValueType = 0x02
+	TypeString           ValueType = 0x03
+	TypeFloat            ValueType = 0x04
+	TypeDimension        ValueType = 0x05
+	TypeFraction         ValueType = 0x06
+	TypeDynamicReference ValueType = 0x07
+	TypeIntDec           ValueType = 0x10
+	TypeIntHex           ValueType = 0x11
+	TypeIntBoolean       ValueType = 0x12
+	TypeIntColorARGB8    ValueType = 0x1c
+	TypeIntColorRGB8     ValueType = 0x1d
+	TypeIntColorARGB4    ValueType = 0x1e
+	TypeIntColorRGB4     ValueType = 0x1f
+)
+
+const resValueSize = 8
+
+// Value is a typed resource value (Res_value). For TypeString values
+// Str holds the referenced string; for everything else the payload
+// is in Data. Ref is the "type/name" of a reference or attribute
+// value, once resolved (see Document.Resolve).
+type Value struct {
+	Type ValueType
+	Data uint32
+	Str  string
+	Ref  string
+}
+
+// A Resolver maps resource IDs to "type/name" form; the resource
+// table (see arscread) is one.
+type Resolver interface {
+	ResourceName(id uint32) (string, bool)
+}
+
+// IsReference reports whether the value refers to another resource
+// (or to a theme attribute).
+func (v Value) IsReference() bool {
+	return v.Type == TypeReference || v.Type == TypeDynamicReference ||
+		v.Type == TypeAttribute
+}
+
+// ReadValue decodes the Res_value at the start of 'b', looking up
+// string values in 'pool'.
+func ReadValue(b []byte, pool *StringPool) (Value, error) {
+	if len(b) < resValueSize {
+		return Value{}, errTruncatedChunk
+	}
+	v := Value{
+		Type: ValueType(b[3]),
+		Data: binary.LittleEndian.Uint32(b[4:]),
+	}
+	if v.Type == TypeString {
+		v.Str = pool.Get(v.Data)
+	}
+	return v, nil
+}
+
+// IsInt reports whether the value holds an integer (decimal, hex,
+// boolean or color).
+func (v Value) IsInt() bool {
+	return v.Type >= TypeIntDec && v.Type <= TypeIntColorRGB4
+}
+
+// Int returns the value as an integer: integer values are returned
+// directly, and string values are parsed if possible.
+func (v Value) Int() (int, bool) {
+	if v.IsInt() {
+		return int(int32(v.Data)), true
+	}
+	if v.Type == TypeString {
+		if n, err := strconv.Atoi(v.Str); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+var dimensionUnits = []string{"px", "dp", "sp", "pt", "in", "mm"}
+var fractionUnits = []string{"%", "%p"}
+
+// complexToFloat decodes the mantissa/radix representation used for
+// dimensions and fractions.
+func complexToFloat(data uint32) float64 {
+	mantissa := float64(int32(data&0xffffff00) >> 8)
+	switch (data >> 4) & 3 {
+	case 0:
+		return mantissa
+	case 1:
+		return mantissa / (1 << 7)
+	case 2:
+		return mantissa / (1 << 15)
+	default:
+		return mantissa / (1 << 23)
+	}
+}
+
+func unitName(units []string, u uint32) string {
+	if u < uint32(len(units)) {
+		return units[u]
+	}
+	return fmt.Sprintf("unit%d", u)
+}
+
+// String renders the value the way aapt's "dump xmltree" would
+// display it in a source XML file, e.g. "@0x7f040001" for a
+// reference or "16.0dp" for a dimension.
+func (v Value) String() string {
+	switch v.Type {
+	case TypeNull:
+		return ""
+	case TypeReference, TypeDynamicReference:
+		if v.Ref != "" {
+			return "@" + v.Ref
+		}
+		return fmt.Sprintf("@0x%08x", v.Data)
+	case TypeAttribute:
+		if v.Ref != "" {
+			return "?" + v.Ref
+		}
+		return fmt.Sprintf("?0x%08x", v.Data)
+	case TypeString:
+		return v.Str
+	case TypeFloat:
+		return strconv.FormatFloat(float64(math.Float32frombits(v.Data)), 'g', -1, 32)
+	case TypeDimension:
+		return strconv.FormatFloat(complexToFloat(v.Data), 'f', -1, 64) +
+			unitName(dimensionUnits, v.Data&0xf)
+	case TypeFraction:
+		return strconv.FormatFloat(complexToFloat(v.Data)*100, 'f', -1, 64) +
+			unitName(fractionUnits, v.Data&0xf)
+	case TypeIntDec:
+		return strconv.Itoa(int(int32(v.Data)))
+	case TypeIntHex:
+		return fmt.Sprintf("0x%x", v.Data)
+	case TypeIntBoolean:
+		if v.Data != 0 {
+			return "true"
+		}
+		return "false"
+	case TypeIntColorARGB8:
+		return fmt.Sprintf("#%08x", v.Data)
+	case TypeIntColorRGB8:
+		return fmt.Sprintf("#%06x", v.Data&0xffffff)
+	case TypeIntColorARGB4:
+		return fmt.Sprintf("#%x%x%x%x", (v.Data>>28)&0xf, (v.Data>>20)&0xf, (v.Data>>12)&0xf, (v.Data>>4)&0xf)
+	case TypeIntColorRGB4:
+		return fmt.Sprintf("#%x%x%x", (v.Data>>20)&0xf, (v.Data>>12)&0xf, (v.Data>>4)&0xf)
+	}
+	return fmt.Sprintf("(type 0x%02x)0x%x", uint8(v.Type), v.Data)
+}
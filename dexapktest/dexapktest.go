@@ -8,6 +8,9 @@ package dexapktest
 import (
 	"fmt"
 	"regexp"
+	"strings"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
 )
 
 // A visitor to pass to ReadDEX/ReadAPK during unit testing. It
@@ -22,17 +25,48 @@ func (c *CaptureDexApkVisitOperations) VisitAPK(apk string) {
 	c.Result = append(c.Result, fmt.Sprintf("APK %s", apk))
 }
 
-func (c *CaptureDexApkVisitOperations) VisitDEX(dexname string, sha1signature [20]byte) {
-	c.Result = append(c.Result, fmt.Sprintf(" DEX %s sha1 %x", dexname, sha1signature))
+func (c *CaptureDexApkVisitOperations) VisitDEX(dexname string, version int, sha1signature [20]byte) {
+	c.Result = append(c.Result, fmt.Sprintf(" DEX %s version %03d sha1 %x", dexname, version, sha1signature))
+}
+
+func (c *CaptureDexApkVisitOperations) VisitDEXIntegrity(dexname string, check *dexapkvisit.IntegrityCheck) {
+	c.Result = append(c.Result, fmt.Sprintf(" DEX %s integrity check failed: %s", dexname, check.String()))
+}
+
+func (c *CaptureDexApkVisitOperations) VisitClass(classname string, nmethods uint32, accessFlags dexapkvisit.AccessFlags, superclass string, interfaces []string) {
+	r := fmt.Sprintf("  class %s flags '%s' methods: %d", classname, accessFlags.ClassString(), nmethods)
+	if superclass != "" {
+		r += " extends " + superclass
+	}
+	if len(interfaces) != 0 {
+		r += " implements " + strings.Join(interfaces, ", ")
+	}
+	c.Result = append(c.Result, r)
+}
+
+func (c *CaptureDexApkVisitOperations) VisitField(field *dexapkvisit.FieldId, fieldIdx uint64, accessFlags dexapkvisit.AccessFlags, isStatic bool, value *dexapkvisit.EncodedValue) {
+	kind := "instance"
+	if isStatic {
+		kind = "static"
+	}
+	r := fmt.Sprintf("   field id %d name '%s' type '%s' flags '%s' %s",
+		fieldIdx, field.Name, field.Type, accessFlags.FieldString(), kind)
+	if value != nil {
+		r += " value " + value.String()
+	}
+	c.Result = append(c.Result, r)
 }
 
-func (c *CaptureDexApkVisitOperations) VisitClass(classname string, nmethods uint32) {
-	c.Result = append(c.Result, fmt.Sprintf("  class %s methods: %d",
-		classname, nmethods))
+func (c *CaptureDexApkVisitOperations) VisitMethod(method *dexapkvisit.MethodId, methodIdx uint64, accessFlags dexapkvisit.AccessFlags, codeOffset uint64, code *dexapkvisit.MethodCode) {
+	c.Result = append(c.Result, fmt.Sprintf("   method id %d name '%s' sig '%s' flags '%s' code offset %d", methodIdx, method.Name, method.Signature, accessFlags.MethodString(), codeOffset))
+	if code != nil {
+		c.Result = append(c.Result, fmt.Sprintf("    registers %d ins %d outs %d insns %d",
+			code.RegistersSize, code.InsSize, code.OutsSize, code.InsnsSize))
+	}
 }
 
-func (c *CaptureDexApkVisitOperations) VisitMethod(methodname string, methodIdx uint64, codeOffset uint64) {
-	c.Result = append(c.Result, fmt.Sprintf("   method id %d name '%s' code offset %d", methodIdx, methodname, codeOffset))
+func (c *CaptureDexApkVisitOperations) VisitAnnotation(target *dexapkvisit.AnnotationTarget, annotation *dexapkvisit.Annotation) {
+	c.Result = append(c.Result, fmt.Sprintf("    annotation %s %s", target.String(), annotation.String()))
 }
 
 func (c *CaptureDexApkVisitOperations) Verbose(vlevel int, s string, a ...interface{}) {
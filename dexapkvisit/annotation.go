@@ -0,0 +1,123 @@
+package dexapkvisit
+
+import (
+	"fmt"
+)
+
+// Visibility is the visibility of an annotation_item, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#visibility
+type Visibility uint8
+
+const (
+	// Visible only at build time (e.g. to other classes being
+	// compiled).
+	VisibilityBuild Visibility = 0x00
+	// Visible at runtime, via reflection.
+	VisibilityRuntime Visibility = 0x01
+	// Used by the runtime itself (see the System* type names below).
+	VisibilitySystem Visibility = 0x02
+)
+
+func (v Visibility) String() string {
+	switch v {
+	case VisibilityBuild:
+		return "build"
+	case VisibilityRuntime:
+		return "runtime"
+	case VisibilitySystem:
+		return "system"
+	}
+	return fmt.Sprintf("Visibility(0x%02x)", uint8(v))
+}
+
+// Type descriptors of the system annotations, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#system-annotation
+const (
+	SystemAnnotationDefault = "Ldalvik/annotation/AnnotationDefault;"
+	SystemEnclosingClass    = "Ldalvik/annotation/EnclosingClass;"
+	SystemEnclosingMethod   = "Ldalvik/annotation/EnclosingMethod;"
+	SystemInnerClass        = "Ldalvik/annotation/InnerClass;"
+	SystemMemberClasses     = "Ldalvik/annotation/MemberClasses;"
+	SystemMethodParameters  = "Ldalvik/annotation/MethodParameters;"
+	SystemSignature         = "Ldalvik/annotation/Signature;"
+	SystemThrows            = "Ldalvik/annotation/Throws;"
+)
+
+// Annotation is a decoded annotation_item.
+type Annotation struct {
+	Visibility Visibility
+	EncodedAnnotation
+}
+
+func (a *Annotation) String() string {
+	return a.Visibility.String() + " " + a.EncodedAnnotation.String()
+}
+
+// Element returns the value of element 'name', or nil if the
+// annotation doesn't have one.
+func (a *Annotation) Element(name string) *EncodedValue {
+	for i := range a.Elements {
+		if a.Elements[i].Name == name {
+			return &a.Elements[i].Value
+		}
+	}
+	return nil
+}
+
+// FindAnnotation returns the annotation of type 'typ' (a type
+// descriptor) from 'annos', or nil.
+func FindAnnotation(annos []*Annotation, typ string) *Annotation {
+	for _, a := range annos {
+		if a.Type == typ {
+			return a
+		}
+	}
+	return nil
+}
+
+// AnnotationTargetKind says what an annotation is attached to.
+type AnnotationTargetKind uint8
+
+const (
+	TargetClass AnnotationTargetKind = iota
+	TargetField
+	TargetMethod
+	TargetParameter
+)
+
+func (k AnnotationTargetKind) String() string {
+	switch k {
+	case TargetClass:
+		return "class"
+	case TargetField:
+		return "field"
+	case TargetMethod:
+		return "method"
+	case TargetParameter:
+		return "parameter"
+	}
+	return fmt.Sprintf("AnnotationTargetKind(%d)", uint8(k))
+}
+
+// AnnotationTarget identifies the annotated element: Class is the
+// class descriptor, and Field or Method is set for field, method and
+// parameter annotations; Parameter is the parameter number.
+type AnnotationTarget struct {
+	Kind      AnnotationTargetKind
+	Class     string
+	Field     *FieldId
+	Method    *MethodId
+	Parameter int
+}
+
+func (t *AnnotationTarget) String() string {
+	switch t.Kind {
+	case TargetField:
+		return fmt.Sprintf("field %s", t.Field.Name)
+	case TargetMethod:
+		return fmt.Sprintf("method %s", t.Method.Name)
+	case TargetParameter:
+		return fmt.Sprintf("method %s parameter %d", t.Method.Name, t.Parameter)
+	}
+	return fmt.Sprintf("class %s", t.Class)
+}
@@ -0,0 +1,128 @@
+package dexapkvisit
+
+import (
+	"fmt"
+)
+
+// IndexKind describes which DEX constant pool (if any) is referred to
+// by the index operand of a Dalvik instruction.
+type IndexKind uint8
+
+const (
+	IndexNone IndexKind = iota
+	IndexString
+	IndexType
+	IndexField
+	IndexMethod
+	IndexProto
+	IndexCallSite
+	IndexMethodHandle
+)
+
+func (k IndexKind) String() string {
+	switch k {
+	case IndexNone:
+		return "none"
+	case IndexString:
+		return "string"
+	case IndexType:
+		return "type"
+	case IndexField:
+		return "field"
+	case IndexMethod:
+		return "method"
+	case IndexProto:
+		return "proto"
+	case IndexCallSite:
+		return "call_site"
+	case IndexMethodHandle:
+		return "method_handle"
+	}
+	return fmt.Sprintf("IndexKind(%d)", uint8(k))
+}
+
+// Instruction is a single decoded Dalvik instruction (or one of the
+// switch/array payload pseudo-instructions that are embedded in the
+// instruction stream). Offsets and sizes are in 16-bit code units.
+type Instruction struct {
+	Offset uint32
+	Size   uint32
+	// Opcode is the low byte of the first code unit; for payload
+	// pseudo-instructions it is the full 16-bit ident (0x0100,
+	// 0x0200 or 0x0300).
+	Opcode uint16
+	Name   string
+	Format string
+
+	// Decoded operands. Not every operand is meaningful for every
+	// format; Target is an absolute code offset for branches, and
+	// Index/Kind refer to a constant pool entry. Proto holds the
+	// second (proto) index for invoke-polymorphic.
+	Regs    []uint32
+	Literal int64
+	Target  uint32
+	Kind    IndexKind
+	Index   uint32
+	Proto   uint32
+
+	// Keys and Targets are the cases of a switch payload. Targets
+	// are absolute code offsets, and are only filled in if the
+	// switch instruction that uses the payload was found.
+	Keys    []int32
+	Targets []uint32
+
+	// Operand text with pool indices resolved, e.g.
+	// "{v0, v1}, Ljava/io/PrintStream;->println(I)V"
+	Operands string
+}
+
+func (i *Instruction) String() string {
+	if i.Operands == "" {
+		return i.Name
+	}
+	return i.Name + " " + i.Operands
+}
+
+// ResourceId returns the literal loaded by a "const" or
+// "const/high16" instruction if it has the form of an Android
+// resource ID (0xPPTTEEEE with non-zero package and type bytes), for
+// example the R.string.app_name in "const v0, #2131034113".
+func (i *Instruction) ResourceId() (uint32, bool) {
+	if i.Name != "const" && i.Name != "const/high16" {
+		return 0, false
+	}
+	id := uint32(i.Literal)
+	if int64(int32(id)) != i.Literal || id>>24 == 0 || (id>>16)&0xff == 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// CatchHandler is a single (exception type, handler address) pair; a
+// Type of "" denotes a catch-all handler.
+type CatchHandler struct {
+	Type    string
+	Address uint32
+}
+
+// TryItem describes a range of instructions covered by a set of
+// exception handlers.
+type TryItem struct {
+	StartAddr uint32
+	InsnCount uint16
+	Handlers  []CatchHandler
+}
+
+// MethodCode holds the decoded contents of a DEX code_item, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#code-item
+// Debug is nil if the code_item has no debug info.
+type MethodCode struct {
+	RegistersSize uint16
+	InsSize       uint16
+	OutsSize      uint16
+	DebugInfoOff  uint32
+	InsnsSize     uint32
+	Insns         []Instruction
+	Tries         []TryItem
+	Debug         *DebugInfo
+}
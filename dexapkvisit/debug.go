@@ -0,0 +1,80 @@
+package dexapkvisit
+
+import (
+	"fmt"
+)
+
+// PositionEntry maps a bytecode address to a source position; the
+// position holds from Address up to the next entry's address. File
+// is the class's source file unless the debug info overrides it.
+type PositionEntry struct {
+	Address uint32
+	Line    uint32
+	File    string
+}
+
+func (p *PositionEntry) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%04x: line %d", p.Address, p.Line)
+	}
+	return fmt.Sprintf("%04x: %s:%d", p.Address, p.File, p.Line)
+}
+
+// LocalVariable is a named local (or parameter) held in Register
+// from StartAddr up to, but not including, EndAddr. Type is a type
+// descriptor, and Signature the generic signature, if any.
+type LocalVariable struct {
+	Register  uint32
+	Name      string
+	Type      string
+	Signature string
+	StartAddr uint32
+	EndAddr   uint32
+}
+
+func (l *LocalVariable) String() string {
+	s := fmt.Sprintf("v%d %04x..%04x %s %s", l.Register, l.StartAddr, l.EndAddr, l.Name, l.Type)
+	if l.Signature != "" {
+		s += " " + l.Signature
+	}
+	return s
+}
+
+// DebugInfo holds a decoded debug_info_item, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#debug-info-item
+// Positions are in address order. Locals include the method's
+// parameters (and "this"), which are live for the whole method;
+// ParameterNames has an empty string for each parameter with no name.
+type DebugInfo struct {
+	LineStart      uint32
+	ParameterNames []string
+	Positions      []PositionEntry
+	Locals         []LocalVariable
+	PrologueEnd    []uint32
+	EpilogueBegin  []uint32
+}
+
+// Position returns the source position of the instruction at
+// 'addr', which is that of the last entry at or before it.
+func (d *DebugInfo) Position(addr uint32) (PositionEntry, bool) {
+	var result PositionEntry
+	found := false
+	for _, p := range d.Positions {
+		if p.Address > addr {
+			break
+		}
+		result, found = p, true
+	}
+	return result, found
+}
+
+// LocalsAt returns the locals live at 'addr'.
+func (d *DebugInfo) LocalsAt(addr uint32) []LocalVariable {
+	var result []LocalVariable
+	for _, l := range d.Locals {
+		if l.StartAddr <= addr && addr < l.EndAddr {
+			result = append(result, l)
+		}
+	}
+	return result
+}
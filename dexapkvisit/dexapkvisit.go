@@ -1,24 +1,46 @@
 //
 // Interfaces for visiting interesting elements within and Android DEX
-// file. These focus narrowly on methods; there are many of the
-// aspects of APK and DEX files that could be visited but are
-// not. Visit order is logically top-down, e.g.
+// file. These focus narrowly on classes, fields and methods; there
+// are many of the aspects of APK and DEX files that could be visited
+// but are not. Methods are described by a MethodId (name, defining
+// class and prototype), and method bodies are handed to VisitMethod
+// in decoded form (see MethodCode); the code pointer is nil for
+// abstract and native methods. The fields of a class are visited
+// (static fields first, then instance fields) before its methods;
+// static fields carry their initial value, if the class supplies one.
+// Classes, fields and methods all come with their access flags;
+// classes also come with the Java-style names of their superclass
+// (empty for java.lang.Object itself) and of the interfaces they
+// directly implement. VisitDEX reports the DEX format version from
+// the header magic (35 for "dex\n035\0", and so on). When asked to
+// (see dexread.Options), the reader reports a DEX file whose header
+// checksum or signature does not match its contents via
+// VisitDEXIntegrity, right after VisitDEX. Annotations are visited
+// right after the class, field or method they are attached to (for a
+// method, its own annotations come before those of its parameters).
+// Visit order is logically top-down, e.g.
 //
 //        VisitAPK("mumble.apk")
 //          VisitDEX("classes1.dex")
-//            VisitClass("foo", 1)
-//              VisitMethod("foomethod1", 0, 400)
-//            VisitClass("bar", 2)
-//              VisitMethod("barmethod1", 1, 500)
+//            VisitClass("foo", 1, flags, "java.lang.Object", nil)
+//              VisitAnnotation(class foo, annotation)
+//              VisitField(foofield1, 0, flags, true, value)
+//              VisitMethod(foomethod1, 0, flags, 400, code)
+//              VisitAnnotation(method foomethod1, annotation)
+//            VisitClass("bar", 2, flags, "foo", []string{"java.lang.Runnable"})
+//              VisitMethod(barmethod1, 1, flags, 500, code)
 //          VisitDEX("classes2.dex")
 //           ...
 //
 package dexapkvisit
 
 type DexVisitor interface {
-	VisitDEX(dexname string, sha1signature [20]byte)
-	VisitClass(classname string, nmethods uint32)
-	VisitMethod(methodname string, methodIdx uint64, codeOffset uint64)
+	VisitDEX(dexname string, version int, sha1signature [20]byte)
+	VisitDEXIntegrity(dexname string, check *IntegrityCheck)
+	VisitClass(classname string, nmethods uint32, accessFlags AccessFlags, superclass string, interfaces []string)
+	VisitField(field *FieldId, fieldIdx uint64, accessFlags AccessFlags, isStatic bool, value *EncodedValue)
+	VisitMethod(method *MethodId, methodIdx uint64, accessFlags AccessFlags, codeOffset uint64, code *MethodCode)
+	VisitAnnotation(target *AnnotationTarget, annotation *Annotation)
 }
 type ApkVisitor interface {
 	VisitAPK(apk string)
@@ -0,0 +1,126 @@
+package dexapkvisit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AccessFlags holds the access_flags of a class, field or method, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#access-flags
+// Some bits mean different things depending on what they are attached
+// to (0x40 is "volatile" for a field but "bridge" for a method), hence
+// the separate ClassString/FieldString/MethodString renderings.
+type AccessFlags uint32
+
+const (
+	AccPublic               AccessFlags = 0x1
+	AccPrivate              AccessFlags = 0x2
+	AccProtected            AccessFlags = 0x4
+	AccStatic               AccessFlags = 0x8
+	AccFinal                AccessFlags = 0x10
+	AccSynchronized         AccessFlags = 0x20
+	AccVolatile             AccessFlags = 0x40
+	AccBridge               AccessFlags = 0x40
+	AccTransient            AccessFlags = 0x80
+	AccVarargs              AccessFlags = 0x80
+	AccNative               AccessFlags = 0x100
+	AccInterface            AccessFlags = 0x200
+	AccAbstract             AccessFlags = 0x400
+	AccStrict               AccessFlags = 0x800
+	AccSynthetic            AccessFlags = 0x1000
+	AccAnnotation           AccessFlags = 0x2000
+	AccEnum                 AccessFlags = 0x4000
+	AccConstructor          AccessFlags = 0x10000
+	AccDeclaredSynchronized AccessFlags = 0x20000
+)
+
+// Has reports whether all of the flags in 'x' are set.
+func (f AccessFlags) Has(x AccessFlags) bool {
+	return f&x == x
+}
+
+type flagName struct {
+	flag AccessFlags
+	name string
+}
+
+// Flag names in the order that Java source would list the modifiers,
+// followed by the ones that have no source-level keyword.
+var classFlagNames = []flagName{
+	{AccPublic, "public"},
+	{AccProtected, "protected"},
+	{AccPrivate, "private"},
+	{AccAbstract, "abstract"},
+	{AccStatic, "static"},
+	{AccFinal, "final"},
+	{AccStrict, "strictfp"},
+	{AccInterface, "interface"},
+	{AccAnnotation, "annotation"},
+	{AccEnum, "enum"},
+	{AccSynthetic, "synthetic"},
+}
+
+var fieldFlagNames = []flagName{
+	{AccPublic, "public"},
+	{AccProtected, "protected"},
+	{AccPrivate, "private"},
+	{AccStatic, "static"},
+	{AccFinal, "final"},
+	{AccTransient, "transient"},
+	{AccVolatile, "volatile"},
+	{AccEnum, "enum"},
+	{AccSynthetic, "synthetic"},
+}
+
+var methodFlagNames = []flagName{
+	{AccPublic, "public"},
+	{AccProtected, "protected"},
+	{AccPrivate, "private"},
+	{AccAbstract, "abstract"},
+	{AccStatic, "static"},
+	{AccFinal, "final"},
+	{AccSynchronized, "synchronized"},
+	{AccNative, "native"},
+	{AccStrict, "strictfp"},
+	{AccBridge, "bridge"},
+	{AccVarargs, "varargs"},
+	{AccSynthetic, "synthetic"},
+	{AccConstructor, "constructor"},
+	{AccDeclaredSynchronized, "declared-synchronized"},
+}
+
+func (f AccessFlags) render(names []flagName) string {
+	var words []string
+	left := f
+	for _, n := range names {
+		if f.Has(n.flag) {
+			words = append(words, n.name)
+			left &^= n.flag
+		}
+	}
+	if left != 0 {
+		words = append(words, fmt.Sprintf("0x%x", uint32(left)))
+	}
+	return strings.Join(words, " ")
+}
+
+// ClassString renders the flags Java-style as class modifiers, e.g.
+// "public final".
+func (f AccessFlags) ClassString() string {
+	return f.render(classFlagNames)
+}
+
+// FieldString renders the flags Java-style as field modifiers.
+func (f AccessFlags) FieldString() string {
+	return f.render(fieldFlagNames)
+}
+
+// MethodString renders the flags Java-style as method modifiers, e.g.
+// "public static native".
+func (f AccessFlags) MethodString() string {
+	return f.render(methodFlagNames)
+}
+
+func (f AccessFlags) String() string {
+	return fmt.Sprintf("0x%x", uint32(f))
+}
@@ -0,0 +1,22 @@
+package dexapkvisit
+
+import (
+	"testing"
+)
+
+func TestAccessFlagsStrings(t *testing.T) {
+	f := AccPublic | AccVolatile | AccVarargs | AccSynthetic
+	if s := f.FieldString(); s != "public transient volatile synthetic" {
+		t.Errorf("FieldString got '%s'", s)
+	}
+	if s := f.MethodString(); s != "public bridge varargs synthetic" {
+		t.Errorf("MethodString got '%s'", s)
+	}
+	c := AccPublic | AccInterface | AccAbstract | 0x80000
+	if s := c.ClassString(); s != "public abstract interface 0x80000" {
+		t.Errorf("ClassString got '%s'", s)
+	}
+	if !c.Has(AccInterface|AccAbstract) || c.Has(AccFinal) {
+		t.Errorf("Has: unexpected result for %s", c)
+	}
+}
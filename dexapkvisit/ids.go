@@ -0,0 +1,37 @@
+package dexapkvisit
+
+import (
+	"strings"
+)
+
+// Proto describes a method prototype (proto_id_item). Types are in
+// type descriptor form, e.g. "Ljava/lang/String;" or "I".
+type Proto struct {
+	Shorty     string
+	ReturnType string
+	Parameters []string
+}
+
+// Descriptor returns the prototype in method descriptor form, for
+// example "(ILjava/lang/String;)V".
+func (p *Proto) Descriptor() string {
+	return "(" + strings.Join(p.Parameters, "") + ")" + p.ReturnType
+}
+
+// MethodId describes a method_id_item: the defining class (as a type
+// descriptor), the method name and its prototype. Signature holds a
+// Java-style rendering, e.g. "int fibonacci.rfibonacci(int)".
+type MethodId struct {
+	Class     string
+	Name      string
+	Proto     Proto
+	Signature string
+}
+
+// FieldId describes a field_id_item; Class and Type are type
+// descriptors.
+type FieldId struct {
+	Class string
+	Name  string
+	Type  string
+}
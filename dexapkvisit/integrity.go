@@ -0,0 +1,55 @@
+package dexapkvisit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IntegrityCheck compares the checksum, SHA-1 signature and file
+// size recorded in a DEX header with the values computed from the
+// file contents: the Adler-32 checksum covers everything after the
+// checksum field (offset 12 on), and the SHA-1 signature everything
+// after the signature (offset 32 on).
+type IntegrityCheck struct {
+	StoredChecksum   uint32
+	ComputedChecksum uint32
+	StoredSha1       [20]byte
+	ComputedSha1     [20]byte
+	StoredSize       uint32
+	ActualSize       uint64
+}
+
+func (c *IntegrityCheck) ChecksumOK() bool {
+	return c.StoredChecksum == c.ComputedChecksum
+}
+
+func (c *IntegrityCheck) Sha1OK() bool {
+	return c.StoredSha1 == c.ComputedSha1
+}
+
+func (c *IntegrityCheck) SizeOK() bool {
+	return uint64(c.StoredSize) == c.ActualSize
+}
+
+func (c *IntegrityCheck) OK() bool {
+	return c.ChecksumOK() && c.Sha1OK() && c.SizeOK()
+}
+
+// String describes the mismatches, e.g. "checksum 0x1234abcd
+// (computed 0x5678ef01)", or returns "ok".
+func (c *IntegrityCheck) String() string {
+	var problems []string
+	if !c.SizeOK() {
+		problems = append(problems, fmt.Sprintf("file size %d (actual %d)", c.StoredSize, c.ActualSize))
+	}
+	if !c.ChecksumOK() {
+		problems = append(problems, fmt.Sprintf("checksum 0x%08x (computed 0x%08x)", c.StoredChecksum, c.ComputedChecksum))
+	}
+	if !c.Sha1OK() {
+		problems = append(problems, fmt.Sprintf("sha1 %x (computed %x)", c.StoredSha1, c.ComputedSha1))
+	}
+	if len(problems) == 0 {
+		return "ok"
+	}
+	return strings.Join(problems, ", ")
+}
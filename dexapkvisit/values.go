@@ -0,0 +1,134 @@
+package dexapkvisit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ValueType is the value_type of an encoded_value, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#value-formats
+type ValueType uint8
+
+const (
+	ValueByte         ValueType = 0x00
+	ValueShort        ValueType = 0x02
+	ValueChar         ValueType = 0x03
+	ValueInt          ValueType = 0x04
+	ValueLong         ValueType = 0x06
+	ValueFloat        ValueType = 0x10
+	ValueDouble       ValueType = 0x11
+	ValueMethodType   ValueType = 0x15
+	ValueMethodHandle ValueType = 0x16
+	ValueString       ValueType = 0x17
+	ValueTypeRef      ValueType = 0x18
+	ValueField        ValueType = 0x19
+	ValueMethod       ValueType = 0x1a
+	ValueEnum         ValueType = 0x1b
+	ValueArray        ValueType = 0x1c
+	ValueAnnotation   ValueType = 0x1d
+	ValueNull         ValueType = 0x1e
+	ValueBoolean      ValueType = 0x1f
+)
+
+var valueTypeNames = map[ValueType]string{
+	ValueByte:         "byte",
+	ValueShort:        "short",
+	ValueChar:         "char",
+	ValueInt:          "int",
+	ValueLong:         "long",
+	ValueFloat:        "float",
+	ValueDouble:       "double",
+	ValueMethodType:   "method_type",
+	ValueMethodHandle: "method_handle",
+	ValueString:       "string",
+	ValueTypeRef:      "type",
+	ValueField:        "field",
+	ValueMethod:       "method",
+	ValueEnum:         "enum",
+	ValueArray:        "array",
+	ValueAnnotation:   "annotation",
+	ValueNull:         "null",
+	ValueBoolean:      "boolean",
+}
+
+func (t ValueType) String() string {
+	if n, ok := valueTypeNames[t]; ok {
+		return n
+	}
+	return fmt.Sprintf("ValueType(0x%02x)", uint8(t))
+}
+
+// EncodedValue is a decoded encoded_value. The dynamic type of Value
+// depends on Type:
+//
+//	ValueByte, ValueShort, ValueInt, ValueLong   int64
+//	ValueChar                                    uint16
+//	ValueFloat                                   float32
+//	ValueDouble                                  float64
+//	ValueBoolean                                 bool
+//	ValueNull                                    nil
+//	ValueArray                                   []EncodedValue
+//	ValueAnnotation                              *EncodedAnnotation
+//	everything else                              string
+//
+// For the string/type/field/method/enum/method_type/method_handle
+// variants Value holds the resolved reference text (e.g. a type
+// descriptor) and Index the raw constant pool index.
+type EncodedValue struct {
+	Type  ValueType
+	Value interface{}
+	Index uint32
+}
+
+func (v *EncodedValue) String() string {
+	switch x := v.Value.(type) {
+	case nil:
+		return "null"
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case uint16:
+		return strconv.QuoteRune(rune(x))
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case []EncodedValue:
+		elems := make([]string, len(x))
+		for i := range x {
+			elems[i] = x[i].String()
+		}
+		return "{" + strings.Join(elems, ", ") + "}"
+	case *EncodedAnnotation:
+		return x.String()
+	case string:
+		if v.Type == ValueString {
+			return strconv.Quote(x)
+		}
+		return x
+	}
+	return fmt.Sprintf("%v", v.Value)
+}
+
+// AnnotationElement is a single name=value pair of an annotation.
+type AnnotationElement struct {
+	Name  string
+	Value EncodedValue
+}
+
+// EncodedAnnotation is a decoded encoded_annotation; Type is the
+// annotation type descriptor.
+type EncodedAnnotation struct {
+	Type     string
+	Elements []AnnotationElement
+}
+
+func (a *EncodedAnnotation) String() string {
+	elems := make([]string, len(a.Elements))
+	for i := range a.Elements {
+		elems[i] = a.Elements[i].Name + "=" + a.Elements[i].Value.String()
+	}
+	return "@" + a.Type + "(" + strings.Join(elems, ", ") + ")"
+}
@@ -0,0 +1,142 @@
+package dexread
+
+import (
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+//
+// Decoding of annotations, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#annotations-directory
+// A class's annotations_directory_item points at annotation_set_items
+// for the class itself and for its fields and methods, and at an
+// annotation_set_ref_list (one set per parameter) for each method
+// with parameter annotations.
+//
+
+// uintsAt reads 'n' uints starting at 'off'.
+func (state *dexState) uintsAt(off uint32, n uint64) ([]uint32, error) {
+	content := state.b.Bytes()
+	if uint64(off)+4*n > uint64(len(content)) {
+		return nil, mkError(state, "%d uints at offset %d run past the end of the file", n, off)
+	}
+	retval := make([]uint32, n)
+	for i := range retval {
+		retval[i] = state.order.Uint32(content[uint64(off)+4*uint64(i):])
+	}
+	return retval, nil
+}
+
+// sizedList reads a uint size at 'off' followed by that many uints.
+func (state *dexState) sizedList(off uint32) ([]uint32, error) {
+	size, err := state.uintsAt(off, 1)
+	if err != nil {
+		return nil, err
+	}
+	return state.uintsAt(off+4, uint64(size[0]))
+}
+
+// unpackAnnotation decodes the annotation_item at 'off'.
+func unpackAnnotation(state *dexState, off uint32) (*dexapkvisit.Annotation, error) {
+	content := state.b.Bytes()
+	if uint64(off) >= uint64(len(content)) {
+		return nil, mkError(state, "annotation offset %d out of range", off)
+	}
+	helper := ulebHelper{content[off+1:]}
+	ea, err := decodeEncodedAnnotation(state, &helper, 0)
+	if err != nil {
+		return nil, mkError(state, "annotation at offset %d: %v", off, err)
+	}
+	return &dexapkvisit.Annotation{
+		Visibility:        dexapkvisit.Visibility(content[off]),
+		EncodedAnnotation: *ea,
+	}, nil
+}
+
+// unpackAnnotationSet decodes the annotation_set_item at 'off'.
+func unpackAnnotationSet(state *dexState, off uint32) ([]*dexapkvisit.Annotation, error) {
+	if off == 0 {
+		return nil, nil
+	}
+	offs, err := state.sizedList(off)
+	if err != nil {
+		return nil, err
+	}
+	var retval []*dexapkvisit.Annotation
+	for _, o := range offs {
+		a, err := unpackAnnotation(state, o)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, a)
+	}
+	return retval, nil
+}
+
+// unpackAnnotationSetRefList decodes the annotation_set_ref_list at
+// 'off', which has a (possibly empty) set for each parameter.
+func unpackAnnotationSetRefList(state *dexState, off uint32) ([][]*dexapkvisit.Annotation, error) {
+	offs, err := state.sizedList(off)
+	if err != nil {
+		return nil, err
+	}
+	var retval [][]*dexapkvisit.Annotation
+	for _, o := range offs {
+		set, err := unpackAnnotationSet(state, o)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, set)
+	}
+	return retval, nil
+}
+
+// unpackAnnotationsDirectory decodes the annotations_directory_item at
+// 'off', attaching the annotations to class 'c' and its members.
+// Annotations for members that the class does not define are dropped.
+func unpackAnnotationsDirectory(state *dexState, off uint32, c *Class) error {
+	hdr, err := state.uintsAt(off, 4)
+	if err != nil {
+		return err
+	}
+	if c.Annotations, err = unpackAnnotationSet(state, hdr[0]); err != nil {
+		return err
+	}
+	nfields, nmethods, nparams := uint64(hdr[1]), uint64(hdr[2]), uint64(hdr[3])
+	pairs, err := state.uintsAt(off+16, 2*(nfields+nmethods+nparams))
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[uint32]*Field)
+	for _, f := range c.fields {
+		fields[f.Index] = f
+	}
+	methods := make(map[uint32]*Method)
+	for _, m := range c.methods {
+		methods[m.Index] = m
+	}
+	for i := uint64(0); i < nfields+nmethods+nparams; i++ {
+		idx, setOff := pairs[2*i], pairs[2*i+1]
+		switch {
+		case i < nfields:
+			if f := fields[idx]; f != nil {
+				if f.Annotations, err = unpackAnnotationSet(state, setOff); err != nil {
+					return err
+				}
+			}
+		case i < nfields+nmethods:
+			if m := methods[idx]; m != nil {
+				if m.Annotations, err = unpackAnnotationSet(state, setOff); err != nil {
+					return err
+				}
+			}
+		default:
+			if m := methods[idx]; m != nil {
+				if m.ParameterAnnotations, err = unpackAnnotationSetRefList(state, setOff); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
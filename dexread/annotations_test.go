@@ -0,0 +1,126 @@
+package dexread
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/dexapktest"
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// Helpers for writing encoded_annotation and encoded_value bytes;
+// indices must be small enough to fit in a single byte.
+
+func (b *dexBuilder) encAnnotation(typ string, nameValues ...interface{}) []byte {
+	enc := []byte{byte(b.typ(typ)), byte(len(nameValues) / 2)}
+	for i := 0; i < len(nameValues); i += 2 {
+		enc = append(enc, byte(b.str(nameValues[i].(string))))
+		enc = append(enc, nameValues[i+1].([]byte)...)
+	}
+	return enc
+}
+
+func encInt(v byte) []byte { return []byte{byte(dexapkvisit.ValueInt), v} }
+
+func (b *dexBuilder) encString(s string) []byte {
+	return []byte{byte(dexapkvisit.ValueString), byte(b.str(s))}
+}
+
+func (b *dexBuilder) encType(d string) []byte {
+	return []byte{byte(dexapkvisit.ValueTypeRef), byte(b.typ(d))}
+}
+
+func encArray(values ...[]byte) []byte {
+	enc := []byte{byte(dexapkvisit.ValueArray), byte(len(values))}
+	for _, v := range values {
+		enc = append(enc, v...)
+	}
+	return enc
+}
+
+func TestAnnotations(t *testing.T) {
+	b := newDexBuilder()
+	cls := "Lcom/example/Web;"
+	ready := b.field(cls, "Z", "ready")
+	show := b.method(cls, "show", b.proto("VIL", "V", "I", "Ljava/lang/String;"))
+	load := b.method(cls, "load", b.proto("V", "V"))
+	runtime, system := dexapkvisit.VisibilityRuntime, dexapkvisit.VisibilitySystem
+	b.class(testClass{
+		typ:            cls,
+		flags:          uint32(dexapkvisit.AccPublic),
+		instanceFields: []testEncodedField{{ready, 0}},
+		virtualMethods: []testEncodedMethod{{show, uint32(dexapkvisit.AccPublic), nil}, {load, uint32(dexapkvisit.AccPublic), nil}},
+		annotations: &testAnnotations{
+			class: []testAnnotation{
+				{runtime, b.encAnnotation("Landroidx/annotation/Keep;")},
+				{system, b.encAnnotation(dexapkvisit.SystemSignature, "value",
+					encArray(b.encString("Ljava/lang/Object;"), b.encString("Ljava/util/List<"),
+						b.encString("Ljava/lang/String;"), b.encString(">;")))},
+				{system, b.encAnnotation(dexapkvisit.SystemInnerClass,
+					"accessFlags", encInt(9), "name", b.encString("Web"))},
+			},
+			fields: map[uint32][]testAnnotation{
+				ready: {{dexapkvisit.VisibilityBuild, b.encAnnotation("Lcom/example/Flag;", "x", encInt(5))}},
+			},
+			methods: map[uint32][]testAnnotation{
+				show: {{runtime, b.encAnnotation("Landroid/webkit/JavascriptInterface;")}},
+				load: {{system, b.encAnnotation(dexapkvisit.SystemThrows, "value",
+					encArray(b.encType("Ljava/io/IOException;")))}},
+			},
+			params: map[uint32][][]testAnnotation{
+				show: {nil, {{runtime, b.encAnnotation("Landroidx/annotation/NonNull;")}}},
+			},
+		},
+	})
+	data := b.build()
+	visitor := &dexapktest.CaptureDexApkVisitOperations{}
+	if err := readTestDex(data, visitor); err != nil {
+		t.Fatalf("ReadDEX error %v", err)
+	}
+	actual := strings.Join(visitor.Result[1:], "\n")
+	expected := `  class com.example.Web flags 'public' methods: 2
+    annotation class Lcom/example/Web; runtime @Landroidx/annotation/Keep;()
+    annotation class Lcom/example/Web; system @Ldalvik/annotation/Signature;(value={"Ljava/lang/Object;", "Ljava/util/List<", "Ljava/lang/String;", ">;"})
+    annotation class Lcom/example/Web; system @Ldalvik/annotation/InnerClass;(accessFlags=9, name="Web")
+   field id 0 name 'ready' type 'Z' flags '' instance
+    annotation field ready build @Lcom/example/Flag;(x=5)
+   method id 0 name 'show' sig 'void com.example.Web.show(int, java.lang.String)' flags 'public' code offset 0
+    annotation method show runtime @Landroid/webkit/JavascriptInterface;()
+    annotation method show parameter 1 runtime @Landroidx/annotation/NonNull;()
+   method id 1 name 'load' sig 'void com.example.Web.load()' flags 'public' code offset 0
+    annotation method load system @Ldalvik/annotation/Throws;(value={Ljava/io/IOException;})`
+	if actual != expected {
+		t.Errorf("got\n%s\nexpected\n%s", actual, expected)
+	}
+
+	// The model hangs on to them too.
+	dex, err := Parse(nil, "test.dex", bytes.NewReader(data), uint64(len(data)))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	c := dex.ClassByName(cls)
+	inner := dexapkvisit.FindAnnotation(c.Annotations, dexapkvisit.SystemInnerClass)
+	if inner == nil || inner.Element("name").String() != `"Web"` || inner.Element("nope") != nil {
+		t.Errorf("unexpected InnerClass annotation %v", inner)
+	}
+	m := c.Methods()[0]
+	if len(m.ParameterAnnotations) != 2 || len(m.ParameterAnnotations[0]) != 0 ||
+		m.ParameterAnnotations[1][0].Type != "Landroidx/annotation/NonNull;" {
+		t.Errorf("unexpected parameter annotations %v", m.ParameterAnnotations)
+	}
+	if problems := dex.CheckSections(); len(problems) != 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	// Every annotation item is accounted for by the size report.
+	r, err := dex.SizeReport()
+	if err != nil {
+		t.Fatalf("SizeReport error %v", err)
+	}
+	for _, s := range r.Shared {
+		if s.Name == unattributed {
+			t.Errorf("%d bytes unattributed", s.Size)
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package dexread
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/adler32"
+	"sort"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+//
+// A tiny DEX file writer for unit tests, so that we can exercise
+// features (fields, access flags, static values, ...) that are not
+// present in testdata/classes.dex. Strings, types, protos, fields and
+// methods are interned and numbered in order of first use; the
+// reader does not care whether the tables are sorted.
+//
+
+type testProto struct {
+	shorty string
+	ret    string
+	params []string
+}
+
+type testCode struct {
+	registers, ins, outs uint16
+	insns                []uint16
+	// raw debug_info_item, if any
+	debugInfo []byte
+}
+
+type testEncodedField struct {
+	idx   uint32
+	flags uint32
+}
+
+type testEncodedMethod struct {
+	idx   uint32
+	flags uint32
+	code  *testCode
+}
+
+type testClass struct {
+	typ            string
+	flags          uint32
+	super          string
+	interfaces     []string
+	sourceFile     string
+	staticFields   []testEncodedField
+	instanceFields []testEncodedField
+	directMethods  []testEncodedMethod
+	virtualMethods []testEncodedMethod
+	// raw encoded_array_item contents (without the leading size),
+	// along with the number of values it contains
+	staticValues      []byte
+	staticValuesCount uint32
+	annotations       *testAnnotations
+}
+
+// testAnnotation is an annotation_item: a visibility and the raw
+// encoded_annotation.
+type testAnnotation struct {
+	visibility dexapkvisit.Visibility
+	encoded    []byte
+}
+
+// testAnnotations is the contents of an annotations_directory_item;
+// the maps are keyed by field or method index.
+type testAnnotations struct {
+	class   []testAnnotation
+	fields  map[uint32][]testAnnotation
+	methods map[uint32][]testAnnotation
+	params  map[uint32][][]testAnnotation
+}
+
+type dexBuilder struct {
+	version   string // e.g. "038"; defaults to "035"
+	bigEndian bool   // write a reverse-endian file
+	strings   []string
+	stringIdx map[string]uint32
+	types     []uint32
+	typeIdx   map[string]uint32
+	protos    []testProto
+	fields    [][3]string
+	methods   []dexBuilderMethod
+	classes   []testClass
+	// method handles as {type, field or method index}, and call
+	// sites as raw encoded_array_items
+	methodHandles [][2]uint16
+	callSites     [][]byte
+}
+
+type dexBuilderMethod struct {
+	class, name string
+	proto       uint32
+}
+
+func newDexBuilder() *dexBuilder {
+	return &dexBuilder{
+		stringIdx: make(map[string]uint32),
+		typeIdx:   make(map[string]uint32),
+	}
+}
+
+func (b *dexBuilder) str(s string) uint32 {
+	if idx, ok := b.stringIdx[s]; ok {
+		return idx
+	}
+	b.strings = append(b.strings, s)
+	b.stringIdx[s] = uint32(len(b.strings) - 1)
+	return b.stringIdx[s]
+}
+
+func (b *dexBuilder) typ(d string) uint32 {
+	if idx, ok := b.typeIdx[d]; ok {
+		return idx
+	}
+	b.types = append(b.types, b.str(d))
+	b.typeIdx[d] = uint32(len(b.types) - 1)
+	return b.typeIdx[d]
+}
+
+func (b *dexBuilder) proto(shorty, ret string, params ...string) uint32 {
+	b.str(shorty)
+	b.typ(ret)
+	for _, p := range params {
+		b.typ(p)
+	}
+	b.protos = append(b.protos, testProto{shorty, ret, params})
+	return uint32(len(b.protos) - 1)
+}
+
+func (b *dexBuilder) field(class, typ, name string) uint32 {
+	b.typ(class)
+	b.typ(typ)
+	b.str(name)
+	b.fields = append(b.fields, [3]string{class, typ, name})
+	return uint32(len(b.fields) - 1)
+}
+
+func (b *dexBuilder) method(class, name string, proto uint32) uint32 {
+	b.typ(class)
+	b.str(name)
+	b.methods = append(b.methods, dexBuilderMethod{class, name, proto})
+	return uint32(len(b.methods) - 1)
+}
+
+func (b *dexBuilder) class(c testClass) {
+	b.typ(c.typ)
+	if c.super != "" {
+		b.typ(c.super)
+	}
+	for _, i := range c.interfaces {
+		b.typ(i)
+	}
+	if c.sourceFile != "" {
+		b.str(c.sourceFile)
+	}
+	b.classes = append(b.classes, c)
+}
+
+func putUleb(buf *bytes.Buffer, v uint32) {
+	var tmp [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(tmp[:], uint64(v))
+	buf.Write(tmp[:n])
+}
+
+func align4(buf *bytes.Buffer) {
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+// put writes 'v' in the byte order of the DEX being built.
+func (b *dexBuilder) put(buf *bytes.Buffer, v interface{}) {
+	binary.Write(buf, b.byteOrder(), v)
+}
+
+// build lays out the DEX file: header, id sections, class defs and
+// then the data section, and fills in the checksum and signature.
+func (b *dexBuilder) build() []byte {
+	result := b.buildAt(0, false)
+	signDex(result, b.byteOrder())
+	return result
+}
+
+func (b *dexBuilder) byteOrder() binary.ByteOrder {
+	if b.bigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// buildContainer lays out a version 041 container holding a DEX file
+// for each of the builders.
+func buildContainer(bs ...*dexBuilder) []byte {
+	var out []byte
+	for _, b := range bs {
+		b.version = "041"
+		out = append(out, b.buildAt(uint32(len(out)), true)...)
+	}
+	for off := 0; off < len(out); {
+		binary.LittleEndian.PutUint32(out[off+dexFileHeaderSize:], uint32(len(out)))
+		size := int(binary.LittleEndian.Uint32(out[off+32:]))
+		signDex(out[off:off+size], binary.LittleEndian)
+		off += size
+	}
+	return out
+}
+
+// signDex fills in the checksum and signature of a DEX file.
+func signDex(dex []byte, order binary.ByteOrder) {
+	sig := sha1.Sum(dex[32:])
+	copy(dex[12:32], sig[:])
+	order.PutUint32(dex[8:], adler32.Checksum(dex[12:]))
+}
+
+// buildAt does the work for build and buildContainer; offsets are
+// relative to the container, in which the DEX file starts at 'base'.
+func (b *dexBuilder) buildAt(base uint32, container bool) []byte {
+	hdr := dexFileHeader{HeaderSize: dexFileHeaderSize, EndianTag: endianConstant}
+	if container {
+		hdr.HeaderSize = dexContainerHeaderSize
+	}
+	version := b.version
+	if version == "" {
+		version = "035"
+	}
+	copy(hdr.Magic[:], "dex\n"+version+"\x00")
+
+	off := base + hdr.HeaderSize
+	hdr.StringIdsSize, hdr.StringIdsOff = uint32(len(b.strings)), off
+	off += 4 * uint32(len(b.strings))
+	hdr.TypeIdsSize, hdr.TypeIdsOff = uint32(len(b.types)), off
+	off += 4 * uint32(len(b.types))
+	hdr.ProtoIdsSize, hdr.ProtoIdsOff = uint32(len(b.protos)), off
+	off += 12 * uint32(len(b.protos))
+	hdr.FieldIdsSize, hdr.FieldIdsOff = uint32(len(b.fields)), off
+	off += 8 * uint32(len(b.fields))
+	hdr.MethodIdsSize, hdr.MethodIdsOff = uint32(len(b.methods)), off
+	off += 8 * uint32(len(b.methods))
+	hdr.ClassDefsSize, hdr.ClassDefsOff = uint32(len(b.classes)), off
+	off += dexClassHeaderSize * uint32(len(b.classes))
+	callSiteIdsOff := off
+	off += 4 * uint32(len(b.callSites))
+	methodHandlesOff := off
+	off += 8 * uint32(len(b.methodHandles))
+	dataOff := off
+
+	// Data section; 'data' holds everything from dataOff onwards.
+	// Items are grouped by type, as in real DEX files, and each
+	// group is recorded for the map_list.
+	var data bytes.Buffer
+	at := func() uint32 { return dataOff + uint32(data.Len()) }
+	var dataSections []dexMapItem
+	item := func(t SectionType) {
+		if n := len(dataSections); n == 0 || dataSections[n-1].Type != uint16(t) {
+			dataSections = append(dataSections, dexMapItem{Type: uint16(t), Offset: at()})
+		}
+		dataSections[len(dataSections)-1].Size++
+	}
+
+	stringOffs := make([]uint32, len(b.strings))
+	for i, s := range b.strings {
+		item(SectionStringData)
+		stringOffs[i] = at()
+		putUleb(&data, uint32(len(s)))
+		data.WriteString(s)
+		data.WriteByte(0)
+	}
+	typeList := func(descs []string) uint32 {
+		if len(descs) == 0 {
+			return 0
+		}
+		align4(&data)
+		item(SectionTypeLists)
+		o := at()
+		b.put(&data, uint32(len(descs)))
+		for _, d := range descs {
+			b.put(&data, uint16(b.typeIdx[d]))
+		}
+		return o
+	}
+	protoParams := make([]uint32, len(b.protos))
+	for i, p := range b.protos {
+		protoParams[i] = typeList(p.params)
+	}
+	classHeaders := make([]dexClassHeader, len(b.classes))
+	for i, c := range b.classes {
+		ch := &classHeaders[i]
+		ch.ClassIdx = b.typeIdx[c.typ]
+		ch.AccessFlags = c.flags
+		ch.SuperClassIdx = noIndex
+		if c.super != "" {
+			ch.SuperClassIdx = b.typeIdx[c.super]
+		}
+		ch.InterfacesOff = typeList(c.interfaces)
+		ch.SourceFileIdx = noIndex
+		if c.sourceFile != "" {
+			ch.SourceFileIdx = b.stringIdx[c.sourceFile]
+		}
+	}
+
+	// Debug info and then code items have to be emitted before
+	// the class data that refers to them.
+	debugOffs := make(map[*testCode]uint32)
+	for _, c := range b.classes {
+		for _, ms := range [2][]testEncodedMethod{c.directMethods, c.virtualMethods} {
+			for _, m := range ms {
+				if m.code != nil && m.code.debugInfo != nil {
+					item(SectionDebugInfo)
+					debugOffs[m.code] = at()
+					data.Write(m.code.debugInfo)
+				}
+			}
+		}
+	}
+	codeOffs := make([][2][]uint32, len(b.classes))
+	for i, c := range b.classes {
+		for k, ms := range [2][]testEncodedMethod{c.directMethods, c.virtualMethods} {
+			for _, m := range ms {
+				o := uint32(0)
+				if m.code != nil {
+					align4(&data)
+					item(SectionCode)
+					o = at()
+					b.put(&data, dexCodeItemHeader{
+						RegistersSize: m.code.registers,
+						InsSize:       m.code.ins,
+						OutsSize:      m.code.outs,
+						DebugInfoOff:  debugOffs[m.code],
+						InsnsSize:     uint32(len(m.code.insns)),
+					})
+					b.put(&data, m.code.insns)
+				}
+				codeOffs[i][k] = append(codeOffs[i][k], o)
+			}
+		}
+	}
+	callSiteOffs := make([]uint32, len(b.callSites))
+	for i, cs := range b.callSites {
+		item(SectionEncodedArrays)
+		callSiteOffs[i] = at()
+		data.Write(cs)
+	}
+	for i, c := range b.classes {
+		if c.staticValues != nil {
+			item(SectionEncodedArrays)
+			classHeaders[i].StaticValuesOff = at()
+			putUleb(&data, c.staticValuesCount)
+			data.Write(c.staticValues)
+		}
+	}
+	b.emitAnnotations(&data, item, at, classHeaders)
+	for i, c := range b.classes {
+		item(SectionClassData)
+		classHeaders[i].ClassDataOff = at()
+		putUleb(&data, uint32(len(c.staticFields)))
+		putUleb(&data, uint32(len(c.instanceFields)))
+		putUleb(&data, uint32(len(c.directMethods)))
+		putUleb(&data, uint32(len(c.virtualMethods)))
+		for _, fs := range [][]testEncodedField{c.staticFields, c.instanceFields} {
+			prev := uint32(0)
+			for _, f := range fs {
+				putUleb(&data, f.idx-prev)
+				putUleb(&data, f.flags)
+				prev = f.idx
+			}
+		}
+		for k, ms := range [2][]testEncodedMethod{c.directMethods, c.virtualMethods} {
+			prev := uint32(0)
+			for j, m := range ms {
+				putUleb(&data, m.idx-prev)
+				putUleb(&data, m.flags)
+				putUleb(&data, codeOffs[i][k][j])
+				prev = m.idx
+			}
+		}
+	}
+
+	// The map_list comes last.
+	align4(&data)
+	hdr.MapOff = at()
+	var mapItems []dexMapItem
+	for _, m := range []dexMapItem{
+		{Type: uint16(SectionHeader), Size: 1, Offset: base},
+		{Type: uint16(SectionStringIds), Size: hdr.StringIdsSize, Offset: hdr.StringIdsOff},
+		{Type: uint16(SectionTypeIds), Size: hdr.TypeIdsSize, Offset: hdr.TypeIdsOff},
+		{Type: uint16(SectionProtoIds), Size: hdr.ProtoIdsSize, Offset: hdr.ProtoIdsOff},
+		{Type: uint16(SectionFieldIds), Size: hdr.FieldIdsSize, Offset: hdr.FieldIdsOff},
+		{Type: uint16(SectionMethodIds), Size: hdr.MethodIdsSize, Offset: hdr.MethodIdsOff},
+		{Type: uint16(SectionClassDefs), Size: hdr.ClassDefsSize, Offset: hdr.ClassDefsOff},
+		{Type: uint16(SectionCallSiteIds), Size: uint32(len(b.callSites)), Offset: callSiteIdsOff},
+		{Type: uint16(SectionMethodHandles), Size: uint32(len(b.methodHandles)), Offset: methodHandlesOff},
+	} {
+		if m.Size != 0 {
+			mapItems = append(mapItems, m)
+		}
+	}
+	mapItems = append(mapItems, dataSections...)
+	mapItems = append(mapItems, dexMapItem{Type: uint16(SectionMapList), Size: 1, Offset: hdr.MapOff})
+	b.put(&data, uint32(len(mapItems)))
+	b.put(&data, mapItems)
+
+	// Version 041 containers leave data_off and data_size zero.
+	if !container {
+		hdr.DataOff, hdr.DataSize = dataOff, uint32(data.Len())
+	}
+	hdr.FileSize = dataOff + uint32(data.Len()) - base
+
+	// Now emit everything in order.
+	var out bytes.Buffer
+	b.put(&out, hdr)
+	if container {
+		b.put(&out, dexContainerHeader{HeaderOffset: base})
+	}
+	b.put(&out, stringOffs)
+	b.put(&out, b.types)
+	for i, p := range b.protos {
+		b.put(&out, dexProtoIdItem{b.stringIdx[p.shorty], b.typeIdx[p.ret], protoParams[i]})
+	}
+	for _, f := range b.fields {
+		b.put(&out, dexFieldIdItem{uint16(b.typeIdx[f[0]]), uint16(b.typeIdx[f[1]]), b.stringIdx[f[2]]})
+	}
+	for _, m := range b.methods {
+		b.put(&out, dexMethodIdItem{uint16(b.typeIdx[m.class]), uint16(m.proto), b.stringIdx[m.name]})
+	}
+	b.put(&out, classHeaders)
+	b.put(&out, callSiteOffs)
+	for _, mh := range b.methodHandles {
+		b.put(&out, dexMethodHandleItem{MethodHandleType: mh[0], FieldOrMethodId: mh[1]})
+	}
+	out.Write(data.Bytes())
+	return out.Bytes()
+}
+
+// emitAnnotations writes the annotation items, then the sets, the
+// set ref lists and finally the directories, filling in the class
+// headers' annotations_off.
+func (b *dexBuilder) emitAnnotations(data *bytes.Buffer, item func(SectionType), at func() uint32, classHeaders []dexClassHeader) {
+	type set struct {
+		annos    []testAnnotation
+		itemOffs []uint32
+		off      uint32
+	}
+	type directory struct {
+		class               *set
+		fieldIdx, methodIdx []uint32
+		fields, methods     []*set
+		paramIdx            []uint32
+		params              [][]*set
+		paramOffs           []uint32
+	}
+	var sets []*set
+	newSet := func(annos []testAnnotation) *set {
+		if len(annos) == 0 {
+			return nil
+		}
+		s := &set{annos: annos}
+		sets = append(sets, s)
+		return s
+	}
+	dirs := make([]*directory, len(b.classes))
+	for i, c := range b.classes {
+		a := c.annotations
+		if a == nil {
+			continue
+		}
+		d := &directory{class: newSet(a.class)}
+		for k := range a.fields {
+			d.fieldIdx = append(d.fieldIdx, k)
+		}
+		sortIndices(d.fieldIdx)
+		for _, k := range d.fieldIdx {
+			d.fields = append(d.fields, newSet(a.fields[k]))
+		}
+		for k := range a.methods {
+			d.methodIdx = append(d.methodIdx, k)
+		}
+		sortIndices(d.methodIdx)
+		for _, k := range d.methodIdx {
+			d.methods = append(d.methods, newSet(a.methods[k]))
+		}
+		for k := range a.params {
+			d.paramIdx = append(d.paramIdx, k)
+		}
+		sortIndices(d.paramIdx)
+		for _, k := range d.paramIdx {
+			var ps []*set
+			for _, annos := range a.params[k] {
+				ps = append(ps, newSet(annos))
+			}
+			d.params = append(d.params, ps)
+		}
+		dirs[i] = d
+	}
+	setOff := func(s *set) uint32 {
+		if s == nil {
+			return 0
+		}
+		return s.off
+	}
+
+	for _, s := range sets {
+		for _, a := range s.annos {
+			item(SectionAnnotations)
+			s.itemOffs = append(s.itemOffs, at())
+			data.WriteByte(byte(a.visibility))
+			data.Write(a.encoded)
+		}
+	}
+	for _, s := range sets {
+		align4(data)
+		item(SectionAnnotationSets)
+		s.off = at()
+		b.put(data, uint32(len(s.itemOffs)))
+		b.put(data, s.itemOffs)
+	}
+	for _, d := range dirs {
+		if d == nil {
+			continue
+		}
+		for _, ps := range d.params {
+			align4(data)
+			item(SectionAnnotationSetRefLists)
+			d.paramOffs = append(d.paramOffs, at())
+			b.put(data, uint32(len(ps)))
+			for _, s := range ps {
+				b.put(data, setOff(s))
+			}
+		}
+	}
+	for i, d := range dirs {
+		if d == nil {
+			continue
+		}
+		align4(data)
+		item(SectionAnnotationsDirectories)
+		classHeaders[i].AnnotationsOff = at()
+		b.put(data, []uint32{setOff(d.class), uint32(len(d.fields)), uint32(len(d.methods)), uint32(len(d.params))})
+		for j, s := range d.fields {
+			b.put(data, []uint32{d.fieldIdx[j], setOff(s)})
+		}
+		for j, s := range d.methods {
+			b.put(data, []uint32{d.methodIdx[j], setOff(s)})
+		}
+		for j := range d.params {
+			b.put(data, []uint32{d.paramIdx[j], d.paramOffs[j]})
+		}
+	}
+}
+
+func sortIndices(idx []uint32) {
+	sort.Slice(idx, func(i, j int) bool { return idx[i] < idx[j] })
+}
+
+// readTestDex runs ReadDEX over the contents of a built DEX file.
+func readTestDex(data []byte, visitor dexapkvisit.DexApkVisitor) error {
+	return ReadDEX(nil, "test.dex", bytes.NewReader(data), uint64(len(data)), visitor)
+}
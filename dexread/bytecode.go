@@ -0,0 +1,327 @@
+package dexread
+
+import (
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+//
+// Dalvik instruction formats and the opcode table. See
+// https://source.android.com/devices/tech/dalvik/instruction-formats.html
+// and https://source.android.com/devices/tech/dalvik/dalvik-bytecode.html
+// for the details.
+//
+
+type insnFormat uint8
+
+const (
+	fmtUnused insnFormat = iota
+	fmt10x
+	fmt12x
+	fmt11n
+	fmt11x
+	fmt10t
+	fmt20t
+	fmt22x
+	fmt21t
+	fmt21s
+	fmt21h
+	fmt21c
+	fmt23x
+	fmt22b
+	fmt22t
+	fmt22s
+	fmt22c
+	fmt30t
+	fmt32x
+	fmt31i
+	fmt31t
+	fmt31c
+	fmt35c
+	fmt3rc
+	fmt45cc
+	fmt4rcc
+	fmt51l
+)
+
+var formatNames = [...]string{
+	fmtUnused: "unused",
+	fmt10x:    "10x",
+	fmt12x:    "12x",
+	fmt11n:    "11n",
+	fmt11x:    "11x",
+	fmt10t:    "10t",
+	fmt20t:    "20t",
+	fmt22x:    "22x",
+	fmt21t:    "21t",
+	fmt21s:    "21s",
+	fmt21h:    "21h",
+	fmt21c:    "21c",
+	fmt23x:    "23x",
+	fmt22b:    "22b",
+	fmt22t:    "22t",
+	fmt22s:    "22s",
+	fmt22c:    "22c",
+	fmt30t:    "30t",
+	fmt32x:    "32x",
+	fmt31i:    "31i",
+	fmt31t:    "31t",
+	fmt31c:    "31c",
+	fmt35c:    "35c",
+	fmt3rc:    "3rc",
+	fmt45cc:   "45cc",
+	fmt4rcc:   "4rcc",
+	fmt51l:    "51l",
+}
+
+func (f insnFormat) String() string {
+	return formatNames[f]
+}
+
+// size returns the length of an instruction in the given format, in
+// 16-bit code units. The first digit of the format name is the size.
+func (f insnFormat) size() uint32 {
+	return uint32(formatNames[f][0] - '0')
+}
+
+type opInfo struct {
+	name   string
+	format insnFormat
+	kind   dexapkvisit.IndexKind
+}
+
+// Pseudo-instruction idents for the data payloads that can appear in
+// the instruction stream (these are encoded as a "nop" with a non-zero
+// high byte).
+const (
+	packedSwitchPayload  = 0x0100
+	sparseSwitchPayload  = 0x0200
+	fillArrayDataPayload = 0x0300
+)
+
+// Unlisted entries are unused opcodes (fmtUnused).
+var opcodes = [256]opInfo{
+	0x00: {"nop", fmt10x, dexapkvisit.IndexNone},
+	0x01: {"move", fmt12x, dexapkvisit.IndexNone},
+	0x02: {"move/from16", fmt22x, dexapkvisit.IndexNone},
+	0x03: {"move/16", fmt32x, dexapkvisit.IndexNone},
+	0x04: {"move-wide", fmt12x, dexapkvisit.IndexNone},
+	0x05: {"move-wide/from16", fmt22x, dexapkvisit.IndexNone},
+	0x06: {"move-wide/16", fmt32x, dexapkvisit.IndexNone},
+	0x07: {"move-object", fmt12x, dexapkvisit.IndexNone},
+	0x08: {"move-object/from16", fmt22x, dexapkvisit.IndexNone},
+	0x09: {"move-object/16", fmt32x, dexapkvisit.IndexNone},
+	0x0a: {"move-result", fmt11x, dexapkvisit.IndexNone},
+	0x0b: {"move-result-wide", fmt11x, dexapkvisit.IndexNone},
+	0x0c: {"move-result-object", fmt11x, dexapkvisit.IndexNone},
+	0x0d: {"move-exception", fmt11x, dexapkvisit.IndexNone},
+	0x0e: {"return-void", fmt10x, dexapkvisit.IndexNone},
+	0x0f: {"return", fmt11x, dexapkvisit.IndexNone},
+	0x10: {"return-wide", fmt11x, dexapkvisit.IndexNone},
+	0x11: {"return-object", fmt11x, dexapkvisit.IndexNone},
+	0x12: {"const/4", fmt11n, dexapkvisit.IndexNone},
+	0x13: {"const/16", fmt21s, dexapkvisit.IndexNone},
+	0x14: {"const", fmt31i, dexapkvisit.IndexNone},
+	0x15: {"const/high16", fmt21h, dexapkvisit.IndexNone},
+	0x16: {"const-wide/16", fmt21s, dexapkvisit.IndexNone},
+	0x17: {"const-wide/32", fmt31i, dexapkvisit.IndexNone},
+	0x18: {"const-wide", fmt51l, dexapkvisit.IndexNone},
+	0x19: {"const-wide/high16", fmt21h, dexapkvisit.IndexNone},
+	0x1a: {"const-string", fmt21c, dexapkvisit.IndexString},
+	0x1b: {"const-string/jumbo", fmt31c, dexapkvisit.IndexString},
+	0x1c: {"const-class", fmt21c, dexapkvisit.IndexType},
+	0x1d: {"monitor-enter", fmt11x, dexapkvisit.IndexNone},
+	0x1e: {"monitor-exit", fmt11x, dexapkvisit.IndexNone},
+	0x1f: {"check-cast", fmt21c, dexapkvisit.IndexType},
+	0x20: {"instance-of", fmt22c, dexapkvisit.IndexType},
+	0x21: {"array-length", fmt12x, dexapkvisit.IndexNone},
+	0x22: {"new-instance", fmt21c, dexapkvisit.IndexType},
+	0x23: {"new-array", fmt22c, dexapkvisit.IndexType},
+	0x24: {"filled-new-array", fmt35c, dexapkvisit.IndexType},
+	0x25: {"filled-new-array/range", fmt3rc, dexapkvisit.IndexType},
+	0x26: {"fill-array-data", fmt31t, dexapkvisit.IndexNone},
+	0x27: {"throw", fmt11x, dexapkvisit.IndexNone},
+	0x28: {"goto", fmt10t, dexapkvisit.IndexNone},
+	0x29: {"goto/16", fmt20t, dexapkvisit.IndexNone},
+	0x2a: {"goto/32", fmt30t, dexapkvisit.IndexNone},
+	0x2b: {"packed-switch", fmt31t, dexapkvisit.IndexNone},
+	0x2c: {"sparse-switch", fmt31t, dexapkvisit.IndexNone},
+	0x2d: {"cmpl-float", fmt23x, dexapkvisit.IndexNone},
+	0x2e: {"cmpg-float", fmt23x, dexapkvisit.IndexNone},
+	0x2f: {"cmpl-double", fmt23x, dexapkvisit.IndexNone},
+	0x30: {"cmpg-double", fmt23x, dexapkvisit.IndexNone},
+	0x31: {"cmp-long", fmt23x, dexapkvisit.IndexNone},
+	0x32: {"if-eq", fmt22t, dexapkvisit.IndexNone},
+	0x33: {"if-ne", fmt22t, dexapkvisit.IndexNone},
+	0x34: {"if-lt", fmt22t, dexapkvisit.IndexNone},
+	0x35: {"if-ge", fmt22t, dexapkvisit.IndexNone},
+	0x36: {"if-gt", fmt22t, dexapkvisit.IndexNone},
+	0x37: {"if-le", fmt22t, dexapkvisit.IndexNone},
+	0x38: {"if-eqz", fmt21t, dexapkvisit.IndexNone},
+	0x39: {"if-nez", fmt21t, dexapkvisit.IndexNone},
+	0x3a: {"if-ltz", fmt21t, dexapkvisit.IndexNone},
+	0x3b: {"if-gez", fmt21t, dexapkvisit.IndexNone},
+	0x3c: {"if-gtz", fmt21t, dexapkvisit.IndexNone},
+	0x3d: {"if-lez", fmt21t, dexapkvisit.IndexNone},
+	0x44: {"aget", fmt23x, dexapkvisit.IndexNone},
+	0x45: {"aget-wide", fmt23x, dexapkvisit.IndexNone},
+	0x46: {"aget-object", fmt23x, dexapkvisit.IndexNone},
+	0x47: {"aget-boolean", fmt23x, dexapkvisit.IndexNone},
+	0x48: {"aget-byte", fmt23x, dexapkvisit.IndexNone},
+	0x49: {"aget-char", fmt23x, dexapkvisit.IndexNone},
+	0x4a: {"aget-short", fmt23x, dexapkvisit.IndexNone},
+	0x4b: {"aput", fmt23x, dexapkvisit.IndexNone},
+	0x4c: {"aput-wide", fmt23x, dexapkvisit.IndexNone},
+	0x4d: {"aput-object", fmt23x, dexapkvisit.IndexNone},
+	0x4e: {"aput-boolean", fmt23x, dexapkvisit.IndexNone},
+	0x4f: {"aput-byte", fmt23x, dexapkvisit.IndexNone},
+	0x50: {"aput-char", fmt23x, dexapkvisit.IndexNone},
+	0x51: {"aput-short", fmt23x, dexapkvisit.IndexNone},
+	0x52: {"iget", fmt22c, dexapkvisit.IndexField},
+	0x53: {"iget-wide", fmt22c, dexapkvisit.IndexField},
+	0x54: {"iget-object", fmt22c, dexapkvisit.IndexField},
+	0x55: {"iget-boolean", fmt22c, dexapkvisit.IndexField},
+	0x56: {"iget-byte", fmt22c, dexapkvisit.IndexField},
+	0x57: {"iget-char", fmt22c, dexapkvisit.IndexField},
+	0x58: {"iget-short", fmt22c, dexapkvisit.IndexField},
+	0x59: {"iput", fmt22c, dexapkvisit.IndexField},
+	0x5a: {"iput-wide", fmt22c, dexapkvisit.IndexField},
+	0x5b: {"iput-object", fmt22c, dexapkvisit.IndexField},
+	0x5c: {"iput-boolean", fmt22c, dexapkvisit.IndexField},
+	0x5d: {"iput-byte", fmt22c, dexapkvisit.IndexField},
+	0x5e: {"iput-char", fmt22c, dexapkvisit.IndexField},
+	0x5f: {"iput-short", fmt22c, dexapkvisit.IndexField},
+	0x60: {"sget", fmt21c, dexapkvisit.IndexField},
+	0x61: {"sget-wide", fmt21c, dexapkvisit.IndexField},
+	0x62: {"sget-object", fmt21c, dexapkvisit.IndexField},
+	0x63: {"sget-boolean", fmt21c, dexapkvisit.IndexField},
+	0x64: {"sget-byte", fmt21c, dexapkvisit.IndexField},
+	0x65: {"sget-char", fmt21c, dexapkvisit.IndexField},
+	0x66: {"sget-short", fmt21c, dexapkvisit.IndexField},
+	0x67: {"sput", fmt21c, dexapkvisit.IndexField},
+	0x68: {"sput-wide", fmt21c, dexapkvisit.IndexField},
+	0x69: {"sput-object", fmt21c, dexapkvisit.IndexField},
+	0x6a: {"sput-boolean", fmt21c, dexapkvisit.IndexField},
+	0x6b: {"sput-byte", fmt21c, dexapkvisit.IndexField},
+	0x6c: {"sput-char", fmt21c, dexapkvisit.IndexField},
+	0x6d: {"sput-short", fmt21c, dexapkvisit.IndexField},
+	0x6e: {"invoke-virtual", fmt35c, dexapkvisit.IndexMethod},
+	0x6f: {"invoke-super", fmt35c, dexapkvisit.IndexMethod},
+	0x70: {"invoke-direct", fmt35c, dexapkvisit.IndexMethod},
+	0x71: {"invoke-static", fmt35c, dexapkvisit.IndexMethod},
+	0x72: {"invoke-interface", fmt35c, dexapkvisit.IndexMethod},
+	0x74: {"invoke-virtual/range", fmt3rc, dexapkvisit.IndexMethod},
+	0x75: {"invoke-super/range", fmt3rc, dexapkvisit.IndexMethod},
+	0x76: {"invoke-direct/range", fmt3rc, dexapkvisit.IndexMethod},
+	0x77: {"invoke-static/range", fmt3rc, dexapkvisit.IndexMethod},
+	0x78: {"invoke-interface/range", fmt3rc, dexapkvisit.IndexMethod},
+	0x7b: {"neg-int", fmt12x, dexapkvisit.IndexNone},
+	0x7c: {"not-int", fmt12x, dexapkvisit.IndexNone},
+	0x7d: {"neg-long", fmt12x, dexapkvisit.IndexNone},
+	0x7e: {"not-long", fmt12x, dexapkvisit.IndexNone},
+	0x7f: {"neg-float", fmt12x, dexapkvisit.IndexNone},
+	0x80: {"neg-double", fmt12x, dexapkvisit.IndexNone},
+	0x81: {"int-to-long", fmt12x, dexapkvisit.IndexNone},
+	0x82: {"int-to-float", fmt12x, dexapkvisit.IndexNone},
+	0x83: {"int-to-double", fmt12x, dexapkvisit.IndexNone},
+	0x84: {"long-to-int", fmt12x, dexapkvisit.IndexNone},
+	0x85: {"long-to-float", fmt12x, dexapkvisit.IndexNone},
+	0x86: {"long-to-double", fmt12x, dexapkvisit.IndexNone},
+	0x87: {"float-to-int", fmt12x, dexapkvisit.IndexNone},
+	0x88: {"float-to-long", fmt12x, dexapkvisit.IndexNone},
+	0x89: {"float-to-double", fmt12x, dexapkvisit.IndexNone},
+	0x8a: {"double-to-int", fmt12x, dexapkvisit.IndexNone},
+	0x8b: {"double-to-long", fmt12x, dexapkvisit.IndexNone},
+	0x8c: {"double-to-float", fmt12x, dexapkvisit.IndexNone},
+	0x8d: {"int-to-byte", fmt12x, dexapkvisit.IndexNone},
+	0x8e: {"int-to-char", fmt12x, dexapkvisit.IndexNone},
+	0x8f: {"int-to-short", fmt12x, dexapkvisit.IndexNone},
+	0x90: {"add-int", fmt23x, dexapkvisit.IndexNone},
+	0x91: {"sub-int", fmt23x, dexapkvisit.IndexNone},
+	0x92: {"mul-int", fmt23x, dexapkvisit.IndexNone},
+	0x93: {"div-int", fmt23x, dexapkvisit.IndexNone},
+	0x94: {"rem-int", fmt23x, dexapkvisit.IndexNone},
+	0x95: {"and-int", fmt23x, dexapkvisit.IndexNone},
+	0x96: {"or-int", fmt23x, dexapkvisit.IndexNone},
+	0x97: {"xor-int", fmt23x, dexapkvisit.IndexNone},
+	0x98: {"shl-int", fmt23x, dexapkvisit.IndexNone},
+	0x99: {"shr-int", fmt23x, dexapkvisit.IndexNone},
+	0x9a: {"ushr-int", fmt23x, dexapkvisit.IndexNone},
+	0x9b: {"add-long", fmt23x, dexapkvisit.IndexNone},
+	0x9c: {"sub-long", fmt23x, dexapkvisit.IndexNone},
+	0x9d: {"mul-long", fmt23x, dexapkvisit.IndexNone},
+	0x9e: {"div-long", fmt23x, dexapkvisit.IndexNone},
+	0x9f: {"rem-long", fmt23x, dexapkvisit.IndexNone},
+	0xa0: {"and-long", fmt23x, dexapkvisit.IndexNone},
+	0xa1: {"or-long", fmt23x, dexapkvisit.IndexNone},
+	0xa2: {"xor-long", fmt23x, dexapkvisit.IndexNone},
+	0xa3: {"shl-long", fmt23x, dexapkvisit.IndexNone},
+	0xa4: {"shr-long", fmt23x, dexapkvisit.IndexNone},
+	0xa5: {"ushr-long", fmt23x, dexapkvisit.IndexNone},
+	0xa6: {"add-float", fmt23x, dexapkvisit.IndexNone},
+	0xa7: {"sub-float", fmt23x, dexapkvisit.IndexNone},
+	0xa8: {"mul-float", fmt23x, dexapkvisit.IndexNone},
+	0xa9: {"div-float", fmt23x, dexapkvisit.IndexNone},
+	0xaa: {"rem-float", fmt23x, dexapkvisit.IndexNone},
+	0xab: {"add-double", fmt23x, dexapkvisit.IndexNone},
+	0xac: {"sub-double", fmt23x, dexapkvisit.IndexNone},
+	0xad: {"mul-double", fmt23x, dexapkvisit.IndexNone},
+	0xae: {"div-double", fmt23x, dexapkvisit.IndexNone},
+	0xaf: {"rem-double", fmt23x, dexapkvisit.IndexNone},
+	0xb0: {"add-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xb1: {"sub-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xb2: {"mul-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xb3: {"div-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xb4: {"rem-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xb5: {"and-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xb6: {"or-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xb7: {"xor-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xb8: {"shl-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xb9: {"shr-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xba: {"ushr-int/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xbb: {"add-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xbc: {"sub-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xbd: {"mul-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xbe: {"div-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xbf: {"rem-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xc0: {"and-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xc1: {"or-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xc2: {"xor-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xc3: {"shl-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xc4: {"shr-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xc5: {"ushr-long/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xc6: {"add-float/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xc7: {"sub-float/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xc8: {"mul-float/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xc9: {"div-float/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xca: {"rem-float/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xcb: {"add-double/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xcc: {"sub-double/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xcd: {"mul-double/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xce: {"div-double/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xcf: {"rem-double/2addr", fmt12x, dexapkvisit.IndexNone},
+	0xd0: {"add-int/lit16", fmt22s, dexapkvisit.IndexNone},
+	0xd1: {"rsub-int", fmt22s, dexapkvisit.IndexNone},
+	0xd2: {"mul-int/lit16", fmt22s, dexapkvisit.IndexNone},
+	0xd3: {"div-int/lit16", fmt22s, dexapkvisit.IndexNone},
+	0xd4: {"rem-int/lit16", fmt22s, dexapkvisit.IndexNone},
+	0xd5: {"and-int/lit16", fmt22s, dexapkvisit.IndexNone},
+	0xd6: {"or-int/lit16", fmt22s, dexapkvisit.IndexNone},
+	0xd7: {"xor-int/lit16", fmt22s, dexapkvisit.IndexNone},
+	0xd8: {"add-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xd9: {"rsub-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xda: {"mul-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xdb: {"div-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xdc: {"rem-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xdd: {"and-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xde: {"or-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xdf: {"xor-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xe0: {"shl-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xe1: {"shr-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xe2: {"ushr-int/lit8", fmt22b, dexapkvisit.IndexNone},
+	0xfa: {"invoke-polymorphic", fmt45cc, dexapkvisit.IndexMethod},
+	0xfb: {"invoke-polymorphic/range", fmt4rcc, dexapkvisit.IndexMethod},
+	0xfc: {"invoke-custom", fmt35c, dexapkvisit.IndexCallSite},
+	0xfd: {"invoke-custom/range", fmt3rc, dexapkvisit.IndexCallSite},
+	0xfe: {"const-method-handle", fmt21c, dexapkvisit.IndexMethodHandle},
+	0xff: {"const-method-type", fmt21c, dexapkvisit.IndexProto},
+}
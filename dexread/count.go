@@ -0,0 +1,123 @@
+package dexread
+
+import (
+	"sort"
+	"strings"
+)
+
+// MaxMemberIds is the number of entries the method_ids and field_ids
+// tables can hold, since instructions refer to them by 16-bit index
+// (the "64K limit").
+const MaxMemberIds = 65536
+
+// MemberCounts tallies methods and fields. The Methods and Fields
+// counts are of method_ids and field_ids entries, i.e. everything
+// referenced (which includes everything defined); these are what the
+// 64K limit applies to. DefinedMethods and DefinedFields count only
+// those defined by classes in the DEX file.
+type MemberCounts struct {
+	Methods        int
+	Fields         int
+	DefinedMethods int
+	DefinedFields  int
+}
+
+// Add adds the counts in 'o' to 'c'.
+func (c *MemberCounts) Add(o *MemberCounts) {
+	c.Methods += o.Methods
+	c.Fields += o.Fields
+	c.DefinedMethods += o.DefinedMethods
+	c.DefinedFields += o.DefinedFields
+}
+
+// Counts returns the totals for the DEX file.
+func (d *DexFile) Counts() MemberCounts {
+	c := MemberCounts{Methods: d.NumMethods(), Fields: d.NumFields()}
+	for _, cl := range d.classes {
+		c.DefinedMethods += len(cl.methods)
+		c.DefinedFields += len(cl.fields)
+	}
+	return c
+}
+
+// PackageCounts is the tally for a package (e.g. "com.example"; the
+// default package is ""). Depth is the number of components in the
+// package name.
+type PackageCounts struct {
+	Package string
+	Depth   int
+	MemberCounts
+}
+
+// packageOf returns the package of the class named by 'descriptor',
+// truncated to 'depth' components if depth is positive. Array types
+// belong to the package of their element type.
+func packageOf(descriptor string, depth int) string {
+	d := strings.TrimLeft(descriptor, "[")
+	if !strings.HasPrefix(d, "L") {
+		return ""
+	}
+	parts := strings.Split(strings.TrimSuffix(d[1:], ";"), "/")
+	parts = parts[:len(parts)-1]
+	if depth > 0 && len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, ".")
+}
+
+// CountByPackage tallies the methods and fields of 'dexes' by the
+// package of the class they belong to, with package names truncated
+// to 'depth' components (no limit if depth is zero or less). If
+// 'prefixes' is set, each enclosing package also gets an entry,
+// which includes everything within it (as for a tree view). Entries
+// are sorted by package name, component by component, so that a
+// package comes right before the packages within it.
+func CountByPackage(dexes []*DexFile, depth int, prefixes bool) []PackageCounts {
+	counts := make(map[string]*MemberCounts)
+	tally := func(descriptor string, delta MemberCounts) {
+		pkg := packageOf(descriptor, depth)
+		for {
+			c := counts[pkg]
+			if c == nil {
+				c = &MemberCounts{}
+				counts[pkg] = c
+			}
+			c.Add(&delta)
+			i := strings.LastIndex(pkg, ".")
+			if !prefixes || pkg == "" || i < 0 {
+				break
+			}
+			pkg = pkg[:i]
+		}
+	}
+	for _, d := range dexes {
+		for _, m := range d.state.methodIds {
+			tally(d.state.typeDescriptor(uint32(m.ClassIdx)), MemberCounts{Methods: 1})
+		}
+		for _, f := range d.state.fieldIds {
+			tally(d.state.typeDescriptor(uint32(f.ClassIdx)), MemberCounts{Fields: 1})
+		}
+		for _, c := range d.classes {
+			tally(c.Descriptor, MemberCounts{DefinedMethods: len(c.methods), DefinedFields: len(c.fields)})
+		}
+	}
+
+	retval := make([]PackageCounts, 0, len(counts))
+	for pkg, c := range counts {
+		pc := PackageCounts{Package: pkg, MemberCounts: *c}
+		if pkg != "" {
+			pc.Depth = strings.Count(pkg, ".") + 1
+		}
+		retval = append(retval, pc)
+	}
+	sort.Slice(retval, func(i, j int) bool {
+		a, b := strings.Split(retval[i].Package, "."), strings.Split(retval[j].Package, ".")
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return retval
+}
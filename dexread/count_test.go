@@ -0,0 +1,49 @@
+package dexread
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCountByPackage(t *testing.T) {
+	dex, err := Open("testdata/classes.dex")
+	if err != nil {
+		t.Fatalf("Open error %v", err)
+	}
+	if c := dex.Counts(); c != (MemberCounts{Methods: 12, Fields: 2, DefinedMethods: 6}) {
+		t.Errorf("unexpected counts %+v", c)
+	}
+	format := func(pcs []PackageCounts) string {
+		var lines []string
+		for _, pc := range pcs {
+			lines = append(lines, fmt.Sprintf("%q %d: %d %d %d %d", pc.Package, pc.Depth,
+				pc.Methods, pc.Fields, pc.DefinedMethods, pc.DefinedFields))
+		}
+		return strings.Join(lines, "\n")
+	}
+	tests := []struct {
+		depth    int
+		prefixes bool
+		expected string
+	}{
+		{0, false, `"" 0: 6 0 6 0
+"java.io" 2: 2 0 0 0
+"java.lang" 2: 4 2 0 0`},
+		{1, false, `"" 0: 6 0 6 0
+"java" 1: 6 2 0 0`},
+		{0, true, `"" 0: 6 0 6 0
+"java" 1: 6 2 0 0
+"java.io" 2: 2 0 0 0
+"java.lang" 2: 4 2 0 0`},
+	}
+	for _, tc := range tests {
+		actual := format(CountByPackage([]*DexFile{dex}, tc.depth, tc.prefixes))
+		if actual != tc.expected {
+			t.Errorf("depth %d prefixes %v: got\n%s\nexpected\n%s", tc.depth, tc.prefixes, actual, tc.expected)
+		}
+	}
+	if p := packageOf("[[Lcom/example/Foo$Bar;", 0); p != "com.example" {
+		t.Errorf("packageOf array got %q", p)
+	}
+}
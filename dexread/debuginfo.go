@@ -0,0 +1,153 @@
+package dexread
+
+import (
+	"sort"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// Debug info state machine opcodes, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#debug-info-item
+const (
+	dbgEndSequence        = 0x00
+	dbgAdvancePc          = 0x01
+	dbgAdvanceLine        = 0x02
+	dbgStartLocal         = 0x03
+	dbgStartLocalExtended = 0x04
+	dbgEndLocal           = 0x05
+	dbgRestartLocal       = 0x06
+	dbgSetPrologueEnd     = 0x07
+	dbgSetEpilogueBegin   = 0x08
+	dbgSetFile            = 0x09
+	dbgFirstSpecial       = 0x0a
+	dbgLineBase           = -4
+	dbgLineRange          = 15
+)
+
+// unpackDebugInfo runs the state machine in the debug_info_item for
+// method 'm', whose decoded code_item is 'code'. The method's class
+// supplies the initial source file and the type of "this".
+func unpackDebugInfo(state *dexState, m *Method, code *dexapkvisit.MethodCode) (*dexapkvisit.DebugInfo, error) {
+	off := code.DebugInfoOff
+	content := state.b.Bytes()
+	if uint64(off) >= uint64(len(content)) {
+		return nil, mkError(state, "debug info offset %d out of range", off)
+	}
+	helper := ulebHelper{content[off:]}
+
+	// Indices in the debug info are biased by one, so that zero
+	// can mean "none".
+	stringP1 := func() string {
+		if idx := helper.grabULEB128(); idx != 0 {
+			return state.stringAt(uint32(idx - 1))
+		}
+		return ""
+	}
+	typeP1 := func() string {
+		if idx := helper.grabULEB128(); idx != 0 {
+			return state.typeDescriptor(uint32(idx - 1))
+		}
+		return ""
+	}
+
+	info := &dexapkvisit.DebugInfo{LineStart: uint32(helper.grabULEB128())}
+	nparams := helper.grabULEB128()
+	if nparams > uint64(len(m.Id.Proto.Parameters)) {
+		return nil, mkError(state, "debug info at offset %d: %d parameter names for %d parameters",
+			off, nparams, len(m.Id.Proto.Parameters))
+	}
+	for i := uint64(0); i < nparams; i++ {
+		info.ParameterNames = append(info.ParameterNames, stringP1())
+	}
+
+	// Locals are recorded as they end; 'last' remembers the most
+	// recent one in each register for DBG_RESTART_LOCAL.
+	var address uint32
+	live := make(map[uint32]*dexapkvisit.LocalVariable)
+	last := make(map[uint32]dexapkvisit.LocalVariable)
+	end := func(r uint32) {
+		if l := live[r]; l != nil {
+			l.EndAddr = address
+			info.Locals = append(info.Locals, *l)
+			last[r] = *l
+			delete(live, r)
+		}
+	}
+	start := func(l dexapkvisit.LocalVariable) {
+		end(l.Register)
+		l.StartAddr = address
+		live[l.Register] = &l
+	}
+
+	// The incoming arguments occupy the last registers, starting
+	// with "this" for instance methods.
+	reg := uint32(code.RegistersSize) - uint32(code.InsSize)
+	if !m.AccessFlags.Has(dexapkvisit.AccStatic) {
+		start(dexapkvisit.LocalVariable{Register: reg, Name: "this", Type: m.Id.Class})
+		reg++
+	}
+	for i, t := range m.Id.Proto.Parameters {
+		if i < len(info.ParameterNames) && info.ParameterNames[i] != "" {
+			start(dexapkvisit.LocalVariable{Register: reg, Name: info.ParameterNames[i], Type: t})
+		}
+		reg++
+		if t == "J" || t == "D" {
+			reg++
+		}
+	}
+
+	line := info.LineStart
+	file := m.class.SourceFile
+	for {
+		if len(helper.data) == 0 {
+			return nil, mkError(state, "debug info at offset %d: missing DBG_END_SEQUENCE", off)
+		}
+		op := helper.data[0]
+		helper.data = helper.data[1:]
+		switch op {
+		case dbgEndSequence:
+			address = code.InsnsSize
+			var regs []int
+			for r := range live {
+				regs = append(regs, int(r))
+			}
+			sort.Ints(regs)
+			for _, r := range regs {
+				end(uint32(r))
+			}
+			return info, nil
+		case dbgAdvancePc:
+			address += uint32(helper.grabULEB128())
+		case dbgAdvanceLine:
+			line = uint32(int64(line) + helper.grabSLEB128())
+		case dbgStartLocal, dbgStartLocalExtended:
+			l := dexapkvisit.LocalVariable{Register: uint32(helper.grabULEB128())}
+			l.Name = stringP1()
+			l.Type = typeP1()
+			if op == dbgStartLocalExtended {
+				l.Signature = stringP1()
+			}
+			start(l)
+		case dbgEndLocal:
+			end(uint32(helper.grabULEB128()))
+		case dbgRestartLocal:
+			r := uint32(helper.grabULEB128())
+			if l, ok := last[r]; ok && live[r] == nil {
+				start(l)
+			}
+		case dbgSetPrologueEnd:
+			info.PrologueEnd = append(info.PrologueEnd, address)
+		case dbgSetEpilogueBegin:
+			info.EpilogueBegin = append(info.EpilogueBegin, address)
+		case dbgSetFile:
+			// NO_INDEX means the file is unknown.
+			file = stringP1()
+		default:
+			adjusted := int64(op) - dbgFirstSpecial
+			line = uint32(int64(line) + dbgLineBase + adjusted%dbgLineRange)
+			address += uint32(adjusted / dbgLineRange)
+			info.Positions = append(info.Positions,
+				dexapkvisit.PositionEntry{Address: address, Line: line, File: file})
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package dexread
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+func TestDebugInfoSmallDex(t *testing.T) {
+	dex, err := Open("testdata/classes.dex")
+	if err != nil {
+		t.Fatalf("Open error %v", err)
+	}
+	c := dex.ClassByName("Lfibonacci;")
+	if c == nil || c.SourceFile != "fibonacci.java" {
+		t.Fatalf("unexpected class %+v", c)
+	}
+	var m *Method
+	for _, cm := range c.Methods() {
+		if cm.Id.Name == "ifibonacci" {
+			m = cm
+		}
+	}
+	if p, ok := m.Position(0x8); !ok || p.String() != "0006: fibonacci.java:28" {
+		t.Errorf("Position(0x8): got %v %v", p, ok)
+	}
+	code, _ := m.Code()
+	var locals []string
+	for _, l := range code.Debug.Locals {
+		locals = append(locals, l.String())
+	}
+	expected := "v0 0007..0010 i I, v1 0005..0010 x I, v2 0006..0010 y I, v3 000b..0010 z I, v4 0000..0010 n I"
+	if strings.Join(locals, ", ") != expected {
+		t.Errorf("got locals %s expected %s", strings.Join(locals, ", "), expected)
+	}
+}
+
+func TestDebugInfoStateMachine(t *testing.T) {
+	b := newDexBuilder()
+	cls := "Lcom/example/Foo;"
+	bar := b.method(cls, "bar", b.proto("VJI", "V", "J", "I"))
+	listType := b.typ("Ljava/util/List;")
+	debugInfo := []byte{
+		10,                         // line_start
+		2, byte(b.str("a") + 1), 0, // parameter names
+		0x07, // prologue end
+		0x0e, // address 0, line 10
+		0x04, 0, byte(b.str("count") + 1), byte(listType + 1),
+		byte(b.str("Ljava/util/List<Ljava/lang/String;>;") + 1),
+		0x2d,    // address 2, line 11
+		0x05, 0, // end local v0
+		0x09, byte(b.str("Other.kt") + 1),
+		0x01, 1, // advance pc to 3
+		0x02, 5, // advance line to 16
+		0x06, 0, // restart local v0
+		0x0e,    // address 3, line 16
+		0x08,    // epilogue begin
+		0x09, 0, // file unknown
+		0x1e, // address 4, line 17
+		0x00,
+	}
+	b.class(testClass{
+		typ:        cls,
+		sourceFile: "Foo.java",
+		virtualMethods: []testEncodedMethod{{bar, uint32(dexapkvisit.AccPublic),
+			&testCode{registers: 6, ins: 4, insns: []uint16{0, 0, 0, 0, 0x000e}, debugInfo: debugInfo}}},
+	})
+	data := b.build()
+	dex, err := Parse(nil, "test.dex", bytes.NewReader(data), uint64(len(data)))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	m := dex.ClassByName(cls).Methods()[0]
+	code, err := m.Code()
+	if err != nil || code.Debug == nil {
+		t.Fatalf("Code: got %v %v", code, err)
+	}
+	dbg := code.Debug
+	var actual []string
+	for _, p := range dbg.Positions {
+		actual = append(actual, p.String())
+	}
+	for _, l := range dbg.Locals {
+		actual = append(actual, l.String())
+	}
+	actual = append(actual, fmt.Sprintf("params %q prologue %v epilogue %v",
+		dbg.ParameterNames, dbg.PrologueEnd, dbg.EpilogueBegin))
+	expected := []string{
+		"0000: Foo.java:10",
+		"0002: Foo.java:11",
+		"0003: Other.kt:16",
+		"0004: line 17",
+		"v0 0000..0002 count Ljava/util/List; Ljava/util/List<Ljava/lang/String;>;",
+		"v0 0003..0005 count Ljava/util/List; Ljava/util/List<Ljava/lang/String;>;",
+		"v2 0000..0005 this Lcom/example/Foo;",
+		"v3 0000..0005 a J",
+		`params ["a" ""] prologue [0] epilogue [3]`,
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got\n%s\nexpected\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+	if p, ok := m.Position(3); !ok || p.File != "Other.kt" || p.Line != 16 {
+		t.Errorf("Position(3): got %v %v", p, ok)
+	}
+	if p, ok := m.Position(4); !ok || p.File != "" || p.Line != 17 {
+		t.Errorf("Position(4): got %v %v", p, ok)
+	}
+	if n := len(dbg.LocalsAt(1)); n != 3 {
+		t.Errorf("LocalsAt(1): got %d locals, expected 3", n)
+	}
+}
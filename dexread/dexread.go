@@ -8,17 +8,19 @@
 // This package focuses on the classes and methods in a DEX file; you
 // pass it a visitor object and it will invoke interfaces on the
 // visitor for each DEX class and DEX method in the DEX file of
-// interest.
+// interest. Method code items are decoded and disassembled (see
+// disasm.go) before being handed to the visitor. Alternatively, Open
+// or Parse will hand back a DexFile (see model.go) that can be
+// queried directly; the visitor interface is implemented as a walk
+// over the DexFile.
 //
 package dexread
 
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strings"
 
 	"github.com/thanm/go-read-a-dex/dexapkvisit"
@@ -30,36 +32,58 @@ type dexState struct {
 	b          bytes.Buffer
 	rdr        *bytes.Reader
 	methodIds  []dexMethodIdItem
+	fieldIds   []dexFieldIdItem
+	protoIds   []dexProtoIdItem
 	typeIds    []uint32
 	strings    []string
 	fileHeader dexFileHeader
 	visitor    dexapkvisit.DexApkVisitor
+	opts       Options
+
+	// call_site_ids and method_handles (version 038 on)
+	callSiteOffs  []uint32
+	methodHandles []dexMethodHandleItem
+
+	// DEX version, and for version 041 containers the offset of
+	// this DEX file's header within the container
+	version         int
+	base            uint32
+	containerHeader dexContainerHeader
+
+	// byte order given by the header's endian tag
+	order binary.ByteOrder
 }
 
+// mkError formats an error about the DEX file; a %w verb in
+// 'fmtstring' wraps the corresponding argument, as with fmt.Errorf.
 func mkError(state *dexState, fmtstring string, a ...interface{}) error {
-	gripe := fmt.Sprintf(fmtstring, a...)
 	apkPre := ""
 	if state.apk != nil {
-		apkPre = fmt.Sprintf("apk %s ", state.apk)
+		apkPre = fmt.Sprintf("apk %s ", *state.apk)
 	}
-	msg := fmt.Sprintf("reading %sdex %s: %s", apkPre, state.dexName, gripe)
-	return errors.New(msg)
+	a = append([]interface{}{apkPre, state.dexName}, a...)
+	return fmt.Errorf("reading %sdex %s: "+fmtstring, a...)
+}
+
+// EndianTagError is the error (wrapped; see errors.As) for a DEX file
+// whose header endian_tag is neither ENDIAN_CONSTANT nor
+// REVERSE_ENDIAN_CONSTANT.
+type EndianTagError struct {
+	Tag uint32
+}
+
+func (e *EndianTagError) Error() string {
+	return fmt.Sprintf("unknown endian tag 0x%08x", e.Tag)
 }
 
 // Examine the contents of the DEX file 'dexFilePath', invoking callbacks
 // within the visitor object 'visitor.
 func ReadDEXFile(dexFilePath string, visitor dexapkvisit.DexApkVisitor) error {
-	state := dexState{dexName: dexFilePath, visitor: visitor}
-	fi, err := os.Stat(dexFilePath)
-	if err != nil {
-		return mkError(&state, "os.Stat failed(): %v", err)
-	}
-	dfile, err := os.Open(dexFilePath)
+	dexes, err := openDEX(dexFilePath, visitor)
 	if err != nil {
-		return mkError(&state, "os.Open() failed(): %v", err)
+		return err
 	}
-	defer dfile.Close()
-	return ReadDEX(nil, dexFilePath, dfile, uint64(fi.Size()), visitor)
+	return walkAll(dexes, visitor)
 }
 
 // Examine the contents of the DEX file that that is pointed to by the
@@ -68,7 +92,66 @@ func ReadDEXFile(dexFilePath string, visitor dexapkvisit.DexApkVisitor) error {
 // purposes); if 'apk' is nil the assumption is that we're looking at
 // a stand-alone DEX file.
 func ReadDEX(apk *string, dexName string, reader io.Reader, expectedSize uint64, visitor dexapkvisit.DexApkVisitor) error {
-	state := dexState{apk: apk, dexName: dexName, visitor: visitor}
+	return ReadDEXWithOptions(apk, dexName, reader, expectedSize, visitor, Options{})
+}
+
+// ReadDEXWithOptions is like ReadDEX, with control over how the DEX
+// file is checked (see Options).
+func ReadDEXWithOptions(apk *string, dexName string, reader io.Reader, expectedSize uint64, visitor dexapkvisit.DexApkVisitor, opts Options) error {
+	dexes, err := parseContainer(apk, dexName, reader, expectedSize, visitor, opts)
+	if err != nil {
+		return err
+	}
+	return walkAll(dexes, visitor)
+}
+
+// walkAll walks each of the DEX files in a container.
+func walkAll(dexes []*DexFile, visitor dexapkvisit.DexApkVisitor) error {
+	for _, dex := range dexes {
+		if err := dex.Walk(visitor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse reads the DEX file pointed to by 'reader' (see ReadDEX for
+// the meaning of the other parameters) and returns a DexFile that can
+// be used to query its contents. For a version 041 container holding
+// several DEX files this is the first of them; see ParseAll.
+func Parse(apk *string, dexName string, reader io.Reader, expectedSize uint64) (*DexFile, error) {
+	return parseDEX(apk, dexName, reader, expectedSize, nil, Options{})
+}
+
+// ParseWithOptions is like Parse, with control over how the DEX file
+// is checked (see Options).
+func ParseWithOptions(apk *string, dexName string, reader io.Reader, expectedSize uint64, opts Options) (*DexFile, error) {
+	return parseDEX(apk, dexName, reader, expectedSize, nil, opts)
+}
+
+// ParseAll is like ParseWithOptions, but returns every DEX file in
+// the container. Only version 041 files can hold more than one; the
+// second and later ones are named after 'dexName' with their position
+// in the container appended, e.g. "classes.dex[1]".
+func ParseAll(apk *string, dexName string, reader io.Reader, expectedSize uint64, opts Options) ([]*DexFile, error) {
+	return parseContainer(apk, dexName, reader, expectedSize, nil, opts)
+}
+
+// parseDEX does the work for Parse and ReadDEX; 'visitor' (which may be
+// nil) is used only for verbose trace output. Only the first DEX file
+// in a container is returned.
+func parseDEX(apk *string, dexName string, reader io.Reader, expectedSize uint64, visitor dexapkvisit.DexApkVisitor, opts Options) (*DexFile, error) {
+	dexes, err := parseContainer(apk, dexName, reader, expectedSize, visitor, opts)
+	if err != nil {
+		return nil, err
+	}
+	return dexes[0], nil
+}
+
+// parseContainer reads in the DEX data and parses each of the DEX
+// files within it.
+func parseContainer(apk *string, dexName string, reader io.Reader, expectedSize uint64, visitor dexapkvisit.DexApkVisitor, opts Options) ([]*DexFile, error) {
+	state := &dexState{apk: apk, dexName: dexName, visitor: visitor, opts: opts}
 
 	// NB: the following seems clunky/inelegant (reading in entire
 	// contents of DEX and then creating a new bytes.Reader to muck
@@ -79,70 +162,177 @@ func ReadDEX(apk *string, dexName string, reader io.Reader, expectedSize uint64,
 	var nread int64
 	var err error
 	if nread, err = io.Copy(&state.b, reader); err != nil {
-		return mkError(&state, "reading dex data: %v", err)
+		return nil, mkError(state, "reading dex data: %v", err)
 	}
 	if uint64(nread) != expectedSize {
-		return mkError(&state, "expected %d bytes read %d", expectedSize, nread)
+		return nil, mkError(state, "expected %d bytes read %d", expectedSize, nread)
+	}
+	content := state.b.Bytes()
+	state.rdr = bytes.NewReader(content)
+
+	// In a version 041 container the DEX files follow one another,
+	// each header's file size giving the offset of the next one.
+	var dexes []*DexFile
+	for {
+		dex, err := parseOneDEX(state)
+		if err != nil {
+			return nil, err
+		}
+		dexes = append(dexes, dex)
+		if !state.inContainer() {
+			return dexes, nil
+		}
+		next := uint64(state.base) + uint64(state.fileHeader.FileSize)
+		if next >= uint64(state.containerHeader.ContainerSize) {
+			return dexes, nil
+		}
+		state = &dexState{
+			apk:     apk,
+			dexName: fmt.Sprintf("%s[%d]", dexName, len(dexes)),
+			b:       *bytes.NewBuffer(content),
+			rdr:     bytes.NewReader(content),
+			base:    uint32(next),
+			visitor: visitor,
+			opts:    opts,
+		}
 	}
-	state.rdr = bytes.NewReader(state.b.Bytes())
+}
+
+// parseOneDEX parses the DEX file whose header is at state.base.
+func parseOneDEX(state *dexState) (*DexFile, error) {
+	var err error
 
 	// Unpack file header and verify magic string
-	if state.fileHeader, err = unpackDexFileHeader(&state); err != nil {
-		return err
+	if err = unpackDexFileHeader(state); err != nil {
+		return nil, err
 	}
 
-	// Invoke visitor callback
-	visitor.VisitDEX(dexName, state.fileHeader.Sha1Sig)
+	// Check the header against the contents, if asked to
+	if state.opts.Integrity == IntegrityStrict {
+		if check := checkIntegrity(state); !check.OK() {
+			return nil, mkError(state, "integrity check failed: %s", check.String())
+		}
+	}
 
 	// Read method ids
-	if state.methodIds, err = unpackMethodIds(&state); err != nil {
-		return err
+	if state.methodIds, err = unpackMethodIds(state); err != nil {
+		return nil, err
 	}
 
 	// Read type ids
-	if state.typeIds, err = unpackTypeIds(&state); err != nil {
-		return err
+	if state.typeIds, err = unpackTypeIds(state); err != nil {
+		return nil, err
 	}
 
 	// Read strings
-	if state.strings, err = unpackStringIds(&state); err != nil {
-		return err
+	if state.strings, err = unpackStringIds(state); err != nil {
+		return nil, err
+	}
+
+	// Read field and proto ids
+	if state.fieldIds, err = unpackFieldIds(state); err != nil {
+		return nil, err
+	}
+	if state.protoIds, err = unpackProtoIds(state); err != nil {
+		return nil, err
+	}
+
+	// Read call sites and method handles
+	if err = unpackCallSitesAndHandles(state); err != nil {
+		return nil, err
 	}
 
-	// Dive into each class
+	// Read in each class
+	dex := &DexFile{state: state, classByName: make(map[string]*Class)}
 	numClasses := state.fileHeader.ClassDefsSize
 	off := state.fileHeader.ClassDefsOff
 	for cl := uint32(0); cl < numClasses; cl++ {
 		var classHeader dexClassHeader
-		if classHeader, err = unpackDexClass(&state, off); err != nil {
-			return err
+		if classHeader, err = unpackDexClass(state, off); err != nil {
+			return nil, err
 		}
-		visitor.Verbose(1, "class %d type idx is %d", cl, classHeader.ClassIdx)
-		examineClass(&state, &classHeader)
+		state.verbose(1, "class %d type idx is %d", cl, classHeader.ClassIdx)
+		c, err := parseClass(dex, &classHeader)
+		if err != nil {
+			return nil, err
+		}
+		dex.classes = append(dex.classes, c)
+		dex.classByName[c.Descriptor] = c
 		off += dexClassHeaderSize
 	}
-	return err
+	return dex, nil
 }
 
-func unpackDexFileHeader(state *dexState) (retval dexFileHeader, err error) {
+// verbose emits trace output via the visitor, if there is one.
+func (state *dexState) verbose(vlevel int, s string, a ...interface{}) {
+	if state.visitor != nil {
+		state.visitor.Verbose(vlevel, s, a...)
+	}
+}
 
-	// NB: do I really need a loop here? it would be nice to
-	// compare slices using a single operation -- wondering if
-	// there is some more idiomatic way to do this
-	headerBytes := state.b.Bytes()
-	DexFileMagic := [8]byte{0x64, 0x65, 0x78, 0x0a, 0x30, 0x33, 0x35, 0x00}
-	for i := 0; i < 8; i++ {
-		if DexFileMagic[i] != headerBytes[i] {
-			return retval, mkError(state, "not a DEX file")
-		}
+// unpackDexFileHeader reads the header at state.base, checking the
+// magic string and recording the version.
+func unpackDexFileHeader(state *dexState) error {
+	content := state.b.Bytes()
+	if uint64(state.base)+8 > uint64(len(content)) {
+		return mkError(state, "not a DEX file")
+	}
+	version, err := parseMagic(content[state.base:])
+	if err != nil {
+		return mkError(state, "%v", err)
+	}
+	state.version = version
+
+	// The endian tag says how to read everything else, header
+	// included; reverse-endian files have every multi-byte integer
+	// byte-swapped.
+	tagOff := uint64(state.base) + 40
+	if tagOff+4 > uint64(len(content)) {
+		return mkError(state, "unable to decode DEX header: truncated")
+	}
+	switch tag := binary.LittleEndian.Uint32(content[tagOff:]); tag {
+	case endianConstant:
+		state.order = binary.LittleEndian
+	case reverseEndianConst:
+		state.order = binary.BigEndian
+	default:
+		return mkError(state, "%w", &EndianTagError{Tag: tag})
 	}
 
 	// Populate the header file struct
-	if err = binary.Read(state.rdr, binary.LittleEndian, &retval); err != nil {
-		return retval, mkError(state, "unable to decode DEX header: %v", err)
+	if err = seekReader(state, state.base); err != nil {
+		return err
+	}
+	if err = binary.Read(state.rdr, state.order, &state.fileHeader); err != nil {
+		return mkError(state, "unable to decode DEX header: %v", err)
 	}
 
-	return
+	// Version 041 adds the container size and the offset of this
+	// header within the container. The whole container is the data
+	// range of each DEX file in it (data_off and data_size are
+	// unused), so all offsets, in this header and in the data it
+	// points to, are relative to the start of the container; this is
+	// what ART's DexFile::GetDataRange does. The DEX files can then
+	// share data, string data for instance.
+	if version >= DexVersion041 && state.fileHeader.HeaderSize >= dexContainerHeaderSize {
+		if err = binary.Read(state.rdr, state.order, &state.containerHeader); err != nil {
+			return mkError(state, "unable to decode DEX container header: %v", err)
+		}
+		if state.containerHeader.HeaderOffset != state.base {
+			return mkError(state, "header at offset %d claims offset %d",
+				state.base, state.containerHeader.HeaderOffset)
+		}
+		if state.fileHeader.FileSize == 0 {
+			return mkError(state, "zero file size in container")
+		}
+	}
+	return nil
+}
+
+// inContainer reports whether the DEX file is part of a version 041
+// container.
+func (state *dexState) inContainer() bool {
+	return state.containerHeader.ContainerSize != 0
 }
 
 // NB: can't use io.SeekStart with gccgo (gccgo has an older version of
@@ -160,7 +350,7 @@ func unpackDexClass(state *dexState, off uint32) (retval dexClassHeader, err err
 	if err = seekReader(state, off); err != nil {
 		return
 	}
-	if err = binary.Read(state.rdr, binary.LittleEndian, &retval); err != nil {
+	if err = binary.Read(state.rdr, state.order, &retval); err != nil {
 		return retval, mkError(state, "unable to unpack class header: %v", err)
 	}
 	return
@@ -172,10 +362,35 @@ type ulebHelper struct {
 
 func (a *ulebHelper) grabULEB128() uint64 {
 	v, size := binary.Uvarint(a.data)
+	if size <= 0 {
+		// truncated or overflowing value
+		a.data = nil
+		return 0
+	}
 	a.data = a.data[size:]
 	return v
 }
 
+// Note: binary.Varint uses zig-zag encoding, which is not what DEX
+// uses for signed values, hence the hand-rolled decoder.
+func (a *ulebHelper) grabSLEB128() int64 {
+	var result int64
+	var shift uint
+	for i, b := range a.data {
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			a.data = a.data[i+1:]
+			return result
+		}
+	}
+	a.data = nil
+	return result
+}
+
 //
 // For the rules on how type descriptors are encoded, see
 // https://source.android.com/devices/tech/dalvik/dex-format.html#typedescriptor
@@ -232,68 +447,20 @@ func decodeDescriptor(d string) string {
 	return base
 }
 
-func getClassName(state *dexState, ci *dexClassHeader) string {
-	typeidx := state.typeIds[ci.ClassIdx]
-	typename := state.strings[typeidx]
-	return decodeDescriptor(typename)
+// JavaName turns a type descriptor into a Java-style name, e.g.
+// "Lfoo/Bar;" into "foo.Bar" and "[I" into "int[]".
+func JavaName(descriptor string) string {
+	return decodeDescriptor(descriptor)
 }
 
-func examineClass(state *dexState, ci *dexClassHeader) {
-
-	// No class data? In theory this can happen
-	if ci.ClassDataOff == 0 {
-		state.visitor.VisitClass(getClassName(state, ci), 0)
-		return
-	}
-
-	// Create new slice pointing to correct spot in buffer for class data
-	content := state.b.Bytes()
-	cldata := content[ci.ClassDataOff:]
-	helper := ulebHelper{cldata}
-
-	// Read four ULEB128 encoded values into struct
-	var clh dexClassContents
-	clh.numStaticFields = uint32(helper.grabULEB128())
-	clh.numInstanceFields = uint32(helper.grabULEB128())
-	clh.numDirectMethods = uint32(helper.grabULEB128())
-	clh.numVirtualMethods = uint32(helper.grabULEB128())
-	numMethods := clh.numDirectMethods + clh.numVirtualMethods
-
-	// invoke visitor callback
-	state.visitor.VisitClass(getClassName(state, ci), numMethods)
-
-	// debugging
-	state.visitor.Verbose(1, "num static fields is %d", clh.numStaticFields)
-	state.visitor.Verbose(1, "num instance fields is %d", clh.numInstanceFields)
-	state.visitor.Verbose(1, "num direct methods is %d", clh.numDirectMethods)
-	state.visitor.Verbose(1, "num virtual methods is %d", clh.numVirtualMethods)
-
-	// Not interested in field info, but we have to get by that
-	// information to get to the interesting stuff that follows (since
-	// it's ULEB, we can't skip over it directly)
-	numFields := clh.numStaticFields + clh.numInstanceFields
-	for i := uint32(0); i < numFields; i++ {
-		helper.grabULEB128() // field_idx
-		helper.grabULEB128() // access_flags
-	}
-
-	// Examine the methods. Note that method ID value read is a
-	// difference from the index of the previous element in the list.
-	var methodIdx uint64 = 0
-	for i := uint32(0); i < numMethods; i++ {
-		methodDelta := helper.grabULEB128()
-		if i == 0 || i == clh.numDirectMethods {
-			methodIdx = methodDelta
-		} else {
-			methodIdx = methodIdx + methodDelta
-		}
-		_ = helper.grabULEB128() // access flags currently unused
-		methodCodeOffset := helper.grabULEB128()
-		state.visitor.Verbose(1, "method %d idx %d off %d",
-			i, methodIdx, methodCodeOffset)
-
-		examineMethod(state, methodIdx, methodCodeOffset)
+// ClassDescriptor turns a Java-style class name such as "foo.Bar"
+// into a type descriptor; something that is already a descriptor
+// is returned unchanged.
+func ClassDescriptor(name string) string {
+	if strings.HasPrefix(name, "L") && strings.HasSuffix(name, ";") {
+		return name
 	}
+	return "L" + strings.Replace(name, ".", "/", -1) + ";"
 }
 
 func unpackStringIds(state *dexState) (retval []string, err error) {
@@ -307,7 +474,7 @@ func unpackStringIds(state *dexState) (retval []string, err error) {
 
 	// read offsets
 	for i := 0; i < nStringIds; i++ {
-		err := binary.Read(state.rdr, binary.LittleEndian, &stringOffsets[i])
+		err := binary.Read(state.rdr, state.order, &stringOffsets[i])
 		if err != nil {
 			return []string{}, mkError(state, "string ID %d unpack failed: %v", i, err)
 		}
@@ -334,10 +501,8 @@ func zLen(sd []byte) int {
 	return len(sd)
 }
 
-//
 // DEX file strings use a somewhat peculiar "Modified" UTF-8 encoding, details
 // in https://source.android.com/devices/tech/dalvik/dex-format.html#mutf-8
-//
 func unpackModUTFString(state *dexState, off uint32) string {
 	content := state.b.Bytes()
 	sdata := content[off:]
@@ -359,13 +524,13 @@ func unpackMethodIds(state *dexState) (retval []dexMethodIdItem, err error) {
 	nMethods := int(state.fileHeader.MethodIdsSize)
 	retval = make([]dexMethodIdItem, nMethods, nMethods)
 	for i := 0; i < nMethods; i++ {
-		err = binary.Read(state.rdr, binary.LittleEndian, &retval[i])
+		err = binary.Read(state.rdr, state.order, &retval[i])
 		if err != nil {
 			return retval, mkError(state, "method ID %d unpack failed: %v", i, err)
 		}
 	}
 
-	state.visitor.Verbose(1, "read %d methodids", nMethods)
+	state.verbose(1, "read %d methodids", nMethods)
 
 	return retval, err
 }
@@ -387,23 +552,95 @@ func unpackTypeIds(state *dexState) (retval []uint32, err error) {
 	nTypeIds := int(state.fileHeader.TypeIdsSize)
 	retval = make([]uint32, nTypeIds, nTypeIds)
 	for i := 0; i < nTypeIds; i++ {
-		err := binary.Read(state.rdr, binary.LittleEndian, &retval[i])
+		err := binary.Read(state.rdr, state.order, &retval[i])
 		if err != nil {
 			return retval, mkError(state, "type ID %d unpack:: %v", i, err)
 		}
 	}
 
-	state.visitor.Verbose(1, "read %d typeids", nTypeIds)
+	state.verbose(1, "read %d typeids", nTypeIds)
+
+	return retval, err
+}
+
+func unpackFieldIds(state *dexState) (retval []dexFieldIdItem, err error) {
+
+	// position the reader at the right spot
+	if err = seekReader(state, state.fileHeader.FieldIdsOff); err != nil {
+		return retval, err
+	}
+
+	// read in the array of field id items
+	nFields := int(state.fileHeader.FieldIdsSize)
+	retval = make([]dexFieldIdItem, nFields, nFields)
+	for i := 0; i < nFields; i++ {
+		err = binary.Read(state.rdr, state.order, &retval[i])
+		if err != nil {
+			return retval, mkError(state, "field ID %d unpack failed: %v", i, err)
+		}
+	}
+
+	state.verbose(1, "read %d fieldids", nFields)
 
 	return retval, err
 }
 
-func examineMethod(state *dexState, methodIdx, methodCodeOffset uint64) {
+// unpackCallSitesAndHandles reads the call_site_ids and method_handles
+// sections of a version 038 or later DEX file. The header doesn't
+// point to these, so they are found through the map_list.
+func unpackCallSitesAndHandles(state *dexState) error {
+	if state.version < DexVersion038 || state.fileHeader.MapOff == 0 {
+		return nil
+	}
+	items, err := readMapList(state)
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		typ := SectionType(it.Type)
+		if typ != SectionCallSiteIds && typ != SectionMethodHandles {
+			continue
+		}
+		if uint64(it.Size)*uint64(typ.itemSize()) > uint64(state.b.Len()) {
+			return mkError(state, "%s section size %d exceeds file size", typ, it.Size)
+		}
+		if err := seekReader(state, it.Offset); err != nil {
+			return err
+		}
+		if typ == SectionCallSiteIds {
+			state.callSiteOffs = make([]uint32, it.Size)
+			err = binary.Read(state.rdr, state.order, state.callSiteOffs)
+		} else {
+			state.methodHandles = make([]dexMethodHandleItem, it.Size)
+			err = binary.Read(state.rdr, state.order, state.methodHandles)
+		}
+		if err != nil {
+			return mkError(state, "%s unpack failed: %v", typ, err)
+		}
+	}
+	state.verbose(1, "read %d call sites, %d method handles",
+		len(state.callSiteOffs), len(state.methodHandles))
+	return nil
+}
+
+func unpackProtoIds(state *dexState) (retval []dexProtoIdItem, err error) {
 
-	// Look up method name from method ID
-	nameIdx := state.methodIds[methodIdx].NameIdx
+	// position the reader at the right spot
+	if err = seekReader(state, state.fileHeader.ProtoIdsOff); err != nil {
+		return retval, err
+	}
+
+	// read in the array of proto id items
+	nProtos := int(state.fileHeader.ProtoIdsSize)
+	retval = make([]dexProtoIdItem, nProtos, nProtos)
+	for i := 0; i < nProtos; i++ {
+		err = binary.Read(state.rdr, state.order, &retval[i])
+		if err != nil {
+			return retval, mkError(state, "proto ID %d unpack failed: %v", i, err)
+		}
+	}
 
-	name := state.strings[nameIdx]
+	state.verbose(1, "read %d protoids", nProtos)
 
-	state.visitor.VisitMethod(name, methodIdx, methodCodeOffset)
+	return retval, err
 }
@@ -1,7 +1,11 @@
 package dexread
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"strings"
 	"testing"
 
@@ -11,6 +15,7 @@ import (
 	// go-read-a-dex or move it to some other location)?
 
 	"github.com/thanm/go-read-a-dex/dexapktest"
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
 )
 
 func TestDecodeDescriptor(t *testing.T) {
@@ -55,14 +60,20 @@ func TestSmallDexFileRead(t *testing.T) {
 	actual := strings.Join(visitor.Result, "\n")
 
 	expected := ` DEX testdata/classes.dex
-            sha1 fd56aced78355c305a9503d6f3dfe1f7ff6ac440
-		    class fibonacci methods: 6
-		    method id 0 name '<init>' code offset 584
-		    method id 1 name 'ifibonacci' code offset 608
-		    method id 2 name 'main' code offset 656
-		    method id 3 name 'rcnm1' code offset 1008
-		    method id 4 name 'rcnm2' code offset 1040
-		    method id 5 name 'rfibonacci' code offset 1072`
+            version 035 sha1 fd56aced78355c305a9503d6f3dfe1f7ff6ac440
+		    class fibonacci flags 'final' methods: 6 extends java.lang.Object
+		    method id 0 name '<init>' sig 'void fibonacci.<init>()' flags 'constructor' code offset 584
+		     registers 1 ins 1 outs 1 insns 4
+		    method id 1 name 'ifibonacci' sig 'int fibonacci.ifibonacci(int)' flags 'static' code offset 608
+		     registers 5 ins 1 outs 0 insns 16
+		    method id 2 name 'main' sig 'void fibonacci.main(java.lang.String[])' flags 'public static' code offset 656
+		     registers 14 ins 1 outs 3 insns 159
+		    method id 3 name 'rcnm1' sig 'int fibonacci.rcnm1(int)' flags 'private static final' code offset 1008
+		     registers 2 ins 1 outs 1 insns 7
+		    method id 4 name 'rcnm2' sig 'int fibonacci.rcnm2(int)' flags 'private static final' code offset 1040
+		     registers 2 ins 1 outs 1 insns 7
+		    method id 5 name 'rfibonacci' sig 'int fibonacci.rfibonacci(int)' flags 'private static final' code offset 1072
+		     registers 3 ins 1 outs 1 insns 17`
 
 	if dexapktest.SqueezeWhite(actual) != dexapktest.SqueezeWhite(expected) {
 		t.Errorf("TestSmallApkRead: got '%s' expected '%s'",
@@ -97,3 +108,268 @@ func TestBadDexFileRead(t *testing.T) {
 		t.Errorf("TestSmallApkRead: expected '%s' got '%s', f error", expected, actual)
 	}
 }
+
+// Records the MethodId for each method visited.
+type methodIdVisitor struct {
+	dexapktest.CaptureDexApkVisitOperations
+	methods map[string]dexapkvisit.MethodId
+}
+
+func (m *methodIdVisitor) VisitMethod(method *dexapkvisit.MethodId, methodIdx uint64, accessFlags dexapkvisit.AccessFlags, codeOffset uint64, code *dexapkvisit.MethodCode) {
+	m.methods[method.Name] = *method
+}
+
+func TestMethodProtos(t *testing.T) {
+	visitor := &methodIdVisitor{methods: make(map[string]dexapkvisit.MethodId)}
+	if err := ReadDEXFile("testdata/classes.dex", visitor); err != nil {
+		t.Fatalf("ReadDEXFile error %v", err)
+	}
+	main := visitor.methods["main"]
+	if main.Class != "Lfibonacci;" || main.Proto.Shorty != "VL" ||
+		main.Proto.ReturnType != "V" ||
+		strings.Join(main.Proto.Parameters, ",") != "[Ljava/lang/String;" {
+		t.Errorf("main: unexpected method id %+v", main)
+	}
+	if d := main.Proto.Descriptor(); d != "([Ljava/lang/String;)V" {
+		t.Errorf("main: descriptor got '%s'", d)
+	}
+	init := visitor.methods["<init>"]
+	if init.Proto.Shorty != "V" || len(init.Proto.Parameters) != 0 {
+		t.Errorf("<init>: unexpected method id %+v", init)
+	}
+}
+
+func TestNativeMethodFlags(t *testing.T) {
+	b := newDexBuilder()
+	cls := "Lcom/example/Jni;"
+	p := b.proto("V", "V")
+	nat := b.method(cls, "nativeInit", p)
+	syn := b.method(cls, "access$000", p)
+	b.class(testClass{
+		typ:   cls,
+		flags: uint32(dexapkvisit.AccPublic | dexapkvisit.AccAbstract),
+		directMethods: []testEncodedMethod{
+			{nat, uint32(dexapkvisit.AccPrivate | dexapkvisit.AccStatic | dexapkvisit.AccNative), nil},
+			{syn, uint32(dexapkvisit.AccStatic | dexapkvisit.AccSynthetic),
+				&testCode{registers: 0, insns: []uint16{0x000e}}},
+		},
+	})
+	visitor := &dexapktest.CaptureDexApkVisitOperations{}
+	if err := readTestDex(b.build(), visitor); err != nil {
+		t.Fatalf("ReadDEX error %v", err)
+	}
+	actual := strings.Join(visitor.Result[1:], "\n")
+	expected := `  class com.example.Jni flags 'public abstract' methods: 2
+		method id 0 name 'nativeInit' sig 'void com.example.Jni.nativeInit()' flags 'private static native' code offset 0
+		method id 1 name 'access$000' sig 'void com.example.Jni.access$000()' flags 'static synthetic' code offset 244
+		registers 0 ins 0 outs 0 insns 1`
+	if dexapktest.SqueezeWhite(actual) != dexapktest.SqueezeWhite(expected) {
+		t.Errorf("got '%s' expected '%s'", actual, expected)
+	}
+}
+
+func TestIntegrityCheck(t *testing.T) {
+	good, err := ioutil.ReadFile("testdata/classes.dex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dex, err := Parse(nil, "classes.dex", bytes.NewReader(good), uint64(len(good)))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	if check := dex.Integrity(); !check.OK() || check.String() != "ok" {
+		t.Errorf("unexpected integrity failure %s", check.String())
+	}
+
+	// Patch a byte within the string data.
+	bad := append([]byte{}, good...)
+	bad[len(bad)-100] ^= 0xff
+	read := func(mode IntegrityMode) ([]string, error) {
+		visitor := &dexapktest.CaptureDexApkVisitOperations{}
+		err := ReadDEXWithOptions(nil, "bad.dex", bytes.NewReader(bad), uint64(len(bad)),
+			visitor, Options{Integrity: mode})
+		return visitor.Result, err
+	}
+	if result, err := read(IntegrityIgnore); err != nil || strings.Contains(strings.Join(result, "\n"), "integrity") {
+		t.Errorf("IntegrityIgnore: unexpected %v %v", err, result)
+	}
+	result, err := read(IntegrityReport)
+	if err != nil || len(result) < 2 ||
+		!strings.HasPrefix(result[1], " DEX bad.dex integrity check failed: checksum 0x") ||
+		!strings.Contains(result[1], "sha1 fd56aced78355c305a9503d6f3dfe1f7ff6ac440 (computed ") {
+		t.Errorf("IntegrityReport: unexpected %v %v", err, result)
+	}
+	if _, err := read(IntegrityStrict); err == nil ||
+		!strings.HasPrefix(err.Error(), "reading dex bad.dex: integrity check failed: checksum") {
+		t.Errorf("IntegrityStrict: unexpected error %v", err)
+	}
+
+	// Truncation shows up as a size mismatch too; losing the end of
+	// the map_list does not stop the file parsing.
+	short := good[:len(good)-4]
+	dex, err = Parse(nil, "short.dex", bytes.NewReader(short), uint64(len(short)))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	check := dex.Integrity()
+	if check.SizeOK() || check.StoredSize != uint32(len(good)) || check.ActualSize != uint64(len(short)) {
+		t.Errorf("truncated file: unexpected size check %d (actual %d)", check.StoredSize, check.ActualSize)
+	}
+	if want := fmt.Sprintf("file size %d (actual %d), checksum ", len(good), len(short)); !strings.HasPrefix(check.String(), want) {
+		t.Errorf("truncated file: integrity %q, expected prefix %q", check.String(), want)
+	}
+}
+
+// versionTestDex builds a DEX file of the given version with one
+// method that uses invoke-custom.
+func versionTestDex(version string) *dexBuilder {
+	b := newDexBuilder()
+	b.version = version
+	cls := "Lcom/example/Lambda;"
+	run := b.method(cls, "run", b.proto("V", "V"))
+	b.class(testClass{
+		typ:   cls,
+		flags: uint32(dexapkvisit.AccPublic),
+		directMethods: []testEncodedMethod{{run, uint32(dexapkvisit.AccStatic),
+			&testCode{insns: []uint16{0x00fc, 0x0000, 0x0000, 0x000e}}}},
+	})
+	return b
+}
+
+func TestDexVersions(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		want    string // error, if any
+	}{
+		{"035", "invoke-custom instruction at 0000 requires DEX version 038"},
+		{"036", "unsupported DEX version 036"},
+		{"037", "invoke-custom instruction at 0000 requires DEX version 038"},
+		{"038", ""},
+		{"039", ""},
+		{"040", ""},
+		{"041", ""},
+		{"099", "unsupported DEX version 099"},
+		{"0x5", "not a DEX file"},
+	} {
+		data := versionTestDex(tc.version).build()
+		visitor := &dexapktest.CaptureDexApkVisitOperations{}
+		err := readTestDex(data, visitor)
+		if tc.want != "" {
+			if err == nil || !strings.HasSuffix(err.Error(), tc.want) {
+				t.Errorf("version %s: got error %v, expected %q", tc.version, err, tc.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("version %s: unexpected error %v", tc.version, err)
+			continue
+		}
+		if !strings.HasPrefix(visitor.Result[0], " DEX test.dex version "+tc.version+" sha1 ") {
+			t.Errorf("version %s: got %q", tc.version, visitor.Result[0])
+		}
+	}
+}
+
+func TestDexContainer(t *testing.T) {
+	b1, b2 := versionTestDex(""), newDexBuilder()
+	b1.str("shared")
+	b2.str("shared")
+	b2.class(testClass{typ: "Lcom/example/Other;", flags: uint32(dexapkvisit.AccPublic)})
+	data := buildContainer(b1, b2)
+
+	// Have the second DEX file share the first one's copy of
+	// "shared": offsets are relative to the container, not to the
+	// DEX file's own header.
+	stringDataOff := func(base uint32, s string) (uint32, uint32) {
+		n := binary.LittleEndian.Uint32(data[base+56:])
+		ids := binary.LittleEndian.Uint32(data[base+60:])
+		for i := uint32(0); i < n; i++ {
+			off := binary.LittleEndian.Uint32(data[ids+4*i:])
+			if string(data[off+1:off+1+uint32(data[off])]) == s {
+				return ids + 4*i, off
+			}
+		}
+		t.Fatalf("no string %q in DEX at %d", s, base)
+		return 0, 0
+	}
+	base2 := binary.LittleEndian.Uint32(data[32:])
+	_, off1 := stringDataOff(0, "shared")
+	id2, _ := stringDataOff(base2, "shared")
+	binary.LittleEndian.PutUint32(data[id2:], off1)
+	signDex(data[base2:], binary.LittleEndian)
+
+	dexes, err := ParseAll(nil, "test.dex", bytes.NewReader(data), uint64(len(data)),
+		Options{Integrity: IntegrityStrict})
+	if err != nil {
+		t.Fatalf("ParseAll error %v", err)
+	}
+	if len(dexes) != 2 {
+		t.Fatalf("got %d DEX files, expected 2", len(dexes))
+	}
+	for i, want := range []string{"com.example.Lambda", "com.example.Other"} {
+		d := dexes[i]
+		if d.Version() != DexVersion041 || len(d.Classes()) != 1 || d.Classes()[0].Name() != want {
+			t.Errorf("DEX %d: version %d classes %v", i, d.Version(), d.Classes())
+		}
+	}
+	if dexes[1].Name() != "test.dex[1]" {
+		t.Errorf("got name %q", dexes[1].Name())
+	}
+	found := false
+	for _, s := range dexes[1].Strings() {
+		found = found || s == "shared"
+	}
+	if !found {
+		t.Errorf("DEX 1 strings %q", dexes[1].Strings())
+	}
+
+	// ReadDEX visits both.
+	visitor := &dexapktest.CaptureDexApkVisitOperations{}
+	if err := readTestDex(data, visitor); err != nil {
+		t.Fatalf("ReadDEX error %v", err)
+	}
+	var dexLines []string
+	for _, r := range visitor.Result {
+		if strings.HasPrefix(r, " DEX ") {
+			dexLines = append(dexLines, strings.Join(strings.Fields(r)[:4], " "))
+		}
+	}
+	if got := strings.Join(dexLines, ", "); got != "DEX test.dex version 041, DEX test.dex[1] version 041" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestReverseEndian(t *testing.T) {
+	read := func(bigEndian bool) []string {
+		b := versionTestDex("038")
+		b.bigEndian = bigEndian
+		cls := "Lcom/example/Lambda;"
+		add := b.method(cls, "add", b.proto("JIJ", "J", "I", "J"))
+		b.classes[0].virtualMethods = []testEncodedMethod{{add, uint32(dexapkvisit.AccPublic),
+			&testCode{registers: 4, ins: 4, insns: []uint16{0x0013, 0x1234, 0x0010}}}}
+		data := b.build()
+		visitor := &dexapktest.CaptureDexApkVisitOperations{}
+		err := ReadDEXWithOptions(nil, "test.dex", bytes.NewReader(data), uint64(len(data)),
+			visitor, Options{Integrity: IntegrityStrict})
+		if err != nil {
+			t.Fatalf("bigEndian=%v: ReadDEX error %v", bigEndian, err)
+		}
+		return visitor.Result[1:]
+	}
+	little, big := strings.Join(read(false), "\n"), strings.Join(read(true), "\n")
+	if little != big {
+		t.Errorf("reverse-endian DEX read as\n%s\nexpected\n%s", big, little)
+	}
+	if !strings.Contains(little, "sig 'long com.example.Lambda.add(int, long)'") {
+		t.Errorf("unexpected result %s", little)
+	}
+
+	// Anything else in the endian tag is rejected.
+	data := versionTestDex("").build()
+	copy(data[40:44], []byte{1, 2, 3, 4})
+	err := readTestDex(data, &dexapktest.CaptureDexApkVisitOperations{})
+	var tagErr *EndianTagError
+	if !errors.As(err, &tagErr) || tagErr.Tag != 0x04030201 {
+		t.Errorf("expected EndianTagError, got %v", err)
+	}
+}
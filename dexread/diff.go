@@ -0,0 +1,376 @@
+package dexread
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// CodeHash returns a hash of the method's code, for telling whether
+// two methods (typically from different builds) have the same body.
+// Constant pool references are hashed by what they refer to rather
+// than by index, and debug info is left out, so the hash does not
+// change when unrelated code is added, removed or moved. Instructions
+// are numbered rather than located by code offset, and branch, switch
+// and try targets are hashed relative to those numbers, so that a
+// const-string becoming a const-string/jumbo (when the string pool
+// grows past 65536 entries) or a goto becoming a goto/16 does not
+// change the hash either; a nop that only pads the payload after it
+// to 32-bit alignment is skipped. Abstract and native methods hash to
+// the zero value.
+func (m *Method) CodeHash() ([sha256.Size]byte, error) {
+	code, err := m.Code()
+	if err != nil || code == nil {
+		return [sha256.Size]byte{}, err
+	}
+	// padding reports whether instruction i is a nop that aligns
+	// the payload after it.
+	padding := func(i int) bool {
+		insn := &code.Insns[i]
+		return insn.Name == "nop" && insn.Offset%2 == 1 &&
+			i+1 < len(code.Insns) && code.Insns[i+1].Opcode > 0xff
+	}
+	// Number the instructions; a padding nop gets the number of the
+	// payload after it.
+	num := make(map[uint32]int, len(code.Insns)+1)
+	n := 0
+	for i := range code.Insns {
+		num[code.Insns[i].Offset] = n
+		if !padding(i) {
+			n++
+		}
+	}
+	num[code.InsnsSize] = n
+	// target describes the code offset 't' relative to instruction
+	// number 'from', or by offset if it is not an instruction.
+	target := func(from int, t uint32) string {
+		if to, ok := num[t]; ok {
+			return fmt.Sprintf("%+d", to-from)
+		}
+		return fmt.Sprintf("@%04x", t)
+	}
+	// origin maps a switch payload's offset to the number of the
+	// switch instruction that uses it.
+	origin := make(map[uint32]int)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "registers %d ins %d outs %d\n", code.RegistersSize, code.InsSize, code.OutsSize)
+	for i := range code.Insns {
+		insn := &code.Insns[i]
+		at := num[insn.Offset]
+		switch {
+		case padding(i):
+		case strings.HasSuffix(insn.Format, "t"):
+			name := insn.Name
+			if strings.HasPrefix(name, "goto") {
+				name = "goto"
+			}
+			if insn.Format == "31t" {
+				origin[insn.Target] = at
+			}
+			fmt.Fprintf(h, "%s %v %s\n", name, insn.Regs, target(at, insn.Target))
+		case insn.Targets != nil:
+			from := origin[insn.Offset]
+			fmt.Fprintf(h, "%s", insn.Name)
+			for j, key := range insn.Keys {
+				fmt.Fprintf(h, " %d: %s", key, target(from, insn.Targets[j]))
+			}
+			fmt.Fprintf(h, "\n")
+		case insn.Name == "const-string/jumbo":
+			fmt.Fprintf(h, "const-string %s\n", insn.Operands)
+		default:
+			fmt.Fprintf(h, "%s\n", insn.String())
+		}
+	}
+	for _, t := range code.Tries {
+		start := num[t.StartAddr]
+		fmt.Fprintf(h, "try %s %s", target(0, t.StartAddr), target(start, t.StartAddr+uint32(t.InsnCount)))
+		for _, c := range t.Handlers {
+			fmt.Fprintf(h, " %s%s", c.Type, target(0, c.Address))
+		}
+		fmt.Fprintf(h, "\n")
+	}
+	var retval [sha256.Size]byte
+	copy(retval[:], h.Sum(nil))
+	return retval, nil
+}
+
+// Change is the kind of a difference reported by DiffDexes.
+type Change uint8
+
+const (
+	Added Change = iota
+	Removed
+	Changed
+)
+
+func (c Change) String() string {
+	switch c {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Changed:
+		return "changed"
+	}
+	return fmt.Sprintf("Change(%d)", uint8(c))
+}
+
+// ClassDiff describes a class that was added, removed or changed.
+// For a changed class, Details lists the changes to the class itself
+// (e.g. "superclass java.lang.Object -> com.example.Base") and Fields
+// and Methods the changes to its members.
+type ClassDiff struct {
+	Descriptor string
+	Change     Change
+	Details    []string
+	Fields     []MemberDiff
+	Methods    []MemberDiff
+}
+
+// MemberDiff describes a field or method that was added, removed or
+// changed. Name is "name:type" for fields and "name(params)return"
+// for methods, both in descriptor form. For a changed member, Details
+// lists what changed: "flags 'x' -> 'y'", "value x -> y" (for static
+// field values) or "code".
+type MemberDiff struct {
+	Name    string
+	Change  Change
+	Details []string
+}
+
+// PackageDiff is the change in method and field counts (see
+// CountByPackage) and in size (see SizeByPackage) of a package.
+type PackageDiff struct {
+	Package  string
+	Old, New MemberCounts
+	OldSize  uint32
+	NewSize  uint32
+}
+
+// Diff is the structural difference between two sets of DEX files,
+// typically all those of two builds of an APK.
+type Diff struct {
+	Classes  []ClassDiff
+	Packages []PackageDiff
+}
+
+// DiffDexes compares the classes in 'oldDexes' and 'newDexes'. Classes are
+// matched by descriptor and members by name and type, so a class that
+// moves from one DEX file to another is not a change; if a class is
+// defined more than once, the first definition is used. Methods are
+// compared by CodeHash. Packages are truncated to 'depth' components
+// (no limit if depth is zero or less), and only those whose counts
+// or size differ are included, largest size change first.
+func DiffDexes(oldDexes, newDexes []*DexFile, depth int) (*Diff, error) {
+	oldh, newh := NewHierarchy(oldDexes), NewHierarchy(newDexes)
+	all := make(map[string]bool)
+	for desc := range oldh.classes {
+		all[desc] = true
+	}
+	for desc := range newh.classes {
+		all[desc] = true
+	}
+	diff := &Diff{}
+	for _, desc := range sortedKeys(all) {
+		oc, nc := oldh.classes[desc], newh.classes[desc]
+		switch {
+		case oc == nil:
+			diff.Classes = append(diff.Classes, ClassDiff{Descriptor: desc, Change: Added})
+		case nc == nil:
+			diff.Classes = append(diff.Classes, ClassDiff{Descriptor: desc, Change: Removed})
+		default:
+			cd, err := diffClass(oc, nc)
+			if err != nil {
+				return nil, err
+			}
+			if cd != nil {
+				diff.Classes = append(diff.Classes, *cd)
+			}
+		}
+	}
+
+	oldSizes, err := sizesByPackage(oldDexes, depth)
+	if err != nil {
+		return nil, err
+	}
+	newSizes, err := sizesByPackage(newDexes, depth)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make(map[string]*PackageDiff)
+	pkg := func(name string) *PackageDiff {
+		pd := pkgs[name]
+		if pd == nil {
+			pd = &PackageDiff{Package: name}
+			pkgs[name] = pd
+		}
+		return pd
+	}
+	for _, pc := range CountByPackage(oldDexes, depth, false) {
+		pkg(pc.Package).Old = pc.MemberCounts
+	}
+	for _, pc := range CountByPackage(newDexes, depth, false) {
+		pkg(pc.Package).New = pc.MemberCounts
+	}
+	for name, size := range oldSizes {
+		pkg(name).OldSize = size
+	}
+	for name, size := range newSizes {
+		pkg(name).NewSize = size
+	}
+	for _, pd := range pkgs {
+		if pd.Old != pd.New || pd.OldSize != pd.NewSize {
+			diff.Packages = append(diff.Packages, *pd)
+		}
+	}
+	sort.Slice(diff.Packages, func(i, j int) bool {
+		a, b := &diff.Packages[i], &diff.Packages[j]
+		da, db := sizeDelta(a), sizeDelta(b)
+		if da != db {
+			return da > db
+		}
+		return a.Package < b.Package
+	})
+	return diff, nil
+}
+
+// sizeDelta returns the magnitude of the change in size of a package.
+func sizeDelta(pd *PackageDiff) int64 {
+	d := int64(pd.NewSize) - int64(pd.OldSize)
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func sizesByPackage(dexes []*DexFile, depth int) (map[string]uint32, error) {
+	var reports []*SizeReport
+	for _, d := range dexes {
+		r, err := d.SizeReport()
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	sizes := make(map[string]uint32)
+	for _, ps := range SizeByPackage(reports, depth) {
+		sizes[ps.Package] = ps.Size
+	}
+	return sizes, nil
+}
+
+// diffClass compares two definitions of a class, returning nil if
+// they are the same.
+func diffClass(oc, nc *Class) (*ClassDiff, error) {
+	cd := &ClassDiff{Descriptor: oc.Descriptor, Change: Changed}
+	if oc.AccessFlags != nc.AccessFlags {
+		cd.Details = append(cd.Details, fmt.Sprintf("flags '%s' -> '%s'",
+			oc.AccessFlags.ClassString(), nc.AccessFlags.ClassString()))
+	}
+	if oc.Superclass != nc.Superclass {
+		cd.Details = append(cd.Details, fmt.Sprintf("superclass %s -> %s",
+			JavaName(oc.Superclass), JavaName(nc.Superclass)))
+	}
+	if strings.Join(oc.Interfaces, ",") != strings.Join(nc.Interfaces, ",") {
+		cd.Details = append(cd.Details, fmt.Sprintf("interfaces [%s] -> [%s]",
+			javaNames(oc.Interfaces), javaNames(nc.Interfaces)))
+	}
+
+	oldFields, newFields := make(map[string]*Field), make(map[string]*Field)
+	names := make(map[string]bool)
+	for _, f := range oc.fields {
+		oldFields[f.Id.Name+":"+f.Id.Type] = f
+		names[f.Id.Name+":"+f.Id.Type] = true
+	}
+	for _, f := range nc.fields {
+		newFields[f.Id.Name+":"+f.Id.Type] = f
+		names[f.Id.Name+":"+f.Id.Type] = true
+	}
+	for _, name := range sortedKeys(names) {
+		of, nf := oldFields[name], newFields[name]
+		md := MemberDiff{Name: name, Change: Changed}
+		switch {
+		case of == nil:
+			md.Change = Added
+		case nf == nil:
+			md.Change = Removed
+		default:
+			if of.AccessFlags != nf.AccessFlags {
+				md.Details = append(md.Details, fmt.Sprintf("flags '%s' -> '%s'",
+					of.AccessFlags.FieldString(), nf.AccessFlags.FieldString()))
+			}
+			if ov, nv := valueString(of.Value), valueString(nf.Value); ov != nv {
+				md.Details = append(md.Details, fmt.Sprintf("value %s -> %s", ov, nv))
+			}
+			if md.Details == nil {
+				continue
+			}
+		}
+		cd.Fields = append(cd.Fields, md)
+	}
+
+	oldMethods, newMethods := make(map[string]*Method), make(map[string]*Method)
+	names = make(map[string]bool)
+	for _, m := range oc.methods {
+		oldMethods[m.Id.Name+m.Id.Proto.Descriptor()] = m
+		names[m.Id.Name+m.Id.Proto.Descriptor()] = true
+	}
+	for _, m := range nc.methods {
+		newMethods[m.Id.Name+m.Id.Proto.Descriptor()] = m
+		names[m.Id.Name+m.Id.Proto.Descriptor()] = true
+	}
+	for _, name := range sortedKeys(names) {
+		om, nm := oldMethods[name], newMethods[name]
+		md := MemberDiff{Name: name, Change: Changed}
+		switch {
+		case om == nil:
+			md.Change = Added
+		case nm == nil:
+			md.Change = Removed
+		default:
+			if om.AccessFlags != nm.AccessFlags {
+				md.Details = append(md.Details, fmt.Sprintf("flags '%s' -> '%s'",
+					om.AccessFlags.MethodString(), nm.AccessFlags.MethodString()))
+			}
+			oh, err := om.CodeHash()
+			if err != nil {
+				return nil, err
+			}
+			nh, err := nm.CodeHash()
+			if err != nil {
+				return nil, err
+			}
+			if oh != nh {
+				md.Details = append(md.Details, "code")
+			}
+			if md.Details == nil {
+				continue
+			}
+		}
+		cd.Methods = append(cd.Methods, md)
+	}
+
+	if cd.Details == nil && cd.Fields == nil && cd.Methods == nil {
+		return nil, nil
+	}
+	return cd, nil
+}
+
+func javaNames(descs []string) string {
+	names := make([]string, len(descs))
+	for i, d := range descs {
+		names[i] = JavaName(d)
+	}
+	return strings.Join(names, ", ")
+}
+
+func valueString(v *dexapkvisit.EncodedValue) string {
+	if v == nil {
+		return "none"
+	}
+	return v.String()
+}
@@ -0,0 +1,202 @@
+package dexread
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+func TestDiffDexes(t *testing.T) {
+	const (
+		a = "Lcom/example/A;"
+		b = "Lcom/example/B;"
+		c = "Lcom/example/C;"
+	)
+	public := uint32(dexapkvisit.AccPublic)
+	private := uint32(dexapkvisit.AccPrivate)
+	returnVoid := uint16(0x000e)
+
+	parse := func(b *dexBuilder) *DexFile {
+		data := b.build()
+		d, err := Parse(nil, "test.dex", bytes.NewReader(data), uint64(len(data)))
+		if err != nil {
+			t.Fatalf("Parse error %v", err)
+		}
+		return d
+	}
+
+	ob := newDexBuilder()
+	p := ob.proto("V", "V")
+	greeting := ob.str("hello")
+	ob.class(testClass{typ: a, flags: public, super: "Ljava/lang/Object;",
+		instanceFields: []testEncodedField{{ob.field(a, "I", "count"), private}},
+		virtualMethods: []testEncodedMethod{
+			{ob.method(a, "greet", p), public, &testCode{registers: 1,
+				insns: []uint16{0x001a, uint16(greeting), returnVoid}}},
+			{ob.method(a, "one", p), public, &testCode{registers: 1,
+				insns: []uint16{0x1012, returnVoid}}},
+			{ob.method(a, "gone", p), public, &testCode{insns: []uint16{returnVoid}}},
+		}})
+	ob.class(testClass{typ: c, flags: public, super: "Ljava/lang/Object;"})
+	oldDex := parse(ob)
+
+	// The new build has more strings, so "hello" has a different
+	// index, but greet() should still be unchanged.
+	nb := newDexBuilder()
+	nb.str("aaa")
+	nb.str("bbb")
+	p = nb.proto("V", "V")
+	greeting = nb.str("hello")
+	nb.class(testClass{typ: b, flags: public, super: "Ljava/lang/Object;",
+		virtualMethods: []testEncodedMethod{
+			{nb.method(b, "run", p), public, &testCode{insns: []uint16{returnVoid}}},
+		}})
+	nb.class(testClass{typ: a, flags: public, super: b,
+		instanceFields: []testEncodedField{{nb.field(a, "I", "count"), public}},
+		virtualMethods: []testEncodedMethod{
+			{nb.method(a, "greet", p), public, &testCode{registers: 1,
+				insns: []uint16{0x001a, uint16(greeting), returnVoid}}},
+			{nb.method(a, "one", p), public, &testCode{registers: 1,
+				insns: []uint16{0x2012, returnVoid}}},
+			{nb.method(a, "added", p), public, &testCode{insns: []uint16{returnVoid}}},
+		}})
+	newDex := parse(nb)
+
+	greetOld, greetNew := oldDex.ClassByName(a).Methods()[0], newDex.ClassByName(a).Methods()[0]
+	if greetOld.CodeOffset == greetNew.CodeOffset {
+		t.Errorf("expected greet() to move")
+	}
+	oh, err := greetOld.CodeHash()
+	if err != nil {
+		t.Fatalf("CodeHash error %v", err)
+	}
+	if nh, _ := greetNew.CodeHash(); oh != nh {
+		t.Errorf("greet() hashes differ")
+	}
+
+	diff, err := DiffDexes([]*DexFile{oldDex}, []*DexFile{newDex}, 0)
+	if err != nil {
+		t.Fatalf("DiffDexes error %v", err)
+	}
+	var lines []string
+	for _, cd := range diff.Classes {
+		lines = append(lines, strings.TrimSpace(fmt.Sprintf("%s %s %s", cd.Change, cd.Descriptor, strings.Join(cd.Details, "; "))))
+		for _, md := range append(cd.Fields, cd.Methods...) {
+			lines = append(lines, strings.TrimRight(fmt.Sprintf(" %s %s %s", md.Change, md.Name, strings.Join(md.Details, "; ")), " "))
+		}
+	}
+	for _, pd := range diff.Packages {
+		lines = append(lines, fmt.Sprintf("package %q methods %d -> %d size %d -> %d",
+			pd.Package, pd.Old.Methods, pd.New.Methods, pd.OldSize, pd.NewSize))
+	}
+	actual := strings.Join(lines, "\n")
+	expected := `changed Lcom/example/A; superclass java.lang.Object -> com.example.B
+ changed count:I flags 'private' -> 'public'
+ added added()V
+ removed gone()V
+ changed one()V code
+added Lcom/example/B;
+removed Lcom/example/C;
+package "com.example" methods 3 -> 4 size 180 -> 212`
+	if actual != expected {
+		t.Errorf("got\n%s\nexpected\n%s", actual, expected)
+	}
+
+	diff, err = DiffDexes([]*DexFile{oldDex}, []*DexFile{oldDex}, 0)
+	if err != nil || len(diff.Classes) != 0 || len(diff.Packages) != 0 {
+		t.Errorf("diff of identical DEX files: %+v %v", diff, err)
+	}
+}
+
+func TestCodeHashNormalizes(t *testing.T) {
+	const a = "Lcom/example/A;"
+	hash := func(insns []uint16) [32]byte {
+		b := newDexBuilder()
+		p := b.proto("V", "V")
+		s := b.str("hello")
+		for i, u := range insns {
+			if u == 0xffff {
+				insns[i] = uint16(s)
+			}
+		}
+		b.class(testClass{typ: a, flags: uint32(dexapkvisit.AccPublic), super: "Ljava/lang/Object;",
+			directMethods: []testEncodedMethod{
+				{b.method(a, "f", p), uint32(dexapkvisit.AccStatic), &testCode{registers: 1, insns: insns}},
+			}})
+		data := b.build()
+		d, err := Parse(nil, "test.dex", bytes.NewReader(data), uint64(len(data)))
+		if err != nil {
+			t.Fatalf("Parse error %v", err)
+		}
+		h, err := d.ClassByName(a).Methods()[0].CodeHash()
+		if err != nil {
+			t.Fatalf("CodeHash error %v", err)
+		}
+		return h
+	}
+
+	// const-string, a packed-switch and an if-eqz, with the switch
+	// payload padded by a nop.
+	old := hash([]uint16{
+		0x001a, 0xffff, // 0: const-string v0, "hello"
+		0x002b, 8, 0, // 2: packed-switch v0, 000a
+		0x0038, 3, // 5: if-eqz v0, 0008
+		0x000e,                // 7: return-void
+		0x000e,                // 8: return-void
+		0x0000,                // 9: nop
+		0x0100, 1, 0, 0, 5, 0, // 10: 0: 0007
+	})
+	// The same with const-string/jumbo, which moves everything along
+	// one code unit and so does without the nop.
+	jumbo := hash([]uint16{
+		0x001b, 0xffff, 0, // 0: const-string/jumbo v0, "hello"
+		0x002b, 7, 0, // 3: packed-switch v0, 000a
+		0x0038, 3, // 6: if-eqz v0, 0009
+		0x000e,                // 8: return-void
+		0x000e,                // 9: return-void
+		0x0100, 1, 0, 0, 5, 0, // 10: 0: 0008
+	})
+	if old != jumbo {
+		t.Errorf("const-string/jumbo changed the hash")
+	}
+	// A different branch target should change it.
+	other := hash([]uint16{
+		0x001b, 0xffff, 0,
+		0x002b, 7, 0,
+		0x0038, 2, // 6: if-eqz v0, 0008
+		0x000e,
+		0x000e,
+		0x0100, 1, 0, 0, 5, 0,
+	})
+	if other == jumbo {
+		t.Errorf("changing a branch target did not change the hash")
+	}
+	// So should a different switch target.
+	other = hash([]uint16{
+		0x001b, 0xffff, 0,
+		0x002b, 7, 0,
+		0x0038, 3,
+		0x000e,
+		0x000e,
+		0x0100, 1, 0, 0, 6, 0, // 10: 0: 0009
+	})
+	if other == jumbo {
+		t.Errorf("changing a switch target did not change the hash")
+	}
+	// And so should moving the nop to where it is not padding.
+	other = hash([]uint16{
+		0x001a, 0xffff,
+		0x002b, 8, 0,
+		0x0038, 4, // 5: if-eqz v0, 0009
+		0x0000,                // 7: nop
+		0x000e,                // 8: return-void
+		0x000e,                // 9: return-void
+		0x0100, 1, 0, 0, 6, 0, // 10: 0: 0008
+	})
+	if other == old {
+		t.Errorf("adding a nop did not change the hash")
+	}
+}
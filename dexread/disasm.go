@@ -0,0 +1,427 @@
+package dexread
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// The disassembler needs to turn constant pool indices into something
+// readable; dexState implements this interface, and unit tests can
+// supply something simpler.
+type poolResolver interface {
+	resolveIndex(kind dexapkvisit.IndexKind, idx uint32) string
+}
+
+func unpackCodeItem(state *dexState, off uint32) (*dexapkvisit.MethodCode, error) {
+	if err := seekReader(state, off); err != nil {
+		return nil, err
+	}
+	var hdr dexCodeItemHeader
+	if err := binary.Read(state.rdr, state.order, &hdr); err != nil {
+		return nil, mkError(state, "unable to unpack code item at offset %d: %v", off, err)
+	}
+	if uint64(hdr.InsnsSize)*2 > uint64(state.rdr.Len()) {
+		return nil, mkError(state, "code item at offset %d: insns_size %d exceeds file size", off, hdr.InsnsSize)
+	}
+	insns := make([]uint16, hdr.InsnsSize)
+	if err := binary.Read(state.rdr, state.order, insns); err != nil {
+		return nil, mkError(state, "unable to read insns for code item at offset %d: %v", off, err)
+	}
+	decoded, err := decodeInsns(state, state.version, insns)
+	if err != nil {
+		return nil, mkError(state, "code item at offset %d: %v", off, err)
+	}
+	code := &dexapkvisit.MethodCode{
+		RegistersSize: hdr.RegistersSize,
+		InsSize:       hdr.InsSize,
+		OutsSize:      hdr.OutsSize,
+		DebugInfoOff:  hdr.DebugInfoOff,
+		InsnsSize:     hdr.InsnsSize,
+		Insns:         decoded,
+	}
+	if hdr.TriesSize == 0 {
+		return code, nil
+	}
+
+	// Tries are 4-byte aligned, hence the padding if insns_size is odd.
+	triesOff := off + dexCodeItemHeaderSize + hdr.InsnsSize*2
+	if hdr.InsnsSize%2 != 0 {
+		triesOff += 2
+	}
+	if err := seekReader(state, triesOff); err != nil {
+		return nil, err
+	}
+	tries := make([]dexTryItem, hdr.TriesSize)
+	if err := binary.Read(state.rdr, state.order, tries); err != nil {
+		return nil, mkError(state, "unable to read tries for code item at offset %d: %v", off, err)
+	}
+	handlersOff := triesOff + uint32(hdr.TriesSize)*dexTryItemSize
+	for _, t := range tries {
+		handlers, err := unpackCatchHandler(state, handlersOff+uint32(t.HandlerOff))
+		if err != nil {
+			return nil, err
+		}
+		code.Tries = append(code.Tries, dexapkvisit.TryItem{
+			StartAddr: t.StartAddr,
+			InsnCount: t.InsnCount,
+			Handlers:  handlers,
+		})
+	}
+	return code, nil
+}
+
+// unpackCatchHandler decodes the encoded_catch_handler at 'off'.
+func unpackCatchHandler(state *dexState, off uint32) ([]dexapkvisit.CatchHandler, error) {
+	content := state.b.Bytes()
+	if uint64(off) >= uint64(len(content)) {
+		return nil, mkError(state, "catch handler offset %d out of range", off)
+	}
+	helper := ulebHelper{content[off:]}
+
+	// A non-positive size means there is a catch-all at the end.
+	size := helper.grabSLEB128()
+	n := size
+	if n < 0 {
+		n = -n
+	}
+	var handlers []dexapkvisit.CatchHandler
+	for i := int64(0); i < n; i++ {
+		typeIdx := uint32(helper.grabULEB128())
+		addr := uint32(helper.grabULEB128())
+		handlers = append(handlers, dexapkvisit.CatchHandler{
+			Type:    state.resolveIndex(dexapkvisit.IndexType, typeIdx),
+			Address: addr,
+		})
+	}
+	if size <= 0 {
+		addr := uint32(helper.grabULEB128())
+		handlers = append(handlers, dexapkvisit.CatchHandler{Address: addr})
+	}
+	return handlers, nil
+}
+
+func (state *dexState) resolveIndex(kind dexapkvisit.IndexKind, idx uint32) string {
+	switch kind {
+	case dexapkvisit.IndexString:
+		if idx < uint32(len(state.strings)) {
+			return strconv.Quote(state.strings[idx])
+		}
+	case dexapkvisit.IndexType:
+		if idx < uint32(len(state.typeIds)) {
+			return state.typeDescriptor(idx)
+		}
+	case dexapkvisit.IndexField:
+		if idx < uint32(len(state.fieldIds)) {
+			f := state.fieldId(idx)
+			return fmt.Sprintf("%s->%s:%s", f.Class, f.Name, f.Type)
+		}
+	case dexapkvisit.IndexMethod:
+		if idx < uint32(len(state.methodIds)) {
+			m := state.methodId(idx)
+			return fmt.Sprintf("%s->%s%s", m.Class, m.Name, m.Proto.Descriptor())
+		}
+	case dexapkvisit.IndexProto:
+		if idx < uint32(len(state.protoIds)) {
+			p := state.proto(idx)
+			return p.Descriptor()
+		}
+	case dexapkvisit.IndexMethodHandle:
+		if idx < uint32(len(state.methodHandles)) {
+			return state.methodHandle(idx)
+		}
+	case dexapkvisit.IndexCallSite:
+		if idx < uint32(len(state.callSiteOffs)) {
+			if s, ok := state.callSite(idx); ok {
+				return s
+			}
+		}
+	}
+	return fmt.Sprintf("%s@%d", kind, idx)
+}
+
+// decodeInsns disassembles the instruction array of a code_item from a
+// DEX file of the given version; opcodes introduced by later versions
+// are rejected.
+func decodeInsns(r poolResolver, version int, insns []uint16) ([]dexapkvisit.Instruction, error) {
+	var result []dexapkvisit.Instruction
+
+	// Switch targets are relative to the switch instruction, not to
+	// the payload, so remember where each payload is referenced from.
+	payloadOrigin := make(map[uint32]uint32)
+
+	n := uint32(len(insns))
+	for pc := uint32(0); pc < n; {
+		u := insns[pc]
+		op := u & 0xff
+		if op == 0 && u != 0 {
+			insn, err := decodePayload(insns, pc, payloadOrigin)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, insn)
+			pc += insn.Size
+			continue
+		}
+		info := &opcodes[op]
+		if info.format == fmtUnused {
+			return nil, fmt.Errorf("unused opcode 0x%02x at %04x", op, pc)
+		}
+		if v := opcodeVersion(op); version < v {
+			return nil, fmt.Errorf("%s instruction at %04x requires DEX version %03d", info.name, pc, v)
+		}
+		size := info.format.size()
+		if pc+size > n {
+			return nil, fmt.Errorf("truncated %s instruction at %04x", info.name, pc)
+		}
+		insn := decodeInsn(r, insns[pc:pc+size], pc, info)
+		insn.Offset = pc
+		insn.Size = size
+		insn.Opcode = op
+		insn.Name = info.name
+		insn.Format = info.format.String()
+		if op == 0x26 || op == 0x2b || op == 0x2c {
+			payloadOrigin[insn.Target] = pc
+		}
+		result = append(result, insn)
+		pc += size
+	}
+	return result, nil
+}
+
+func vreg(r uint32) string {
+	return fmt.Sprintf("v%d", r)
+}
+
+func branchTarget(pc uint32, rel int32) (uint32, string) {
+	target := uint32(int64(pc) + int64(rel))
+	return target, fmt.Sprintf("%04x // %+05x", target, rel)
+}
+
+func decodeInsn(r poolResolver, u []uint16, pc uint32, info *opInfo) dexapkvisit.Instruction {
+	var insn dexapkvisit.Instruction
+	a4 := uint32(u[0]>>8) & 0xf
+	b4 := uint32(u[0] >> 12)
+	aa := uint32(u[0] >> 8)
+	ref := func(idx uint32) string {
+		insn.Kind = info.kind
+		insn.Index = idx
+		return r.resolveIndex(info.kind, idx)
+	}
+	u32 := func(i int) uint32 {
+		return uint32(u[i]) | uint32(u[i+1])<<16
+	}
+
+	var ops []string
+	switch info.format {
+	case fmt10x:
+	case fmt12x:
+		insn.Regs = []uint32{a4, b4}
+	case fmt11n:
+		insn.Regs = []uint32{a4}
+		insn.Literal = int64(int8(u[0]>>8) >> 4)
+	case fmt11x:
+		insn.Regs = []uint32{aa}
+	case fmt10t:
+		insn.Target, ops = appendTarget(ops, pc, int32(int8(aa)))
+	case fmt20t:
+		insn.Target, ops = appendTarget(ops, pc, int32(int16(u[1])))
+	case fmt30t:
+		insn.Target, ops = appendTarget(ops, pc, int32(u32(1)))
+	case fmt22x:
+		insn.Regs = []uint32{aa, uint32(u[1])}
+	case fmt32x:
+		insn.Regs = []uint32{uint32(u[1]), uint32(u[2])}
+	case fmt21t:
+		insn.Regs = []uint32{aa}
+		insn.Target, ops = appendTarget(ops, pc, int32(int16(u[1])))
+	case fmt21s:
+		insn.Regs = []uint32{aa}
+		insn.Literal = int64(int16(u[1]))
+	case fmt21h:
+		insn.Regs = []uint32{aa}
+		if info.name == "const-wide/high16" {
+			insn.Literal = int64(u[1]) << 48
+		} else {
+			insn.Literal = int64(int32(uint32(u[1]) << 16))
+		}
+	case fmt21c:
+		insn.Regs = []uint32{aa}
+		ops = append(ops, ref(uint32(u[1])))
+	case fmt31c:
+		insn.Regs = []uint32{aa}
+		ops = append(ops, ref(u32(1)))
+	case fmt23x:
+		insn.Regs = []uint32{aa, uint32(u[1] & 0xff), uint32(u[1] >> 8)}
+	case fmt22b:
+		insn.Regs = []uint32{aa, uint32(u[1] & 0xff)}
+		insn.Literal = int64(int8(u[1] >> 8))
+	case fmt22t:
+		insn.Regs = []uint32{a4, b4}
+		insn.Target, ops = appendTarget(ops, pc, int32(int16(u[1])))
+	case fmt22s:
+		insn.Regs = []uint32{a4, b4}
+		insn.Literal = int64(int16(u[1]))
+	case fmt22c:
+		insn.Regs = []uint32{a4, b4}
+		ops = append(ops, ref(uint32(u[1])))
+	case fmt31i:
+		insn.Regs = []uint32{aa}
+		insn.Literal = int64(int32(u32(1)))
+	case fmt31t:
+		insn.Regs = []uint32{aa}
+		insn.Target, ops = appendTarget(ops, pc, int32(u32(1)))
+	case fmt51l:
+		insn.Regs = []uint32{aa}
+		insn.Literal = int64(uint64(u32(1)) | uint64(u32(3))<<32)
+	case fmt35c, fmt45cc:
+		count := b4
+		all := []uint32{uint32(u[2]) & 0xf, uint32(u[2]>>4) & 0xf,
+			uint32(u[2]>>8) & 0xf, uint32(u[2] >> 12), a4}
+		if count > 5 {
+			count = 5
+		}
+		insn.Regs = all[:count]
+		ops = append(ops, regList(insn.Regs), ref(uint32(u[1])))
+		if info.format == fmt45cc {
+			insn.Proto = uint32(u[3])
+			ops = append(ops, r.resolveIndex(dexapkvisit.IndexProto, insn.Proto))
+		}
+	case fmt3rc, fmt4rcc:
+		first := uint32(u[2])
+		for i := uint32(0); i < aa; i++ {
+			insn.Regs = append(insn.Regs, first+i)
+		}
+		rng := "{}"
+		if aa != 0 {
+			rng = fmt.Sprintf("{v%d .. v%d}", first, first+aa-1)
+		}
+		ops = append(ops, rng, ref(uint32(u[1])))
+		if info.format == fmt4rcc {
+			insn.Proto = uint32(u[3])
+			ops = append(ops, r.resolveIndex(dexapkvisit.IndexProto, insn.Proto))
+		}
+	}
+
+	// Registers come first in the operand text (except for the
+	// invoke-style register lists, which were rendered above),
+	// followed by any reference or branch target, then any literal.
+	var regs []string
+	if info.format != fmt35c && info.format != fmt45cc &&
+		info.format != fmt3rc && info.format != fmt4rcc {
+		for _, reg := range insn.Regs {
+			regs = append(regs, vreg(reg))
+		}
+	}
+	ops = append(regs, ops...)
+	switch info.format {
+	case fmt11n, fmt21s, fmt21h, fmt22b, fmt22s, fmt31i, fmt51l:
+		ops = append(ops, fmt.Sprintf("#%d", insn.Literal))
+	}
+	insn.Operands = strings.Join(ops, ", ")
+	return insn
+}
+
+func appendTarget(ops []string, pc uint32, rel int32) (uint32, []string) {
+	target, s := branchTarget(pc, rel)
+	return target, append(ops, s)
+}
+
+func regList(regs []uint32) string {
+	var s []string
+	for _, r := range regs {
+		s = append(s, vreg(r))
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+// decodePayload decodes one of the packed-switch, sparse-switch or
+// fill-array-data payloads at 'pc'.
+func decodePayload(insns []uint16, pc uint32, payloadOrigin map[uint32]uint32) (dexapkvisit.Instruction, error) {
+	insn := dexapkvisit.Instruction{Offset: pc, Opcode: insns[pc]}
+	n := uint64(len(insns))
+	u32 := func(i uint32) uint32 {
+		return uint32(insns[pc+i]) | uint32(insns[pc+i+1])<<16
+	}
+	if uint64(pc)+2 > n {
+		return insn, fmt.Errorf("truncated payload at %04x", pc)
+	}
+	origin, haveOrigin := payloadOrigin[pc]
+	target := func(key, rel int32) string {
+		insn.Keys = append(insn.Keys, key)
+		if !haveOrigin {
+			return fmt.Sprintf("%+05x", rel)
+		}
+		t, _ := branchTarget(origin, rel)
+		insn.Targets = append(insn.Targets, t)
+		return fmt.Sprintf("%04x", t)
+	}
+
+	var ops []string
+	switch insns[pc] {
+	case packedSwitchPayload:
+		insn.Name = "packed-switch-payload"
+		size := uint32(insns[pc+1])
+		insn.Size = size*2 + 4
+		if uint64(pc)+uint64(insn.Size) > n {
+			return insn, fmt.Errorf("truncated %s at %04x", insn.Name, pc)
+		}
+		firstKey := int32(u32(2))
+		for i := uint32(0); i < size; i++ {
+			key := firstKey + int32(i)
+			ops = append(ops, fmt.Sprintf("%d: %s", key, target(key, int32(u32(4+i*2)))))
+		}
+	case sparseSwitchPayload:
+		insn.Name = "sparse-switch-payload"
+		size := uint32(insns[pc+1])
+		insn.Size = size*4 + 2
+		if uint64(pc)+uint64(insn.Size) > n {
+			return insn, fmt.Errorf("truncated %s at %04x", insn.Name, pc)
+		}
+		for i := uint32(0); i < size; i++ {
+			key := int32(u32(2 + i*2))
+			rel := int32(u32(2 + size*2 + i*2))
+			ops = append(ops, fmt.Sprintf("%d: %s", key, target(key, rel)))
+		}
+	case fillArrayDataPayload:
+		insn.Name = "fill-array-data-payload"
+		if uint64(pc)+4 > n {
+			return insn, fmt.Errorf("truncated %s at %04x", insn.Name, pc)
+		}
+		width := uint64(insns[pc+1])
+		if width != 1 && width != 2 && width != 4 && width != 8 {
+			return insn, fmt.Errorf("bad element width %d in %s at %04x", width, insn.Name, pc)
+		}
+		size := uint64(u32(2))
+		nbytes := width * size
+		insn.Size = uint32((nbytes+1)/2 + 4)
+		if uint64(pc)+(nbytes+1)/2+4 > n {
+			return insn, fmt.Errorf("truncated %s at %04x", insn.Name, pc)
+		}
+		data := make([]byte, 0, nbytes+1)
+		for i := uint32(0); i < uint32((nbytes+1)/2); i++ {
+			v := insns[pc+4+i]
+			data = append(data, byte(v), byte(v>>8))
+		}
+		for i := uint64(0); i < size; i++ {
+			elem := data[i*width : (i+1)*width]
+			switch width {
+			case 1:
+				ops = append(ops, fmt.Sprintf("%d", int8(elem[0])))
+			case 2:
+				ops = append(ops, fmt.Sprintf("%d", int16(binary.LittleEndian.Uint16(elem))))
+			case 4:
+				ops = append(ops, fmt.Sprintf("%d", int32(binary.LittleEndian.Uint32(elem))))
+			case 8:
+				ops = append(ops, fmt.Sprintf("%d", int64(binary.LittleEndian.Uint64(elem))))
+			}
+		}
+	default:
+		return insn, fmt.Errorf("unknown payload ident 0x%04x at %04x", insns[pc], pc)
+	}
+	insn.Operands = "{" + strings.Join(ops, ", ") + "}"
+	return insn, nil
+}
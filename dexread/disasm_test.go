@@ -0,0 +1,197 @@
+package dexread
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/dexapktest"
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// Captures the disassembly of every method visited.
+type disasmVisitor struct {
+	dexapktest.CaptureDexApkVisitOperations
+	listings map[string][]string
+}
+
+func (d *disasmVisitor) VisitMethod(method *dexapkvisit.MethodId, methodIdx uint64, accessFlags dexapkvisit.AccessFlags, codeOffset uint64, code *dexapkvisit.MethodCode) {
+	var lines []string
+	for i := range code.Insns {
+		lines = append(lines, fmt.Sprintf("%04x: %s", code.Insns[i].Offset, code.Insns[i].String()))
+	}
+	for _, t := range code.Tries {
+		for _, h := range t.Handlers {
+			lines = append(lines, fmt.Sprintf("try %04x+%d catch %s -> %04x",
+				t.StartAddr, t.InsnCount, h.Type, h.Address))
+		}
+	}
+	d.listings[method.Name] = lines
+}
+
+func TestDisassembleSmallDex(t *testing.T) {
+	visitor := &disasmVisitor{listings: make(map[string][]string)}
+	if err := ReadDEXFile("testdata/classes.dex", visitor); err != nil {
+		t.Fatalf("ReadDEXFile error %v", err)
+	}
+
+	expected := []string{
+		"0000: if-eqz v2, 0005 // +0005",
+		"0002: const/4 v0, #1",
+		"0003: if-ne v2, v0, 0006 // +0003",
+		"0005: return v2",
+		"0006: invoke-static {v2}, Lfibonacci;->rcnm1(I)I",
+		"0009: move-result v0",
+		"000a: invoke-static {v2}, Lfibonacci;->rcnm2(I)I",
+		"000d: move-result v1",
+		"000e: add-int v2, v0, v1",
+		"0010: goto 0005 // -000b",
+	}
+	actual := visitor.listings["rfibonacci"]
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("rfibonacci: got\n%s\nexpected\n%s",
+			strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+
+	main := visitor.listings["main"]
+	checks := []string{
+		"001c: sget-object v7, Ljava/lang/System;->out:Ljava/io/PrintStream;",
+		"001e: const-string v8, \"rfibonacci(%d)=%d\\n\"",
+		"0021: new-array v9, v9, [Ljava/lang/Object;",
+		"0031: invoke-virtual {v7, v8, v9}, Ljava/io/PrintStream;->printf(Ljava/lang/String;[Ljava/lang/Object;)Ljava/io/PrintStream;",
+		"0095: move-exception v2",
+		"try 000c+128 catch Ljava/lang/NumberFormatException; -> 0095",
+	}
+	for _, c := range checks {
+		found := false
+		for _, l := range main {
+			if l == c {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("main: missing line '%s'", c)
+		}
+	}
+}
+
+// Resolves everything to "kind@idx".
+type fakeResolver struct{}
+
+func (fakeResolver) resolveIndex(kind dexapkvisit.IndexKind, idx uint32) string {
+	return fmt.Sprintf("%s@%d", kind, idx)
+}
+
+func TestDecodeInsns(t *testing.T) {
+	insns := []uint16{
+		0x0118, 0x0001, 0x0000, 0x0000, 0x8000, // const-wide v1, #...
+		0x1015, 0xffff, // const/high16 v16, #-65536
+		0x2319, 0x0001, // const-wide/high16 v35, #281474976710656
+		0x5571, 0x0007, 0x4321, // invoke-static {v1, v2, v3, v4, v5}, method@7
+		0x0376, 0x0009, 0x000a, // invoke-direct/range {v10 .. v12}, method@9
+		0x30fa, 0x0002, 0x0321, 0x0004, // invoke-polymorphic {v1, v2, v3}, method@2, proto@4
+		0x0ffc, 0x0003, 0x0000, // invoke-custom {}, call_site@3
+		0x052b, 0x0004, 0x0000, // packed-switch v5, 0019
+		0x0000,                                                         // nop
+		0x0100, 0x0002, 0x000a, 0x0000, 0x0002, 0x0000, 0xfffe, 0xffff, // packed-switch-payload
+		0x0300, 0x0002, 0x0003, 0x0000, 0x0001, 0xfffe, 0x0003, // fill-array-data-payload
+	}
+	expected := []string{
+		"0000: const-wide v1, #-9223372036854775807",
+		"0005: const/high16 v16, #-65536",
+		"0007: const-wide/high16 v35, #281474976710656",
+		"0009: invoke-static {v1, v2, v3, v4, v5}, method@7",
+		"000c: invoke-direct/range {v10 .. v12}, method@9",
+		"000f: invoke-polymorphic {v1, v2, v3}, method@2, proto@4",
+		"0013: invoke-custom {}, call_site@3",
+		"0016: packed-switch v5, 001a // +0004",
+		"0019: nop",
+		"001a: packed-switch-payload {10: 0018, 11: 0014}",
+		"0022: fill-array-data-payload {1, -2, 3}",
+	}
+	decoded, err := decodeInsns(fakeResolver{}, DexVersion039, insns)
+	if err != nil {
+		t.Fatalf("decodeInsns error %v", err)
+	}
+	var actual []string
+	for i := range decoded {
+		actual = append(actual, fmt.Sprintf("%04x: %s", decoded[i].Offset, decoded[i].String()))
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got\n%s\nexpected\n%s",
+			strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+	if decoded[3].Kind != dexapkvisit.IndexMethod || decoded[3].Index != 7 {
+		t.Errorf("invoke-static: got kind %s index %d", decoded[3].Kind, decoded[3].Index)
+	}
+	if p := decoded[9]; fmt.Sprint(p.Keys, p.Targets) != "[10 11] [24 20]" {
+		t.Errorf("packed-switch-payload: got keys %v targets %v", p.Keys, p.Targets)
+	}
+
+	bad := [][]uint16{
+		{0x003e},                         // unused opcode
+		{0x0014, 0x0000},                 // truncated const
+		{0x0100, 0x0004},                 // truncated payload
+		{0x0300, 0x0000, 0xffff, 0xffff}, // zero element width
+		{0x0300, 0x0003, 0x0001, 0x0000, 0x0000, 0x0000}, // element width 3
+	}
+	for _, b := range bad {
+		if _, err := decodeInsns(fakeResolver{}, DexVersion039, b); err == nil {
+			t.Errorf("decodeInsns(%x): expected error", b)
+		}
+	}
+
+	// invoke-polymorphic and invoke-custom need version 038.
+	_, err = decodeInsns(fakeResolver{}, DexVersion037, insns)
+	if err == nil || err.Error() != "invoke-polymorphic instruction at 000f requires DEX version 038" {
+		t.Errorf("decodeInsns version 037: got %v", err)
+	}
+}
+
+func TestCallSitesAndMethodHandles(t *testing.T) {
+	const (
+		cls    = "Lcom/example/Indy;"
+		bsmSig = "(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite;"
+	)
+	b := newDexBuilder()
+	b.version = "039"
+	static := uint32(dexapkvisit.AccPublic | dexapkvisit.AccStatic)
+	bsm := b.method(cls, "bsm", b.proto("LLLL", "Ljava/lang/invoke/CallSite;",
+		"Ljava/lang/invoke/MethodHandles$Lookup;", "Ljava/lang/String;", "Ljava/lang/invoke/MethodType;"))
+	run := b.method(cls, "run", b.proto("V", "V"))
+	apply := b.proto("I", "I")
+	b.methodHandles = [][2]uint16{{4, uint16(bsm)}}
+	// method handle 0, "apply", ()I
+	b.callSites = [][]byte{{0x03, 0x16, 0x00, 0x17, byte(b.str("apply")), 0x15, byte(apply)}}
+	b.class(testClass{typ: cls, flags: uint32(dexapkvisit.AccPublic), super: "Ljava/lang/Object;",
+		directMethods: []testEncodedMethod{
+			{bsm, static, nil},
+			// invoke-custom {}, call_site@0; const-method-handle v0, method_handle@0
+			{run, static, &testCode{registers: 1, insns: []uint16{0x00fc, 0x0000, 0x0000, 0x00fe, 0x0000, 0x000e}}},
+		}})
+	data := b.build()
+	dex, err := Parse(nil, "test.dex", bytes.NewReader(data), uint64(len(data)))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	code, err := dex.ClassByName(cls).Methods()[1].Code()
+	if err != nil {
+		t.Fatalf("Code error %v", err)
+	}
+	var actual []string
+	for i := range code.Insns {
+		actual = append(actual, code.Insns[i].String())
+	}
+	expected := []string{
+		`invoke-custom {}, call_site_0("apply", ()I)@invoke-static@` + cls + "->bsm" + bsmSig,
+		"const-method-handle v0, invoke-static@" + cls + "->bsm" + bsmSig,
+		"return-void",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got\n%s\nexpected\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+	if problems := dex.CheckSections(); len(problems) != 0 {
+		t.Errorf("CheckSections: %v", problems)
+	}
+}
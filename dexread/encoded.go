@@ -0,0 +1,187 @@
+package dexread
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+//
+// Decoding of encoded_value, encoded_array and encoded_annotation, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#encoding
+//
+
+var errTruncatedValue = errors.New("truncated encoded value")
+
+// maxValueDepth limits the nesting of arrays and annotations within
+// encoded values, which otherwise costs only two bytes of input a
+// level.
+const maxValueDepth = 100
+
+func (a *ulebHelper) grabBytes(n int) ([]byte, error) {
+	if n > len(a.data) {
+		return nil, errTruncatedValue
+	}
+	b := a.data[:n]
+	a.data = a.data[n:]
+	return b, nil
+}
+
+// Encoded values are little-endian and variable length; signed
+// integers are sign-extended, everything else is zero-extended.
+func grabSized(a *ulebHelper, n int, signed bool) (uint64, error) {
+	b, err := a.grabBytes(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	if signed && n < 8 && b[n-1]&0x80 != 0 {
+		v |= ^uint64(0) << (8 * uint(n))
+	}
+	return v, nil
+}
+
+// Floating point values are zero-extended to the right, i.e. the
+// bytes present are the most significant ones.
+func grabFloatBits(a *ulebHelper, n int, width int) (uint64, error) {
+	v, err := grabSized(a, n, false)
+	if err != nil {
+		return 0, err
+	}
+	return v << (8 * uint(width-n)), nil
+}
+
+// The depth argument of the decode functions is the nesting depth of
+// the value being decoded, zero at top level.
+func decodeEncodedValue(state *dexState, a *ulebHelper, depth int) (retval dexapkvisit.EncodedValue, err error) {
+	if len(a.data) == 0 {
+		return retval, errTruncatedValue
+	}
+	hdr := a.data[0]
+	a.data = a.data[1:]
+	valueArg := int(hdr >> 5)
+	retval.Type = dexapkvisit.ValueType(hdr & 0x1f)
+	size := valueArg + 1
+
+	var v uint64
+	switch retval.Type {
+	case dexapkvisit.ValueByte, dexapkvisit.ValueShort,
+		dexapkvisit.ValueInt, dexapkvisit.ValueLong:
+		if v, err = grabSized(a, size, true); err == nil {
+			retval.Value = int64(v)
+		}
+	case dexapkvisit.ValueChar:
+		if v, err = grabSized(a, size, false); err == nil {
+			retval.Value = uint16(v)
+		}
+	case dexapkvisit.ValueFloat:
+		if v, err = grabFloatBits(a, size, 4); err == nil {
+			retval.Value = math.Float32frombits(uint32(v))
+		}
+	case dexapkvisit.ValueDouble:
+		if v, err = grabFloatBits(a, size, 8); err == nil {
+			retval.Value = math.Float64frombits(v)
+		}
+	case dexapkvisit.ValueString, dexapkvisit.ValueTypeRef,
+		dexapkvisit.ValueField, dexapkvisit.ValueEnum,
+		dexapkvisit.ValueMethod, dexapkvisit.ValueMethodType,
+		dexapkvisit.ValueMethodHandle:
+		if (retval.Type == dexapkvisit.ValueMethodType || retval.Type == dexapkvisit.ValueMethodHandle) &&
+			state.version < DexVersion038 {
+			err = fmt.Errorf("%s value requires DEX version 038", retval.Type)
+			break
+		}
+		if v, err = grabSized(a, size, false); err != nil {
+			break
+		}
+		retval.Index = uint32(v)
+		switch retval.Type {
+		case dexapkvisit.ValueString:
+			retval.Value = state.stringAt(retval.Index)
+		case dexapkvisit.ValueTypeRef:
+			retval.Value = state.typeDescriptor(retval.Index)
+		case dexapkvisit.ValueField, dexapkvisit.ValueEnum:
+			retval.Value = state.resolveIndex(dexapkvisit.IndexField, retval.Index)
+		case dexapkvisit.ValueMethod:
+			retval.Value = state.resolveIndex(dexapkvisit.IndexMethod, retval.Index)
+		case dexapkvisit.ValueMethodType:
+			retval.Value = state.resolveIndex(dexapkvisit.IndexProto, retval.Index)
+		case dexapkvisit.ValueMethodHandle:
+			retval.Value = state.resolveIndex(dexapkvisit.IndexMethodHandle, retval.Index)
+		}
+	case dexapkvisit.ValueArray:
+		retval.Value, err = decodeEncodedArray(state, a, depth+1)
+	case dexapkvisit.ValueAnnotation:
+		retval.Value, err = decodeEncodedAnnotation(state, a, depth+1)
+	case dexapkvisit.ValueNull:
+	case dexapkvisit.ValueBoolean:
+		retval.Value = valueArg != 0
+	default:
+		err = errors.New("unknown encoded value type " + retval.Type.String())
+	}
+	return retval, err
+}
+
+func decodeEncodedArray(state *dexState, a *ulebHelper, depth int) ([]dexapkvisit.EncodedValue, error) {
+	if depth > maxValueDepth {
+		return nil, fmt.Errorf("encoded values nested more than %d deep", maxValueDepth)
+	}
+	size := a.grabULEB128()
+	if size > uint64(len(a.data)) {
+		return nil, errTruncatedValue
+	}
+	retval := make([]dexapkvisit.EncodedValue, 0, size)
+	for i := uint64(0); i < size; i++ {
+		v, err := decodeEncodedValue(state, a, depth)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, v)
+	}
+	return retval, nil
+}
+
+func decodeEncodedAnnotation(state *dexState, a *ulebHelper, depth int) (*dexapkvisit.EncodedAnnotation, error) {
+	if depth > maxValueDepth {
+		return nil, fmt.Errorf("encoded values nested more than %d deep", maxValueDepth)
+	}
+	typeIdx := uint32(a.grabULEB128())
+	size := a.grabULEB128()
+	if size > uint64(len(a.data)) {
+		return nil, errTruncatedValue
+	}
+	retval := &dexapkvisit.EncodedAnnotation{Type: state.typeDescriptor(typeIdx)}
+	for i := uint64(0); i < size; i++ {
+		nameIdx := uint32(a.grabULEB128())
+		v, err := decodeEncodedValue(state, a, depth)
+		if err != nil {
+			return nil, err
+		}
+		retval.Elements = append(retval.Elements, dexapkvisit.AnnotationElement{
+			Name:  state.stringAt(nameIdx),
+			Value: v,
+		})
+	}
+	return retval, nil
+}
+
+// unpackStaticValues decodes the encoded_array_item holding the initial
+// values of a class's static fields (in field order; trailing fields
+// with default values may be omitted).
+func unpackStaticValues(state *dexState, off uint32) ([]dexapkvisit.EncodedValue, error) {
+	content := state.b.Bytes()
+	if uint64(off) >= uint64(len(content)) {
+		return nil, mkError(state, "static values offset %d out of range", off)
+	}
+	helper := ulebHelper{content[off:]}
+	values, err := decodeEncodedArray(state, &helper, 0)
+	if err != nil {
+		return nil, mkError(state, "static values at offset %d: %v", off, err)
+	}
+	return values, nil
+}
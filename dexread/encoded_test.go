@@ -0,0 +1,113 @@
+package dexread
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/dexapktest"
+)
+
+func TestDecodeEncodedValues(t *testing.T) {
+	state := &dexState{
+		strings: []string{"Lfoo;", "hello", "x"},
+		typeIds: []uint32{0},
+	}
+	raw := []byte{
+		0x0b,       // array, 11 elements
+		0x00, 0xff, // byte -1
+		0x22, 0x00, 0x80, // short -32768
+		0x03, 0x41, // char 'A'
+		0x24, 0x34, 0x12, // int 0x1234 (2 bytes)
+		0x26, 0xfe, 0xff, // long -2 (2 bytes)
+		0x30, 0xc0, 0x3f, // float 1.5 (right zero-extended)
+		0x31, 0x04, 0x40, // double 2.5
+		0x17, 0x01, // string "hello"
+		0x3f,                               // boolean true
+		0x1e,                               // null
+		0x1d, 0x00, 0x01, 0x02, 0x18, 0x00, // @Lfoo;(x=Lfoo;)
+	}
+	helper := ulebHelper{raw}
+	values, err := decodeEncodedArray(state, &helper, 0)
+	if err != nil {
+		t.Fatalf("decodeEncodedArray error %v", err)
+	}
+	var actual []string
+	for i := range values {
+		actual = append(actual, values[i].String())
+	}
+	expected := `-1 -32768 'A' 4660 -2 1.5 2.5 "hello" true null @Lfoo;(x=Lfoo;)`
+	if strings.Join(actual, " ") != expected {
+		t.Errorf("got '%s' expected '%s'", strings.Join(actual, " "), expected)
+	}
+	if len(helper.data) != 0 {
+		t.Errorf("%d bytes left over", len(helper.data))
+	}
+
+	truncated := ulebHelper{[]byte{0x02, 0x24, 0x01}}
+	if _, err := decodeEncodedArray(state, &truncated, 0); err == nil {
+		t.Errorf("expected error for truncated array")
+	}
+
+	// Method types and handles came in with version 038.
+	for _, version := range []int{DexVersion035, DexVersion038} {
+		state.version = version
+		methodType := ulebHelper{[]byte{0x01, 0x15, 0x00}}
+		_, err := decodeEncodedArray(state, &methodType, 0)
+		if version == DexVersion038 && err != nil {
+			t.Errorf("version %03d: method_type value error %v", version, err)
+		} else if version == DexVersion035 && (err == nil || err.Error() != "method_type value requires DEX version 038") {
+			t.Errorf("version %03d: method_type value got error %v", version, err)
+		}
+	}
+
+	// Arrays, and annotations, nested a million deep.
+	for _, level := range [][]byte{{0x1c, 0x01}, {0x1d, 0x00, 0x01, 0x01}} {
+		deep := ulebHelper{append(append([]byte{0x01}, bytes.Repeat(level, 1000000)...), 0x1e)}
+		if _, err := decodeEncodedArray(state, &deep, 0); err == nil || !strings.Contains(err.Error(), "nested more than") {
+			t.Errorf("deep nesting: got error %v", err)
+		}
+	}
+}
+
+func TestVisitFields(t *testing.T) {
+	b := newDexBuilder()
+	cls := "Lcom/example/Holder;"
+	fCount := b.field(cls, "I", "COUNT")
+	fName := b.field(cls, "Ljava/lang/String;", "NAME")
+	fLast := b.field(cls, "J", "LAST")
+	fValue := b.field(cls, "D", "value")
+	b.str("holder")
+	b.class(testClass{
+		typ:   cls,
+		super: "Ljava/lang/Object;",
+		staticFields: []testEncodedField{
+			{fCount, 0x19}, {fName, 0x19}, {fLast, 0x9},
+		},
+		instanceFields: []testEncodedField{{fValue, 0x2}},
+		// COUNT = 42, NAME = "holder"; LAST is left at its default
+		staticValues:      []byte{0x04, 42, 0x17, byte(b.stringIdx["holder"])},
+		staticValuesCount: 2,
+	})
+	visitor := &dexapktest.CaptureDexApkVisitOperations{}
+	if err := readTestDex(b.build(), visitor); err != nil {
+		t.Fatalf("ReadDEX error %v", err)
+	}
+	actual := strings.Join(visitor.Result, "\n")
+	expected := ` DEX test.dex version 035 sha1 ` + sha1Of(visitor) + `
+		  class com.example.Holder flags '' methods: 0 extends java.lang.Object
+		   field id 0 name 'COUNT' type 'I' flags 'public static final' static value 42
+		   field id 1 name 'NAME' type 'Ljava/lang/String;' flags 'public static final' static value "holder"
+		   field id 2 name 'LAST' type 'J' flags 'public static' static
+		   field id 3 name 'value' type 'D' flags 'private' instance`
+	if dexapktest.SqueezeWhite(actual) != dexapktest.SqueezeWhite(expected) {
+		t.Errorf("got '%s' expected '%s'", actual, expected)
+	}
+}
+
+// sha1Of pulls the signature out of the captured VisitDEX line, since
+// it depends on the exact layout chosen by the test DEX builder.
+func sha1Of(c *dexapktest.CaptureDexApkVisitOperations) string {
+	f := strings.Fields(c.Result[0])
+	return f[len(f)-1]
+}
@@ -0,0 +1,135 @@
+package dexread
+
+import (
+	"sort"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// Hierarchy is the class hierarchy of a set of DEX files (typically
+// all those in an APK, see apkread.ParseAPK). Classes are identified
+// by type descriptor. A class that is referenced as a superclass or
+// interface but not defined by any of the DEX files is taken to be a
+// platform or library class (e.g. "Landroid/app/Activity;"); such
+// classes show up in queries, but nothing is known about their own
+// supertypes.
+type Hierarchy struct {
+	classes      map[string]*Class
+	subclasses   map[string][]string
+	implementers map[string][]string
+}
+
+// NewHierarchy builds the class hierarchy of 'dexes'. If a class is
+// defined more than once, the first definition wins, as it does at
+// runtime.
+func NewHierarchy(dexes []*DexFile) *Hierarchy {
+	h := &Hierarchy{
+		classes:      make(map[string]*Class),
+		subclasses:   make(map[string][]string),
+		implementers: make(map[string][]string),
+	}
+	for _, d := range dexes {
+		for _, c := range d.classes {
+			if h.classes[c.Descriptor] != nil {
+				continue
+			}
+			h.classes[c.Descriptor] = c
+			if c.Superclass != "" {
+				h.subclasses[c.Superclass] = append(h.subclasses[c.Superclass], c.Descriptor)
+			}
+			for _, iface := range c.Interfaces {
+				h.implementers[iface] = append(h.implementers[iface], c.Descriptor)
+			}
+		}
+	}
+	return h
+}
+
+// Class returns the class with descriptor 'desc', or nil if it is not
+// defined by the DEX files.
+func (h *Hierarchy) Class(desc string) *Class {
+	return h.classes[desc]
+}
+
+// IsExternal reports whether 'desc' is a platform or library class:
+// one that is not defined by the DEX files.
+func (h *Hierarchy) IsExternal(desc string) bool {
+	return h.classes[desc] == nil
+}
+
+// Subclasses returns the classes that extend 'desc', directly or
+// indirectly, in sorted order.
+func (h *Hierarchy) Subclasses(desc string) []string {
+	seen := make(map[string]bool)
+	h.addSubclasses(desc, seen)
+	return sortedKeys(seen)
+}
+
+func (h *Hierarchy) addSubclasses(desc string, seen map[string]bool) {
+	for _, sub := range h.subclasses[desc] {
+		if !seen[sub] {
+			seen[sub] = true
+			h.addSubclasses(sub, seen)
+		}
+	}
+}
+
+// Implementers returns the (non-interface) classes that implement
+// interface 'desc', in sorted order. This includes classes that
+// implement an interface extending 'desc', and subclasses of
+// implementing classes.
+func (h *Hierarchy) Implementers(desc string) []string {
+	seen := make(map[string]bool)
+	visited := map[string]bool{desc: true}
+	work := []string{desc}
+	for len(work) != 0 {
+		iface := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, impl := range h.implementers[iface] {
+			if c := h.classes[impl]; c.AccessFlags.Has(dexapkvisit.AccInterface) {
+				if !visited[impl] {
+					visited[impl] = true
+					work = append(work, impl)
+				}
+			} else if !seen[impl] {
+				seen[impl] = true
+				h.addSubclasses(impl, seen)
+			}
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// Ancestors returns the superclass chain of 'desc', nearest first. The
+// chain ends with java.lang.Object or with the first external class,
+// whose own superclass isn't known.
+func (h *Hierarchy) Ancestors(desc string) []string {
+	var retval []string
+	seen := map[string]bool{desc: true}
+	for c := h.classes[desc]; c != nil && c.Superclass != "" && !seen[c.Superclass]; c = h.classes[c.Superclass] {
+		seen[c.Superclass] = true
+		retval = append(retval, c.Superclass)
+	}
+	return retval
+}
+
+// ExternalSuperclasses returns, in sorted order, the platform or
+// library classes that classes in the DEX files directly extend.
+func (h *Hierarchy) ExternalSuperclasses() []string {
+	seen := make(map[string]bool)
+	for super := range h.subclasses {
+		if h.IsExternal(super) {
+			seen[super] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	retval := make([]string, 0, len(m))
+	for k := range m {
+		retval = append(retval, k)
+	}
+	sort.Strings(retval)
+	return retval
+}
@@ -0,0 +1,84 @@
+package dexread
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/dexapktest"
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+func TestHierarchy(t *testing.T) {
+	const (
+		object   = "Ljava/lang/Object;"
+		runnable = "Ljava/lang/Runnable;"
+		activity = "Landroid/app/Activity;"
+		base     = "Lcom/example/Base;"
+		impl     = "Lcom/example/Impl;"
+		task     = "Lcom/example/Task;"
+		leaf     = "Lcom/example/Leaf;"
+		other    = "Lcom/example/Other;"
+	)
+	public := uint32(dexapkvisit.AccPublic)
+	iface := uint32(dexapkvisit.AccPublic | dexapkvisit.AccInterface | dexapkvisit.AccAbstract)
+
+	b1 := newDexBuilder()
+	b1.class(testClass{typ: base, flags: public, super: activity})
+	b1.class(testClass{typ: task, flags: iface, super: object, interfaces: []string{runnable}})
+	b1.class(testClass{typ: impl, flags: public, super: base, interfaces: []string{task}})
+	b2 := newDexBuilder()
+	b2.class(testClass{typ: leaf, flags: public, super: impl})
+	b2.class(testClass{typ: other, flags: public, super: object, interfaces: []string{task}})
+	// A second definition is ignored.
+	b2.class(testClass{typ: base, flags: public, super: object})
+
+	var dexes []*DexFile
+	for i, b := range []*dexBuilder{b1, b2} {
+		data := b.build()
+		if i == 0 {
+			visitor := &dexapktest.CaptureDexApkVisitOperations{}
+			if err := readTestDex(data, visitor); err != nil {
+				t.Fatalf("ReadDEX error %v", err)
+			}
+			actual := strings.Join(visitor.Result[1:], "\n")
+			expected := `  class com.example.Base flags 'public' methods: 0 extends android.app.Activity
+  class com.example.Task flags 'public abstract interface' methods: 0 extends java.lang.Object implements java.lang.Runnable
+  class com.example.Impl flags 'public' methods: 0 extends com.example.Base implements com.example.Task`
+			if actual != expected {
+				t.Errorf("got\n%s\nexpected\n%s", actual, expected)
+			}
+		}
+		d, err := Parse(nil, "test.dex", bytes.NewReader(data), uint64(len(data)))
+		if err != nil {
+			t.Fatalf("Parse error %v", err)
+		}
+		dexes = append(dexes, d)
+	}
+
+	h := NewHierarchy(dexes)
+	tests := []struct {
+		query    string
+		actual   []string
+		expected []string
+	}{
+		{"subclasses of Base", h.Subclasses(base), []string{impl, leaf}},
+		{"subclasses of Object", h.Subclasses(object), []string{other, task}},
+		{"implementers of Runnable", h.Implementers(runnable), []string{impl, leaf, other}},
+		{"implementers of Task", h.Implementers(task), []string{impl, leaf, other}},
+		{"ancestors of Leaf", h.Ancestors(leaf), []string{impl, base, activity}},
+		{"ancestors of Activity", h.Ancestors(activity), nil},
+		{"external superclasses", h.ExternalSuperclasses(), []string{activity, object}},
+	}
+	for _, tc := range tests {
+		if strings.Join(tc.actual, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("%s: got %v expected %v", tc.query, tc.actual, tc.expected)
+		}
+	}
+	if !h.IsExternal(activity) || h.IsExternal(base) || h.Class(base).Superclass != activity {
+		t.Errorf("unexpected hierarchy classes")
+	}
+	if ClassDescriptor("com.example.Base") != base || ClassDescriptor(base) != base || JavaName(base) != "com.example.Base" {
+		t.Errorf("unexpected name conversion")
+	}
+}
@@ -0,0 +1,147 @@
+package dexread
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+//
+// Helpers for turning string/type/proto/field/method indices into
+// something more useful. Out-of-range indices are rendered as
+// placeholders ("type@123") rather than treated as errors, since
+// these are used mainly for display purposes.
+//
+
+// stringAt returns string 'idx' from the string table, or a
+// placeholder if the index is out of range.
+func (state *dexState) stringAt(idx uint32) string {
+	if idx >= uint32(len(state.strings)) {
+		return fmt.Sprintf("string@%d", idx)
+	}
+	return state.strings[idx]
+}
+
+// typeDescriptor returns the raw type descriptor (ex: "Ljava/lang/Object;")
+// for type 'idx'.
+func (state *dexState) typeDescriptor(idx uint32) string {
+	if idx >= uint32(len(state.typeIds)) {
+		return fmt.Sprintf("type@%d", idx)
+	}
+	return state.stringAt(state.typeIds[idx])
+}
+
+// typeList returns the descriptors in the type_list at offset 'off'
+// (an offset of zero denotes an empty list).
+func (state *dexState) typeList(off uint32) []string {
+	content := state.b.Bytes()
+	if off == 0 || uint64(off)+4 > uint64(len(content)) {
+		return nil
+	}
+	size := state.order.Uint32(content[off:])
+	var retval []string
+	for i := uint32(0); i < size; i++ {
+		pos := uint64(off) + 4 + uint64(i)*2
+		if pos+2 > uint64(len(content)) {
+			break
+		}
+		tidx := state.order.Uint16(content[pos:])
+		retval = append(retval, state.typeDescriptor(uint32(tidx)))
+	}
+	return retval
+}
+
+func (state *dexState) proto(idx uint32) dexapkvisit.Proto {
+	if idx >= uint32(len(state.protoIds)) {
+		return dexapkvisit.Proto{ReturnType: fmt.Sprintf("proto@%d", idx)}
+	}
+	p := state.protoIds[idx]
+	return dexapkvisit.Proto{
+		Shorty:     state.stringAt(p.ShortyIdx),
+		ReturnType: state.typeDescriptor(p.ReturnTypeIdx),
+		Parameters: state.typeList(p.ParametersOff),
+	}
+}
+
+func (state *dexState) fieldId(idx uint32) dexapkvisit.FieldId {
+	if idx >= uint32(len(state.fieldIds)) {
+		return dexapkvisit.FieldId{Name: fmt.Sprintf("field@%d", idx)}
+	}
+	f := state.fieldIds[idx]
+	return dexapkvisit.FieldId{
+		Class: state.typeDescriptor(uint32(f.ClassIdx)),
+		Name:  state.stringAt(f.NameIdx),
+		Type:  state.typeDescriptor(uint32(f.TypeIdx)),
+	}
+}
+
+func (state *dexState) methodId(idx uint32) dexapkvisit.MethodId {
+	if idx >= uint32(len(state.methodIds)) {
+		return dexapkvisit.MethodId{Name: fmt.Sprintf("method@%d", idx)}
+	}
+	m := state.methodIds[idx]
+	retval := dexapkvisit.MethodId{
+		Class: state.typeDescriptor(uint32(m.ClassIdx)),
+		Name:  state.stringAt(m.NameIdx),
+		Proto: state.proto(uint32(m.ProtoIdx)),
+	}
+	retval.Signature = javaSignature(&retval)
+	return retval
+}
+
+// javaSignature renders a method the way Java source would declare
+// it, for example "int fibonacci.rfibonacci(int)".
+func javaSignature(m *dexapkvisit.MethodId) string {
+	params := make([]string, len(m.Proto.Parameters))
+	for i, p := range m.Proto.Parameters {
+		params[i] = decodeDescriptor(p)
+	}
+	return fmt.Sprintf("%s %s.%s(%s)", decodeDescriptor(m.Proto.ReturnType),
+		decodeDescriptor(m.Class), m.Name, strings.Join(params, ", "))
+}
+
+// Method handle types, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#method-handle-type-codes
+// The first four refer to fields, the rest to methods.
+var methodHandleTypes = []string{
+	"static-put", "static-get", "instance-put", "instance-get",
+	"invoke-static", "invoke-instance", "invoke-constructor",
+	"invoke-direct", "invoke-interface",
+}
+
+// methodHandle renders method handle 'idx' the way smali does, for
+// example "invoke-static@Lfoo/Bar;->baz(I)V".
+func (state *dexState) methodHandle(idx uint32) string {
+	mh := state.methodHandles[idx]
+	if int(mh.MethodHandleType) >= len(methodHandleTypes) {
+		return fmt.Sprintf("method_handle@%d", idx)
+	}
+	kind := dexapkvisit.IndexMethod
+	if mh.MethodHandleType < 4 {
+		kind = dexapkvisit.IndexField
+	}
+	return methodHandleTypes[mh.MethodHandleType] + "@" + state.resolveIndex(kind, uint32(mh.FieldOrMethodId))
+}
+
+// callSite renders call site 'idx' the way smali does: the method
+// name, method type and any extra arguments passed to the bootstrap
+// method, then the bootstrap method handle, for example
+// `call_site_0("apply", (I)Ljava/util/function/IntFunction;)@invoke-static@...`.
+// It fails if the call_site_item can't be decoded.
+func (state *dexState) callSite(idx uint32) (string, bool) {
+	content := state.b.Bytes()
+	off := state.callSiteOffs[idx]
+	if uint64(off) >= uint64(len(content)) {
+		return "", false
+	}
+	values, err := decodeEncodedArray(state, &ulebHelper{content[off:]}, 0)
+	if err != nil || len(values) < 3 {
+		return "", false
+	}
+	args := make([]string, len(values)-1)
+	for i := range args {
+		args[i] = values[i+1].String()
+	}
+	return fmt.Sprintf("call_site_%d(%s)@%s", idx, strings.Join(args, ", "), values[0].String()), true
+}
@@ -0,0 +1,65 @@
+package dexread
+
+import (
+	"crypto/sha1"
+	"hash/adler32"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// IntegrityMode says what to do about a DEX file whose header
+// checksum, SHA-1 signature or file size does not match its contents.
+type IntegrityMode int
+
+const (
+	// Don't check (the default).
+	IntegrityIgnore IntegrityMode = iota
+	// Report mismatches via the visitor's VisitDEXIntegrity callback.
+	IntegrityReport
+	// Reject the DEX file with an error.
+	IntegrityStrict
+)
+
+// Options control how DEX files are read; the zero value gives the
+// behavior of ReadDEX and Parse.
+type Options struct {
+	Integrity IntegrityMode
+}
+
+const (
+	checksumStart = 12
+	sha1Start     = 32
+)
+
+// checkIntegrity computes the checksum and signature of the DEX
+// contents for comparison with the header. Within a version 041
+// container these cover just the DEX file's own part of it, and the
+// size checked is that of the whole container.
+func checkIntegrity(state *dexState) dexapkvisit.IntegrityCheck {
+	content := state.b.Bytes()
+	check := dexapkvisit.IntegrityCheck{
+		StoredChecksum: state.fileHeader.Checksum,
+		StoredSha1:     state.fileHeader.Sha1Sig,
+		StoredSize:     state.fileHeader.FileSize,
+		ActualSize:     uint64(len(content)),
+	}
+	if state.inContainer() {
+		check.StoredSize = state.containerHeader.ContainerSize
+		end := uint64(state.base) + uint64(state.fileHeader.FileSize)
+		if end > uint64(len(content)) {
+			end = uint64(len(content))
+		}
+		content = content[state.base:end]
+	}
+	if len(content) >= sha1Start {
+		check.ComputedChecksum = adler32.Checksum(content[checksumStart:])
+		check.ComputedSha1 = sha1.Sum(content[sha1Start:])
+	}
+	return check
+}
+
+// Integrity compares the checksum, signature and size in the DEX
+// header with the actual contents.
+func (d *DexFile) Integrity() dexapkvisit.IntegrityCheck {
+	return checkIntegrity(d.state)
+}
@@ -0,0 +1,419 @@
+package dexread
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// SectionType is the type code of a map_list entry. See
+// https://source.android.com/devices/tech/dalvik/dex-format.html#type-codes
+type SectionType uint16
+
+const (
+	SectionHeader                 SectionType = 0x0000
+	SectionStringIds              SectionType = 0x0001
+	SectionTypeIds                SectionType = 0x0002
+	SectionProtoIds               SectionType = 0x0003
+	SectionFieldIds               SectionType = 0x0004
+	SectionMethodIds              SectionType = 0x0005
+	SectionClassDefs              SectionType = 0x0006
+	SectionCallSiteIds            SectionType = 0x0007
+	SectionMethodHandles          SectionType = 0x0008
+	SectionMapList                SectionType = 0x1000
+	SectionTypeLists              SectionType = 0x1001
+	SectionAnnotationSetRefLists  SectionType = 0x1002
+	SectionAnnotationSets         SectionType = 0x1003
+	SectionClassData              SectionType = 0x2000
+	SectionCode                   SectionType = 0x2001
+	SectionStringData             SectionType = 0x2002
+	SectionDebugInfo              SectionType = 0x2003
+	SectionAnnotations            SectionType = 0x2004
+	SectionEncodedArrays          SectionType = 0x2005
+	SectionAnnotationsDirectories SectionType = 0x2006
+	SectionHiddenapiClassData     SectionType = 0xf000
+)
+
+var sectionNames = map[SectionType]string{
+	SectionHeader:                 "header_item",
+	SectionStringIds:              "string_id_item",
+	SectionTypeIds:                "type_id_item",
+	SectionProtoIds:               "proto_id_item",
+	SectionFieldIds:               "field_id_item",
+	SectionMethodIds:              "method_id_item",
+	SectionClassDefs:              "class_def_item",
+	SectionCallSiteIds:            "call_site_id_item",
+	SectionMethodHandles:          "method_handle_item",
+	SectionMapList:                "map_list",
+	SectionTypeLists:              "type_list",
+	SectionAnnotationSetRefLists:  "annotation_set_ref_list",
+	SectionAnnotationSets:         "annotation_set_item",
+	SectionClassData:              "class_data_item",
+	SectionCode:                   "code_item",
+	SectionStringData:             "string_data_item",
+	SectionDebugInfo:              "debug_info_item",
+	SectionAnnotations:            "annotation_item",
+	SectionEncodedArrays:          "encoded_array_item",
+	SectionAnnotationsDirectories: "annotations_directory_item",
+	SectionHiddenapiClassData:     "hiddenapi_class_data_item",
+}
+
+func (t SectionType) String() string {
+	if s, ok := sectionNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("section_0x%04x", uint16(t))
+}
+
+// itemSize returns the size of each item in sections whose items
+// have a fixed size, or zero.
+func (t SectionType) itemSize() uint32 {
+	switch t {
+	case SectionStringIds, SectionTypeIds, SectionCallSiteIds:
+		return 4
+	case SectionFieldIds, SectionMethodIds, SectionMethodHandles:
+		return 8
+	case SectionProtoIds:
+		return 12
+	case SectionClassDefs:
+		return dexClassHeaderSize
+	}
+	return 0
+}
+
+// Section is an entry in the map_list. Size is the number of bytes
+// from Offset to the start of the next section (or to the end of the
+// DEX file, for the last one), and so includes any alignment padding.
+type Section struct {
+	Type   SectionType
+	Count  uint32
+	Offset uint32
+	Size   uint32
+}
+
+// dexMapItem is a map_item as stored in the file.
+type dexMapItem struct {
+	Type   uint16
+	Unused uint16
+	Size   uint32
+	Offset uint32
+}
+
+const dexMapItemSize = 12
+
+// readMapList reads the map_list items.
+func readMapList(state *dexState) ([]dexMapItem, error) {
+	off := state.fileHeader.MapOff
+	if off == 0 {
+		return nil, mkError(state, "no map_list")
+	}
+	if err := seekReader(state, off); err != nil {
+		return nil, err
+	}
+	var n uint32
+	if err := binary.Read(state.rdr, state.order, &n); err != nil {
+		return nil, mkError(state, "unable to read map_list size: %v", err)
+	}
+	if uint64(n)*dexMapItemSize > uint64(state.rdr.Len()) {
+		return nil, mkError(state, "map_list size %d exceeds file size", n)
+	}
+	items := make([]dexMapItem, n)
+	if err := binary.Read(state.rdr, state.order, items); err != nil {
+		return nil, mkError(state, "unable to read map_list: %v", err)
+	}
+	return items, nil
+}
+
+// Sections parses the map_list, returning the sections in the order
+// listed (which the format requires to be by increasing offset).
+func (d *DexFile) Sections() ([]Section, error) {
+	state := d.state
+	items, err := readMapList(state)
+	if err != nil {
+		return nil, err
+	}
+	n := len(items)
+	sections := make([]Section, n)
+	for i, it := range items {
+		sections[i] = Section{Type: SectionType(it.Type), Count: it.Size, Offset: it.Offset}
+	}
+
+	// Sizes run up to the next section by offset.
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sections[order[i]].Offset < sections[order[j]].Offset
+	})
+	end := uint64(state.base) + uint64(state.fileHeader.FileSize)
+	for k := len(order) - 1; k >= 0; k-- {
+		s := &sections[order[k]]
+		if uint64(s.Offset) < end {
+			s.Size = uint32(end - uint64(s.Offset))
+		}
+		end = uint64(s.Offset)
+	}
+	return sections, nil
+}
+
+// CheckSections cross-checks the map_list against the header, and
+// checks that the sections are in order, don't overlap, and (apart
+// from the header and id tables) lie within the data range. Sections
+// of variable-size items are decoded to find where they end. It
+// returns an error for each problem found.
+func (d *DexFile) CheckSections() []error {
+	state := d.state
+	sections, err := d.Sections()
+	if err != nil {
+		return []error{err}
+	}
+	var problems []error
+	gripe := func(format string, a ...interface{}) {
+		problems = append(problems, mkError(state, format, a...))
+	}
+
+	hdr := &state.fileHeader
+	expected := []struct {
+		typ        SectionType
+		count, off uint32
+	}{
+		{SectionHeader, 1, state.base},
+		{SectionStringIds, hdr.StringIdsSize, hdr.StringIdsOff},
+		{SectionTypeIds, hdr.TypeIdsSize, hdr.TypeIdsOff},
+		{SectionProtoIds, hdr.ProtoIdsSize, hdr.ProtoIdsOff},
+		{SectionFieldIds, hdr.FieldIdsSize, hdr.FieldIdsOff},
+		{SectionMethodIds, hdr.MethodIdsSize, hdr.MethodIdsOff},
+		{SectionClassDefs, hdr.ClassDefsSize, hdr.ClassDefsOff},
+		{SectionMapList, 1, hdr.MapOff},
+	}
+	byType := make(map[SectionType]*Section)
+	for i := range sections {
+		s := &sections[i]
+		if byType[s.Type] != nil {
+			gripe("map_list has more than one %s section", s.Type)
+		}
+		byType[s.Type] = s
+	}
+	for _, e := range expected {
+		s := byType[e.typ]
+		switch {
+		case s == nil && e.count != 0:
+			gripe("map_list has no %s section (header says %d at offset %d)", e.typ, e.count, e.off)
+		case s != nil && (s.Count != e.count || s.Offset != e.off):
+			gripe("map_list %s section has %d at offset %d, header says %d at offset %d",
+				e.typ, s.Count, s.Offset, e.count, e.off)
+		}
+	}
+
+	// In version 041 containers data_off and data_size are unused:
+	// the data range is the whole container.
+	fileEnd := uint64(state.base) + uint64(hdr.FileSize)
+	dataStart := uint64(hdr.DataOff)
+	dataEnd := dataStart + uint64(hdr.DataSize)
+	checkData := state.version < DexVersion041
+	extents := make([]uint64, len(sections))
+	for i := range sections {
+		s := &sections[i]
+		ext, err := d.extent(s, len(sections))
+		switch {
+		case err == errSectionEnd:
+			ext = fileEnd - uint64(s.Offset) + 1
+		case err != nil:
+			gripe("%s section at offset %d: %v", s.Type, s.Offset, err)
+		}
+		extents[i] = ext
+		start, end := uint64(s.Offset), uint64(s.Offset)+ext
+		if end > fileEnd {
+			gripe("%s section at offset %d runs past the end of the file", s.Type, s.Offset)
+		}
+		if checkData && s.Type >= SectionMapList && (start < dataStart || end > dataEnd) {
+			gripe("%s section at offset %d is outside the data range %d..%d",
+				s.Type, s.Offset, dataStart, dataEnd)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := &sections[i-1]
+		if s.Offset <= prev.Offset {
+			gripe("%s section at offset %d is out of order (after %s at offset %d)",
+				s.Type, s.Offset, prev.Type, prev.Offset)
+		} else if uint64(prev.Offset)+extents[i-1] > start {
+			gripe("%s section at offset %d overlaps %s section at offset %d",
+				prev.Type, prev.Offset, s.Type, s.Offset)
+		}
+	}
+	return problems
+}
+
+// errSectionEnd says that decoding a section ran past the end of the
+// DEX file.
+var errSectionEnd = errors.New("runs past the end of the file")
+
+// extent returns the number of bytes that section 's' occupies,
+// decoding its items if they vary in size; items that must be 4-byte
+// aligned are taken to be padded to that. The map_list's count is
+// always 1, so its size comes from 'mapItems', the number of items it
+// holds. The extent of a section of unknown type is zero.
+func (d *DexFile) extent(s *Section, mapItems int) (uint64, error) {
+	switch s.Type {
+	case SectionHeader:
+		return uint64(d.state.fileHeader.HeaderSize), nil
+	case SectionMapList:
+		return 4 + uint64(mapItems)*dexMapItemSize, nil
+	}
+	if n := s.Type.itemSize(); n != 0 {
+		return uint64(s.Count) * uint64(n), nil
+	}
+	state := d.state
+	content := state.b.Bytes()
+	if end := uint64(state.base) + uint64(state.fileHeader.FileSize); end < uint64(len(content)) {
+		content = content[:end]
+	}
+	off := uint64(s.Offset)
+	for i := uint32(0); i < s.Count; i++ {
+		switch s.Type {
+		case SectionTypeLists, SectionAnnotationSetRefLists, SectionAnnotationSets,
+			SectionCode, SectionAnnotationsDirectories:
+			off = (off + 3) &^ 3
+		}
+		if off > uint64(len(content)) {
+			return 0, errSectionEnd
+		}
+		n, err := itemExtent(state, s.Type, content[off:])
+		if err != nil {
+			return 0, err
+		}
+		off += n
+	}
+	return off - uint64(s.Offset), nil
+}
+
+// dbgOperands is the number of operands of each debug_info opcode.
+// They are all uleb128s apart from DBG_ADVANCE_LINE's sleb128, which
+// is skipped over in the same way.
+var dbgOperands = [256]int{dbgAdvancePc: 1, dbgAdvanceLine: 1, dbgStartLocal: 3,
+	dbgStartLocalExtended: 4, dbgEndLocal: 1, dbgRestartLocal: 1, dbgSetFile: 1}
+
+// itemExtent returns the size of the item of type 't' at the start of
+// 'b'.
+func itemExtent(state *dexState, t SectionType, b []byte) (uint64, error) {
+	u32 := func(off uint64) uint64 {
+		if off+4 > uint64(len(b)) {
+			return 0
+		}
+		return uint64(state.order.Uint32(b[off:]))
+	}
+	var n uint64
+	a := &ulebHelper{b}
+	switch t {
+	case SectionTypeLists:
+		n = 4 + u32(0)*2
+	case SectionAnnotationSetRefLists, SectionAnnotationSets:
+		n = 4 + u32(0)*4
+	case SectionAnnotationsDirectories:
+		n = 16 + (u32(4)+u32(8)+u32(12))*8
+	case SectionHiddenapiClassData:
+		n = u32(0)
+	case SectionStringData:
+		a.grabULEB128()
+		if a.data == nil {
+			return 0, errSectionEnd
+		}
+		nul := bytes.IndexByte(a.data, 0)
+		if nul < 0 {
+			return 0, errSectionEnd
+		}
+		n = uint64(len(b)-len(a.data)) + uint64(nul) + 1
+	case SectionCode:
+		if len(b) < 16 {
+			return 0, errSectionEnd
+		}
+		insns, tries := u32(12), uint64(state.order.Uint16(b[6:]))
+		n = 16 + insns*2
+		if tries != 0 {
+			if insns%2 != 0 {
+				n += 2
+			}
+			n += tries * 8
+			if n > uint64(len(b)) {
+				return 0, errSectionEnd
+			}
+			a.data = b[n:]
+			for handlers := a.grabULEB128(); handlers != 0 && a.data != nil; handlers-- {
+				size := a.grabSLEB128()
+				pairs := size
+				if pairs < 0 {
+					pairs = -pairs
+				}
+				for ; pairs != 0 && a.data != nil; pairs-- {
+					a.grabULEB128()
+					a.grabULEB128()
+				}
+				if size <= 0 {
+					a.grabULEB128()
+				}
+			}
+			if a.data == nil {
+				return 0, errSectionEnd
+			}
+			n = uint64(len(b) - len(a.data))
+		}
+	case SectionClassData:
+		var counts [4]uint64
+		for i := range counts {
+			counts[i] = a.grabULEB128()
+		}
+		// Fields are two ulebs, methods three.
+		for k := 2*(counts[0]+counts[1]) + 3*(counts[2]+counts[3]); k != 0 && a.data != nil; k-- {
+			a.grabULEB128()
+		}
+		if a.data == nil {
+			return 0, errSectionEnd
+		}
+		n = uint64(len(b) - len(a.data))
+	case SectionDebugInfo:
+		a.grabULEB128()
+		for params := a.grabULEB128(); params != 0 && a.data != nil; params-- {
+			a.grabULEB128()
+		}
+		for a.data != nil {
+			if len(a.data) == 0 {
+				a.data = nil
+				break
+			}
+			op := a.data[0]
+			a.data = a.data[1:]
+			if op == dbgEndSequence {
+				break
+			}
+			for k := dbgOperands[op]; k != 0; k-- {
+				a.grabULEB128()
+			}
+		}
+		if a.data == nil {
+			return 0, errSectionEnd
+		}
+		n = uint64(len(b) - len(a.data))
+	case SectionAnnotations:
+		if len(b) == 0 {
+			return 0, errSectionEnd
+		}
+		a.data = b[1:]
+		if _, err := decodeEncodedAnnotation(state, a, 0); err != nil {
+			return 0, err
+		}
+		n = uint64(len(b) - len(a.data))
+	case SectionEncodedArrays:
+		if _, err := decodeEncodedArray(state, a, 0); err != nil {
+			return 0, err
+		}
+		n = uint64(len(b) - len(a.data))
+	}
+	if n > uint64(len(b)) {
+		return 0, errSectionEnd
+	}
+	return n, nil
+}
@@ -0,0 +1,151 @@
+package dexread
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSections(t *testing.T) {
+	dex, err := Open("testdata/classes.dex")
+	if err != nil {
+		t.Fatalf("Open error %v", err)
+	}
+	sections, err := dex.Sections()
+	if err != nil {
+		t.Fatalf("Sections error %v", err)
+	}
+	var actual []string
+	for _, s := range sections {
+		actual = append(actual, fmt.Sprintf("%s %d @%d +%d", s.Type, s.Count, s.Offset, s.Size))
+	}
+	expected := []string{
+		"header_item 1 @0 +112",
+		"string_id_item 47 @112 +188",
+		"type_id_item 11 @300 +44",
+		"proto_id_item 8 @344 +96",
+		"field_id_item 2 @440 +16",
+		"method_id_item 12 @456 +96",
+		"class_def_item 1 @552 +32",
+		"code_item 6 @584 +540",
+		"type_list 5 @1124 +38",
+		"string_data_item 47 @1162 +444",
+		"debug_info_item 6 @1606 +144",
+		"class_data_item 1 @1750 +30",
+		"map_list 1 @1780 +160",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got\n%s\nexpected\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+	if problems := dex.CheckSections(); len(problems) != 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+	// Each section's items, decoded, fill it up to any padding.
+	for i := range sections {
+		s := &sections[i]
+		ext, err := dex.extent(s, len(sections))
+		if err != nil || ext > uint64(s.Size) || uint64(s.Size)-ext >= 4 {
+			t.Errorf("%s: extent %d (error %v), size %d", s.Type, ext, err, s.Size)
+		}
+	}
+
+	// One more item in a section of variable-size items runs it
+	// into the next section.
+	data, err := ioutil.ReadFile("testdata/classes.dex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		typ  SectionType
+		want string
+	}{
+		{SectionStringData, "string_data_item section at offset 1162 overlaps debug_info_item section at offset 1606"},
+		{SectionClassData, "class_data_item section at offset 1750 overlaps map_list section at offset 1780"},
+	} {
+		bad := append([]byte{}, data...)
+		for i, s := range sections {
+			if s.Type == tc.typ {
+				pos := 1780 + 4 + uint32(i)*dexMapItemSize + 4
+				binary.LittleEndian.PutUint32(bad[pos:], s.Count+1)
+			}
+		}
+		d, err := Parse(nil, "classes.dex", bytes.NewReader(bad), uint64(len(bad)))
+		if err != nil {
+			t.Fatalf("Parse error %v", err)
+		}
+		var problems []string
+		for _, p := range d.CheckSections() {
+			problems = append(problems, p.Error())
+		}
+		if want := "reading dex classes.dex: " + tc.want; len(problems) != 1 || problems[0] != want {
+			t.Errorf("%s: got problems %q, expected %q", tc.typ, problems, want)
+		}
+	}
+}
+
+func TestCheckSections(t *testing.T) {
+	b := versionTestDex("038")
+	data := b.build()
+	dex, err := Parse(nil, "test.dex", bytes.NewReader(data), uint64(len(data)))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	if problems := dex.CheckSections(); len(problems) != 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+	sections, _ := dex.Sections()
+
+	// Likewise for each DEX file in a container.
+	cdata := buildContainer(versionTestDex(""), versionTestDex(""))
+	dexes, err := ParseAll(nil, "test.dex", bytes.NewReader(cdata), uint64(len(cdata)), Options{})
+	if err != nil {
+		t.Fatalf("ParseAll error %v", err)
+	}
+	for _, d := range dexes {
+		if problems := d.CheckSections(); len(problems) != 0 {
+			t.Errorf("%s: unexpected problems %v", d.Name(), problems)
+		}
+	}
+
+	// The map_list is last, so a file size that cuts off its last
+	// item leaves it running past the end.
+	short := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(short[32:], uint32(len(data)-dexMapItemSize))
+	dex, err = Parse(nil, "test.dex", bytes.NewReader(short), uint64(len(short)))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	problems := dex.CheckSections()
+	if len(problems) != 1 || !strings.HasSuffix(problems[0].Error(), "runs past the end of the file") ||
+		!strings.Contains(problems[0].Error(), "map_list section") {
+		t.Errorf("short file: got problems %v", problems)
+	}
+
+	// Claim one more type id than the header does; the type ids
+	// then run into the proto ids.
+	mapOff := binary.LittleEndian.Uint32(data[52:])
+	for i, s := range sections {
+		if s.Type == SectionTypeIds {
+			pos := mapOff + 4 + uint32(i)*dexMapItemSize + 4
+			binary.LittleEndian.PutUint32(data[pos:], s.Count+1)
+		}
+	}
+	dex, err = Parse(nil, "test.dex", bytes.NewReader(data), uint64(len(data)))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	var actual []string
+	for _, p := range dex.CheckSections() {
+		actual = append(actual, p.Error())
+	}
+	expected := []string{
+		"reading dex test.dex: map_list type_id_item section has 3 at offset 124, header says 2 at offset 124",
+		"reading dex test.dex: type_id_item section at offset 124 overlaps proto_id_item section at offset 132",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got\n%s\nexpected\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
@@ -0,0 +1,391 @@
+package dexread
+
+import (
+	"os"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// DexFile is a parsed DEX file that can be queried in random-access
+// fashion (as opposed to ReadDEX, which pushes everything at a
+// visitor). Use Open or Parse to create one. Method code is decoded on
+// demand, so a DexFile should not be used from multiple goroutines
+// at once.
+//
+// Index-based accessors (Type, Method, ...) follow the conventions of
+// the disassembler: an out-of-range index yields a placeholder such
+// as "type@1234" rather than a panic.
+type DexFile struct {
+	state       *dexState
+	classes     []*Class
+	classByName map[string]*Class
+	xrefs       *xrefIndex
+}
+
+// Class is a single class_def_item along with its class_data_item.
+type Class struct {
+	// Type descriptor of the class, e.g. "Lfoo/Bar;"
+	Descriptor  string
+	AccessFlags dexapkvisit.AccessFlags
+	// Name of the file the class was compiled from (e.g. "Bar.java"),
+	// if recorded
+	SourceFile  string
+	Annotations []*dexapkvisit.Annotation
+	// Type descriptors of the superclass (empty for
+	// java.lang.Object) and of the directly implemented interfaces
+	Superclass string
+	Interfaces []string
+
+	dex     *DexFile
+	header  dexClassHeader
+	fields  []*Field
+	methods []*Method
+}
+
+// Field is an encoded_field within a class. Value is the static
+// initial value (nil for instance fields, or if the class does not
+// supply one).
+type Field struct {
+	Index       uint32
+	Id          dexapkvisit.FieldId
+	AccessFlags dexapkvisit.AccessFlags
+	IsStatic    bool
+	Value       *dexapkvisit.EncodedValue
+	Annotations []*dexapkvisit.Annotation
+}
+
+// Method is an encoded_method within a class. IsDirect is set for
+// static, private and constructor methods (the class_data_item
+// "direct_methods" list), and CodeOffset is zero for abstract and
+// native methods. ParameterAnnotations, if not nil, has an entry for
+// each parameter.
+type Method struct {
+	Index                uint32
+	Id                   dexapkvisit.MethodId
+	AccessFlags          dexapkvisit.AccessFlags
+	IsDirect             bool
+	CodeOffset           uint32
+	Annotations          []*dexapkvisit.Annotation
+	ParameterAnnotations [][]*dexapkvisit.Annotation
+
+	dex   *DexFile
+	class *Class
+	code  *dexapkvisit.MethodCode
+}
+
+// Open reads and parses the DEX file 'dexFilePath'.
+func Open(dexFilePath string) (*DexFile, error) {
+	dexes, err := openDEX(dexFilePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	return dexes[0], nil
+}
+
+func openDEX(dexFilePath string, visitor dexapkvisit.DexApkVisitor) ([]*DexFile, error) {
+	state := dexState{dexName: dexFilePath}
+	fi, err := os.Stat(dexFilePath)
+	if err != nil {
+		return nil, mkError(&state, "os.Stat failed(): %v", err)
+	}
+	dfile, err := os.Open(dexFilePath)
+	if err != nil {
+		return nil, mkError(&state, "os.Open() failed(): %v", err)
+	}
+	defer dfile.Close()
+	return parseContainer(nil, dexFilePath, dfile, uint64(fi.Size()), visitor, Options{})
+}
+
+// Name returns the name the DEX was opened or parsed with.
+func (d *DexFile) Name() string {
+	return d.state.dexName
+}
+
+// Version returns the DEX format version from the header magic, e.g.
+// 35 for "dex\n035\0" (see DexVersion035 and friends).
+func (d *DexFile) Version() int {
+	return d.state.version
+}
+
+// FileSize returns the size of the DEX file according to its header.
+func (d *DexFile) FileSize() uint32 {
+	return d.state.fileHeader.FileSize
+}
+
+// Sha1Signature returns the SHA-1 signature stored in the DEX header.
+func (d *DexFile) Sha1Signature() [20]byte {
+	return d.state.fileHeader.Sha1Sig
+}
+
+// Strings returns the string table.
+func (d *DexFile) Strings() []string {
+	return d.state.strings
+}
+
+// NumTypes returns the number of entries in the type_ids table.
+func (d *DexFile) NumTypes() int {
+	return len(d.state.typeIds)
+}
+
+// Type returns the descriptor of type 'idx', e.g. "Ljava/lang/String;".
+func (d *DexFile) Type(idx uint32) string {
+	return d.state.typeDescriptor(idx)
+}
+
+// NumProtos returns the number of entries in the proto_ids table.
+func (d *DexFile) NumProtos() int {
+	return len(d.state.protoIds)
+}
+
+// Proto returns prototype 'idx'.
+func (d *DexFile) Proto(idx uint32) dexapkvisit.Proto {
+	return d.state.proto(idx)
+}
+
+// NumFields returns the number of entries in the field_ids table.
+func (d *DexFile) NumFields() int {
+	return len(d.state.fieldIds)
+}
+
+// Field returns field reference 'idx'.
+func (d *DexFile) Field(idx uint32) dexapkvisit.FieldId {
+	return d.state.fieldId(idx)
+}
+
+// NumMethods returns the number of entries in the method_ids table.
+func (d *DexFile) NumMethods() int {
+	return len(d.state.methodIds)
+}
+
+// Method returns method reference 'idx'.
+func (d *DexFile) Method(idx uint32) dexapkvisit.MethodId {
+	return d.state.methodId(idx)
+}
+
+// Classes returns the classes defined in the DEX, in class_defs order.
+func (d *DexFile) Classes() []*Class {
+	return d.classes
+}
+
+// ClassByName looks up a class by type descriptor ("Lfoo/Bar;"),
+// returning nil if the DEX does not define it.
+func (d *DexFile) ClassByName(descriptor string) *Class {
+	return d.classByName[descriptor]
+}
+
+// Name returns the Java-style name of the class, e.g. "foo.Bar".
+func (c *Class) Name() string {
+	return decodeDescriptor(c.Descriptor)
+}
+
+// Fields returns the fields of the class: static fields first, then
+// instance fields.
+func (c *Class) Fields() []*Field {
+	return c.fields
+}
+
+// Methods returns the methods of the class: direct methods first,
+// then virtual methods.
+func (c *Class) Methods() []*Method {
+	return c.methods
+}
+
+// Code returns the decoded code_item for the method, or nil for
+// abstract and native methods. The code's debug info, if any, is
+// decoded too.
+func (m *Method) Code() (*dexapkvisit.MethodCode, error) {
+	if m.CodeOffset == 0 || m.code != nil {
+		return m.code, nil
+	}
+	code, err := unpackCodeItem(m.dex.state, m.CodeOffset)
+	if err != nil {
+		return nil, err
+	}
+	if code.DebugInfoOff != 0 {
+		if code.Debug, err = unpackDebugInfo(m.dex.state, m, code); err != nil {
+			return nil, err
+		}
+	}
+	m.code = code
+	return code, nil
+}
+
+// Position returns the source position of the instruction at
+// bytecode address 'addr' (in 16-bit code units), for symbolizing
+// stack traces; it fails if the method has no line number info.
+func (m *Method) Position(addr uint32) (dexapkvisit.PositionEntry, bool) {
+	code, err := m.Code()
+	if err != nil || code == nil || code.Debug == nil {
+		return dexapkvisit.PositionEntry{}, false
+	}
+	return code.Debug.Position(addr)
+}
+
+// parseClass reads the class_data_item and static values for a class.
+func parseClass(d *DexFile, ci *dexClassHeader) (*Class, error) {
+	state := d.state
+	c := &Class{
+		Descriptor:  state.typeDescriptor(ci.ClassIdx),
+		AccessFlags: dexapkvisit.AccessFlags(ci.AccessFlags),
+		dex:         d,
+		header:      *ci,
+	}
+	if ci.SourceFileIdx != noIndex {
+		c.SourceFile = state.stringAt(ci.SourceFileIdx)
+	}
+	if ci.SuperClassIdx != noIndex {
+		c.Superclass = state.typeDescriptor(ci.SuperClassIdx)
+	}
+	c.Interfaces = state.typeList(ci.InterfacesOff)
+
+	// No class data? In theory this can happen
+	if ci.ClassDataOff == 0 {
+		return finishClass(state, c)
+	}
+
+	// Create new slice pointing to correct spot in buffer for class data
+	content := state.b.Bytes()
+	if uint64(ci.ClassDataOff) >= uint64(len(content)) {
+		return nil, mkError(state, "class data offset %d out of range", ci.ClassDataOff)
+	}
+	helper := ulebHelper{content[ci.ClassDataOff:]}
+
+	// Read four ULEB128 encoded values into struct
+	var clh dexClassContents
+	clh.numStaticFields = uint32(helper.grabULEB128())
+	clh.numInstanceFields = uint32(helper.grabULEB128())
+	clh.numDirectMethods = uint32(helper.grabULEB128())
+	clh.numVirtualMethods = uint32(helper.grabULEB128())
+
+	// debugging
+	state.verbose(1, "num static fields is %d", clh.numStaticFields)
+	state.verbose(1, "num instance fields is %d", clh.numInstanceFields)
+	state.verbose(1, "num direct methods is %d", clh.numDirectMethods)
+	state.verbose(1, "num virtual methods is %d", clh.numVirtualMethods)
+
+	// Initial values for static fields, if any
+	var staticValues []dexapkvisit.EncodedValue
+	if ci.StaticValuesOff != 0 {
+		var err error
+		if staticValues, err = unpackStaticValues(state, ci.StaticValuesOff); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read the fields. As with methods (below), the field ID
+	// value read is a difference from the index of the previous
+	// element in the list.
+	var fieldIdx uint64 = 0
+	numFields := clh.numStaticFields + clh.numInstanceFields
+	for i := uint32(0); i < numFields; i++ {
+		fieldDelta := helper.grabULEB128()
+		if i == 0 || i == clh.numStaticFields {
+			fieldIdx = fieldDelta
+		} else {
+			fieldIdx = fieldIdx + fieldDelta
+		}
+		f := &Field{
+			Index:       uint32(fieldIdx),
+			Id:          state.fieldId(uint32(fieldIdx)),
+			AccessFlags: dexapkvisit.AccessFlags(helper.grabULEB128()),
+			IsStatic:    i < clh.numStaticFields,
+		}
+		if f.IsStatic && i < uint32(len(staticValues)) {
+			f.Value = &staticValues[i]
+		}
+		c.fields = append(c.fields, f)
+	}
+
+	// Read the methods. Note that method ID value read is a
+	// difference from the index of the previous element in the list.
+	var methodIdx uint64 = 0
+	numMethods := clh.numDirectMethods + clh.numVirtualMethods
+	for i := uint32(0); i < numMethods; i++ {
+		methodDelta := helper.grabULEB128()
+		if i == 0 || i == clh.numDirectMethods {
+			methodIdx = methodDelta
+		} else {
+			methodIdx = methodIdx + methodDelta
+		}
+		m := &Method{
+			Index:       uint32(methodIdx),
+			AccessFlags: dexapkvisit.AccessFlags(helper.grabULEB128()),
+			CodeOffset:  uint32(helper.grabULEB128()),
+			IsDirect:    i < clh.numDirectMethods,
+			dex:         d,
+			class:       c,
+		}
+		if methodIdx >= uint64(len(state.methodIds)) {
+			return nil, mkError(state, "method index %d out of range", methodIdx)
+		}
+		m.Id = state.methodId(m.Index)
+		state.verbose(1, "method %d idx %d flags %s off %d",
+			i, methodIdx, m.AccessFlags, m.CodeOffset)
+		c.methods = append(c.methods, m)
+	}
+	return finishClass(state, c)
+}
+
+// finishClass attaches annotations to the class, once its fields and
+// methods are known.
+func finishClass(state *dexState, c *Class) (*Class, error) {
+	if c.header.AnnotationsOff != 0 {
+		if err := unpackAnnotationsDirectory(state, c.header.AnnotationsOff, c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Walk visits the contents of the DEX file, invoking callbacks within
+// the visitor object 'visitor' (this is what ReadDEX does once the
+// DEX has been parsed).
+func (d *DexFile) Walk(visitor dexapkvisit.DexApkVisitor) error {
+	visitor.VisitDEX(d.Name(), d.Version(), d.Sha1Signature())
+	if d.state.opts.Integrity == IntegrityReport {
+		if check := d.Integrity(); !check.OK() {
+			visitor.VisitDEXIntegrity(d.Name(), &check)
+		}
+	}
+	for cl, c := range d.classes {
+		visitor.Verbose(1, "class %d type idx is %d", cl, c.header.ClassIdx)
+		var super string
+		if c.Superclass != "" {
+			super = decodeDescriptor(c.Superclass)
+		}
+		interfaces := make([]string, len(c.Interfaces))
+		for i, iface := range c.Interfaces {
+			interfaces[i] = decodeDescriptor(iface)
+		}
+		visitor.VisitClass(c.Name(), uint32(len(c.methods)), c.AccessFlags, super, interfaces)
+		target := dexapkvisit.AnnotationTarget{Kind: dexapkvisit.TargetClass, Class: c.Descriptor}
+		for _, a := range c.Annotations {
+			visitor.VisitAnnotation(&target, a)
+		}
+		for _, f := range c.fields {
+			visitor.VisitField(&f.Id, uint64(f.Index), f.AccessFlags, f.IsStatic, f.Value)
+			target := dexapkvisit.AnnotationTarget{Kind: dexapkvisit.TargetField, Class: c.Descriptor, Field: &f.Id}
+			for _, a := range f.Annotations {
+				visitor.VisitAnnotation(&target, a)
+			}
+		}
+		for _, m := range c.methods {
+			code, err := m.Code()
+			if err != nil {
+				return err
+			}
+			visitor.VisitMethod(&m.Id, uint64(m.Index), m.AccessFlags, uint64(m.CodeOffset), code)
+			target := dexapkvisit.AnnotationTarget{Kind: dexapkvisit.TargetMethod, Class: c.Descriptor, Method: &m.Id}
+			for _, a := range m.Annotations {
+				visitor.VisitAnnotation(&target, a)
+			}
+			for i, set := range m.ParameterAnnotations {
+				target := dexapkvisit.AnnotationTarget{Kind: dexapkvisit.TargetParameter, Class: c.Descriptor, Method: &m.Id, Parameter: i}
+				for _, a := range set {
+					visitor.VisitAnnotation(&target, a)
+				}
+			}
+		}
+	}
+	return nil
+}
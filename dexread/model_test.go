@@ -0,0 +1,104 @@
+package dexread
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+func TestDexFileModel(t *testing.T) {
+	dex, err := Open("testdata/classes.dex")
+	if err != nil {
+		t.Fatalf("Open error %v", err)
+	}
+	if len(dex.Strings()) != 47 || dex.NumTypes() != 11 ||
+		dex.NumProtos() != 8 || dex.NumFields() != 2 || dex.NumMethods() != 12 {
+		t.Errorf("unexpected table sizes: strings %d types %d protos %d fields %d methods %d",
+			len(dex.Strings()), dex.NumTypes(), dex.NumProtos(),
+			dex.NumFields(), dex.NumMethods())
+	}
+	if len(dex.Classes()) != 1 {
+		t.Fatalf("got %d classes, expected 1", len(dex.Classes()))
+	}
+
+	c := dex.ClassByName("Lfibonacci;")
+	if c == nil || c != dex.Classes()[0] {
+		t.Fatalf("ClassByName(Lfibonacci;) returned %v", c)
+	}
+	if dex.ClassByName("Lfoo/Bar;") != nil {
+		t.Errorf("ClassByName(Lfoo/Bar;) should return nil")
+	}
+	if c.Name() != "fibonacci" || !c.AccessFlags.Has(dexapkvisit.AccFinal) {
+		t.Errorf("unexpected class %s flags %s", c.Name(), c.AccessFlags.ClassString())
+	}
+	if len(c.Fields()) != 0 || len(c.Methods()) != 6 {
+		t.Errorf("got %d fields %d methods", len(c.Fields()), len(c.Methods()))
+	}
+
+	var rfib *Method
+	for _, m := range c.Methods() {
+		if m.Id.Name == "rfibonacci" {
+			rfib = m
+		}
+	}
+	if rfib == nil || !rfib.IsDirect || rfib.CodeOffset != 1072 {
+		t.Fatalf("unexpected rfibonacci method %+v", rfib)
+	}
+	code, err := rfib.Code()
+	if err != nil || code.InsnsSize != 17 {
+		t.Fatalf("rfibonacci Code() returned %v, %v", code, err)
+	}
+
+	// Resolve the callee of the first invoke through the id tables.
+	callee := dex.Method(code.Insns[4].Index)
+	if callee.Signature != "int fibonacci.rcnm1(int)" {
+		t.Errorf("callee signature got '%s'", callee.Signature)
+	}
+	for i := uint32(0); i < uint32(dex.NumFields()); i++ {
+		f := dex.Field(i)
+		if f.Class != "Ljava/lang/System;" || f.Type != "Ljava/io/PrintStream;" {
+			t.Errorf("unexpected field %d: %+v", i, f)
+		}
+	}
+	for i := uint32(0); i < uint32(dex.NumProtos()); i++ {
+		if p := dex.Proto(i); p.Descriptor() == "(I)I" {
+			if p.Shorty != callee.Proto.Shorty {
+				t.Errorf("proto %d shorty got '%s'", i, p.Shorty)
+			}
+		}
+	}
+	if s := dex.Type(1234); s != "type@1234" {
+		t.Errorf("out of range Type() got '%s'", s)
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	b := newDexBuilder()
+	cls := "Lcom/example/Holder;"
+	count := b.field(cls, "I", "count")
+	name := b.field(cls, "Ljava/lang/String;", "name")
+	b.class(testClass{
+		typ:            cls,
+		flags:          uint32(dexapkvisit.AccPublic),
+		staticFields:   []testEncodedField{{count, uint32(dexapkvisit.AccStatic)}},
+		instanceFields: []testEncodedField{{name, uint32(dexapkvisit.AccPrivate)}},
+	})
+	data := b.build()
+	dex, err := Parse(nil, "test.dex", bytes.NewReader(data), uint64(len(data)))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	c := dex.ClassByName(cls)
+	if c == nil || len(c.Fields()) != 2 || len(c.Methods()) != 0 {
+		t.Fatalf("unexpected class %+v", c)
+	}
+	s, i := c.Fields()[0], c.Fields()[1]
+	if !s.IsStatic || s.Id.Name != "count" || s.Value != nil {
+		t.Errorf("unexpected static field %+v", s)
+	}
+	if i.IsStatic || i.Id.Type != "Ljava/lang/String;" ||
+		!i.AccessFlags.Has(dexapkvisit.AccPrivate) {
+		t.Errorf("unexpected instance field %+v", i)
+	}
+}
@@ -0,0 +1,324 @@
+package dexread
+
+import (
+	"sort"
+)
+
+// SizeReport attributes the bytes of a DEX file to the classes (and
+// methods) that own them. Items in the data sections are located via
+// the offsets in the class definitions, and each one is taken to run
+// up to the next known item in its section (so alignment padding goes
+// with the item before it). An item owned by several classes, such as
+// an interface list or annotation set that the compiler has
+// deduplicated, is split evenly between them. The string, type and
+// proto pools, and method_ids/field_ids entries for classes that the
+// DEX file does not define, are not owned by any one class; they are
+// reported as Shared, along with the header, map_list and anything
+// that could not be attributed. The sizes of the Classes and Shared
+// entries add up to FileSize.
+type SizeReport struct {
+	Dex      *DexFile
+	FileSize uint32
+	Classes  []*ClassSize
+	Shared   []SharedSize
+}
+
+// ClassSize is the number of bytes attributed to a class. Size
+// includes the sizes of the Methods, which lists the methods that
+// have code (their code_item and debug_info_item).
+type ClassSize struct {
+	Class   *Class
+	Size    uint32
+	Methods []MethodSize
+}
+
+// MethodSize is the number of bytes attributed to a method.
+type MethodSize struct {
+	Method *Method
+	Size   uint32
+}
+
+// SharedSize is the number of bytes in one of the shared categories
+// (see SizeReport): "header", "strings", "types", "protos",
+// "references" (to classes defined elsewhere), "call sites",
+// "method handles", "hiddenapi", "map_list" and "unattributed".
+type SharedSize struct {
+	Name string
+	Size uint32
+}
+
+// sizeOwner is the owner of an item: a class (and possibly one of its
+// methods) or a shared category.
+type sizeOwner struct {
+	class  *ClassSize
+	method int
+	shared string
+}
+
+func sharedOwner(name string) sizeOwner {
+	return sizeOwner{method: -1, shared: name}
+}
+
+// Sections whose contents are all shared.
+var sharedSections = map[SectionType]string{
+	SectionHeader:             "header",
+	SectionStringIds:          "strings",
+	SectionStringData:         "strings",
+	SectionTypeIds:            "types",
+	SectionProtoIds:           "protos",
+	SectionCallSiteIds:        "call sites",
+	SectionMethodHandles:      "method handles",
+	SectionMapList:            "map_list",
+	SectionHiddenapiClassData: "hiddenapi",
+}
+
+const unattributed = "unattributed"
+
+type sizeAttributor struct {
+	state  *dexState
+	owners map[SectionType]map[uint32][]sizeOwner
+	shared map[string]uint32
+}
+
+// own records 'o' as an owner of the item of type 't' at 'off'.
+func (a *sizeAttributor) own(t SectionType, off uint32, o sizeOwner) {
+	if off == 0 {
+		return
+	}
+	m := a.owners[t]
+	if m == nil {
+		m = make(map[uint32][]sizeOwner)
+		a.owners[t] = m
+	}
+	for _, x := range m[off] {
+		if x == o {
+			return
+		}
+	}
+	m[off] = append(m[off], o)
+}
+
+// charge splits 'size' bytes between 'owners'.
+func (a *sizeAttributor) charge(owners []sizeOwner, size uint32) {
+	n := uint32(len(owners))
+	for i, o := range owners {
+		share := size / n
+		if i == 0 {
+			share += size % n
+		}
+		if o.class == nil {
+			a.shared[o.shared] += share
+			continue
+		}
+		o.class.Size += share
+		if o.method >= 0 {
+			o.class.Methods[o.method].Size += share
+		}
+	}
+}
+
+// ownAnnotationSet records the annotation_set_item at 'off', and the
+// annotations in it, as owned by 'o'.
+func (a *sizeAttributor) ownAnnotationSet(off uint32, o sizeOwner) {
+	if off == 0 {
+		return
+	}
+	a.own(SectionAnnotationSets, off, o)
+	items, err := a.state.sizedList(off)
+	if err != nil {
+		return
+	}
+	for _, item := range items {
+		a.own(SectionAnnotations, item, o)
+	}
+}
+
+// ownAnnotations records the annotations_directory_item at 'off', and
+// everything it refers to, as owned by 'o'. The annotations were
+// decoded when the class was parsed, so errors can't happen here;
+// if they do, the items concerned just end up unattributed.
+func (a *sizeAttributor) ownAnnotations(off uint32, o sizeOwner) {
+	if off == 0 {
+		return
+	}
+	a.own(SectionAnnotationsDirectories, off, o)
+	hdr, err := a.state.uintsAt(off, 4)
+	if err != nil {
+		return
+	}
+	a.ownAnnotationSet(hdr[0], o)
+	nfields, nmethods, nparams := uint64(hdr[1]), uint64(hdr[2]), uint64(hdr[3])
+	pairs, err := a.state.uintsAt(off+16, 2*(nfields+nmethods+nparams))
+	if err != nil {
+		return
+	}
+	for i := uint64(0); i < nfields+nmethods+nparams; i++ {
+		setOff := pairs[2*i+1]
+		if i < nfields+nmethods {
+			a.ownAnnotationSet(setOff, o)
+			continue
+		}
+		a.own(SectionAnnotationSetRefLists, setOff, o)
+		sets, err := a.state.sizedList(setOff)
+		if err != nil {
+			continue
+		}
+		for _, s := range sets {
+			a.ownAnnotationSet(s, o)
+		}
+	}
+}
+
+// SizeReport works out which classes and methods own the bytes of the
+// DEX file, based on its map_list.
+func (d *DexFile) SizeReport() (*SizeReport, error) {
+	sections, err := d.Sections()
+	if err != nil {
+		return nil, err
+	}
+	state := d.state
+	content := state.b.Bytes()
+	a := &sizeAttributor{
+		state:  state,
+		owners: make(map[SectionType]map[uint32][]sizeOwner),
+		shared: make(map[string]uint32),
+	}
+	r := &SizeReport{Dex: d, FileSize: state.fileHeader.FileSize}
+
+	// Record the owners of the data items.
+	byDesc := make(map[string]*ClassSize)
+	for _, c := range d.classes {
+		cs := &ClassSize{Class: c}
+		r.Classes = append(r.Classes, cs)
+		if byDesc[c.Descriptor] == nil {
+			byDesc[c.Descriptor] = cs
+		}
+		o := sizeOwner{class: cs, method: -1}
+		a.own(SectionTypeLists, c.header.InterfacesOff, o)
+		a.own(SectionClassData, c.header.ClassDataOff, o)
+		a.own(SectionEncodedArrays, c.header.StaticValuesOff, o)
+		a.ownAnnotations(c.header.AnnotationsOff, o)
+		for _, m := range c.methods {
+			if m.CodeOffset == 0 {
+				continue
+			}
+			mo := sizeOwner{class: cs, method: len(cs.Methods)}
+			cs.Methods = append(cs.Methods, MethodSize{Method: m})
+			a.own(SectionCode, m.CodeOffset, mo)
+			// debug_info_off is at offset 8 in the code_item.
+			if uint64(m.CodeOffset)+12 <= uint64(len(content)) {
+				a.own(SectionDebugInfo, state.order.Uint32(content[m.CodeOffset+8:]), mo)
+			}
+		}
+	}
+	for _, p := range state.protoIds {
+		a.own(SectionTypeLists, p.ParametersOff, sharedOwner("protos"))
+	}
+
+	// member returns the owner of a method_ids or field_ids entry
+	// for a member of the class with type index 'classIdx'.
+	member := func(classIdx uint16) sizeOwner {
+		if cs := byDesc[state.typeDescriptor(uint32(classIdx))]; cs != nil {
+			return sizeOwner{class: cs, method: -1}
+		}
+		return sharedOwner("references")
+	}
+
+	for _, s := range sections {
+		if name, ok := sharedSections[s.Type]; ok {
+			a.shared[name] += s.Size
+			continue
+		}
+		switch s.Type {
+		case SectionFieldIds, SectionMethodIds, SectionClassDefs:
+			size, used := s.Type.itemSize(), uint32(0)
+			for i := uint32(0); i < s.Count && used+size <= s.Size; i++ {
+				o := sharedOwner(unattributed)
+				switch {
+				case s.Type == SectionFieldIds && i < uint32(len(state.fieldIds)):
+					o = member(state.fieldIds[i].ClassIdx)
+				case s.Type == SectionMethodIds && i < uint32(len(state.methodIds)):
+					o = member(state.methodIds[i].ClassIdx)
+				case s.Type == SectionClassDefs && i < uint32(len(r.Classes)):
+					o = sizeOwner{class: r.Classes[i], method: -1}
+				}
+				a.charge([]sizeOwner{o}, size)
+				used += size
+			}
+			a.shared[unattributed] += s.Size - used
+		default:
+			owned := a.owners[s.Type]
+			var offs []uint32
+			for off := range owned {
+				if off >= s.Offset && off-s.Offset < s.Size {
+					offs = append(offs, off)
+				}
+			}
+			sort.Slice(offs, func(i, j int) bool { return offs[i] < offs[j] })
+			end := s.Offset + s.Size
+			if len(offs) == 0 {
+				a.shared[unattributed] += s.Size
+				continue
+			}
+			a.shared[unattributed] += offs[0] - s.Offset
+			for i, off := range offs {
+				next := end
+				if i+1 < len(offs) {
+					next = offs[i+1]
+				}
+				a.charge(owned[off], next-off)
+			}
+		}
+	}
+
+	for name, size := range a.shared {
+		if size != 0 {
+			r.Shared = append(r.Shared, SharedSize{Name: name, Size: size})
+		}
+	}
+	sort.Slice(r.Shared, func(i, j int) bool {
+		if r.Shared[i].Size != r.Shared[j].Size {
+			return r.Shared[i].Size > r.Shared[j].Size
+		}
+		return r.Shared[i].Name < r.Shared[j].Name
+	})
+	return r, nil
+}
+
+// PackageSize is the number of bytes attributed to the classes in a
+// package.
+type PackageSize struct {
+	Package string
+	Size    uint32
+	Classes int
+}
+
+// SizeByPackage totals the class sizes in 'reports' by package, with
+// package names truncated to 'depth' components (no limit if depth is
+// zero or less), largest first.
+func SizeByPackage(reports []*SizeReport, depth int) []PackageSize {
+	sizes := make(map[string]*PackageSize)
+	for _, r := range reports {
+		for _, cs := range r.Classes {
+			pkg := packageOf(cs.Class.Descriptor, depth)
+			ps := sizes[pkg]
+			if ps == nil {
+				ps = &PackageSize{Package: pkg}
+				sizes[pkg] = ps
+			}
+			ps.Size += cs.Size
+			ps.Classes++
+		}
+	}
+	retval := make([]PackageSize, 0, len(sizes))
+	for _, ps := range sizes {
+		retval = append(retval, *ps)
+	}
+	sort.Slice(retval, func(i, j int) bool {
+		if retval[i].Size != retval[j].Size {
+			return retval[i].Size > retval[j].Size
+		}
+		return retval[i].Package < retval[j].Package
+	})
+	return retval
+}
@@ -0,0 +1,68 @@
+package dexread
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSizeReport(t *testing.T) {
+	dex, err := Open("testdata/classes.dex")
+	if err != nil {
+		t.Fatalf("Open error %v", err)
+	}
+	r, err := dex.SizeReport()
+	if err != nil {
+		t.Fatalf("SizeReport error %v", err)
+	}
+	var lines []string
+	total := uint32(0)
+	for _, cs := range r.Classes {
+		lines = append(lines, fmt.Sprintf("class %s %d", cs.Class.Name(), cs.Size))
+		total += cs.Size
+		for _, ms := range cs.Methods {
+			lines = append(lines, fmt.Sprintf(" method %s %d", ms.Method.Id.Name, ms.Size))
+		}
+	}
+	for _, s := range r.Shared {
+		lines = append(lines, fmt.Sprintf("shared %s %d", s.Name, s.Size))
+		total += s.Size
+	}
+	actual := strings.Join(lines, "\n")
+	expected := `class fibonacci 794
+ method <init> 29
+ method ifibonacci 83
+ method main 431
+ method rcnm1 38
+ method rcnm2 38
+ method rfibonacci 65
+shared strings 632
+shared map_list 160
+shared protos 134
+shared header 112
+shared references 64
+shared types 44`
+	if actual != expected {
+		t.Errorf("got\n%s\nexpected\n%s", actual, expected)
+	}
+	if total != r.FileSize || r.FileSize != dex.FileSize() {
+		t.Errorf("sizes add up to %d, file size %d", total, r.FileSize)
+	}
+
+	pkgs := SizeByPackage([]*SizeReport{r, r}, 0)
+	if len(pkgs) != 1 || pkgs[0] != (PackageSize{Package: "", Size: 2 * 794, Classes: 2}) {
+		t.Errorf("unexpected package sizes %+v", pkgs)
+	}
+}
+
+func TestSizeCharge(t *testing.T) {
+	a := &sizeAttributor{shared: make(map[string]uint32)}
+	c1 := &ClassSize{Methods: []MethodSize{{}}}
+	c2 := &ClassSize{}
+	// Shared items are split evenly, with any remainder going to
+	// the first owner.
+	a.charge([]sizeOwner{{class: c1, method: 0}, {class: c2, method: -1}, sharedOwner("protos")}, 11)
+	if c1.Size != 5 || c1.Methods[0].Size != 5 || c2.Size != 3 || a.shared["protos"] != 3 {
+		t.Errorf("unexpected split %d %d %d %d", c1.Size, c1.Methods[0].Size, c2.Size, a.shared["protos"])
+	}
+}
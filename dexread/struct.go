@@ -4,8 +4,14 @@ const (
 	// https://source.android.com/devices/tech/dalvik/dex-format.html#endian-constant
 	endianConstant     = 0x12345678
 	reverseEndianConst = 0x78563412
+	noIndex            = 0xffffffff
 	dexFileHeaderSize  = 112
 	dexClassHeaderSize = 32
+	// code_item header up to (but not including) the insns array
+	dexCodeItemHeaderSize = 16
+	dexTryItemSize        = 8
+	// version 041 headers add the container fields below
+	dexContainerHeaderSize = dexFileHeaderSize + 8
 )
 
 // Upper case fields are intentional (to allow filling in the contents
@@ -27,7 +33,7 @@ type dexFileHeader struct {
 	TypeIdsOff    uint32
 	ProtoIdsSize  uint32
 	ProtoIdsOff   uint32
-	PieldIdsSize  uint32
+	FieldIdsSize  uint32
 	FieldIdsOff   uint32
 	MethodIdsSize uint32
 	MethodIdsOff  uint32
@@ -37,6 +43,13 @@ type dexFileHeader struct {
 	DataOff       uint32
 }
 
+// dexContainerHeader follows dexFileHeader in version 041 files,
+// where several DEX files can share one container.
+type dexContainerHeader struct {
+	ContainerSize uint32
+	HeaderOffset  uint32
+}
+
 type dexClassHeader struct {
 	// https://source.android.com/devices/tech/dalvik/dex-format.html#class-def-item
 	ClassIdx        uint32
@@ -50,11 +63,51 @@ type dexClassHeader struct {
 }
 
 type dexMethodIdItem struct {
+	ClassIdx uint16
+	ProtoIdx uint16
+	NameIdx  uint32
+}
+
+type dexFieldIdItem struct {
+	// https://source.android.com/devices/tech/dalvik/dex-format.html#field-id-item
 	ClassIdx uint16
 	TypeIdx  uint16
 	NameIdx  uint32
 }
 
+// dexMethodHandleItem is a method_handle_item; the type says whether
+// FieldOrMethodId is a field or a method index.
+type dexMethodHandleItem struct {
+	// https://source.android.com/devices/tech/dalvik/dex-format.html#method-handle-item
+	MethodHandleType uint16
+	Unused1          uint16
+	FieldOrMethodId  uint16
+	Unused2          uint16
+}
+
+type dexProtoIdItem struct {
+	// https://source.android.com/devices/tech/dalvik/dex-format.html#proto-id-item
+	ShortyIdx     uint32
+	ReturnTypeIdx uint32
+	ParametersOff uint32
+}
+
+type dexCodeItemHeader struct {
+	// https://source.android.com/devices/tech/dalvik/dex-format.html#code-item
+	RegistersSize uint16
+	InsSize       uint16
+	OutsSize      uint16
+	TriesSize     uint16
+	DebugInfoOff  uint32
+	InsnsSize     uint32
+}
+
+type dexTryItem struct {
+	StartAddr  uint32
+	InsnCount  uint16
+	HandlerOff uint16
+}
+
 //
 // Note that within the DEX file, these fields are ULEB128 encoded; the
 // struct below is to hold the decoded values.
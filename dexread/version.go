@@ -0,0 +1,57 @@
+package dexread
+
+import (
+	"fmt"
+)
+
+// DEX format versions, as found in the header magic "dex\n0NN\0".
+// Version 036 was never used (it was skipped because of a bug in
+// older Dalvik releases that accepted it).
+const (
+	// The original format.
+	DexVersion035 = 35
+	// Default methods and invoke-super on interfaces (Android 7.0).
+	DexVersion037 = 37
+	// invoke-polymorphic, invoke-custom, call sites and method
+	// handles, and method_type and method_handle encoded values
+	// (Android 8.0).
+	DexVersion038 = 38
+	// const-method-handle and const-method-type (Android 9).
+	DexVersion039 = 39
+	// Relaxed rules for SimpleName characters (Android 11).
+	DexVersion040 = 40
+	// Multi-DEX containers, where several DEX headers share one
+	// file (Android 15).
+	DexVersion041 = 41
+)
+
+// parseMagic checks the header magic and returns the DEX version.
+func parseMagic(magic []byte) (int, error) {
+	if len(magic) < 8 || string(magic[:4]) != "dex\n" || magic[7] != 0 {
+		return 0, fmt.Errorf("not a DEX file")
+	}
+	version := 0
+	for _, c := range magic[4:7] {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("not a DEX file")
+		}
+		version = version*10 + int(c-'0')
+	}
+	switch version {
+	case DexVersion035, DexVersion037, DexVersion038, DexVersion039,
+		DexVersion040, DexVersion041:
+		return version, nil
+	}
+	return 0, fmt.Errorf("unsupported DEX version %03d", version)
+}
+
+// opcodeVersion returns the DEX version that introduced opcode 'op'.
+func opcodeVersion(op uint16) int {
+	switch {
+	case op >= 0xfe:
+		return DexVersion039
+	case op >= 0xfa:
+		return DexVersion038
+	}
+	return DexVersion035
+}
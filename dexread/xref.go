@@ -0,0 +1,165 @@
+package dexread
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// XrefKind says how a method refers to a method, field, string or
+// type.
+type XrefKind uint8
+
+const (
+	// XrefCall is an invoke-* of a method.
+	XrefCall XrefKind = iota
+	// XrefRead is an iget* or sget* of a field.
+	XrefRead
+	// XrefWrite is an iput* or sput* of a field.
+	XrefWrite
+	// XrefRef is any other reference: const-string of a string,
+	// const-class, new-instance, check-cast (and so on) of a type,
+	// or a catch handler for an exception type.
+	XrefRef
+)
+
+func (k XrefKind) String() string {
+	switch k {
+	case XrefCall:
+		return "call"
+	case XrefRead:
+		return "read"
+	case XrefWrite:
+		return "write"
+	case XrefRef:
+		return "ref"
+	}
+	return fmt.Sprintf("XrefKind(%d)", uint8(k))
+}
+
+// Xref is a reference from the code of method Method: the
+// instruction at Offset (in 16-bit code units), or for a catch
+// handler, the handler's address.
+type Xref struct {
+	Method *Method
+	Offset uint32
+	Kind   XrefKind
+}
+
+// xrefIndex holds the xrefs of a DEX file, by method_id, field_id,
+// string_id and type_id index.
+type xrefIndex struct {
+	methods map[uint32][]Xref
+	fields  map[uint32][]Xref
+	strings map[uint32][]Xref
+	types   map[uint32][]Xref
+}
+
+// MethodXrefs returns the xrefs to method_id 'idx': the places that
+// invoke it, in class_defs order. The first call to any of the Xrefs
+// methods decodes the code of every method in the DEX file.
+func (d *DexFile) MethodXrefs(idx uint32) ([]Xref, error) {
+	x, err := d.xrefIndex()
+	if err != nil {
+		return nil, err
+	}
+	return x.methods[idx], nil
+}
+
+// FieldXrefs returns the xrefs to field_id 'idx': the places that
+// read or write it.
+func (d *DexFile) FieldXrefs(idx uint32) ([]Xref, error) {
+	x, err := d.xrefIndex()
+	if err != nil {
+		return nil, err
+	}
+	return x.fields[idx], nil
+}
+
+// StringXrefs returns the xrefs to string_id 'idx'.
+func (d *DexFile) StringXrefs(idx uint32) ([]Xref, error) {
+	x, err := d.xrefIndex()
+	if err != nil {
+		return nil, err
+	}
+	return x.strings[idx], nil
+}
+
+// TypeXrefs returns the xrefs to type_id 'idx'. These are direct
+// references only; calls to methods of the type, for example, are
+// not included.
+func (d *DexFile) TypeXrefs(idx uint32) ([]Xref, error) {
+	x, err := d.xrefIndex()
+	if err != nil {
+		return nil, err
+	}
+	return x.types[idx], nil
+}
+
+func (d *DexFile) xrefIndex() (*xrefIndex, error) {
+	if d.xrefs != nil {
+		return d.xrefs, nil
+	}
+	x := &xrefIndex{
+		methods: make(map[uint32][]Xref),
+		fields:  make(map[uint32][]Xref),
+		strings: make(map[uint32][]Xref),
+		types:   make(map[uint32][]Xref),
+	}
+	// Catch handlers name their exception types by descriptor.
+	var typeIdx map[string]uint32
+	for _, c := range d.classes {
+		for _, m := range c.methods {
+			code, err := m.Code()
+			if err != nil {
+				return nil, err
+			}
+			if code == nil {
+				continue
+			}
+			for i := range code.Insns {
+				insn := &code.Insns[i]
+				switch insn.Kind {
+				case dexapkvisit.IndexMethod:
+					x.methods[insn.Index] = append(x.methods[insn.Index], Xref{m, insn.Offset, XrefCall})
+				case dexapkvisit.IndexField:
+					kind := XrefRead
+					if strings.HasPrefix(insn.Name, "iput") || strings.HasPrefix(insn.Name, "sput") {
+						kind = XrefWrite
+					}
+					x.fields[insn.Index] = append(x.fields[insn.Index], Xref{m, insn.Offset, kind})
+				case dexapkvisit.IndexString:
+					x.strings[insn.Index] = append(x.strings[insn.Index], Xref{m, insn.Offset, XrefRef})
+				case dexapkvisit.IndexType:
+					x.types[insn.Index] = append(x.types[insn.Index], Xref{m, insn.Offset, XrefRef})
+				}
+			}
+			// A handler is often shared by several tries.
+			type handler struct {
+				typ  string
+				addr uint32
+			}
+			seen := make(map[handler]bool)
+			for _, try := range code.Tries {
+				for _, h := range try.Handlers {
+					if h.Type == "" || seen[handler{h.Type, h.Address}] {
+						continue
+					}
+					seen[handler{h.Type, h.Address}] = true
+					if typeIdx == nil {
+						typeIdx = make(map[string]uint32, len(d.state.typeIds))
+						for i := range d.state.typeIds {
+							typeIdx[d.Type(uint32(i))] = uint32(i)
+						}
+					}
+					if idx, ok := typeIdx[h.Type]; ok {
+						x.types[idx] = append(x.types[idx], Xref{m, h.Address, XrefRef})
+					}
+				}
+			}
+		}
+	}
+	d.xrefs = x
+	return x, nil
+}
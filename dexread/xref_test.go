@@ -0,0 +1,105 @@
+package dexread
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// xrefString renders xrefs as "method kind offset" lines.
+func xrefString(xrefs []Xref) string {
+	var lines []string
+	for _, x := range xrefs {
+		lines = append(lines, fmt.Sprintf("%s %s %04x", x.Method.Id.Name, x.Kind, x.Offset))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestXrefs(t *testing.T) {
+	dex, err := Open("testdata/classes.dex")
+	if err != nil {
+		t.Fatalf("Open error %v", err)
+	}
+	lookup := func(n int, name func(uint32) string, want string) uint32 {
+		for i := uint32(0); i < uint32(n); i++ {
+			if name(i) == want {
+				return i
+			}
+		}
+		t.Fatalf("%s not found", want)
+		return 0
+	}
+
+	rfib := lookup(dex.NumMethods(), func(i uint32) string { return dex.Method(i).Name }, "rfibonacci")
+	xrefs, err := dex.MethodXrefs(rfib)
+	if err != nil {
+		t.Fatalf("MethodXrefs error %v", err)
+	}
+	expected := "main call 0014\nmain call 004e\nrcnm1 call 0002\nrcnm2 call 0002"
+	if got := xrefString(xrefs); got != expected {
+		t.Errorf("rfibonacci xrefs: got\n%s\nexpected\n%s", got, expected)
+	}
+
+	out := lookup(dex.NumFields(), func(i uint32) string { return dex.Field(i).Name }, "out")
+	if xrefs, _ := dex.FieldXrefs(out); len(xrefs) != 4 || xrefs[0].Kind != XrefRead || xrefs[0].Method.Id.Name != "main" {
+		t.Errorf("System.out xrefs:\n%s", xrefString(xrefs))
+	}
+	array := lookup(dex.NumTypes(), dex.Type, "[Ljava/lang/Object;")
+	if xrefs, _ := dex.TypeXrefs(array); len(xrefs) != 4 || xrefs[0].Kind != XrefRef {
+		t.Errorf("Object[] xrefs:\n%s", xrefString(xrefs))
+	}
+	strs := dex.Strings()
+	str := lookup(len(strs), func(i uint32) string { return strs[i] }, "19")
+	if xrefs, _ := dex.StringXrefs(str); len(xrefs) != 1 || xrefs[0].Method.Id.Name != "main" {
+		t.Errorf("\"19\" xrefs:\n%s", xrefString(xrefs))
+	}
+	if xrefs, _ := dex.StringXrefs(1234); len(xrefs) != 0 {
+		t.Errorf("out of range StringXrefs:\n%s", xrefString(xrefs))
+	}
+}
+
+func TestXrefsReadWrite(t *testing.T) {
+	const holder = "Lcom/example/Holder;"
+	b := newDexBuilder()
+	count := b.field(holder, "I", "count")
+	name := b.str("name")
+	typ := b.typ("Lcom/example/Other;")
+	p := b.proto("V", "V")
+	public := uint32(dexapkvisit.AccPublic)
+	static := uint32(dexapkvisit.AccPublic | dexapkvisit.AccStatic)
+	returnVoid := uint16(0x000e)
+	b.class(testClass{typ: holder, flags: public, super: "Ljava/lang/Object;",
+		staticFields: []testEncodedField{{count, static}},
+		directMethods: []testEncodedMethod{
+			// sget v0, count; sput v0, count
+			{b.method(holder, "bump", p), static, &testCode{registers: 1,
+				insns: []uint16{0x0060, uint16(count), 0x0067, uint16(count), returnVoid}}},
+			// const-string v0, "name"; const-class v0, Other
+			{b.method(holder, "refs", p), static, &testCode{registers: 1,
+				insns: []uint16{0x001a, uint16(name), 0x001c, uint16(typ), returnVoid}}},
+		}})
+	data := b.build()
+	dex, err := Parse(nil, "test.dex", bytes.NewReader(data), uint64(len(data)))
+	if err != nil {
+		t.Fatalf("Parse error %v", err)
+	}
+	check := func(what string, xrefs []Xref, err error, expected string) {
+		if err != nil {
+			t.Fatalf("%s: error %v", what, err)
+		}
+		if got := xrefString(xrefs); got != expected {
+			t.Errorf("%s: got\n%s\nexpected\n%s", what, got, expected)
+		}
+	}
+	xrefs, err := dex.FieldXrefs(count)
+	check("count", xrefs, err, "bump read 0000\nbump write 0002")
+	xrefs, err = dex.StringXrefs(name)
+	check("name", xrefs, err, "refs ref 0000")
+	xrefs, err = dex.TypeXrefs(typ)
+	check("Other", xrefs, err, "refs ref 0002")
+	xrefs, err = dex.MethodXrefs(0)
+	check("bump", xrefs, err, "")
+}
@@ -0,0 +1,172 @@
+package kotlinmeta
+
+import (
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+//
+// Helpers for unit tests: a protobuf writer, and a builder that wraps
+// the metadata messages up as a kotlin.Metadata annotation the way
+// the Kotlin compiler does (and the DEX reader hands it back).
+//
+
+type pb []byte
+
+func (p pb) varint(v uint64) pb {
+	for v >= 0x80 {
+		p = append(p, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(p, byte(v))
+}
+
+func (p pb) int(num, v int) pb {
+	return p.varint(uint64(num<<3 | wireVarint)).varint(uint64(int64(v)))
+}
+
+func (p pb) bytes(num int, b []byte) pb {
+	p = p.varint(uint64(num<<3 | wireBytes)).varint(uint64(len(b)))
+	return append(p, b...)
+}
+
+func (p pb) msg(num int, m pb) pb {
+	return p.bytes(num, m)
+}
+
+func (p pb) str(num int, s string) pb {
+	return p.bytes(num, []byte(s))
+}
+
+func (p pb) packed(num int, vs ...int) pb {
+	var data pb
+	for _, v := range vs {
+		data = data.varint(uint64(int64(v)))
+	}
+	return p.bytes(num, data)
+}
+
+// metaBuilder collects the d2 strings and the string table records.
+type metaBuilder struct {
+	d2      []string
+	records pb
+	index   map[string]int
+}
+
+func newMetaBuilder() *metaBuilder {
+	return &metaBuilder{index: make(map[string]int)}
+}
+
+// s returns the string table index of 's', adding a plain record for
+// it if needed.
+func (b *metaBuilder) s(s string) int {
+	if i, ok := b.index[s]; ok {
+		return i
+	}
+	b.index[s] = len(b.d2)
+	b.d2 = append(b.d2, s)
+	b.records = b.records.msg(1, nil)
+	return b.index[s]
+}
+
+// predefined returns the index of a record for predefined string 'p'.
+func (b *metaBuilder) predefined(p int) int {
+	b.d2 = append(b.d2, "")
+	b.records = b.records.msg(1, pb(nil).int(2, p))
+	return len(b.d2) - 1
+}
+
+// desc returns the index of a record for the class named by type
+// descriptor 'd'.
+func (b *metaBuilder) desc(d string) int {
+	b.d2 = append(b.d2, d)
+	b.records = b.records.msg(1, pb(nil).int(3, opDescToClassId))
+	return len(b.d2) - 1
+}
+
+// d1 returns the string table followed by 'm', as bytes.
+func (b *metaBuilder) d1(m pb) []byte {
+	data := pb(nil).varint(uint64(len(b.records)))
+	data = append(data, b.records...)
+	return append(data, m...)
+}
+
+// mutf8 encodes bytes (taken as characters) in DEX modified UTF-8.
+func mutf8(b []byte) string {
+	var s []byte
+	for _, c := range b {
+		switch {
+		case c == 0:
+			s = append(s, 0xc0, 0x80)
+		case c < 0x80:
+			s = append(s, c)
+		default:
+			s = append(s, 0xc0|c>>6, 0x80|c&0x3f)
+		}
+	}
+	return string(s)
+}
+
+// utf8ModeD1 encodes 'data' the way current compilers do, splitting it
+// into strings of 'chunk' characters.
+func utf8ModeD1(data []byte, chunk int) []string {
+	data = append([]byte{utf8ModeMarker}, data...)
+	var d1 []string
+	for len(data) > chunk {
+		d1 = append(d1, mutf8(data[:chunk]))
+		data = data[chunk:]
+	}
+	return append(d1, mutf8(data))
+}
+
+// oldD1 encodes 'data' seven bits per character, as older compilers
+// did.
+func oldD1(data []byte) string {
+	var enc []byte
+	var acc, nbits uint
+	for _, c := range data {
+		acc |= uint(c) << nbits
+		nbits += 8
+		for nbits >= 7 {
+			enc = append(enc, byte(acc&0x7f))
+			acc >>= 7
+			nbits -= 7
+		}
+	}
+	if nbits > 0 {
+		enc = append(enc, byte(acc&0x7f))
+	}
+	for i := range enc {
+		enc[i] = (enc[i] + 1) & 0x7f
+	}
+	return mutf8(enc)
+}
+
+func strArray(strs []string) dexapkvisit.EncodedValue {
+	var vals []dexapkvisit.EncodedValue
+	for _, s := range strs {
+		vals = append(vals, dexapkvisit.EncodedValue{Type: dexapkvisit.ValueString, Value: s})
+	}
+	return dexapkvisit.EncodedValue{Type: dexapkvisit.ValueArray, Value: vals}
+}
+
+// annotation wraps d1/d2 up as a kotlin.Metadata annotation of kind 'k'.
+func annotation(k Kind, d1, d2 []string) *dexapkvisit.Annotation {
+	mv := dexapkvisit.EncodedValue{Type: dexapkvisit.ValueArray, Value: []dexapkvisit.EncodedValue{
+		{Type: dexapkvisit.ValueInt, Value: int64(1)},
+		{Type: dexapkvisit.ValueInt, Value: int64(9)},
+		{Type: dexapkvisit.ValueInt, Value: int64(0)},
+	}}
+	return &dexapkvisit.Annotation{
+		Visibility: dexapkvisit.VisibilityRuntime,
+		EncodedAnnotation: dexapkvisit.EncodedAnnotation{
+			Type: MetadataType,
+			Elements: []dexapkvisit.AnnotationElement{
+				{Name: "d1", Value: strArray(d1)},
+				{Name: "d2", Value: strArray(d2)},
+				{Name: "k", Value: dexapkvisit.EncodedValue{Type: dexapkvisit.ValueInt, Value: int64(k)}},
+				{Name: "mv", Value: mv},
+				{Name: "xi", Value: dexapkvisit.EncodedValue{Type: dexapkvisit.ValueInt, Value: int64(48)}},
+			},
+		},
+	}
+}
@@ -0,0 +1,463 @@
+package kotlinmeta
+
+import (
+	"fmt"
+)
+
+// Flag bits, see Flags.java in the Kotlin compiler. All declarations
+// start with HAS_ANNOTATIONS (bit 0), and most continue with
+// visibility (bits 1-3) and modality (bits 4-5).
+const (
+	flagVisibilityShift = 1
+	flagModalityShift   = 4
+
+	classKindShift = 6
+	classInner     = 1 << 9
+	classData      = 1 << 10
+	classExternal  = 1 << 11
+	classExpect    = 1 << 12
+	classValue     = 1 << 13
+	classFun       = 1 << 14
+
+	funOperator = 1 << 8
+	funInfix    = 1 << 9
+	funInline   = 1 << 10
+	funTailrec  = 1 << 11
+	funExternal = 1 << 12
+	funSuspend  = 1 << 13
+
+	propVar       = 1 << 8
+	propGetter    = 1 << 9
+	propSetter    = 1 << 10
+	propConst     = 1 << 11
+	propLateinit  = 1 << 12
+	propDelegated = 1 << 15
+
+	ctorSecondary = 1 << 4
+
+	paramDefault     = 1 << 1
+	paramCrossinline = 1 << 2
+	paramNoinline    = 1 << 3
+
+	typeSuspend = 1 << 0
+)
+
+func visibility(flags int) Visibility {
+	return Visibility(flags >> flagVisibilityShift & 7)
+}
+
+func modality(flags int) Modality {
+	return Modality(flags >> flagModalityShift & 3)
+}
+
+// memberFlags returns the flags of a function or property, which are
+// in 'field', or else in the older layout in field 1.
+func memberFlags(m pbMessage, field, def int) int {
+	if m.has(field) {
+		return m.int(field, def)
+	}
+	old := m.int(1, def)
+	return old&0x3f + (old>>8)<<6
+}
+
+// decoder holds the state needed to decode a metadata message: the
+// string table, the type table in scope (types can be given by index
+// into it) and the names of the type parameters seen so far, which
+// types refer to by id.
+type decoder struct {
+	strings       []string
+	typeTable     []pbMessage
+	firstNullable int
+	typeParams    map[int]string
+	depth         int
+}
+
+// maxTypeDepth limits the nesting of types (through type arguments),
+// which a type table entry that refers to itself would make endless.
+const maxTypeDepth = 100
+
+func (d *decoder) str(idx int) (string, error) {
+	if idx < 0 || idx >= len(d.strings) {
+		return "", fmt.Errorf("string index %d out of range", idx)
+	}
+	return d.strings[idx], nil
+}
+
+func (d *decoder) strs(idxs []int, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	var retval []string
+	for _, i := range idxs {
+		s, err := d.str(i)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, s)
+	}
+	return retval, nil
+}
+
+// withTypeTable installs the TypeTable in field 30 of 'm', if it has
+// one, returning a function that restores the previous table.
+func (d *decoder) withTypeTable(m pbMessage) (func(), error) {
+	saved, savedFirst := d.typeTable, d.firstNullable
+	restore := func() { d.typeTable, d.firstNullable = saved, savedFirst }
+	if !m.has(30) {
+		return restore, nil
+	}
+	tt, err := m.message(30)
+	if err != nil {
+		return restore, err
+	}
+	if d.typeTable, err = tt.messages(1); err != nil {
+		return restore, err
+	}
+	d.firstNullable = tt.int(2, -1)
+	return restore, nil
+}
+
+// typeRef decodes the type in message field 'field' of 'm' or, failing
+// that, the type whose type table index is in field 'idField'. It
+// returns nil if there is neither.
+func (d *decoder) typeRef(m pbMessage, field, idField int) (*Type, error) {
+	if m.has(field) {
+		tm, err := m.message(field)
+		if err != nil {
+			return nil, err
+		}
+		return d.typ(tm)
+	}
+	if !m.has(idField) {
+		return nil, nil
+	}
+	id := m.int(idField, 0)
+	if id < 0 || id >= len(d.typeTable) {
+		return nil, fmt.Errorf("type table index %d out of range", id)
+	}
+	t, err := d.typ(d.typeTable[id])
+	if err == nil && d.firstNullable >= 0 && id >= d.firstNullable {
+		t.Nullable = true
+	}
+	return t, err
+}
+
+func (d *decoder) typ(m pbMessage) (*Type, error) {
+	if d.depth >= maxTypeDepth {
+		return nil, fmt.Errorf("types nested more than %d deep (type table cycle?)", maxTypeDepth)
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+	t := &Type{
+		Nullable: m.bool(3),
+		Suspend:  m.int(1, 0)&typeSuspend != 0,
+	}
+	var err error
+	switch {
+	case m.has(6):
+		t.Class, err = d.str(m.int(6, 0))
+	case m.has(12):
+		t.Class, err = d.str(m.int(12, 0))
+	case m.has(9):
+		t.TypeParameter, err = d.str(m.int(9, 0))
+	case m.has(7):
+		id := m.int(7, 0)
+		if t.TypeParameter = d.typeParams[id]; t.TypeParameter == "" {
+			t.TypeParameter = fmt.Sprintf("T#%d", id)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	args, err := m.messages(2)
+	if err != nil {
+		return nil, err
+	}
+	for _, am := range args {
+		a := TypeArgument{Variance: Variance(am.int(1, int(Invariant)))}
+		if a.Variance != Star {
+			if a.Type, err = d.typeRef(am, 2, 3); err != nil {
+				return nil, err
+			}
+		}
+		t.Arguments = append(t.Arguments, a)
+	}
+	return t, nil
+}
+
+func (d *decoder) typeParameters(m pbMessage, field int) ([]*TypeParameter, error) {
+	msgs, err := m.messages(field)
+	if err != nil {
+		return nil, err
+	}
+	// Register all the names first, since bounds can refer to any
+	// of them (as in <T : Comparable<T>>).
+	var retval []*TypeParameter
+	for _, tm := range msgs {
+		name, err := d.str(tm.int(2, 0))
+		if err != nil {
+			return nil, err
+		}
+		d.typeParams[tm.int(1, 0)] = name
+		retval = append(retval, &TypeParameter{
+			Name:     name,
+			Variance: Variance(tm.int(4, int(Invariant))),
+			Reified:  tm.bool(3),
+		})
+	}
+	for i, tm := range msgs {
+		bounds, err := tm.messages(5)
+		if err != nil {
+			return nil, err
+		}
+		for _, bm := range bounds {
+			b, err := d.typ(bm)
+			if err != nil {
+				return nil, err
+			}
+			retval[i].UpperBounds = append(retval[i].UpperBounds, b)
+		}
+		ids, err := tm.ints(6)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if id < 0 || id >= len(d.typeTable) {
+				return nil, fmt.Errorf("type table index %d out of range", id)
+			}
+			b, err := d.typ(d.typeTable[id])
+			if err != nil {
+				return nil, err
+			}
+			retval[i].UpperBounds = append(retval[i].UpperBounds, b)
+		}
+	}
+	return retval, nil
+}
+
+func (d *decoder) valueParameters(m pbMessage, field int) ([]*ValueParameter, error) {
+	msgs, err := m.messages(field)
+	if err != nil {
+		return nil, err
+	}
+	var retval []*ValueParameter
+	for _, pm := range msgs {
+		flags := pm.int(1, 0)
+		p := &ValueParameter{
+			HasDefaultValue: flags&paramDefault != 0,
+			Crossinline:     flags&paramCrossinline != 0,
+			Noinline:        flags&paramNoinline != 0,
+		}
+		if p.Name, err = d.str(pm.int(2, 0)); err != nil {
+			return nil, err
+		}
+		// For a vararg parameter, show the element type, as in
+		// the source.
+		if pm.has(4) || pm.has(6) {
+			p.Vararg = true
+			p.Type, err = d.typeRef(pm, 4, 6)
+		} else {
+			p.Type, err = d.typeRef(pm, 3, 5)
+		}
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, p)
+	}
+	return retval, nil
+}
+
+func (d *decoder) function(m pbMessage) (*Function, error) {
+	restore, err := d.withTypeTable(m)
+	defer restore()
+	if err != nil {
+		return nil, err
+	}
+	flags := memberFlags(m, 9, 6)
+	f := &Function{
+		Visibility: visibility(flags),
+		Modality:   modality(flags),
+		Operator:   flags&funOperator != 0,
+		Infix:      flags&funInfix != 0,
+		Inline:     flags&funInline != 0,
+		Tailrec:    flags&funTailrec != 0,
+		External:   flags&funExternal != 0,
+		Suspend:    flags&funSuspend != 0,
+	}
+	if f.Name, err = d.str(m.int(2, 0)); err != nil {
+		return nil, err
+	}
+	if f.TypeParameters, err = d.typeParameters(m, 4); err != nil {
+		return nil, err
+	}
+	if f.Receiver, err = d.typeRef(m, 5, 8); err != nil {
+		return nil, err
+	}
+	if f.Parameters, err = d.valueParameters(m, 6); err != nil {
+		return nil, err
+	}
+	if f.ReturnType, err = d.typeRef(m, 3, 7); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d *decoder) property(m pbMessage) (*Property, error) {
+	flags := memberFlags(m, 11, 518)
+	p := &Property{
+		Visibility: visibility(flags),
+		Modality:   modality(flags),
+		Var:        flags&propVar != 0,
+		HasGetter:  flags&propGetter != 0,
+		HasSetter:  flags&propSetter != 0,
+		Const:      flags&propConst != 0,
+		Lateinit:   flags&propLateinit != 0,
+		Delegated:  flags&propDelegated != 0,
+	}
+	var err error
+	if p.Name, err = d.str(m.int(2, 0)); err != nil {
+		return nil, err
+	}
+	if p.TypeParameters, err = d.typeParameters(m, 4); err != nil {
+		return nil, err
+	}
+	if p.Receiver, err = d.typeRef(m, 5, 10); err != nil {
+		return nil, err
+	}
+	if p.ReturnType, err = d.typeRef(m, 3, 9); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// members decodes the functions and properties in fields 'ffield' and
+// 'pfield' of 'm'.
+func (d *decoder) members(m pbMessage, ffield, pfield int) ([]*Function, []*Property, error) {
+	fmsgs, err := m.messages(ffield)
+	if err != nil {
+		return nil, nil, err
+	}
+	var funcs []*Function
+	for _, fm := range fmsgs {
+		f, err := d.function(fm)
+		if err != nil {
+			return nil, nil, err
+		}
+		funcs = append(funcs, f)
+	}
+	pmsgs, err := m.messages(pfield)
+	if err != nil {
+		return nil, nil, err
+	}
+	var props []*Property
+	for _, pm := range pmsgs {
+		p, err := d.property(pm)
+		if err != nil {
+			return nil, nil, err
+		}
+		props = append(props, p)
+	}
+	return funcs, props, nil
+}
+
+func (d *decoder) pkg(m pbMessage) (*Package, error) {
+	restore, err := d.withTypeTable(m)
+	defer restore()
+	if err != nil {
+		return nil, err
+	}
+	p := &Package{}
+	if p.Functions, p.Properties, err = d.members(m, 3, 4); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (d *decoder) class(m pbMessage) (*Class, error) {
+	restore, err := d.withTypeTable(m)
+	defer restore()
+	if err != nil {
+		return nil, err
+	}
+	flags := m.int(1, 6)
+	c := &Class{
+		Kind:       ClassKind(flags >> classKindShift & 7),
+		Visibility: visibility(flags),
+		Modality:   modality(flags),
+		Inner:      flags&classInner != 0,
+		Data:       flags&classData != 0,
+		External:   flags&classExternal != 0,
+		Expect:     flags&classExpect != 0,
+		Value:      flags&classValue != 0,
+		Fun:        flags&classFun != 0,
+	}
+	if c.Name, err = d.str(m.int(3, 0)); err != nil {
+		return nil, err
+	}
+	if m.has(4) {
+		if c.CompanionObject, err = d.str(m.int(4, 0)); err != nil {
+			return nil, err
+		}
+	}
+	if c.TypeParameters, err = d.typeParameters(m, 5); err != nil {
+		return nil, err
+	}
+	supers, err := m.messages(6)
+	if err != nil {
+		return nil, err
+	}
+	for _, sm := range supers {
+		t, err := d.typ(sm)
+		if err != nil {
+			return nil, err
+		}
+		c.Supertypes = append(c.Supertypes, t)
+	}
+	superIds, err := m.ints(2)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range superIds {
+		if id < 0 || id >= len(d.typeTable) {
+			return nil, fmt.Errorf("type table index %d out of range", id)
+		}
+		t, err := d.typ(d.typeTable[id])
+		if err != nil {
+			return nil, err
+		}
+		c.Supertypes = append(c.Supertypes, t)
+	}
+	if c.NestedClasses, err = d.strs(m.ints(7)); err != nil {
+		return nil, err
+	}
+	if c.SealedSubclasses, err = d.strs(m.ints(16)); err != nil {
+		return nil, err
+	}
+	entries, err := m.messages(13)
+	if err != nil {
+		return nil, err
+	}
+	for _, em := range entries {
+		name, err := d.str(em.int(1, 0))
+		if err != nil {
+			return nil, err
+		}
+		c.EnumEntries = append(c.EnumEntries, name)
+	}
+	ctors, err := m.messages(8)
+	if err != nil {
+		return nil, err
+	}
+	for _, cm := range ctors {
+		cflags := cm.int(1, 6)
+		ctor := &Constructor{Visibility: visibility(cflags), Secondary: cflags&ctorSecondary != 0}
+		if ctor.Parameters, err = d.valueParameters(cm, 2); err != nil {
+			return nil, err
+		}
+		c.Constructors = append(c.Constructors, ctor)
+	}
+	if c.Functions, c.Properties, err = d.members(m, 9, 10); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,535 @@
+//
+// Package for decoding the kotlin.Metadata annotation that the Kotlin
+// compiler attaches to every class it generates. The annotation's d1
+// strings carry a protobuf message describing the Kotlin-level view of
+// the class (its kind, Kotlin function and property names, nullable
+// types, suspend functions and so on) and d2 the strings that message
+// refers to. The message layout is in metadata.proto and
+// jvm_metadata.proto in the Kotlin compiler sources; this package
+// decodes the commonly useful parts of it.
+//
+package kotlinmeta
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// MetadataType is the type descriptor of the kotlin.Metadata annotation.
+const MetadataType = "Lkotlin/Metadata;"
+
+// Kind is the kind of class file, the "k" element of kotlin.Metadata.
+type Kind int
+
+const (
+	KindClass                Kind = 1
+	KindFile                 Kind = 2
+	KindSyntheticClass       Kind = 3
+	KindMultiFileClassFacade Kind = 4
+	KindMultiFileClassPart   Kind = 5
+)
+
+var kindNames = map[Kind]string{
+	KindClass:                "class",
+	KindFile:                 "file facade",
+	KindSyntheticClass:       "synthetic class",
+	KindMultiFileClassFacade: "multi-file class facade",
+	KindMultiFileClassPart:   "multi-file class part",
+}
+
+func (k Kind) String() string {
+	if n, ok := kindNames[k]; ok {
+		return n
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Metadata is a decoded kotlin.Metadata annotation. Class is set for
+// KindClass, Package (the top-level declarations of a file) for
+// KindFile and KindMultiFileClassPart, and Lambda for a
+// KindSyntheticClass that implements a lambda. A multi-file class
+// facade just lists the class names of its Parts.
+type Metadata struct {
+	Kind        Kind
+	Version     []int
+	PackageName string
+	ExtraString string
+	ExtraInt    int
+
+	Class   *Class
+	Package *Package
+	Lambda  *Function
+	Parts   []string
+}
+
+// Visibility is the Kotlin visibility of a declaration.
+type Visibility int
+
+const (
+	Internal Visibility = iota
+	Private
+	Protected
+	Public
+	PrivateToThis
+	Local
+)
+
+func (v Visibility) String() string {
+	switch v {
+	case Internal:
+		return "internal"
+	case Private, PrivateToThis:
+		return "private"
+	case Protected:
+		return "protected"
+	case Public:
+		return "public"
+	case Local:
+		return "local"
+	}
+	return fmt.Sprintf("Visibility(%d)", int(v))
+}
+
+// Modality says whether a declaration can be overridden.
+type Modality int
+
+const (
+	Final Modality = iota
+	Open
+	Abstract
+	Sealed
+)
+
+func (m Modality) String() string {
+	switch m {
+	case Final:
+		return "final"
+	case Open:
+		return "open"
+	case Abstract:
+		return "abstract"
+	case Sealed:
+		return "sealed"
+	}
+	return fmt.Sprintf("Modality(%d)", int(m))
+}
+
+// ClassKind is the Kotlin kind of a class.
+type ClassKind int
+
+const (
+	ClassKindClass ClassKind = iota
+	ClassKindInterface
+	ClassKindEnumClass
+	ClassKindEnumEntry
+	ClassKindAnnotationClass
+	ClassKindObject
+	ClassKindCompanionObject
+)
+
+var classKindNames = []string{
+	"class", "interface", "enum class", "enum entry", "annotation class", "object", "companion object",
+}
+
+func (k ClassKind) String() string {
+	if k >= 0 && int(k) < len(classKindNames) {
+		return classKindNames[k]
+	}
+	return fmt.Sprintf("ClassKind(%d)", int(k))
+}
+
+// Variance is the declared variance of a type parameter or the
+// projection of a type argument.
+type Variance int
+
+const (
+	In Variance = iota
+	Out
+	Invariant
+	Star
+)
+
+// Type is a Kotlin type: either a class (Class is a name like
+// "kotlin/collections/List", with '.' separating nested classes) with
+// type Arguments, or a reference to a type parameter.
+type Type struct {
+	Class         string
+	TypeParameter string
+	Arguments     []TypeArgument
+	Nullable      bool
+	Suspend       bool
+}
+
+// TypeArgument is an argument of a generic type; Type is nil for a
+// star projection.
+type TypeArgument struct {
+	Variance Variance
+	Type     *Type
+}
+
+// ClassName turns a Kotlin class name into the dotted form used in
+// Kotlin source.
+func ClassName(name string) string {
+	return strings.Replace(name, "/", ".", -1)
+}
+
+func (t *Type) String() string {
+	if t == nil {
+		return "?"
+	}
+	s := t.TypeParameter
+	if s == "" {
+		s = ClassName(t.Class)
+	}
+	if len(t.Arguments) != 0 {
+		args := make([]string, len(t.Arguments))
+		for i, a := range t.Arguments {
+			switch {
+			case a.Variance == Star || a.Type == nil:
+				args[i] = "*"
+			case a.Variance == In:
+				args[i] = "in " + a.Type.String()
+			case a.Variance == Out:
+				args[i] = "out " + a.Type.String()
+			default:
+				args[i] = a.Type.String()
+			}
+		}
+		s += "<" + strings.Join(args, ", ") + ">"
+	}
+	if t.Nullable {
+		s += "?"
+	}
+	if t.Suspend {
+		s = "suspend " + s
+	}
+	return s
+}
+
+// TypeParameter is a type parameter of a class, function or property.
+type TypeParameter struct {
+	Name        string
+	Variance    Variance
+	Reified     bool
+	UpperBounds []*Type
+}
+
+func (p *TypeParameter) String() string {
+	s := p.Name
+	switch p.Variance {
+	case In:
+		s = "in " + s
+	case Out:
+		s = "out " + s
+	}
+	if p.Reified {
+		s = "reified " + s
+	}
+	if len(p.UpperBounds) != 0 {
+		s += " : " + p.UpperBounds[0].String()
+	}
+	return s
+}
+
+// ValueParameter is a parameter of a function or constructor.
+type ValueParameter struct {
+	Name            string
+	Type            *Type
+	Vararg          bool
+	HasDefaultValue bool
+	Crossinline     bool
+	Noinline        bool
+}
+
+func (p *ValueParameter) String() string {
+	s := p.Name + ": " + p.Type.String()
+	if p.Vararg {
+		s = "vararg " + s
+	}
+	if p.Noinline {
+		s = "noinline " + s
+	}
+	if p.Crossinline {
+		s = "crossinline " + s
+	}
+	if p.HasDefaultValue {
+		s += " = ..."
+	}
+	return s
+}
+
+// Function is a Kotlin function. Receiver is set for extension
+// functions.
+type Function struct {
+	Name           string
+	Visibility     Visibility
+	Modality       Modality
+	Suspend        bool
+	Inline         bool
+	Operator       bool
+	Infix          bool
+	Tailrec        bool
+	External       bool
+	TypeParameters []*TypeParameter
+	Receiver       *Type
+	Parameters     []*ValueParameter
+	ReturnType     *Type
+}
+
+func (f *Function) String() string {
+	mods := []string{f.Visibility.String(), f.Modality.String()}
+	for _, m := range []struct {
+		set  bool
+		name string
+	}{
+		{f.External, "external"}, {f.Tailrec, "tailrec"}, {f.Inline, "inline"},
+		{f.Infix, "infix"}, {f.Operator, "operator"}, {f.Suspend, "suspend"},
+	} {
+		if m.set {
+			mods = append(mods, m.name)
+		}
+	}
+	return strings.Join(mods, " ") + " fun " + typeParams(f.TypeParameters) +
+		receiver(f.Receiver) + f.Name + "(" + params(f.Parameters) + "): " + f.ReturnType.String()
+}
+
+// Property is a Kotlin property.
+type Property struct {
+	Name           string
+	Visibility     Visibility
+	Modality       Modality
+	Var            bool
+	Const          bool
+	Lateinit       bool
+	Delegated      bool
+	HasGetter      bool
+	HasSetter      bool
+	TypeParameters []*TypeParameter
+	Receiver       *Type
+	ReturnType     *Type
+}
+
+func (p *Property) String() string {
+	mods := []string{p.Visibility.String(), p.Modality.String()}
+	if p.Const {
+		mods = append(mods, "const")
+	}
+	if p.Lateinit {
+		mods = append(mods, "lateinit")
+	}
+	kw := "val"
+	if p.Var {
+		kw = "var"
+	}
+	s := strings.Join(mods, " ") + " " + kw + " " + typeParams(p.TypeParameters) +
+		receiver(p.Receiver) + p.Name + ": " + p.ReturnType.String()
+	if p.Delegated {
+		s += " by ..."
+	}
+	return s
+}
+
+// Constructor is a constructor of a Kotlin class.
+type Constructor struct {
+	Visibility Visibility
+	Secondary  bool
+	Parameters []*ValueParameter
+}
+
+func (c *Constructor) String() string {
+	return c.Visibility.String() + " constructor(" + params(c.Parameters) + ")"
+}
+
+// Class is the Kotlin view of a class. Names (of the class itself,
+// its supertypes, nested classes and so on) use '/' to separate
+// packages and '.' to separate nested classes.
+type Class struct {
+	Name             string
+	Kind             ClassKind
+	Visibility       Visibility
+	Modality         Modality
+	Inner            bool
+	Data             bool
+	External         bool
+	Expect           bool
+	Value            bool
+	Fun              bool
+	TypeParameters   []*TypeParameter
+	Supertypes       []*Type
+	CompanionObject  string
+	NestedClasses    []string
+	EnumEntries      []string
+	SealedSubclasses []string
+	Constructors     []*Constructor
+	Functions        []*Function
+	Properties       []*Property
+}
+
+func (c *Class) String() string {
+	mods := []string{c.Visibility.String()}
+	if c.Kind != ClassKindInterface && c.Kind != ClassKindAnnotationClass {
+		mods = append(mods, c.Modality.String())
+	}
+	for _, m := range []struct {
+		set  bool
+		name string
+	}{
+		{c.External, "external"}, {c.Expect, "expect"}, {c.Inner, "inner"},
+		{c.Data, "data"}, {c.Value, "value"}, {c.Fun, "fun"},
+	} {
+		if m.set {
+			mods = append(mods, m.name)
+		}
+	}
+	s := strings.Join(mods, " ") + " " + c.Kind.String() + " " + ClassName(c.Name)
+	if len(c.TypeParameters) != 0 {
+		s += typeParams(c.TypeParameters)
+	}
+	if len(c.Supertypes) != 0 {
+		supers := make([]string, len(c.Supertypes))
+		for i, t := range c.Supertypes {
+			supers[i] = t.String()
+		}
+		s += " : " + strings.Join(supers, ", ")
+	}
+	return s
+}
+
+// Package holds the top-level declarations of a Kotlin source file
+// (or part of a multi-file class).
+type Package struct {
+	Functions  []*Function
+	Properties []*Property
+}
+
+func typeParams(tps []*TypeParameter) string {
+	if len(tps) == 0 {
+		return ""
+	}
+	s := make([]string, len(tps))
+	for i, tp := range tps {
+		s[i] = tp.String()
+	}
+	return "<" + strings.Join(s, ", ") + "> "
+}
+
+func receiver(t *Type) string {
+	if t == nil {
+		return ""
+	}
+	return t.String() + "."
+}
+
+func params(ps []*ValueParameter) string {
+	s := make([]string, len(ps))
+	for i, p := range ps {
+		s[i] = p.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// Find decodes the kotlin.Metadata annotation among 'annos' (a class's
+// annotations). It returns nil (and no error) if there isn't one.
+func Find(annos []*dexapkvisit.Annotation) (*Metadata, error) {
+	if a := dexapkvisit.FindAnnotation(annos, MetadataType); a != nil {
+		return Decode(a)
+	}
+	return nil, nil
+}
+
+// Decode decodes a kotlin.Metadata annotation.
+func Decode(a *dexapkvisit.Annotation) (*Metadata, error) {
+	if a.Type != MetadataType {
+		return nil, fmt.Errorf("%s is not a kotlin.Metadata annotation", a.Type)
+	}
+	md := &Metadata{Kind: KindClass}
+	var d1, d2 []string
+	for _, e := range a.Elements {
+		v := &e.Value
+		switch e.Name {
+		case "k":
+			md.Kind = Kind(intValue(v))
+		case "xi":
+			md.ExtraInt = int(intValue(v))
+		case "mv":
+			for _, x := range arrayValue(v) {
+				md.Version = append(md.Version, int(intValue(&x)))
+			}
+		case "d1", "d2":
+			var strs []string
+			for _, x := range arrayValue(v) {
+				s, _ := x.Value.(string)
+				strs = append(strs, s)
+			}
+			if e.Name == "d1" {
+				d1 = strs
+			} else {
+				d2 = strs
+			}
+		case "xs", "pn":
+			s, _ := v.Value.(string)
+			if e.Name == "xs" {
+				md.ExtraString = mutf8ToString(s)
+			} else {
+				md.PackageName = mutf8ToString(s)
+			}
+		}
+	}
+
+	var err error
+	switch md.Kind {
+	case KindMultiFileClassFacade:
+		for _, s := range d1 {
+			md.Parts = append(md.Parts, mutf8ToString(s))
+		}
+	case KindClass, KindFile, KindMultiFileClassPart, KindSyntheticClass:
+		if len(d1) == 0 {
+			break
+		}
+		err = md.decodeProto(decodeD1(d1), d2)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kotlin.Metadata (%s): %v", md.Kind, err)
+	}
+	return md, nil
+}
+
+func intValue(v *dexapkvisit.EncodedValue) int64 {
+	i, _ := v.Value.(int64)
+	return i
+}
+
+func arrayValue(v *dexapkvisit.EncodedValue) []dexapkvisit.EncodedValue {
+	a, _ := v.Value.([]dexapkvisit.EncodedValue)
+	return a
+}
+
+// decodeProto decodes the d1 protobuf data, a StringTableTypes message
+// (length-prefixed) followed by a Class, Package or Function message
+// depending on the kind.
+func (md *Metadata) decodeProto(data []byte, d2 []string) error {
+	types, rest, err := parseDelimited(data)
+	if err != nil {
+		return err
+	}
+	strs, err := readStringTable(types, d2)
+	if err != nil {
+		return err
+	}
+	msg, err := parseMessage(rest)
+	if err != nil {
+		return err
+	}
+	d := &decoder{strings: strs, typeParams: make(map[int]string)}
+	switch md.Kind {
+	case KindClass:
+		md.Class, err = d.class(msg)
+	case KindSyntheticClass:
+		md.Lambda, err = d.function(msg)
+	default:
+		md.Package, err = d.pkg(msg)
+	}
+	return err
+}
@@ -0,0 +1,189 @@
+package kotlinmeta
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/dexapkvisit"
+)
+
+// Indices of some of the predefined strings.
+const (
+	preAny        = 0
+	preUnit       = 2
+	preInt        = 8
+	preString     = 14
+	preComparable = 15
+	preList       = 32
+)
+
+func classType(name int) pb {
+	return pb(nil).int(6, name)
+}
+
+func valueParam(flags, name int, typ pb) pb {
+	return pb(nil).int(1, flags).int(2, name).msg(3, typ)
+}
+
+func userClass() (*metaBuilder, pb) {
+	b := newMetaBuilder()
+	str, num := classType(b.predefined(preString)), classType(b.predefined(preInt))
+	nullableStr := pb(nil).int(3, 1).int(6, b.predefined(preString))
+
+	// <T : Comparable<T>>, with T used as a vararg and in List<out T>
+	tref := pb(nil).int(7, 0)
+	comparable := classType(b.predefined(preComparable)).msg(2, pb(nil).msg(2, tref))
+	tparam := pb(nil).int(1, 0).int(2, b.s("T")).msg(5, comparable)
+	list := classType(b.predefined(preList)).msg(2, pb(nil).int(1, int(Out)).msg(2, tref))
+	varargs := pb(nil).int(2, b.s("items")).msg(3, classType(b.s("kotlin/Array"))).msg(4, tref)
+
+	return b, pb(nil).
+		int(1, 6|classData).
+		int(3, b.s("com/example/User")).
+		int(4, b.s("Companion")).
+		msg(6, classType(b.predefined(preAny))).
+		msg(6, classType(b.desc("Lcom/example/Base$Impl;"))).
+		packed(7, b.s("Companion")).
+		msg(8, pb(nil).int(1, 6).
+			msg(2, valueParam(0, b.s("name"), str)).
+			msg(2, valueParam(paramDefault, b.s("age"), num))).
+		msg(9, pb(nil).int(9, 6|funSuspend).int(2, b.s("load")).
+			msg(3, nullableStr).
+			msg(6, valueParam(0, b.s("id"), num))).
+		msg(9, pb(nil).int(9, 6).int(2, b.s("sortedBy")).
+			msg(3, list).
+			msg(4, tparam).
+			msg(6, varargs)).
+		msg(10, pb(nil).int(11, 518).int(2, b.s("name")).msg(3, str)).
+		msg(10, pb(nil).int(11, 518|propVar|propSetter).int(2, b.s("nick")).msg(3, nullableStr))
+}
+
+func TestDecodeClass(t *testing.T) {
+	b, cls := userClass()
+	// Short strings, so that the data is split up.
+	md, err := Decode(annotation(KindClass, utf8ModeD1(b.d1(cls), 7), b.d2))
+	if err != nil {
+		t.Fatalf("Decode error %v", err)
+	}
+	if md.Kind != KindClass || md.Class == nil || len(md.Version) != 3 || md.Version[1] != 9 || md.ExtraInt != 48 {
+		t.Fatalf("unexpected metadata %+v", md)
+	}
+	c := md.Class
+	lines := []string{c.String()}
+	for _, ctor := range c.Constructors {
+		lines = append(lines, ctor.String())
+	}
+	for _, p := range c.Properties {
+		lines = append(lines, p.String())
+	}
+	for _, f := range c.Functions {
+		lines = append(lines, f.String())
+	}
+	actual := strings.Join(lines, "\n")
+	expected := `public final data class com.example.User : kotlin.Any, com.example.Base.Impl
+public constructor(name: kotlin.String, age: kotlin.Int = ...)
+public final val name: kotlin.String
+public final var nick: kotlin.String?
+public final suspend fun load(id: kotlin.Int): kotlin.String?
+public final fun <T : kotlin.Comparable<T>> sortedBy(vararg items: T): kotlin.collections.List<out T>`
+	if actual != expected {
+		t.Errorf("got\n%s\nexpected\n%s", actual, expected)
+	}
+	if c.CompanionObject != "Companion" || len(c.NestedClasses) != 1 || c.Kind != ClassKindClass {
+		t.Errorf("unexpected class %+v", c)
+	}
+	if !c.Properties[1].HasSetter || c.Properties[0].HasSetter || !c.Properties[0].HasGetter {
+		t.Errorf("unexpected accessors %+v %+v", c.Properties[0], c.Properties[1])
+	}
+
+	// Find goes via the class's annotations.
+	annos := []*dexapkvisit.Annotation{annotation(KindClass, utf8ModeD1(b.d1(cls), 1000), b.d2)}
+	if md, err := Find(annos); err != nil || md.Class.Name != "com/example/User" {
+		t.Errorf("Find got %v, %v", md, err)
+	}
+	if md, err := Find(nil); md != nil || err != nil {
+		t.Errorf("Find(nil) got %v, %v", md, err)
+	}
+}
+
+func TestDecodeFileFacade(t *testing.T) {
+	b := newMetaBuilder()
+	str := classType(b.predefined(preString))
+	unit := classType(b.predefined(preUnit))
+	pkg := pb(nil).
+		msg(3, pb(nil).int(9, 6|funInline).int(2, b.s("shout")).msg(3, str).msg(5, str)).
+		msg(3, pb(nil).int(9, 2).int(2, b.s("log")).msg(3, unit).
+			msg(6, pb(nil).int(1, paramNoinline).int(2, b.s("msg")).int(5, 0))).
+		msg(4, pb(nil).int(11, 518|propConst).int(2, b.s("MAX")).int(9, 0)).
+		msg(30, pb(nil).msg(1, classType(b.predefined(preInt))).int(2, 0))
+
+	// Older compilers packed the bytes seven bits to a character.
+	a := annotation(KindFile, []string{oldD1(b.d1(pkg))}, b.d2)
+	a.Elements = append(a.Elements, dexapkvisit.AnnotationElement{
+		Name: "pn", Value: dexapkvisit.EncodedValue{Type: dexapkvisit.ValueString, Value: "com.example"}})
+	md, err := Decode(a)
+	if err != nil {
+		t.Fatalf("Decode error %v", err)
+	}
+	if md.Package == nil || md.PackageName != "com.example" {
+		t.Fatalf("unexpected metadata %+v", md)
+	}
+	var lines []string
+	for _, f := range md.Package.Functions {
+		lines = append(lines, f.String())
+	}
+	for _, p := range md.Package.Properties {
+		lines = append(lines, p.String())
+	}
+	actual := strings.Join(lines, "\n")
+	expected := `public final inline fun kotlin.String.shout(): kotlin.String
+private final fun log(noinline msg: kotlin.Int?): kotlin.Unit
+public final const val MAX: kotlin.Int?`
+	if actual != expected {
+		t.Errorf("got\n%s\nexpected\n%s", actual, expected)
+	}
+}
+
+func TestDecodeFacade(t *testing.T) {
+	parts := []string{"com/example/UtilsKt__StringsKt", "com/example/UtilsKt__ListsKt"}
+	md, err := Decode(annotation(KindMultiFileClassFacade, parts, nil))
+	if err != nil {
+		t.Fatalf("Decode error %v", err)
+	}
+	if strings.Join(md.Parts, " ") != strings.Join(parts, " ") || md.Kind.String() != "multi-file class facade" {
+		t.Errorf("unexpected metadata %+v", md)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	b, cls := userClass()
+	good := b.d1(cls)
+	// Type 0 of the type table has itself as a type argument (and is
+	// the supertype and a type parameter's upper bound).
+	loop := pb(nil).
+		int(1, 6).
+		int(3, b.s("com/example/Loop")).
+		msg(5, pb(nil).int(1, 0).int(2, b.s("T")).packed(6, 0)).
+		packed(2, 0).
+		msg(30, pb(nil).msg(1, classType(b.predefined(preList)).msg(2, pb(nil).int(3, 0))))
+	tests := []struct {
+		name string
+		a    *dexapkvisit.Annotation
+		err  string
+	}{
+		{"cycle", annotation(KindClass, utf8ModeD1(b.d1(loop), 1000), b.d2),
+			"nested more than 100 deep"},
+		{"type", &dexapkvisit.Annotation{EncodedAnnotation: dexapkvisit.EncodedAnnotation{Type: "Lkotlin/jvm/JvmName;"}},
+			"not a kotlin.Metadata annotation"},
+		{"truncated", annotation(KindClass, utf8ModeD1(good[:len(good)-3], 1000), b.d2),
+			"truncated protobuf message"},
+		{"strings", annotation(KindClass, utf8ModeD1(good, 1000), b.d2[:3]),
+			"has no string"},
+	}
+	for _, tc := range tests {
+		_, err := Decode(tc.a)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, expected %q", tc.name, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package kotlinmeta
+
+import (
+	"errors"
+	"fmt"
+)
+
+//
+// Just enough of the protobuf wire format to read the Kotlin metadata
+// messages, see https://protobuf.dev/programming-guides/encoding/
+// A message is split into its fields up front; nested messages are
+// only parsed when asked for, so that fields can be consumed in
+// whatever order suits the decoder.
+//
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+type pbField struct {
+	num  int
+	wire int
+	val  uint64
+	data []byte
+}
+
+type pbMessage []pbField
+
+func readVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errTruncated
+}
+
+func parseMessage(b []byte) (pbMessage, error) {
+	var msg pbMessage
+	for len(b) != 0 {
+		tag, n, err := readVarint(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[n:]
+		f := pbField{num: int(tag >> 3), wire: int(tag & 7)}
+		switch f.wire {
+		case wireVarint:
+			if f.val, n, err = readVarint(b); err != nil {
+				return nil, err
+			}
+		case wireFixed64, wireFixed32:
+			n = 8
+			if f.wire == wireFixed32 {
+				n = 4
+			}
+			if len(b) < n {
+				return nil, errTruncated
+			}
+		case wireBytes:
+			l, ln, err := readVarint(b)
+			if err != nil {
+				return nil, err
+			}
+			if l > uint64(len(b)-ln) {
+				return nil, errTruncated
+			}
+			f.data = b[ln : ln+int(l)]
+			n = ln + int(l)
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d (field %d)", f.wire, f.num)
+		}
+		b = b[n:]
+		msg = append(msg, f)
+	}
+	return msg, nil
+}
+
+// parseDelimited parses a length-prefixed message from the front of
+// 'b', returning it along with the rest of 'b'.
+func parseDelimited(b []byte) (pbMessage, []byte, error) {
+	l, n, err := readVarint(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if l > uint64(len(b)-n) {
+		return nil, nil, errTruncated
+	}
+	msg, err := parseMessage(b[n : n+int(l)])
+	return msg, b[n+int(l):], err
+}
+
+func (m pbMessage) has(num int) bool {
+	for _, f := range m {
+		if f.num == num {
+			return true
+		}
+	}
+	return false
+}
+
+// int returns the (last) value of int32/enum/bool field 'num', or
+// 'def' if it is absent.
+func (m pbMessage) int(num int, def int) int {
+	for _, f := range m {
+		if f.num == num && f.wire == wireVarint {
+			def = int(int32(f.val))
+		}
+	}
+	return def
+}
+
+func (m pbMessage) bool(num int) bool {
+	return m.int(num, 0) != 0
+}
+
+func (m pbMessage) str(num int) string {
+	for _, f := range m {
+		if f.num == num && f.wire == wireBytes {
+			return string(f.data)
+		}
+	}
+	return ""
+}
+
+// ints returns the values of repeated int32 field 'num', which may be
+// packed or not.
+func (m pbMessage) ints(num int) ([]int, error) {
+	var vals []int
+	for _, f := range m {
+		if f.num != num {
+			continue
+		}
+		switch f.wire {
+		case wireVarint:
+			vals = append(vals, int(int32(f.val)))
+		case wireBytes:
+			for b := f.data; len(b) != 0; {
+				v, n, err := readVarint(b)
+				if err != nil {
+					return nil, err
+				}
+				vals = append(vals, int(int32(v)))
+				b = b[n:]
+			}
+		}
+	}
+	return vals, nil
+}
+
+// messages returns the values of repeated message field 'num'.
+func (m pbMessage) messages(num int) ([]pbMessage, error) {
+	var msgs []pbMessage
+	for _, f := range m {
+		if f.num == num && f.wire == wireBytes {
+			sub, err := parseMessage(f.data)
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, sub)
+		}
+	}
+	return msgs, nil
+}
+
+// message returns the value of message field 'num', or nil.
+func (m pbMessage) message(num int) (pbMessage, error) {
+	msgs, err := m.messages(num)
+	if err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+	return msgs[len(msgs)-1], nil
+}
@@ -0,0 +1,181 @@
+package kotlinmeta
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// decodeMUTF8 turns a DEX "modified" UTF-8 string into UTF-16 code
+// units, see
+// https://source.android.com/devices/tech/dalvik/dex-format.html#mutf-8
+func decodeMUTF8(s string) []uint16 {
+	var units []uint16
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c < 0x80:
+			units = append(units, uint16(c))
+			i++
+		case c&0xe0 == 0xc0 && i+1 < len(s):
+			units = append(units, uint16(c&0x1f)<<6|uint16(s[i+1]&0x3f))
+			i += 2
+		case c&0xf0 == 0xe0 && i+2 < len(s):
+			units = append(units, uint16(c&0x0f)<<12|uint16(s[i+1]&0x3f)<<6|uint16(s[i+2]&0x3f))
+			i += 3
+		default:
+			units = append(units, unicode.ReplacementChar)
+			i++
+		}
+	}
+	return units
+}
+
+func mutf8ToString(s string) string {
+	return string(utf16.Decode(decodeMUTF8(s)))
+}
+
+// The d1 strings normally hold one byte per character, flagged by a
+// leading NUL character; older compilers instead packed the bytes
+// seven bits per character. This mirrors BitEncoding.decodeBytes in
+// the Kotlin compiler.
+const (
+	utf8ModeMarker = 0x0000
+	oldMarker      = 0xffff
+)
+
+func decodeD1(d1 []string) []byte {
+	units := make([][]uint16, len(d1))
+	for i, s := range d1 {
+		units[i] = decodeMUTF8(s)
+	}
+	utf8Mode := false
+	if len(units) != 0 && len(units[0]) != 0 {
+		switch units[0][0] {
+		case utf8ModeMarker:
+			utf8Mode = true
+			units[0] = units[0][1:]
+		case oldMarker:
+			units[0] = units[0][1:]
+		}
+	}
+	var b []byte
+	for _, u := range units {
+		for _, c := range u {
+			b = append(b, byte(c))
+		}
+	}
+	if utf8Mode {
+		return b
+	}
+	for i := range b {
+		b[i] = (b[i] + 0x7f) & 0x7f
+	}
+	return decode7to8(b)
+}
+
+func decode7to8(data []byte) []byte {
+	result := make([]byte, 7*len(data)/8)
+	idx, bit := 0, uint(0)
+	for i := range result {
+		first := data[idx] >> bit
+		idx++
+		second := (data[idx] & (1<<(bit+1) - 1)) << (7 - bit)
+		result[i] = first + second
+		if bit == 6 {
+			idx++
+			bit = 0
+		} else {
+			bit++
+		}
+	}
+	return result
+}
+
+// predefinedStrings are the strings that a string table record can
+// refer to by predefined_index (JvmNameResolverBase.PREDEFINED_STRINGS).
+var predefinedStrings = []string{
+	"kotlin/Any", "kotlin/Nothing", "kotlin/Unit", "kotlin/Throwable", "kotlin/Number",
+	"kotlin/Byte", "kotlin/Double", "kotlin/Float", "kotlin/Int", "kotlin/Long",
+	"kotlin/Short", "kotlin/Boolean", "kotlin/Char",
+	"kotlin/CharSequence", "kotlin/String", "kotlin/Comparable", "kotlin/Enum",
+	"kotlin/Array", "kotlin/ByteArray", "kotlin/DoubleArray", "kotlin/FloatArray",
+	"kotlin/IntArray", "kotlin/LongArray", "kotlin/ShortArray", "kotlin/BooleanArray",
+	"kotlin/CharArray",
+	"kotlin/Cloneable", "kotlin/Annotation",
+	"kotlin/collections/Iterable", "kotlin/collections/MutableIterable",
+	"kotlin/collections/Collection", "kotlin/collections/MutableCollection",
+	"kotlin/collections/List", "kotlin/collections/MutableList",
+	"kotlin/collections/Set", "kotlin/collections/MutableSet",
+	"kotlin/collections/Map", "kotlin/collections/MutableMap",
+	"kotlin/collections/Map.Entry", "kotlin/collections/MutableMap.MutableEntry",
+	"kotlin/collections/Iterator", "kotlin/collections/MutableIterator",
+	"kotlin/collections/ListIterator", "kotlin/collections/MutableListIterator",
+}
+
+// String table record operations.
+const (
+	opNone = iota
+	opInternalToClassId
+	opDescToClassId
+)
+
+// readStringTable resolves the StringTableTypes message 'types'
+// against the d2 strings, giving the string for each index used by
+// the metadata messages.
+func readStringTable(types pbMessage, d2 []string) ([]string, error) {
+	table := make([]string, len(d2))
+	for i, s := range d2 {
+		table[i] = mutf8ToString(s)
+	}
+	records, err := types.messages(1)
+	if err != nil {
+		return nil, err
+	}
+	// Records can supply strings that d2 doesn't have, but only
+	// one apiece.
+	idx, limit := 0, len(d2)+len(records)
+	for _, r := range records {
+		for n := r.int(1, 1); n > 0 && idx < limit; n-- {
+			if idx == len(table) {
+				if !r.has(6) && !r.has(2) {
+					return nil, fmt.Errorf("string table record %d has no string", idx)
+				}
+				table = append(table, "")
+			}
+			s := table[idx]
+			if r.has(6) {
+				s = r.str(6)
+			} else if p := r.int(2, -1); r.has(2) && p >= 0 && p < len(predefinedStrings) {
+				s = predefinedStrings[p]
+			}
+			sub, err := r.ints(4)
+			if err != nil {
+				return nil, err
+			}
+			if len(sub) >= 2 && 0 <= sub[0] && sub[0] <= sub[1] && sub[1] <= len(s) {
+				s = s[sub[0]:sub[1]]
+			}
+			repl, err := r.ints(5)
+			if err != nil {
+				return nil, err
+			}
+			if len(repl) >= 2 {
+				s = strings.Replace(s, string(rune(repl[0])), string(rune(repl[1])), -1)
+			}
+			switch r.int(3, opNone) {
+			case opInternalToClassId:
+				s = strings.Replace(s, "$", ".", -1)
+			case opDescToClassId:
+				if len(s) >= 2 {
+					s = s[1 : len(s)-1]
+				}
+				s = strings.Replace(s, "$", ".", -1)
+			}
+			table[idx] = s
+			idx++
+		}
+	}
+	return table, nil
+}
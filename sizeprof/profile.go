@@ -0,0 +1,167 @@
+package sizeprof
+
+//
+// A minimal profile.proto writer. Every frame name gets one Function
+// and one Location (with the same id), since there are no addresses
+// or line numbers to distinguish. Functions have no system_name, which
+// stops pprof from "demangling" (and so mangling) Java signatures.
+//
+
+// Field numbers, from profile.proto.
+const (
+	profileSampleType  = 1
+	profileSample      = 2
+	profileLocation    = 4
+	profileFunction    = 5
+	profileStringTable = 6
+	profileDefaultType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationId = 1
+	sampleValue      = 2
+	sampleLabel      = 3
+
+	labelKey = 1
+	labelStr = 2
+
+	locationId   = 1
+	locationLine = 4
+
+	lineFunctionId = 1
+
+	functionId   = 1
+	functionName = 2
+)
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+type buffer []byte
+
+func (b *buffer) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *buffer) uint(num int, v uint64) {
+	b.varint(uint64(num<<3 | wireVarint))
+	b.varint(v)
+}
+
+func (b *buffer) bytes(num int, data []byte) {
+	b.varint(uint64(num<<3 | wireBytes))
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *buffer) packed(num int, vs []uint64) {
+	var data buffer
+	for _, v := range vs {
+		data.varint(v)
+	}
+	b.bytes(num, data)
+}
+
+type builder struct {
+	strings   []string
+	stringIdx map[string]uint64
+	funcIds   map[string]uint64
+	funcs     buffer
+	samples   buffer
+}
+
+func newBuilder() *builder {
+	b := &builder{stringIdx: make(map[string]uint64), funcIds: make(map[string]uint64)}
+	b.str("")
+	return b
+}
+
+// str returns the string table index of 's'.
+func (b *builder) str(s string) uint64 {
+	if i, ok := b.stringIdx[s]; ok {
+		return i
+	}
+	i := uint64(len(b.strings))
+	b.stringIdx[s] = i
+	b.strings = append(b.strings, s)
+	return i
+}
+
+// location returns the location id for frame 'name'.
+func (b *builder) location(name string) uint64 {
+	if id, ok := b.funcIds[name]; ok {
+		return id
+	}
+	id := uint64(len(b.funcIds) + 1)
+	b.funcIds[name] = id
+	var fn buffer
+	fn.uint(functionId, id)
+	fn.uint(functionName, b.str(name))
+	b.funcs.bytes(profileFunction, fn)
+	return id
+}
+
+// sample adds a sample with stack 'frames' (outermost first) and
+// 'values', labelled with the DEX file name. Samples whose values are
+// all zero are left out.
+func (b *builder) sample(frames []string, values [numValues]int64, dex string) {
+	zero := true
+	for _, v := range values {
+		zero = zero && v == 0
+	}
+	if zero {
+		return
+	}
+	// Locations go leaf first.
+	locs := make([]uint64, len(frames))
+	for i, f := range frames {
+		locs[len(frames)-1-i] = b.location(f)
+	}
+	vals := make([]uint64, len(values))
+	for i, v := range values {
+		vals[i] = uint64(v)
+	}
+	var s, label buffer
+	s.packed(sampleLocationId, locs)
+	s.packed(sampleValue, vals)
+	label.uint(labelKey, b.str("dex"))
+	label.uint(labelStr, b.str(dex))
+	s.bytes(sampleLabel, label)
+	b.samples.bytes(profileSample, s)
+}
+
+func (b *builder) encode() []byte {
+	var p buffer
+	for _, vt := range [numValues][2]string{
+		valueSize:       {"size", "bytes"},
+		valueMethods:    {"methods", "count"},
+		valueMethodRefs: {"method_refs", "count"},
+	} {
+		var t buffer
+		t.uint(valueTypeType, b.str(vt[0]))
+		t.uint(valueTypeUnit, b.str(vt[1]))
+		p.bytes(profileSampleType, t)
+	}
+	p = append(p, b.samples...)
+	// Locations, one per function, with the same id.
+	for id := uint64(1); id <= uint64(len(b.funcIds)); id++ {
+		var line, loc buffer
+		line.uint(lineFunctionId, id)
+		loc.uint(locationId, id)
+		loc.bytes(locationLine, line)
+		p.bytes(profileLocation, loc)
+	}
+	p = append(p, b.funcs...)
+	for _, s := range b.strings {
+		p.bytes(profileStringTable, []byte(s))
+	}
+	p.uint(profileDefaultType, b.str("size"))
+	return p
+}
@@ -0,0 +1,115 @@
+//
+// Package for writing the size and method count attribution of DEX
+// files (see dexread.SizeReport) as a pprof profile, so that "go tool
+// pprof" can show flame graphs and top lists of what an APK's DEX
+// files are made of. The profile is in the profile.proto format, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto
+//
+// Each defined method, class and shared item becomes a sample, whose
+// stack runs from the outermost package down: for example
+// "com" -> "com.example" -> "com.example.Foo" -> "com.example.Foo.bar(I)V".
+// Samples have three values:
+//
+//	size         bytes attributed (the default)
+//	methods      number of methods defined
+//	method_refs  number of method_ids entries, i.e. methods referenced
+//
+// Method samples carry the size of their code; class samples carry the
+// rest of the class's size, plus the method_ids entries for its
+// methods (classes that the APK does not define show up with just
+// method_refs). Shared data (strings, types and so on) is under
+// "<shared>". Every sample is labelled with the name of its DEX file.
+//
+package sizeprof
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/thanm/go-read-a-dex/dexread"
+)
+
+// Indices of the sample values.
+const (
+	valueSize = iota
+	valueMethods
+	valueMethodRefs
+	numValues
+)
+
+// SharedRoot is the root frame for shared data.
+const SharedRoot = "<shared>"
+
+// Write writes the profile for 'reports' to 'w', gzipped (as pprof
+// expects).
+func Write(w io.Writer, reports []*dexread.SizeReport) error {
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(Encode(reports)); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Encode returns the profile for 'reports', uncompressed.
+func Encode(reports []*dexread.SizeReport) []byte {
+	b := newBuilder()
+	for _, r := range reports {
+		dex := r.Dex.Name()
+		refs := make(map[string]int64)
+		for i := 0; i < r.Dex.NumMethods(); i++ {
+			refs[r.Dex.Method(uint32(i)).Class]++
+		}
+		for _, cs := range r.Classes {
+			frames := classFrames(cs.Class.Descriptor)
+			rest := int64(cs.Size)
+			for _, ms := range cs.Methods {
+				rest -= int64(ms.Size)
+			}
+			var values [numValues]int64
+			values[valueSize] = rest
+			values[valueMethods] = int64(len(cs.Class.Methods()) - len(cs.Methods))
+			values[valueMethodRefs] = refs[cs.Class.Descriptor]
+			delete(refs, cs.Class.Descriptor)
+			b.sample(frames, values, dex)
+			for _, ms := range cs.Methods {
+				values = [numValues]int64{}
+				values[valueSize] = int64(ms.Size)
+				values[valueMethods] = 1
+				id := &ms.Method.Id
+				b.sample(append(frames, frames[len(frames)-1]+"."+id.Name+id.Proto.Descriptor()), values, dex)
+			}
+		}
+		// References to classes defined elsewhere.
+		var others []string
+		for class := range refs {
+			others = append(others, class)
+		}
+		sort.Strings(others)
+		for _, class := range others {
+			var values [numValues]int64
+			values[valueMethodRefs] = refs[class]
+			b.sample(classFrames(class), values, dex)
+		}
+		for _, s := range r.Shared {
+			var values [numValues]int64
+			values[valueSize] = int64(s.Size)
+			b.sample([]string{SharedRoot, SharedRoot + " " + s.Name}, values, dex)
+		}
+	}
+	return b.encode()
+}
+
+// classFrames returns the frames for a class: its enclosing packages,
+// outermost first, and then the class itself.
+func classFrames(descriptor string) []string {
+	name := dexread.JavaName(descriptor)
+	var frames []string
+	for i, c := range name {
+		if c == '.' {
+			frames = append(frames, name[:i])
+		}
+	}
+	return append(frames, strings.TrimRight(name, "[]"))
+}
@@ -0,0 +1,183 @@
+package sizeprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/thanm/go-read-a-dex/dexread"
+)
+
+// A profile.proto reader, just enough to check what Encode writes.
+
+type field struct {
+	num  int
+	val  uint64
+	data []byte
+}
+
+func varint(b []byte) (uint64, []byte) {
+	var v uint64
+	for i, c := range b {
+		v |= uint64(c&0x7f) << (7 * uint(i))
+		if c < 0x80 {
+			return v, b[i+1:]
+		}
+	}
+	return 0, nil
+}
+
+func fields(b []byte) []field {
+	var fs []field
+	for len(b) != 0 {
+		var tag uint64
+		tag, b = varint(b)
+		f := field{num: int(tag >> 3)}
+		if tag&7 == wireVarint {
+			f.val, b = varint(b)
+		} else {
+			var l uint64
+			l, b = varint(b)
+			f.data, b = b[:l], b[l:]
+		}
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+func packed(b []byte) []uint64 {
+	var vs []uint64
+	for len(b) != 0 {
+		var v uint64
+		v, b = varint(b)
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+type profile struct {
+	strings []string
+	types   []string
+	// stack (outermost first, joined by ";") to values
+	samples map[string][]uint64
+	labels  map[string]bool
+}
+
+func decode(t *testing.T, data []byte) *profile {
+	p := &profile{samples: make(map[string][]uint64), labels: make(map[string]bool)}
+	top := fields(data)
+	funcNames := make(map[uint64]uint64)
+	locFuncs := make(map[uint64]uint64)
+	for _, f := range top {
+		switch f.num {
+		case profileStringTable:
+			p.strings = append(p.strings, string(f.data))
+		case profileFunction:
+			var id, name uint64
+			for _, ff := range fields(f.data) {
+				switch ff.num {
+				case functionId:
+					id = ff.val
+				case functionName:
+					name = ff.val
+				}
+			}
+			funcNames[id] = name
+		case profileLocation:
+			var id, fn uint64
+			for _, ff := range fields(f.data) {
+				switch ff.num {
+				case locationId:
+					id = ff.val
+				case locationLine:
+					fn = fields(ff.data)[0].val
+				}
+			}
+			locFuncs[id] = fn
+		}
+	}
+	if len(p.strings) == 0 || p.strings[0] != "" {
+		t.Fatalf("bad string table %q", p.strings)
+	}
+	for _, f := range top {
+		switch f.num {
+		case profileSampleType:
+			p.types = append(p.types, p.strings[fields(f.data)[0].val])
+		case profileSample:
+			var frames []string
+			var values []uint64
+			for _, ff := range fields(f.data) {
+				switch ff.num {
+				case sampleLocationId:
+					for _, loc := range packed(ff.data) {
+						frames = append([]string{p.strings[funcNames[locFuncs[loc]]]}, frames...)
+					}
+				case sampleValue:
+					values = packed(ff.data)
+				case sampleLabel:
+					l := fields(ff.data)
+					p.labels[p.strings[l[0].val]+"="+p.strings[l[1].val]] = true
+				}
+			}
+			p.samples[strings.Join(frames, ";")] = values
+		case profileDefaultType:
+			if p.strings[f.val] != "size" {
+				t.Errorf("default sample type %s", p.strings[f.val])
+			}
+		}
+	}
+	return p
+}
+
+func TestWrite(t *testing.T) {
+	dex, err := dexread.Open("../dexread/testdata/classes.dex")
+	if err != nil {
+		t.Fatalf("Open error %v", err)
+	}
+	r, err := dex.SizeReport()
+	if err != nil {
+		t.Fatalf("SizeReport error %v", err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, []*dexread.SizeReport{r}); err != nil {
+		t.Fatalf("Write error %v", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip error %v", err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("gzip error %v", err)
+	}
+	p := decode(t, data)
+
+	if strings.Join(p.types, " ") != "size methods method_refs" {
+		t.Errorf("unexpected sample types %v", p.types)
+	}
+	if !p.labels["dex=../dexread/testdata/classes.dex"] || len(p.labels) != 1 {
+		t.Errorf("unexpected labels %v", p.labels)
+	}
+	var totals [numValues]uint64
+	for _, vals := range p.samples {
+		for i, v := range vals {
+			totals[i] += v
+		}
+	}
+	if totals[valueSize] != uint64(r.FileSize) || totals[valueMethods] != 6 || totals[valueMethodRefs] != 12 {
+		t.Errorf("unexpected totals %v", totals)
+	}
+	for stack, expected := range map[string][3]uint64{
+		"fibonacci;fibonacci.main([Ljava/lang/String;)V": {431, 1, 0},
+		"fibonacci":                       {794 - 29 - 83 - 431 - 38 - 38 - 65, 0, 6},
+		"java;java.lang;java.lang.Object": {0, 0, 1},
+		"<shared>;<shared> strings":       {632, 0, 0},
+	} {
+		if vals := p.samples[stack]; len(vals) != 3 || vals[0] != expected[0] ||
+			vals[1] != expected[1] || vals[2] != expected[2] {
+			t.Errorf("sample %s: got %v expected %v", stack, vals, expected)
+		}
+	}
+}